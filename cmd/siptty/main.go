@@ -4,6 +4,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	_ "net/http/pprof"
@@ -11,14 +12,20 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/siptty/siptty/internal/anonymize"
 	"github.com/siptty/siptty/internal/config"
 	"github.com/siptty/siptty/internal/engine"
+	"github.com/siptty/siptty/internal/offline"
 	"github.com/siptty/siptty/internal/tui"
 )
 
 func main() {
 	configPath := flag.String("config", "", "path to config file")
 	debug := flag.Bool("debug", false, "enable pprof endpoint on localhost:6060")
+	pcapPath := flag.String("pcap", "", "on exit, write the SIP trace to this pcapng file")
+	pcapRTP := flag.Bool("pcap-rtp", false, "include captured RTP in the -pcap file (needs capture_rtp)")
+	anonymizeExport := flag.Bool("anonymize", false, "anonymize exports: the -pcap file and the w/W exports (see trace.anonymize)")
+	readPath := flag.String("read", "", "open the SIP messages of this pcap/pcapng file offline, without starting the engine")
 	flag.Parse()
 
 	if *debug {
@@ -30,6 +37,15 @@ func main() {
 		}()
 	}
 
+	if *readPath != "" {
+		if *pcapPath != "" || *pcapRTP {
+			fmt.Fprintln(os.Stderr, "error: -pcap and -pcap-rtp cannot be used with -read")
+			os.Exit(2)
+		}
+		runOffline(*readPath, *configPath, *anonymizeExport)
+		return
+	}
+
 	// Find config file.
 	cfgPath := *configPath
 	if cfgPath == "" {
@@ -84,8 +100,12 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *anonymizeExport {
+		cfg.Trace.Anonymize.Enabled = true
+	}
+
 	// Create TUI.
-	app := tui.NewApp(eng)
+	app := tui.NewApp(eng, cfg.Trace)
 
 	// Start engine with a cancellable context (not tied to signals).
 	ctx, cancel := context.WithCancel(context.Background())
@@ -117,4 +137,53 @@ func main() {
 	// Clean shutdown.
 	cancel()
 	eng.Stop()
+
+	if *pcapPath != "" {
+		var rtp []engine.RTPPacket
+		if *pcapRTP {
+			rtp = eng.RTPPackets("")
+		}
+		events := eng.TraceStore().All()
+		if anon := anonymize.FromConfig(cfg.Trace.Anonymize); anon != nil {
+			events, rtp = anon.Events(events), anon.RTP(rtp)
+		}
+		if _, err := engine.WritePcapFile(*pcapPath, events, rtp); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// runOffline opens the TUI on the SIP messages of a capture file. The config
+// is optional and only supplies the saved trace filters.
+func runOffline(path, cfgPath string, anonymizeExport bool) {
+	events, err := offline.Load(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var traceCfg config.TraceConfig
+	if cfgPath == "" {
+		cfgPath, _ = config.FindConfigFile()
+	}
+	if cfgPath != "" {
+		cfg, err := config.Load(cfgPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		traceCfg = cfg.Trace
+	}
+	if anonymizeExport {
+		traceCfg.Anonymize.Enabled = true
+	}
+
+	// TUI owns the terminal and nothing here needs a log.
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	app := tui.NewApp(offline.NewEngine(events), traceCfg)
+	if err := app.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "TUI error: %v\n", err)
+	}
 }
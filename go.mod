@@ -9,6 +9,7 @@ require (
 	github.com/emiago/diago v0.27.0
 	github.com/emiago/sipgo v1.2.0
 	github.com/gdamore/tcell/v2 v2.13.8
+	github.com/gobwas/ws v1.4.0
 	github.com/rivo/tview v0.42.0
 )
 
@@ -18,7 +19,6 @@ require (
 	github.com/go-audio/riff v1.0.0 // indirect
 	github.com/gobwas/httphead v0.1.0 // indirect
 	github.com/gobwas/pool v0.2.1 // indirect
-	github.com/gobwas/ws v1.4.0 // indirect
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/icholy/digest v1.1.0 // indirect
 	github.com/lucasb-eyer/go-colorful v1.3.0 // indirect
@@ -0,0 +1,294 @@
+// Package anonymize replaces the personal and network details in SIP traces
+// with stable pseudonyms so captures can be shared: user parts and display
+// names become "user1", "user2", ..., hostnames "host1.invalid", IPv4
+// addresses come from 198.18.0.0/15 and IPv6 addresses from 2001:db8::/32,
+// and digest credentials are redacted. One Anonymizer maps a value to the
+// same pseudonym every time, so dialogs stay readable across an export.
+package anonymize
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/siptty/siptty/internal/config"
+	"github.com/siptty/siptty/internal/engine"
+)
+
+// Options selects what an Anonymizer leaves alone.
+type Options struct {
+	KeepUsers []string // user parts and display names kept as they are
+	KeepHosts []string // hostnames (with their subdomains), IPs or CIDR prefixes kept
+	SDP       bool     // also replace SDP and ICE addresses, and the addresses of RTP
+}
+
+var (
+	authRe    = regexp.MustCompile(`(?im)^((?:Authorization|Proxy-Authorization)[ \t]*:[ \t]*)(\S+)[^\r\n]*`)
+	uriRe     = regexp.MustCompile(`(?i)\b(sips?|tel):([^\s<>;,"?]+)`)
+	viaRe     = regexp.MustCompile(`(?i)(SIP/2\.0/[a-z]+[ \t]+)(\[[^\]]+\]|[^\s;:,]+)`)
+	paramRe   = regexp.MustCompile(`(?i)(;[ \t]*(?:received|maddr)=)(\[[^\]]+\]|[^\s;,>]+)`)
+	callIDRe  = regexp.MustCompile(`(?im)^((?:Call-ID|i)[ \t]*:[ \t]*[^@\r\n]*@)([^\s]+)`)
+	quotedRe  = regexp.MustCompile(`(")([^"\r\n]+)("[ \t]*<)`)
+	displayRe = regexp.MustCompile(`(?im)^((?:From|f|To|t|Contact|m|P-Asserted-Identity|P-Preferred-Identity|Remote-Party-ID|Referred-By|b)[ \t]*:[ \t]*)([^"<\r\n,]*[^"<\r\n, \t])([ \t]*<)`)
+	ipv4Re    = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+	sdpIPv6Re = regexp.MustCompile(`(IN IP6 )(\S+)`)
+	lengthRe  = regexp.MustCompile(`(?im)^((?:Content-Length|l)[ \t]*:[ \t]*)\d+`)
+)
+
+// Anonymizer maps users, hosts and addresses to pseudonyms. It is safe for
+// concurrent use.
+type Anonymizer struct {
+	opts      Options
+	keepUsers map[string]bool
+	keepHosts []string
+	keepNets  []netip.Prefix
+
+	mu     sync.Mutex
+	users  map[string]string
+	hosts  map[string]string
+	pseudo map[string]bool // every pseudonym handed out, left alone when seen again
+	nUsers int
+	nHosts int
+	nIPv4  int
+	nIPv6  int
+}
+
+// New returns an Anonymizer with no pseudonyms assigned yet.
+func New(opts Options) *Anonymizer {
+	a := &Anonymizer{
+		opts:      opts,
+		keepUsers: make(map[string]bool),
+		users:     make(map[string]string),
+		hosts:     make(map[string]string),
+		pseudo:    make(map[string]bool),
+	}
+	for _, u := range opts.KeepUsers {
+		a.keepUsers[strings.ToLower(u)] = true
+	}
+	for _, h := range opts.KeepHosts {
+		h = strings.ToLower(strings.Trim(h, "[]"))
+		if p, err := netip.ParsePrefix(h); err == nil {
+			a.keepNets = append(a.keepNets, p.Masked())
+		} else if ip, err := netip.ParseAddr(h); err == nil {
+			a.keepNets = append(a.keepNets, netip.PrefixFrom(ip, ip.BitLen()))
+		} else {
+			a.keepHosts = append(a.keepHosts, h)
+		}
+	}
+	return a
+}
+
+// User returns the pseudonym of a user part or display name.
+func (a *Anonymizer) User(u string) string {
+	if u == "" || a.keepUsers[strings.ToLower(u)] {
+		return u
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.pseudo[u] {
+		return u
+	}
+	p, ok := a.users[u]
+	if !ok {
+		a.nUsers++
+		p = fmt.Sprintf("user%d", a.nUsers)
+		a.users[u] = p
+		a.pseudo[p] = true
+	}
+	return p
+}
+
+// Host returns the pseudonym of a hostname or IP address, which is an
+// address of the same family for IPs. IPv6 addresses may be bracketed.
+func (a *Anonymizer) Host(h string) string {
+	bracketed := strings.HasPrefix(h, "[") && strings.HasSuffix(h, "]")
+	bare := strings.Trim(h, "[]")
+	ip, err := netip.ParseAddr(bare)
+	if bare == "" || a.keep(bare, ip, err == nil) {
+		return h
+	}
+	key := strings.ToLower(bare)
+	if err == nil {
+		key = ip.String()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p, ok := a.hosts[key]
+	switch {
+	case a.pseudo[key]:
+		p = bare
+	case ok:
+	case err != nil:
+		a.nHosts++
+		p = fmt.Sprintf("host%d.invalid", a.nHosts)
+	case ip.Is4():
+		a.nIPv4++
+		p = netip.AddrFrom4([4]byte{198, 18 + byte(a.nIPv4>>16&1), byte(a.nIPv4 >> 8), byte(a.nIPv4)}).String()
+	default:
+		a.nIPv6++
+		b := [16]byte{0x20, 0x01, 0x0d, 0xb8}
+		b[12], b[13], b[14], b[15] = byte(a.nIPv6>>24), byte(a.nIPv6>>16), byte(a.nIPv6>>8), byte(a.nIPv6)
+		p = netip.AddrFrom16(b).String()
+	}
+	if !ok && !a.pseudo[key] {
+		a.hosts[key] = p
+		a.pseudo[p] = true
+	}
+	if bracketed {
+		return "[" + p + "]"
+	}
+	return p
+}
+
+// keep reports whether a host is on the keep-list.
+func (a *Anonymizer) keep(h string, ip netip.Addr, isIP bool) bool {
+	if isIP {
+		for _, p := range a.keepNets {
+			if p.Contains(ip.Unmap()) {
+				return true
+			}
+		}
+		return false
+	}
+	h = strings.ToLower(h)
+	for _, k := range a.keepHosts {
+		if h == k || strings.HasSuffix(h, "."+k) {
+			return true
+		}
+	}
+	return false
+}
+
+// Addr returns the pseudonym of an "ip:port" address, keeping the port.
+// Anything else is returned unchanged.
+func (a *Anonymizer) Addr(addr string) string {
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return addr
+	}
+	ip, _ := netip.ParseAddr(a.Host(ap.Addr().String()))
+	return netip.AddrPortFrom(ip, ap.Port()).String()
+}
+
+// Message returns msg with its users, hosts and credentials replaced. SDP
+// addresses are replaced only with Options.SDP; Content-Length is fixed up
+// when the body changes length.
+func (a *Anonymizer) Message(msg string) string {
+	head, body, sep := msg, "", ""
+	for _, s := range []string{"\r\n\r\n", "\n\n"} {
+		if i := strings.Index(msg, s); i >= 0 {
+			head, sep, body = msg[:i], s, msg[i+len(s):]
+			break
+		}
+	}
+
+	head = authRe.ReplaceAllString(head, "${1}${2} [redacted]")
+	head = a.replaceURIs(head)
+	head = a.replaceGroup(viaRe, head, a.Host)
+	head = a.replaceGroup(paramRe, head, a.Host)
+	head = a.replaceGroup(callIDRe, head, a.Host)
+	head = a.replaceGroup(quotedRe, head, a.User)
+	head = a.replaceGroup(displayRe, head, a.User)
+	head = ipv4Re.ReplaceAllStringFunc(head, a.Host)
+
+	newBody := a.replaceURIs(body)
+	if a.opts.SDP {
+		newBody = ipv4Re.ReplaceAllStringFunc(newBody, a.Host)
+		newBody = a.replaceGroup(sdpIPv6Re, newBody, a.Host)
+	}
+	if len(newBody) != len(body) {
+		head = lengthRe.ReplaceAllString(head, "${1}"+strconv.Itoa(len(newBody)))
+	}
+	return head + sep + newBody
+}
+
+// replaceURIs replaces the user and host of every SIP, SIPS and tel URI.
+func (a *Anonymizer) replaceURIs(s string) string {
+	return uriRe.ReplaceAllStringFunc(s, func(m string) string {
+		scheme, rest, _ := strings.Cut(m, ":")
+		if strings.EqualFold(scheme, "tel") {
+			return scheme + ":" + a.User(rest)
+		}
+		hostport := rest
+		userinfo := ""
+		if i := strings.LastIndex(rest, "@"); i >= 0 {
+			user, _, _ := strings.Cut(rest[:i], ":") // drop any password
+			userinfo, hostport = a.User(user)+"@", rest[i+1:]
+		}
+		host, port := hostport, ""
+		if i := strings.LastIndex(hostport, ":"); i >= 0 && !strings.Contains(hostport[i:], "]") {
+			host, port = hostport[:i], hostport[i:]
+		}
+		return scheme + ":" + userinfo + a.Host(host) + port
+	})
+}
+
+// replaceGroup replaces the second capture group of every match of re,
+// keeping the first and any third.
+func (a *Anonymizer) replaceGroup(re *regexp.Regexp, s string, f func(string) string) string {
+	return re.ReplaceAllStringFunc(s, func(m string) string {
+		g := re.FindStringSubmatch(m)
+		out := g[1] + f(g[2])
+		if len(g) > 3 {
+			out += g[3]
+		}
+		return out
+	})
+}
+
+// Events returns anonymized copies of trace events. DNS notes are dropped,
+// since they name the hosts being resolved in free text.
+func (a *Anonymizer) Events(events []engine.SipTraceEvent) []engine.SipTraceEvent {
+	out := make([]engine.SipTraceEvent, 0, len(events))
+	for _, ev := range events {
+		if ev.Direction == "dns" {
+			continue
+		}
+		ev.Message = a.Message(ev.Message)
+		ev.LocalAddr = a.Addr(ev.LocalAddr)
+		ev.RemoteAddr = a.Addr(ev.RemoteAddr)
+		out = append(out, ev)
+	}
+	return out
+}
+
+// Store returns anonymized copies of every event in a trace store.
+func (a *Anonymizer) Store(s *engine.TraceStore) []engine.SipTraceEvent {
+	return a.Events(s.All())
+}
+
+// RTP returns captured RTP with anonymized addresses; with Options.SDP
+// unset, the media addresses stay as the SDP shows them.
+func (a *Anonymizer) RTP(packets []engine.RTPPacket) []engine.RTPPacket {
+	out := make([]engine.RTPPacket, len(packets))
+	for i, p := range packets {
+		p.CallID = a.callID(p.CallID)
+		if a.opts.SDP {
+			p.LocalAddr = a.Addr(p.LocalAddr)
+			p.RemoteAddr = a.Addr(p.RemoteAddr)
+		}
+		out[i] = p
+	}
+	return out
+}
+
+// callID anonymizes the host part of a Call-ID, as Message does.
+func (a *Anonymizer) callID(id string) string {
+	if i := strings.LastIndex(id, "@"); i >= 0 {
+		return id[:i+1] + a.Host(id[i+1:])
+	}
+	return id
+}
+
+// FromConfig returns an Anonymizer for the trace.anonymize settings, or nil
+// when they are disabled.
+func FromConfig(c config.AnonymizeConfig) *Anonymizer {
+	if !c.Enabled {
+		return nil
+	}
+	return New(Options{KeepUsers: c.KeepUsers, KeepHosts: c.KeepHosts, SDP: c.SDP})
+}
@@ -0,0 +1,112 @@
+package anonymize
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/siptty/siptty/internal/engine"
+)
+
+const invite = "INVITE sip:+15551234567@pbx.example.com:5060;transport=udp SIP/2.0\r\n" +
+	"Via: SIP/2.0/UDP 10.0.0.5:5060;branch=z9hG4bK1;received=203.0.113.7\r\n" +
+	"From: \"Alice Smith\" <sip:alice@example.com>;tag=1\r\n" +
+	"To: Bob <sip:+15551234567@pbx.example.com>\r\n" +
+	"Call-ID: abc123@10.0.0.5\r\n" +
+	"Contact: <sip:alice:secret@10.0.0.5:5060>\r\n" +
+	"P-Asserted-Identity: <tel:+15550001111>\r\n" +
+	"Proxy-Authorization: Digest username=\"alice\", realm=\"example.com\", response=\"0123abcd\"\r\n" +
+	"Content-Type: application/sdp\r\n" +
+	"Content-Length: 49\r\n" +
+	"\r\n" +
+	"v=0\r\no=- 1 1 IN IP4 10.0.0.5\r\nc=IN IP4 10.0.0.5\r\n"
+
+func TestMessage(t *testing.T) {
+	a := New(Options{})
+	got := a.Message(invite)
+	for _, leak := range []string{"alice", "Alice", "Smith", "Bob", "5551234567", "5550001111", "example.com", "203.0.113.7", "secret", "0123abcd"} {
+		if strings.Contains(got, leak) {
+			t.Errorf("anonymized message still contains %q:\n%s", leak, got)
+		}
+	}
+	for _, want := range []string{
+		"INVITE sip:user1@host1.invalid:5060;transport=udp SIP/2.0\r\n",
+		"Via: SIP/2.0/UDP 198.18.0.1:5060;branch=z9hG4bK1;received=198.18.0.2\r\n",
+		"From: \"user4\" <sip:user2@host2.invalid>;tag=1\r\n",
+		"To: user5 <sip:user1@host1.invalid>\r\n",
+		"Call-ID: abc123@198.18.0.1\r\n",
+		"Contact: <sip:user2@198.18.0.1:5060>\r\n",
+		"P-Asserted-Identity: <tel:user3>\r\n",
+		"Proxy-Authorization: Digest [redacted]\r\n",
+		"c=IN IP4 10.0.0.5\r\n", // SDP kept without Options.SDP
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("anonymized message lacks %q:\n%s", want, got)
+		}
+	}
+	if again := a.Message(invite); again != got {
+		t.Error("pseudonyms are not stable across messages")
+	}
+	if twice := a.Message(got); twice != got {
+		t.Errorf("anonymizing twice changed the message:\n%s", twice)
+	}
+}
+
+func TestMessageSDP(t *testing.T) {
+	got := New(Options{SDP: true}).Message(invite)
+	if !strings.Contains(got, "c=IN IP4 198.18.0.1\r\n") {
+		t.Errorf("SDP address not replaced:\n%s", got)
+	}
+	_, body, _ := strings.Cut(got, "\r\n\r\n")
+	if !strings.Contains(got, "Content-Length: "+strconv.Itoa(len(body))+"\r\n") {
+		t.Errorf("Content-Length not updated to %d:\n%s", len(body), got)
+	}
+}
+
+func TestKeepLists(t *testing.T) {
+	a := New(Options{KeepUsers: []string{"Alice"}, KeepHosts: []string{"example.com", "10.0.0.0/8"}})
+	got := a.Message(invite)
+	for _, want := range []string{"sip:alice@example.com", "pbx.example.com", "Via: SIP/2.0/UDP 10.0.0.5:5060", "received=198.18.0.1"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("anonymized message lacks kept %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestHostAndAddr(t *testing.T) {
+	a := New(Options{})
+	if got := a.Addr("[2001:db8:ffff::1]:5060"); got != "[2001:db8::1]:5060" {
+		t.Errorf("Addr(IPv6) = %q", got)
+	}
+	if got := a.Host("[fe80::1]"); got != "[2001:db8::2]" {
+		t.Errorf("Host(bracketed IPv6) = %q", got)
+	}
+	if a.Host("PBX.example.com") != a.Host("pbx.example.com") {
+		t.Error("hostnames are not case-insensitive")
+	}
+	if got := a.Addr("not an address"); got != "not an address" {
+		t.Errorf("Addr(garbage) = %q", got)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	a := New(Options{SDP: true})
+	events := []engine.SipTraceEvent{
+		{Direction: "dns", Message: "DNS pbx.example.com -> 10.0.0.9:5060 (srv)"},
+		{Direction: "send", Message: invite, LocalAddr: "10.0.0.5:5060", RemoteAddr: "10.0.0.9:5060"},
+	}
+	got := a.Events(events)
+	if len(got) != 1 {
+		t.Fatalf("got %d events, want the DNS note dropped", len(got))
+	}
+	if got[0].LocalAddr != "198.18.0.1:5060" || got[0].RemoteAddr == events[1].RemoteAddr {
+		t.Errorf("addresses = %s, %s", got[0].LocalAddr, got[0].RemoteAddr)
+	}
+	if got[0].CallID() != "abc123@198.18.0.1" {
+		t.Errorf("Call-ID = %q", got[0].CallID())
+	}
+	rtp := a.RTP([]engine.RTPPacket{{CallID: "abc123@10.0.0.5", LocalAddr: "10.0.0.5:4000", RemoteAddr: "10.0.0.9:5000"}})
+	if rtp[0].CallID != got[0].CallID() || rtp[0].LocalAddr != "198.18.0.1:4000" {
+		t.Errorf("RTP = %+v, want it to match the anonymized dialog", rtp[0])
+	}
+}
@@ -2,8 +2,14 @@ package config
 
 import (
 	"fmt"
+	"math"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/BurntSushi/toml"
@@ -14,6 +20,7 @@ type Config struct {
 	General  GeneralConfig   `toml:"general"`
 	Accounts []AccountConfig `toml:"accounts"`
 	Audio    AudioConfig     `toml:"audio"`
+	Trace    TraceConfig     `toml:"trace"`
 }
 
 // GeneralConfig holds global application settings.
@@ -21,8 +28,9 @@ type GeneralConfig struct {
 	LogLevel  int    `toml:"log_level"`
 	LogFile   string `toml:"log_file"`
 	UserAgent string `toml:"user_agent"`
-	BindHost  string `toml:"bind_host"` // local IP to bind (default: "0.0.0.0")
-	BindPort  int    `toml:"bind_port"` // local port to bind (default: 0 = ephemeral)
+	BindHost  string `toml:"bind_host"`  // local IP to bind, IPv4 or IPv6 (default: "0.0.0.0", or "::" with ipv6 accounts)
+	BindPort  int    `toml:"bind_port"`  // local port to bind (default: 0 = ephemeral)
+	DNSServer string `toml:"dns_server"` // "ip" or "ip:port" for SIP DNS lookups (default: system resolver)
 }
 
 // AccountConfig holds a single SIP account's settings.
@@ -37,6 +45,107 @@ type AccountConfig struct {
 	Register     bool              `toml:"register"`
 	RegExpiry    int               `toml:"reg_expiry"`
 	Headers      map[string]string `toml:"headers"`
+	TLS          TLSConfig         `toml:"tls"`
+
+	// OutboundProxy is a SIP URI every out-of-dialog request is routed
+	// through (e.g. an SBC), as a loose route; ";lr" is added if missing.
+	OutboundProxy string `toml:"outbound_proxy"`
+	// Routes are preloaded Route URIs placed after the outbound proxy. Once
+	// a registration returns Service-Route, that replaces them for new dialogs.
+	Routes []string `toml:"routes"`
+
+	// MediaEncryption selects SRTP keying: "none", "sdes" (RFC 4568 a=crypto)
+	// or "dtls" (RFC 5764 DTLS-SRTP). SDES keys travel in the SDP, so pair it
+	// with transport = "tls".
+	MediaEncryption string `toml:"media_encryption"`
+	// MediaEncryptionMode is "optional" (fall back to RTP if the peer has no
+	// SRTP) or "mandatory" (reject the call instead).
+	MediaEncryptionMode string `toml:"media_encryption_mode"`
+
+	// Codecs lists the audio codecs to offer, most preferred first: PCMU,
+	// PCMA, G722, opus and telephone-event (RFC 4733 DTMF). Default: PCMU,
+	// PCMA, telephone-event. The codecs screen of the TUI can reorder and
+	// disable them while siptty runs.
+	Codecs []string   `toml:"codecs"`
+	Ptime  int        `toml:"ptime"` // ms of audio per RTP packet: 10, 20, 30, 40 or 60 (default: 20)
+	Opus   OpusConfig `toml:"opus"`
+
+	NAT NATConfig `toml:"nat"`
+
+	// Keepalive is "none", "options" (OPTIONS ping to the registrar, with
+	// round-trip time) or "crlf" (RFC 5626: CRLFCRLF on TCP/TLS, CRLF on UDP).
+	Keepalive         string `toml:"keepalive"`
+	KeepaliveInterval int    `toml:"keepalive_interval"` // seconds between keepalives
+
+	// IPFamily picks the address family for signaling and media: "auto"
+	// (dual-stack: whichever the registrar resolves to first), "ipv4" or "ipv6".
+	IPFamily string `toml:"ip_family"`
+
+	// WSURL is the WebSocket edge for transport = "ws" or "wss" (RFC 7118),
+	// e.g. "ws://edge.example.com:8088/ws". Requests go there instead of
+	// the registrar host. Default: the registrar host and port, path "/".
+	// wss supports only the root path, and all ws/wss accounts share one
+	// host and path: sipgo has a single WebSocket dialer.
+	WSURL string `toml:"ws_url"`
+
+	QualityReport QualityReportConfig `toml:"quality_report"`
+
+	// Impairment degrades the account's calls and SIP on purpose, to
+	// reproduce network problems without tc/netem. Each call starts with
+	// it and can be given its own from the TUI.
+	Impairment ImpairmentConfig `toml:"impairment"`
+}
+
+// QualityReportConfig sends end-of-call voice quality reports the way desk
+// phones do, built on the call's media statistics.
+type QualityReportConfig struct {
+	Collector string `toml:"collector"` // SIP URI to PUBLISH vq-rtcpxr reports to (RFC 6035); empty disables
+	RTCPXR    bool   `toml:"rtcp_xr"`   // send an RTCP XR VoIP metrics packet (RFC 3611) to the peer
+}
+
+// OpusConfig sets how opus is offered (RFC 7587). The format parameters
+// go into the offer's a=fmtp line; zero and false leave them out.
+type OpusConfig struct {
+	PayloadType       int  `toml:"payload_type"`      // dynamic payload type, 96-127 (default: 96)
+	MaxAverageBitrate int  `toml:"maxaveragebitrate"` // bit/s we want to receive at most, 6000-510000
+	Stereo            bool `toml:"stereo"`            // we prefer to receive stereo
+	UseInbandFEC      bool `toml:"useinbandfec"`      // we can decode in-band forward error correction
+	MaxPlaybackRate   int  `toml:"maxplaybackrate"`   // Hz we can play at most, 8000-48000
+}
+
+// ImpairmentConfig is a network impairment. The RTP settings apply to the
+// media received, the SIP ones to the messages received over UDP.
+type ImpairmentConfig struct {
+	Loss      float64 `toml:"loss"`      // percent of RTP packets dropped
+	Burst     float64 `toml:"burst"`     // mean loss burst, in packets; above 1 the Gilbert model replaces random loss
+	Delay     int     `toml:"delay"`     // ms added to every RTP packet
+	Jitter    int     `toml:"jitter"`    // up to this many ms more, at random
+	Reorder   float64 `toml:"reorder"`   // percent of RTP packets held back behind later ones
+	Duplicate float64 `toml:"duplicate"` // percent of RTP packets delivered twice
+	SIPDrop   float64 `toml:"sip_drop"`  // percent of SIP messages dropped, to test retransmissions
+	SIPDelay  int     `toml:"sip_delay"` // ms every SIP message is held
+}
+
+// NATConfig holds per-account NAT traversal settings.
+type NATConfig struct {
+	RewriteContact bool   `toml:"rewrite_contact"` // learn the public address from Via received/rport and re-register with it
+	STUNServer     string `toml:"stun_server"`     // "host[:port]" or "stun:host:port"; maps the SIP port for Contact and SDP c=
+	PublicAddress  string `toml:"public_address"`  // IP to advertise in SDP c= lines, and in Contact without STUN
+
+	ICEEnabled bool   `toml:"ice_enabled"` // offer and answer ICE (RFC 8445) candidates in SDP
+	TURNServer string `toml:"turn_server"` // "host[:port]" or "turn:host:port"; relayed candidates
+	TURNUser   string `toml:"turn_user"`
+	TURNPass   string `toml:"turn_pass"`
+}
+
+// TLSConfig holds per-account TLS signaling settings (used when transport = "tls").
+type TLSConfig struct {
+	CAFile             string `toml:"ca_file"`              // PEM CA bundle (default: system roots)
+	CertFile           string `toml:"cert_file"`            // client certificate for mutual TLS
+	KeyFile            string `toml:"key_file"`             // client private key for mutual TLS
+	ServerName         string `toml:"server_name"`          // name the server certificate must carry (default: target host)
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify"` // lab PBXs with self-signed certs
+	MinVersion         string `toml:"min_version"`          // "1.0", "1.1", "1.2", "1.3" (default: "1.2")
 }
 
 // AudioConfig holds audio/media settings.
@@ -46,6 +155,61 @@ type AudioConfig struct {
 	RecordDir string `toml:"record_dir"`
 }
 
+// TraceConfig holds SIP trace store and panel settings.
+type TraceConfig struct {
+	MaxMessages    int             `toml:"max_messages"`    // messages kept in the trace store
+	MaxBytes       int             `toml:"max_bytes"`       // total message size kept in the trace store
+	CaptureRTP     bool            `toml:"capture_rtp"`     // keep received RTP of calls for pcap export
+	ExcludeOptions bool            `toml:"exclude_options"` // start with OPTIONS hidden
+	Filters        []TraceFilter   `toml:"filters"`         // saved filters, cycled with F in the trace panel
+	HEP            HEPConfig       `toml:"hep"`
+	Log            TraceLogConfig  `toml:"log"`
+	Anonymize      AnonymizeConfig `toml:"anonymize"`
+}
+
+// AnonymizeConfig controls the anonymizer applied to trace exports, which
+// replaces users, hosts and IPs with pseudonyms and redacts credentials.
+type AnonymizeConfig struct {
+	Enabled   bool     `toml:"enabled"`    // anonymize every export
+	KeepUsers []string `toml:"keep_users"` // user parts and display names left alone
+	KeepHosts []string `toml:"keep_hosts"` // hostnames (with subdomains), IPs or CIDR prefixes left alone
+	SDP       bool     `toml:"sdp"`        // also replace SDP addresses and those of exported RTP
+}
+
+// TraceLogConfig has the engine write every traced message to a log file,
+// rotated by size and age.
+type TraceLogConfig struct {
+	Path           string `toml:"path"`            // log file; empty disables the trace log
+	Format         string `toml:"format"`          // "text" (sngrep-style) or "jsonl" (default: "text")
+	MaxSize        int    `toml:"max_size"`        // rotate at this many bytes (default: 10 MiB)
+	RotateInterval int    `toml:"rotate_interval"` // also rotate after this many seconds (default: 0 = size only)
+	Compress       bool   `toml:"compress"`        // gzip rotated files
+	Keep           int    `toml:"keep"`            // rotated files kept (default: 10)
+}
+
+// HEPConfig mirrors every traced SIP message to a HEPv3 collector such as
+// Homer, so siptty sessions show up next to the PBX's own capture.
+type HEPConfig struct {
+	Address       string `toml:"address"`        // collector "host:port"; empty disables mirroring
+	Transport     string `toml:"transport"`      // "udp" or "tcp" (default: "udp")
+	CaptureID     int    `toml:"capture_id"`     // capture agent ID
+	NodeName      string `toml:"node_name"`      // capture node name
+	CorrelationID string `toml:"correlation_id"` // correlation ID of every packet (default: the message's Call-ID)
+}
+
+// TraceFilter is a saved trace filter. A message is shown when it matches
+// every field that is set; an empty filter shows everything.
+type TraceFilter struct {
+	Name      string   `toml:"name"`
+	Methods   []string `toml:"methods"`   // e.g. ["INVITE", "BYE"]; responses match on their CSeq method
+	Status    []string `toml:"status"`    // response classes ("4xx") or codes ("486"); excludes requests
+	CallID    string   `toml:"call_id"`   // substring of the Call-ID
+	Direction string   `toml:"direction"` // "send", "recv" or "dns"
+	Remote    string   `toml:"remote"`    // substring of the remote address
+	Account   string   `toml:"account"`   // account name
+	Regex     string   `toml:"regex"`     // RE2 regular expression over the full message
+}
+
 // rawAccountConfig mirrors AccountConfig but uses *bool for fields that
 // default to true, so we can distinguish "not set" from "explicitly false".
 type rawAccountConfig struct {
@@ -59,12 +223,35 @@ type rawAccountConfig struct {
 	Register     *bool             `toml:"register"`
 	RegExpiry    int               `toml:"reg_expiry"`
 	Headers      map[string]string `toml:"headers"`
+	TLS          TLSConfig         `toml:"tls"`
+
+	OutboundProxy string   `toml:"outbound_proxy"`
+	Routes        []string `toml:"routes"`
+
+	MediaEncryption     string `toml:"media_encryption"`
+	MediaEncryptionMode string `toml:"media_encryption_mode"`
+
+	Codecs []string   `toml:"codecs"`
+	Ptime  int        `toml:"ptime"`
+	Opus   OpusConfig `toml:"opus"`
+
+	NAT NATConfig `toml:"nat"`
+
+	Keepalive         string `toml:"keepalive"`
+	KeepaliveInterval int    `toml:"keepalive_interval"`
+
+	IPFamily string `toml:"ip_family"`
+	WSURL    string `toml:"ws_url"`
+
+	QualityReport QualityReportConfig `toml:"quality_report"`
+	Impairment    ImpairmentConfig    `toml:"impairment"`
 }
 
 type rawConfig struct {
-	General  GeneralConfig    `toml:"general"`
+	General  GeneralConfig      `toml:"general"`
 	Accounts []rawAccountConfig `toml:"accounts"`
-	Audio    AudioConfig      `toml:"audio"`
+	Audio    AudioConfig        `toml:"audio"`
+	Trace    TraceConfig        `toml:"trace"`
 }
 
 // Load reads and parses a TOML config file, applies defaults, and validates.
@@ -114,6 +301,7 @@ func fromRaw(raw *rawConfig) *Config {
 	cfg := &Config{
 		General: raw.General,
 		Audio:   raw.Audio,
+		Trace:   raw.Trace,
 	}
 	for _, ra := range raw.Accounts {
 		a := AccountConfig{
@@ -127,6 +315,28 @@ func fromRaw(raw *rawConfig) *Config {
 			Register:     boolDefault(ra.Register, true),
 			RegExpiry:    ra.RegExpiry,
 			Headers:      ra.Headers,
+			TLS:          ra.TLS,
+
+			OutboundProxy: ra.OutboundProxy,
+			Routes:        ra.Routes,
+
+			MediaEncryption:     ra.MediaEncryption,
+			MediaEncryptionMode: ra.MediaEncryptionMode,
+
+			Codecs: ra.Codecs,
+			Ptime:  ra.Ptime,
+			Opus:   ra.Opus,
+
+			NAT: ra.NAT,
+
+			Keepalive:         ra.Keepalive,
+			KeepaliveInterval: ra.KeepaliveInterval,
+
+			IPFamily: ra.IPFamily,
+			WSURL:    ra.WSURL,
+
+			QualityReport: ra.QualityReport,
+			Impairment:    ra.Impairment,
 		}
 		cfg.Accounts = append(cfg.Accounts, a)
 	}
@@ -147,12 +357,37 @@ func applyDefaults(cfg *Config) {
 	if cfg.General.UserAgent == "" {
 		cfg.General.UserAgent = "siptty/0.1"
 	}
+	cfg.General.BindHost = strings.Trim(cfg.General.BindHost, "[]")
 	if cfg.General.BindHost == "" {
 		cfg.General.BindHost = "0.0.0.0"
+		for _, a := range cfg.Accounts {
+			if a.IPFamily == "ipv6" {
+				cfg.General.BindHost = "::"
+				break
+			}
+		}
 	}
 	if cfg.Audio.Mode == "" {
 		cfg.Audio.Mode = "null"
 	}
+	if cfg.Trace.MaxMessages == 0 {
+		cfg.Trace.MaxMessages = 5000
+	}
+	if cfg.Trace.MaxBytes == 0 {
+		cfg.Trace.MaxBytes = 16 << 20
+	}
+	if cfg.Trace.HEP.Transport == "" {
+		cfg.Trace.HEP.Transport = "udp"
+	}
+	if cfg.Trace.Log.Format == "" {
+		cfg.Trace.Log.Format = "text"
+	}
+	if cfg.Trace.Log.MaxSize == 0 {
+		cfg.Trace.Log.MaxSize = 10 << 20
+	}
+	if cfg.Trace.Log.Keep == 0 {
+		cfg.Trace.Log.Keep = 10
+	}
 
 	for i := range cfg.Accounts {
 		if cfg.Accounts[i].Transport == "" {
@@ -164,24 +399,57 @@ func applyDefaults(cfg *Config) {
 		if cfg.Accounts[i].AuthUser == "" {
 			cfg.Accounts[i].AuthUser = deriveAuthUser(cfg.Accounts[i].SipURI)
 		}
+		if cfg.Accounts[i].TLS.MinVersion == "" {
+			cfg.Accounts[i].TLS.MinVersion = "1.2"
+		}
+		if cfg.Accounts[i].MediaEncryption == "" {
+			cfg.Accounts[i].MediaEncryption = "none"
+		}
+		if cfg.Accounts[i].MediaEncryptionMode == "" {
+			cfg.Accounts[i].MediaEncryptionMode = "optional"
+		}
+		if len(cfg.Accounts[i].Codecs) == 0 {
+			cfg.Accounts[i].Codecs = []string{"PCMU", "PCMA", "telephone-event"}
+		}
+		if cfg.Accounts[i].Ptime == 0 {
+			cfg.Accounts[i].Ptime = 20
+		}
+		if cfg.Accounts[i].Opus.PayloadType == 0 {
+			cfg.Accounts[i].Opus.PayloadType = 96
+		}
+		if cfg.Accounts[i].Keepalive == "" {
+			cfg.Accounts[i].Keepalive = "none"
+		}
+		if cfg.Accounts[i].KeepaliveInterval == 0 {
+			cfg.Accounts[i].KeepaliveInterval = 30
+		}
+		if cfg.Accounts[i].IPFamily == "" {
+			cfg.Accounts[i].IPFamily = "auto"
+		}
+		if cfg.Accounts[i].WSURL == "" {
+			cfg.Accounts[i].WSURL = defaultWSURL(cfg.Accounts[i])
+		}
 	}
 }
 
+// deriveAuthUser returns the user part of a SIP URI, without any password.
 func deriveAuthUser(sipURI string) string {
-	uri := sipURI
-	uri = strings.TrimPrefix(uri, "sips:")
-	uri = strings.TrimPrefix(uri, "sip:")
-
-	if idx := strings.Index(uri, "@"); idx >= 0 {
-		return uri[:idx]
+	userinfo, _, ok := splitUserinfo(sipURI)
+	if !ok {
+		return ""
 	}
-	return ""
+	user, _, _ := strings.Cut(userinfo, ":")
+	return user
 }
 
 func validate(cfg *Config) error {
 	if len(cfg.Accounts) == 0 {
 		return fmt.Errorf("at least one account is required")
 	}
+	bindIP := net.ParseIP(cfg.General.BindHost)
+	if bindIP == nil {
+		return fmt.Errorf("invalid bind_host %q (must be an IPv4 or IPv6 address)", cfg.General.BindHost)
+	}
 
 	for i, a := range cfg.Accounts {
 		if a.SipURI == "" {
@@ -190,21 +458,527 @@ func validate(cfg *Config) error {
 		if a.Registrar == "" {
 			return fmt.Errorf("account %d: registrar is required", i)
 		}
+		if err := validateAddressing(a, bindIP); err != nil {
+			return fmt.Errorf("account %d: %w", i, err)
+		}
 		if !isValidTransport(a.Transport) {
-			return fmt.Errorf("account %d: invalid transport %q (must be udp, tcp, or tls)", i, a.Transport)
+			return fmt.Errorf("account %d: invalid transport %q (must be udp, tcp, tls, ws, or wss)", i, a.Transport)
 		}
+		if err := validateWebSocket(a); err != nil {
+			return fmt.Errorf("account %d: %w", i, err)
+		}
+		if err := validateTLS(a); err != nil {
+			return fmt.Errorf("account %d: %w", i, err)
+		}
+		if err := validateRoutes(a); err != nil {
+			return fmt.Errorf("account %d: %w", i, err)
+		}
+		if !isValidMediaEncryption(a.MediaEncryption) {
+			return fmt.Errorf("account %d: invalid media_encryption %q (must be none, sdes, or dtls)", i, a.MediaEncryption)
+		}
+		if !isValidMediaEncryptionMode(a.MediaEncryptionMode) {
+			return fmt.Errorf("account %d: invalid media_encryption_mode %q (must be optional or mandatory)", i, a.MediaEncryptionMode)
+		}
+		if err := ValidateCodecs(a); err != nil {
+			return fmt.Errorf("account %d: %w", i, err)
+		}
+		if a.NAT.PublicAddress != "" && net.ParseIP(a.NAT.PublicAddress) == nil {
+			return fmt.Errorf("account %d: nat: public_address %q is not an IP address", i, a.NAT.PublicAddress)
+		}
+		if a.NAT.TURNServer != "" && (a.NAT.TURNUser == "" || a.NAT.TURNPass == "") {
+			return fmt.Errorf("account %d: nat: turn_server requires turn_user and turn_pass", i)
+		}
+		if !isValidKeepalive(a.Keepalive) {
+			return fmt.Errorf("account %d: invalid keepalive %q (must be none, options, or crlf)", i, a.Keepalive)
+		}
+		if a.KeepaliveInterval < 0 {
+			return fmt.Errorf("account %d: keepalive_interval must be positive", i)
+		}
+		if c := a.QualityReport.Collector; c != "" && !isSIPURI(c) {
+			return fmt.Errorf("account %d: quality_report: collector %q is not a sip: or sips: URI", i, c)
+		}
+		if err := ValidateImpairment(a.Impairment); err != nil {
+			return fmt.Errorf("account %d: impairment: %w", i, err)
+		}
+		if ImpairsSIP(a.Impairment) && a.Transport != "udp" {
+			return fmt.Errorf("account %d: impairment: sip_drop and sip_delay need transport udp", i)
+		}
+	}
+
+	if err := validateShared(cfg.Accounts); err != nil {
+		return err
+	}
+
+	if cfg.General.DNSServer != "" && !isValidDNSServer(cfg.General.DNSServer) {
+		return fmt.Errorf("invalid dns_server %q (must be an IP address with optional port)", cfg.General.DNSServer)
 	}
 
 	if !isValidAudioMode(cfg.Audio.Mode) {
 		return fmt.Errorf("invalid audio mode %q (must be null or file)", cfg.Audio.Mode)
 	}
 
+	if cfg.Trace.MaxMessages < 0 {
+		return fmt.Errorf("trace: max_messages must be positive")
+	}
+	if cfg.Trace.MaxBytes < 0 {
+		return fmt.Errorf("trace: max_bytes must be positive")
+	}
+
+	if err := validateHEP(cfg.Trace.HEP); err != nil {
+		return fmt.Errorf("trace: hep: %w", err)
+	}
+
+	if err := validateTraceLog(cfg.Trace.Log); err != nil {
+		return fmt.Errorf("trace: log: %w", err)
+	}
+
+	names := make(map[string]bool)
+	for i, f := range cfg.Trace.Filters {
+		if f.Name == "" {
+			return fmt.Errorf("trace filter %d: name is required", i)
+		}
+		if names[f.Name] {
+			return fmt.Errorf("trace filter %q: duplicate name", f.Name)
+		}
+		names[f.Name] = true
+		if err := ValidateTraceFilter(f); err != nil {
+			return fmt.Errorf("trace filter %q: %w", f.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// ValidateTraceFilter checks the fields of a trace filter, saved or typed in
+// the trace panel.
+func ValidateTraceFilter(f TraceFilter) error {
+	for _, m := range f.Methods {
+		if m == "" || strings.ContainsAny(m, " \t") {
+			return fmt.Errorf("invalid method %q", m)
+		}
+	}
+	for _, s := range f.Status {
+		if !isValidStatusFilter(s) {
+			return fmt.Errorf("invalid status %q (must be a class like 4xx or a code like 486)", s)
+		}
+	}
+	switch f.Direction {
+	case "", "send", "recv", "dns":
+	default:
+		return fmt.Errorf("invalid direction %q (must be send, recv, or dns)", f.Direction)
+	}
+	if _, err := regexp.Compile(f.Regex); err != nil {
+		return fmt.Errorf("invalid regex: %w", err)
+	}
+	return nil
+}
+
+// ImpairsSIP reports whether c does anything to SIP messages, which is
+// only supported over UDP.
+func ImpairsSIP(c ImpairmentConfig) bool {
+	return c.SIPDrop != 0 || c.SIPDelay != 0
+}
+
+// ValidateImpairment checks a network impairment, configured or typed in
+// the call panel.
+func ValidateImpairment(c ImpairmentConfig) error {
+	for _, p := range []struct {
+		name  string
+		value float64
+	}{{"loss", c.Loss}, {"reorder", c.Reorder}, {"duplicate", c.Duplicate}, {"sip_drop", c.SIPDrop}} {
+		if p.value < 0 || p.value > 100 {
+			return fmt.Errorf("%s must be a percentage (0-100)", p.name)
+		}
+	}
+	if c.Burst < 0 {
+		return fmt.Errorf("burst must be positive")
+	}
+	if c.Delay < 0 || c.Jitter < 0 || c.SIPDelay < 0 {
+		return fmt.Errorf("delay, jitter and sip_delay must be positive")
+	}
+	return nil
+}
+
+// isValidStatusFilter accepts a response class "1xx".."6xx" or a code 100-699.
+func isValidStatusFilter(s string) bool {
+	if len(s) != 3 || s[0] < '1' || s[0] > '6' {
+		return false
+	}
+	if strings.EqualFold(s[1:], "xx") {
+		return true
+	}
+	return s[1] >= '0' && s[1] <= '9' && s[2] >= '0' && s[2] <= '9'
+}
+
+// validateHEP checks the HEP collector settings.
+func validateHEP(h HEPConfig) error {
+	if h.Transport != "udp" && h.Transport != "tcp" {
+		return fmt.Errorf("invalid transport %q (must be udp or tcp)", h.Transport)
+	}
+	if h.CaptureID < 0 || h.CaptureID > math.MaxUint32 {
+		return fmt.Errorf("capture_id %d out of range", h.CaptureID)
+	}
+	if h.Address == "" {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(h.Address)
+	if err != nil || host == "" {
+		return fmt.Errorf("invalid address %q (must be host:port)", h.Address)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid address %q (must be host:port)", h.Address)
+	}
+	return nil
+}
+
+// validateTraceLog checks the trace log settings.
+func validateTraceLog(l TraceLogConfig) error {
+	if l.Format != "text" && l.Format != "jsonl" {
+		return fmt.Errorf("invalid format %q (must be text or jsonl)", l.Format)
+	}
+	if l.MaxSize < 0 {
+		return fmt.Errorf("max_size must be positive")
+	}
+	if l.RotateInterval < 0 {
+		return fmt.Errorf("rotate_interval must be positive")
+	}
+	if l.Keep < 0 {
+		return fmt.Errorf("keep must be positive")
+	}
+	return nil
+}
+
+// isValidDNSServer accepts "ip", "ip:port" and "[ipv6]:port".
+func isValidDNSServer(s string) bool {
+	host := s
+	if h, port, err := net.SplitHostPort(s); err == nil {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return false
+		}
+		host = h
+	}
+	return net.ParseIP(strings.Trim(host, "[]")) != nil
+}
+
+// validateAddressing checks the hosts of the account's SIP URIs (IPv6
+// literals must be bracketed, RFC 5118 §4.1) and that ip_family agrees with
+// the bind address, IP-literal registrar and public address.
+func validateAddressing(a AccountConfig, bindIP net.IP) error {
+	if !isValidIPFamily(a.IPFamily) {
+		return fmt.Errorf("invalid ip_family %q (must be auto, ipv4, or ipv6)", a.IPFamily)
+	}
+	uris := map[string]string{"sip_uri": a.SipURI, "registrar": a.Registrar, "outbound_proxy": a.OutboundProxy}
+	for _, name := range []string{"sip_uri", "registrar", "outbound_proxy"} {
+		if uris[name] == "" {
+			continue
+		}
+		if _, _, err := uriHostPort(uris[name]); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	for _, r := range a.Routes {
+		if _, _, err := uriHostPort(r); err != nil {
+			return fmt.Errorf("route: %w", err)
+		}
+	}
+
+	switch a.IPFamily {
+	case "ipv6":
+		if bindIP.To4() != nil {
+			return fmt.Errorf("ip_family = \"ipv6\" needs an IPv6 bind_host (e.g. \"::\"), not %q", bindIP)
+		}
+	case "ipv4":
+		if bindIP.To4() == nil && !bindIP.IsUnspecified() {
+			return fmt.Errorf("ip_family = \"ipv4\" needs an IPv4 or unspecified bind_host, not %q", bindIP)
+		}
+	}
+	if host, _, _ := uriHostPort(a.Registrar); !familyMatches(a.IPFamily, net.ParseIP(strings.Trim(host, "[]"))) {
+		return fmt.Errorf("registrar %q is not an %s address", a.Registrar, a.IPFamily)
+	}
+	if !familyMatches(a.IPFamily, net.ParseIP(a.NAT.PublicAddress)) {
+		return fmt.Errorf("nat: public_address %q is not an %s address", a.NAT.PublicAddress, a.IPFamily)
+	}
 	return nil
 }
 
+// familyMatches reports whether ip (nil for a hostname) fits family.
+func familyMatches(family string, ip net.IP) bool {
+	switch {
+	case ip == nil:
+		return true
+	case family == "ipv4":
+		return ip.To4() != nil
+	case family == "ipv6":
+		return ip.To4() == nil
+	}
+	return true
+}
+
+// splitUserinfo splits a sip:/sips: URI, with or without angle brackets,
+// into its userinfo (empty when there is none) and the host part that
+// follows. ok is false when the scheme is missing.
+func splitUserinfo(uri string) (userinfo, rest string, ok bool) {
+	uri = strings.Trim(strings.TrimSpace(uri), "<>")
+	_, rest, ok = strings.Cut(uri, ":")
+	if !ok || !isSIPURI(uri) {
+		return "", "", false
+	}
+	// The host part cannot contain '@', so the last one ends the userinfo.
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		return rest[:i], rest[i+1:], true
+	}
+	return "", rest, true
+}
+
+// uriHostPort returns the host (IPv6 literals keep their brackets) and port
+// (0 if absent) of a sip:/sips: URI.
+func uriHostPort(uri string) (host string, port int, err error) {
+	_, rest, ok := splitUserinfo(uri)
+	if !ok {
+		return "", 0, fmt.Errorf("%q is not a sip: or sips: URI", uri)
+	}
+	rest, _, _ = strings.Cut(rest, ";")
+	rest, _, _ = strings.Cut(rest, "?")
+
+	var portStr string
+	if strings.HasPrefix(rest, "[") {
+		end := strings.Index(rest, "]")
+		if end < 0 {
+			return "", 0, fmt.Errorf("unterminated IPv6 reference in %q", uri)
+		}
+		if ip := net.ParseIP(rest[1:end]); ip == nil || ip.To4() != nil {
+			return "", 0, fmt.Errorf("invalid IPv6 address %q in %q", rest[1:end], uri)
+		}
+		host, rest = rest[:end+1], rest[end+1:]
+		if rest != "" {
+			if rest[0] != ':' {
+				return "", 0, fmt.Errorf("unexpected %q after IPv6 reference in %q", rest, uri)
+			}
+			portStr = rest[1:]
+		}
+	} else {
+		host, portStr, _ = strings.Cut(rest, ":")
+		if strings.Contains(portStr, ":") {
+			return "", 0, fmt.Errorf("IPv6 address in %q must be enclosed in brackets", uri)
+		}
+	}
+	if host == "" {
+		return "", 0, fmt.Errorf("missing host in %q", uri)
+	}
+	if portStr != "" {
+		if port, err = strconv.Atoi(portStr); err != nil || port < 1 || port > 65535 {
+			return "", 0, fmt.Errorf("invalid port %q in %q", portStr, uri)
+		}
+	}
+	return host, port, nil
+}
+
+func isValidIPFamily(f string) bool {
+	switch f {
+	case "auto", "ipv4", "ipv6":
+		return true
+	}
+	return false
+}
+
 func isValidTransport(t string) bool {
 	switch t {
-	case "udp", "tcp", "tls":
+	case "udp", "tcp", "tls", "ws", "wss":
+		return true
+	}
+	return false
+}
+
+// IsSecureTransport reports whether t runs over TLS and so may carry sips: URIs.
+func IsSecureTransport(t string) bool {
+	return t == "tls" || t == "wss"
+}
+
+// IsWebSocket reports whether t is one of the RFC 7118 WebSocket transports.
+func IsWebSocket(t string) bool {
+	return t == "ws" || t == "wss"
+}
+
+// defaultWSURL builds a WebSocket URL from the registrar host and port for
+// ws/wss accounts without ws_url.
+func defaultWSURL(a AccountConfig) string {
+	if !IsWebSocket(a.Transport) {
+		return ""
+	}
+	host, port, err := uriHostPort(a.Registrar)
+	if err != nil {
+		return ""
+	}
+	if port != 0 {
+		host += ":" + strconv.Itoa(port)
+	}
+	return a.Transport + "://" + host + "/"
+}
+
+// validateWebSocket checks ws_url against the transport. CRLF keepalives
+// have no WebSocket framing, so only OPTIONS keepalives are allowed.
+func validateWebSocket(a AccountConfig) error {
+	if !IsWebSocket(a.Transport) {
+		if a.WSURL != "" {
+			return fmt.Errorf("ws_url requires transport = \"ws\" or \"wss\"")
+		}
+		return nil
+	}
+	u, err := url.Parse(a.WSURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid ws_url %q", a.WSURL)
+	}
+	if u.Scheme != a.Transport {
+		return fmt.Errorf("ws_url %q must use the %s:// scheme to match transport = %q", a.WSURL, a.Transport, a.Transport)
+	}
+	if u.Scheme == "wss" && u.RequestURI() != "/" {
+		return fmt.Errorf("ws_url %q: wss supports only the root path (sipgo's wss handshake always asks for /)", a.WSURL)
+	}
+	if a.Keepalive == "crlf" {
+		return fmt.Errorf("keepalive = \"crlf\" is not available over WebSocket (use options)")
+	}
+	return nil
+}
+
+// validateShared checks the settings sipgo applies to every account alike:
+// they must not conflict between accounts.
+func validateShared(accounts []AccountConfig) error {
+	// sipgo makes every WebSocket handshake with one dialer.
+	var edge *url.URL
+	for i, a := range accounts {
+		if !IsWebSocket(a.Transport) {
+			continue
+		}
+		u, err := url.Parse(a.WSURL)
+		if err != nil {
+			continue // reported by validateWebSocket
+		}
+		if edge == nil {
+			edge = u
+		} else if u.Host != edge.Host || u.RequestURI() != edge.RequestURI() {
+			return fmt.Errorf("account %d: ws_url %q differs from %q: all ws/wss accounts must use one WebSocket host and path", i, a.WSURL, edge.String())
+		}
+	}
+	return nil
+}
+
+// validateTLS checks the [accounts.tls] table and that sips: URIs are only
+// used with a TLS transport, tls or wss (RFC 3261 §26.2.2 forbids sips over plain transports).
+func validateTLS(a AccountConfig) error {
+	if !IsSecureTransport(a.Transport) {
+		if IsSIPS(a.SipURI) {
+			return fmt.Errorf("sip_uri %q requires transport = \"tls\" or \"wss\"", a.SipURI)
+		}
+		if IsSIPS(a.Registrar) {
+			return fmt.Errorf("registrar %q requires transport = \"tls\" or \"wss\"", a.Registrar)
+		}
+	}
+	if (a.TLS.CertFile == "") != (a.TLS.KeyFile == "") {
+		return fmt.Errorf("tls: cert_file and key_file must be set together")
+	}
+	if !isValidTLSVersion(a.TLS.MinVersion) {
+		return fmt.Errorf("tls: invalid min_version %q (must be 1.0, 1.1, 1.2, or 1.3)", a.TLS.MinVersion)
+	}
+	return nil
+}
+
+// validateRoutes checks outbound_proxy and routes are SIP URIs, and that a
+// sips: proxy is only used over TLS.
+func validateRoutes(a AccountConfig) error {
+	if a.OutboundProxy != "" {
+		if !isSIPURI(a.OutboundProxy) {
+			return fmt.Errorf("outbound_proxy %q is not a sip: or sips: URI", a.OutboundProxy)
+		}
+		if IsSIPS(a.OutboundProxy) && !IsSecureTransport(a.Transport) {
+			return fmt.Errorf("outbound_proxy %q requires transport = \"tls\" or \"wss\"", a.OutboundProxy)
+		}
+	}
+	for _, r := range a.Routes {
+		if !isSIPURI(r) {
+			return fmt.Errorf("route %q is not a sip: or sips: URI", r)
+		}
+	}
+	return nil
+}
+
+func isSIPURI(uri string) bool {
+	uri = strings.ToLower(strings.Trim(uri, "<>"))
+	return strings.HasPrefix(uri, "sip:") || strings.HasPrefix(uri, "sips:")
+}
+
+// IsSIPS reports whether uri uses the secure sips: scheme.
+func IsSIPS(uri string) bool {
+	return strings.HasPrefix(strings.ToLower(uri), "sips:")
+}
+
+func isValidTLSVersion(v string) bool {
+	switch v {
+	case "1.0", "1.1", "1.2", "1.3":
+		return true
+	}
+	return false
+}
+
+func isValidMediaEncryption(m string) bool {
+	switch m {
+	case "none", "sdes", "dtls":
+		return true
+	}
+	return false
+}
+
+func isValidMediaEncryptionMode(m string) bool {
+	switch m {
+	case "optional", "mandatory":
+		return true
+	}
+	return false
+}
+
+// Codecs are the codec names accepted in an account's codecs list, in
+// their usual spelling.
+var Codecs = []string{"PCMU", "PCMA", "G722", "opus", "telephone-event"}
+
+// ValidateCodecs checks an account's codec list, ptime and opus settings,
+// configured or rearranged in the codecs screen.
+func ValidateCodecs(a AccountConfig) error {
+	seen := make(map[string]bool)
+	for _, c := range a.Codecs {
+		name := strings.ToLower(c)
+		if !slices.ContainsFunc(Codecs, func(k string) bool { return strings.EqualFold(k, c) }) {
+			return fmt.Errorf("unknown codec %q (must be one of %s)", c, strings.Join(Codecs, ", "))
+		}
+		if seen[name] {
+			return fmt.Errorf("codec %q listed twice", c)
+		}
+		seen[name] = true
+	}
+	if len(seen) == 0 || len(seen) == 1 && seen["telephone-event"] {
+		return fmt.Errorf("codecs has no audio codec besides telephone-event")
+	}
+	switch a.Ptime {
+	case 10, 20, 30, 40, 60:
+	default:
+		return fmt.Errorf("invalid ptime %d (must be 10, 20, 30, 40 or 60)", a.Ptime)
+	}
+	if pt := a.Opus.PayloadType; pt < 96 || pt > 127 {
+		return fmt.Errorf("opus: payload_type %d is not a dynamic payload type (96-127)", pt)
+	}
+	if a.Opus.PayloadType == 101 && seen["opus"] && seen["telephone-event"] {
+		return fmt.Errorf("opus: payload_type 101 is telephone-event's")
+	}
+	if r := a.Opus.MaxAverageBitrate; r != 0 && (r < 6000 || r > 510000) {
+		return fmt.Errorf("opus: maxaveragebitrate %d out of range (6000-510000)", r)
+	}
+	if r := a.Opus.MaxPlaybackRate; r != 0 && (r < 8000 || r > 48000) {
+		return fmt.Errorf("opus: maxplaybackrate %d out of range (8000-48000)", r)
+	}
+	return nil
+}
+
+func isValidKeepalive(k string) bool {
+	switch k {
+	case "none", "options", "crlf":
 		return true
 	}
 	return false
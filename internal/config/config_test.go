@@ -192,7 +192,7 @@ func TestInvalidTransport(t *testing.T) {
 name = "bad-transport"
 sip_uri = "sip:alice@example.com"
 registrar = "sip:reg.example.com"
-transport = "ws"
+transport = "sctp"
 `
 	path := writeTestConfig(t, tomlData)
 	_, err := Load(path)
@@ -396,3 +396,951 @@ func TestLoadInvalidTOML(t *testing.T) {
 		t.Fatal("expected error for invalid TOML")
 	}
 }
+
+func TestTLSSettings(t *testing.T) {
+	tomlData := `
+[[accounts]]
+name = "secure"
+sip_uri = "sips:alice@pbx.example.com"
+registrar = "sips:pbx.example.com"
+transport = "tls"
+
+[accounts.tls]
+ca_file = "/etc/siptty/ca.pem"
+cert_file = "/etc/siptty/client.pem"
+key_file = "/etc/siptty/client.key"
+server_name = "sbc.example.com"
+insecure_skip_verify = true
+min_version = "1.3"
+`
+	path := writeTestConfig(t, tomlData)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+
+	tls := cfg.Accounts[0].TLS
+	if tls.CAFile != "/etc/siptty/ca.pem" {
+		t.Errorf("TLS.CAFile = %q, want /etc/siptty/ca.pem", tls.CAFile)
+	}
+	if tls.CertFile != "/etc/siptty/client.pem" || tls.KeyFile != "/etc/siptty/client.key" {
+		t.Errorf("TLS cert/key = %q/%q, want client.pem/client.key", tls.CertFile, tls.KeyFile)
+	}
+	if tls.ServerName != "sbc.example.com" {
+		t.Errorf("TLS.ServerName = %q, want sbc.example.com", tls.ServerName)
+	}
+	if !tls.InsecureSkipVerify {
+		t.Error("TLS.InsecureSkipVerify = false, want true")
+	}
+	if tls.MinVersion != "1.3" {
+		t.Errorf("TLS.MinVersion = %q, want 1.3", tls.MinVersion)
+	}
+}
+
+func TestTLSMinVersionDefault(t *testing.T) {
+	path := writeTestConfig(t, fullConfig)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Accounts[0].TLS.MinVersion != "1.2" {
+		t.Errorf("default TLS.MinVersion = %q, want 1.2", cfg.Accounts[0].TLS.MinVersion)
+	}
+}
+
+func TestTLSValidationErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		account string
+		wantErr string
+	}{
+		{
+			name: "sips uri over udp",
+			account: `sip_uri = "sips:alice@example.com"
+registrar = "sip:reg.example.com"`,
+			wantErr: "requires transport",
+		},
+		{
+			name: "sips registrar over tcp",
+			account: `sip_uri = "sip:alice@example.com"
+registrar = "sips:reg.example.com"
+transport = "tcp"`,
+			wantErr: "requires transport",
+		},
+		{
+			name: "cert without key",
+			account: `sip_uri = "sip:alice@example.com"
+registrar = "sip:reg.example.com"
+transport = "tls"
+tls = { cert_file = "/tmp/client.pem" }`,
+			wantErr: "cert_file and key_file",
+		},
+		{
+			name: "bad min version",
+			account: `sip_uri = "sip:alice@example.com"
+registrar = "sip:reg.example.com"
+transport = "tls"
+tls = { min_version = "1.4" }`,
+			wantErr: "min_version",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestConfig(t, "[[accounts]]\nname = \"x\"\n"+tt.account+"\n")
+			_, err := Load(path)
+			if err == nil {
+				t.Fatalf("expected error containing %q", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error %q should mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMediaEncryption(t *testing.T) {
+	tomlData := `
+[[accounts]]
+name = "srtp"
+sip_uri = "sip:alice@example.com"
+registrar = "sip:reg.example.com"
+transport = "tls"
+media_encryption = "sdes"
+media_encryption_mode = "mandatory"
+`
+	path := writeTestConfig(t, tomlData)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	a := cfg.Accounts[0]
+	if a.MediaEncryption != "sdes" || a.MediaEncryptionMode != "mandatory" {
+		t.Errorf("media encryption = %q/%q, want sdes/mandatory", a.MediaEncryption, a.MediaEncryptionMode)
+	}
+
+	cfg, err = Load(writeTestConfig(t, fullConfig))
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	a = cfg.Accounts[0]
+	if a.MediaEncryption != "none" || a.MediaEncryptionMode != "optional" {
+		t.Errorf("default media encryption = %q/%q, want none/optional", a.MediaEncryption, a.MediaEncryptionMode)
+	}
+}
+
+func TestInvalidMediaEncryption(t *testing.T) {
+	for _, line := range []string{`media_encryption = "zrtp"`, `media_encryption_mode = "sometimes"`} {
+		tomlData := `
+[[accounts]]
+name = "bad"
+sip_uri = "sip:alice@example.com"
+registrar = "sip:reg.example.com"
+` + line + "\n"
+		_, err := Load(writeTestConfig(t, tomlData))
+		if err == nil {
+			t.Fatalf("expected error for %s", line)
+		}
+		if !strings.Contains(err.Error(), "media_encryption") {
+			t.Errorf("error %q should mention media_encryption", err)
+		}
+	}
+}
+
+func TestMediaEncryptionPerAccount(t *testing.T) {
+	tomlData := `
+[[accounts]]
+name = "srtp"
+sip_uri = "sip:alice@example.com"
+registrar = "sip:reg.example.com"
+transport = "tls"
+media_encryption = "sdes"
+
+[[accounts]]
+name = "plain"
+sip_uri = "sip:bob@example.com"
+registrar = "sip:reg.example.com"
+`
+	cfg, err := Load(writeTestConfig(t, tomlData))
+	if err != nil {
+		t.Fatalf("Load() with media_encryption differing per account: %v", err)
+	}
+	if cfg.Accounts[0].MediaEncryption != "sdes" || cfg.Accounts[1].MediaEncryption != "none" {
+		t.Errorf("media_encryption = %q, %q, want sdes, none", cfg.Accounts[0].MediaEncryption, cfg.Accounts[1].MediaEncryption)
+	}
+}
+
+func TestNATSettings(t *testing.T) {
+	tomlData := `
+[[accounts]]
+name = "behind-nat"
+sip_uri = "sip:alice@example.com"
+registrar = "sip:reg.example.com"
+
+[accounts.nat]
+rewrite_contact = true
+stun_server = "stun:stun.example.com:3478"
+public_address = "203.0.113.7"
+`
+	cfg, err := Load(writeTestConfig(t, tomlData))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	nat := cfg.Accounts[0].NAT
+	if !nat.RewriteContact {
+		t.Error("RewriteContact = false, want true")
+	}
+	if nat.STUNServer != "stun:stun.example.com:3478" {
+		t.Errorf("STUNServer = %q, want %q", nat.STUNServer, "stun:stun.example.com:3478")
+	}
+	if nat.PublicAddress != "203.0.113.7" {
+		t.Errorf("PublicAddress = %q, want %q", nat.PublicAddress, "203.0.113.7")
+	}
+}
+
+func TestNATPerAccount(t *testing.T) {
+	tomlData := `
+[[accounts]]
+name = "a"
+sip_uri = "sip:alice@example.com"
+registrar = "sip:reg.example.com"
+
+[accounts.nat]
+stun_server = "stun.example.com"
+public_address = "203.0.113.7"
+
+[[accounts]]
+name = "b"
+sip_uri = "sip:bob@example.com"
+registrar = "sip:reg.example.com"
+
+[accounts.nat]
+rewrite_contact = true
+stun_server = "stun.example.net"
+public_address = "203.0.113.8"
+`
+	cfg, err := Load(writeTestConfig(t, tomlData))
+	if err != nil {
+		t.Fatalf("Load() with nat settings differing per account: %v", err)
+	}
+	if cfg.Accounts[1].NAT.STUNServer != "stun.example.net" || cfg.Accounts[1].NAT.PublicAddress != "203.0.113.8" {
+		t.Errorf("account 1 nat = %+v", cfg.Accounts[1].NAT)
+	}
+}
+
+func TestICESettings(t *testing.T) {
+	tomlData := `
+[[accounts]]
+name = "ice"
+sip_uri = "sip:alice@example.com"
+registrar = "sip:reg.example.com"
+
+[accounts.nat]
+ice_enabled = true
+stun_server = "stun.example.com"
+turn_server = "turn:turn.example.com:3478"
+turn_user = "alice"
+turn_pass = "s3cret"
+`
+	cfg, err := Load(writeTestConfig(t, tomlData))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	nat := cfg.Accounts[0].NAT
+	if !nat.ICEEnabled {
+		t.Error("ICEEnabled = false, want true")
+	}
+	if nat.TURNServer != "turn:turn.example.com:3478" {
+		t.Errorf("TURNServer = %q, want %q", nat.TURNServer, "turn:turn.example.com:3478")
+	}
+	if nat.TURNUser != "alice" || nat.TURNPass != "s3cret" {
+		t.Errorf("TURN credentials = %q/%q, want alice/s3cret", nat.TURNUser, nat.TURNPass)
+	}
+}
+
+func TestTURNRequiresCredentials(t *testing.T) {
+	tomlData := `
+[[accounts]]
+name = "bad"
+sip_uri = "sip:alice@example.com"
+registrar = "sip:reg.example.com"
+
+[accounts.nat]
+ice_enabled = true
+turn_server = "turn.example.com"
+`
+	_, err := Load(writeTestConfig(t, tomlData))
+	if err == nil {
+		t.Fatal("expected error for turn_server without credentials")
+	}
+	if !strings.Contains(err.Error(), "turn_user") {
+		t.Errorf("error %q should mention turn_user", err)
+	}
+}
+
+func TestNATDefaults(t *testing.T) {
+	tomlData := `
+[[accounts]]
+name = "plain"
+sip_uri = "sip:alice@example.com"
+registrar = "sip:reg.example.com"
+`
+	cfg, err := Load(writeTestConfig(t, tomlData))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if nat := cfg.Accounts[0].NAT; nat != (NATConfig{}) {
+		t.Errorf("NAT = %+v, want zero value", nat)
+	}
+}
+
+func TestInvalidPublicAddress(t *testing.T) {
+	tomlData := `
+[[accounts]]
+name = "bad"
+sip_uri = "sip:alice@example.com"
+registrar = "sip:reg.example.com"
+
+[accounts.nat]
+public_address = "pbx.example.com"
+`
+	_, err := Load(writeTestConfig(t, tomlData))
+	if err == nil {
+		t.Fatal("expected error for non-IP public_address")
+	}
+	if !strings.Contains(err.Error(), "public_address") {
+		t.Errorf("error %q should mention public_address", err)
+	}
+}
+
+func TestKeepaliveDefaults(t *testing.T) {
+	tomlData := `
+[[accounts]]
+name = "plain"
+sip_uri = "sip:alice@example.com"
+registrar = "sip:reg.example.com"
+`
+	cfg, err := Load(writeTestConfig(t, tomlData))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	a := cfg.Accounts[0]
+	if a.Keepalive != "none" {
+		t.Errorf("Keepalive = %q, want %q", a.Keepalive, "none")
+	}
+	if a.KeepaliveInterval != 30 {
+		t.Errorf("KeepaliveInterval = %d, want 30", a.KeepaliveInterval)
+	}
+}
+
+func TestKeepaliveSettings(t *testing.T) {
+	tomlData := `
+[[accounts]]
+name = "pinged"
+sip_uri = "sip:alice@example.com"
+registrar = "sip:reg.example.com"
+transport = "tcp"
+keepalive = "crlf"
+keepalive_interval = 15
+`
+	cfg, err := Load(writeTestConfig(t, tomlData))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	a := cfg.Accounts[0]
+	if a.Keepalive != "crlf" || a.KeepaliveInterval != 15 {
+		t.Errorf("keepalive = %q every %ds, want crlf every 15s", a.Keepalive, a.KeepaliveInterval)
+	}
+}
+
+func TestInvalidKeepalive(t *testing.T) {
+	for _, line := range []string{`keepalive = "stun"`, `keepalive_interval = -5`} {
+		tomlData := `
+[[accounts]]
+name = "bad"
+sip_uri = "sip:alice@example.com"
+registrar = "sip:reg.example.com"
+` + line + "\n"
+		_, err := Load(writeTestConfig(t, tomlData))
+		if err == nil {
+			t.Fatalf("expected error for %s", line)
+		}
+		if !strings.Contains(err.Error(), "keepalive") {
+			t.Errorf("error %q should mention keepalive", err)
+		}
+	}
+}
+
+func TestOutboundProxyAndRoutes(t *testing.T) {
+	tomlData := `
+[[accounts]]
+name = "ims"
+sip_uri = "sip:alice@ims.example.com"
+registrar = "sip:ims.example.com"
+outbound_proxy = "sip:sbc.example.com:5060"
+routes = ["sip:pcscf.ims.example.com;lr", "<sip:scscf.ims.example.com;lr>"]
+`
+	cfg, err := Load(writeTestConfig(t, tomlData))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	a := cfg.Accounts[0]
+	if a.OutboundProxy != "sip:sbc.example.com:5060" {
+		t.Errorf("OutboundProxy = %q, want %q", a.OutboundProxy, "sip:sbc.example.com:5060")
+	}
+	if len(a.Routes) != 2 || a.Routes[1] != "<sip:scscf.ims.example.com;lr>" {
+		t.Errorf("Routes = %q", a.Routes)
+	}
+}
+
+func TestRouteValidationErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		extra string
+		want  string
+	}{
+		{"proxy not a URI", `outbound_proxy = "sbc.example.com"`, "outbound_proxy"},
+		{"sips proxy over udp", `outbound_proxy = "sips:sbc.example.com"`, "tls"},
+		{"route not a URI", `routes = ["http://example.com"]`, "route"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tomlData := `
+[[accounts]]
+name = "bad"
+sip_uri = "sip:alice@example.com"
+registrar = "sip:reg.example.com"
+` + tt.extra + "\n"
+			_, err := Load(writeTestConfig(t, tomlData))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q should mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDNSServer(t *testing.T) {
+	for _, server := range []string{"127.0.0.1", "127.0.0.1:5353", "[::1]:53", "::1"} {
+		tomlData := `
+[general]
+dns_server = "` + server + `"
+
+[[accounts]]
+name = "dns"
+sip_uri = "sip:alice@example.com"
+registrar = "sip:example.com"
+`
+		cfg, err := Load(writeTestConfig(t, tomlData))
+		if err != nil {
+			t.Errorf("dns_server %q: unexpected error: %v", server, err)
+			continue
+		}
+		if cfg.General.DNSServer != server {
+			t.Errorf("DNSServer = %q, want %q", cfg.General.DNSServer, server)
+		}
+	}
+}
+
+func TestInvalidDNSServer(t *testing.T) {
+	for _, server := range []string{"dns.example.com", "127.0.0.1:0", "127.0.0.1:dns"} {
+		tomlData := `
+[general]
+dns_server = "` + server + `"
+
+[[accounts]]
+name = "dns"
+sip_uri = "sip:alice@example.com"
+registrar = "sip:example.com"
+`
+		_, err := Load(writeTestConfig(t, tomlData))
+		if err == nil {
+			t.Errorf("dns_server %q: expected error", server)
+			continue
+		}
+		if !strings.Contains(err.Error(), "dns_server") {
+			t.Errorf("error %q should mention dns_server", err)
+		}
+	}
+}
+
+func TestIPv6Account(t *testing.T) {
+	tomlData := `
+[[accounts]]
+name = "v6"
+sip_uri = "sip:alice@[2001:db8::10]:5060"
+registrar = "sip:[2001:db8::1]:5060;transport=udp"
+outbound_proxy = "sip:[2001:db8::2];lr"
+ip_family = "ipv6"
+
+[accounts.nat]
+public_address = "2001:db8::99"
+`
+	cfg, err := Load(writeTestConfig(t, tomlData))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a := cfg.Accounts[0]
+	if a.IPFamily != "ipv6" {
+		t.Errorf("IPFamily = %q, want ipv6", a.IPFamily)
+	}
+	if a.AuthUser != "alice" {
+		t.Errorf("AuthUser = %q, want alice", a.AuthUser)
+	}
+	if cfg.General.BindHost != "::" {
+		t.Errorf("BindHost = %q, want :: for an ipv6 account", cfg.General.BindHost)
+	}
+}
+
+func TestIPFamilyDefault(t *testing.T) {
+	tomlData := `
+[general]
+bind_host = "[::]"
+
+[[accounts]]
+name = "dual"
+sip_uri = "sip:alice@example.com"
+registrar = "sip:example.com"
+`
+	cfg, err := Load(writeTestConfig(t, tomlData))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Accounts[0].IPFamily != "auto" {
+		t.Errorf("IPFamily = %q, want auto", cfg.Accounts[0].IPFamily)
+	}
+	if cfg.General.BindHost != "::" {
+		t.Errorf("BindHost = %q, want brackets stripped", cfg.General.BindHost)
+	}
+}
+
+func TestIPv6ValidationErrors(t *testing.T) {
+	tests := []struct {
+		name, general, account, wantErr string
+	}{
+		{"unbracketed registrar", "", `registrar = "sip:2001:db8::1:5060"`, "enclosed in brackets"},
+		{"unterminated bracket", "", `registrar = "sip:[2001:db8::1:5060"`, "unterminated"},
+		{"bad IPv6 literal", "", `registrar = "sip:[2001:db8::zz]"`, "invalid IPv6 address"},
+		{"IPv4 in brackets", "", `registrar = "sip:[192.0.2.1]"`, "invalid IPv6 address"},
+		{"bad port", "", `registrar = "sip:[2001:db8::1]:99999"`, "invalid port"},
+		{"unbracketed route", "", "registrar = \"sip:example.com\"\nroutes = [\"sip:2001:db8::5;lr\"]", "route"},
+		{"bad ip_family", "", "registrar = \"sip:example.com\"\nip_family = \"ipx\"", "ip_family"},
+		{"ipv6 with IPv4 bind", `bind_host = "192.0.2.1"`, "registrar = \"sip:example.com\"\nip_family = \"ipv6\"", "bind_host"},
+		{"ipv4 with IPv6 bind", `bind_host = "2001:db8::1"`, "registrar = \"sip:example.com\"\nip_family = \"ipv4\"", "bind_host"},
+		{"family mismatch", "", "registrar = \"sip:[2001:db8::1]\"\nip_family = \"ipv4\"", "not an ipv4 address"},
+		{"bad bind_host", `bind_host = "localhost"`, `registrar = "sip:example.com"`, "bind_host"},
+	}
+	for _, tt := range tests {
+		tomlData := "[general]\n" + tt.general + `
+
+[[accounts]]
+name = "bad"
+sip_uri = "sip:alice@example.com"
+` + tt.account + "\n"
+		_, err := Load(writeTestConfig(t, tomlData))
+		if err == nil {
+			t.Errorf("%s: expected error", tt.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error %q should contain %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWebSocketTransport(t *testing.T) {
+	tomlData := `
+[[accounts]]
+name = "edge"
+sip_uri = "sips:alice@example.com"
+registrar = "sips:example.com"
+transport = "wss"
+ws_url = "wss://edge.example.com:8443/"
+`
+	cfg, err := Load(writeTestConfig(t, tomlData))
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if got := cfg.Accounts[0].WSURL; got != "wss://edge.example.com:8443/" {
+		t.Errorf("WSURL = %q, want the configured URL", got)
+	}
+
+	tomlData = `
+[[accounts]]
+name = "plain"
+sip_uri = "sip:bob@pbx.example.com"
+registrar = "sip:pbx.example.com:8088"
+transport = "ws"
+`
+	if cfg, err = Load(writeTestConfig(t, tomlData)); err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if got := cfg.Accounts[0].WSURL; got != "ws://pbx.example.com:8088/" {
+		t.Errorf("default WSURL = %q, want ws://pbx.example.com:8088/", got)
+	}
+}
+
+func TestWebSocketValidationErrors(t *testing.T) {
+	tests := []struct {
+		name, account, wantErr string
+	}{
+		{"scheme mismatch", "transport = \"wss\"\nws_url = \"ws://edge.example.com/\"", "wss:// scheme"},
+		{"no host", "transport = \"ws\"\nws_url = \"ws:///ws\"", "invalid ws_url"},
+		{"ws_url without ws", "transport = \"tcp\"\nws_url = \"ws://edge.example.com/\"", "ws_url requires transport"},
+		{"crlf keepalive", "transport = \"ws\"\nkeepalive = \"crlf\"", "not available over WebSocket"},
+		{"sips over ws", "transport = \"ws\"\nsip_uri = \"sips:alice@example.com\"", "requires transport"},
+		{"wss path", "transport = \"wss\"\nws_url = \"wss://edge.example.com/ws\"", "only the root path"},
+	}
+	for _, tt := range tests {
+		tomlData := `
+[[accounts]]
+name = "bad"
+registrar = "sip:example.com"
+` + tt.account + "\n"
+		if !strings.Contains(tt.account, "sip_uri") {
+			tomlData += "sip_uri = \"sip:alice@example.com\"\n"
+		}
+		_, err := Load(writeTestConfig(t, tomlData))
+		if err == nil {
+			t.Errorf("%s: expected error", tt.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error %q should contain %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWebSocketShared(t *testing.T) {
+	tomlData := `
+[[accounts]]
+name = "a"
+sip_uri = "sip:alice@example.com"
+registrar = "sip:example.com"
+transport = "ws"
+ws_url = "ws://edge.example.com:8088/ws"
+
+[[accounts]]
+name = "b"
+sip_uri = "sip:bob@example.com"
+registrar = "sip:example.com"
+transport = "ws"
+ws_url = "ws://edge.example.com:8088/sip"
+`
+	_, err := Load(writeTestConfig(t, tomlData))
+	if err == nil || !strings.Contains(err.Error(), "one WebSocket host and path") {
+		t.Errorf("Load() error = %v, want ws_url mismatch", err)
+	}
+
+	tomlData = strings.Replace(tomlData, "8088/sip", "8088/ws", 1)
+	if _, err := Load(writeTestConfig(t, tomlData)); err != nil {
+		t.Errorf("Load() with one ws_url: %v", err)
+	}
+}
+
+func TestDeriveAuthUser(t *testing.T) {
+	tests := map[string]string{
+		"sip:alice@example.com":        "alice",
+		"sips:bob@[2001:db8::1]:5061":  "bob",
+		"<sip:carol:secret@192.0.2.1>": "carol",
+		"sip:[2001:db8::1]":            "",
+	}
+	for in, want := range tests {
+		if got := deriveAuthUser(in); got != want {
+			t.Errorf("deriveAuthUser(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestTraceFilters(t *testing.T) {
+	tomlData := `
+[[accounts]]
+name = "ok"
+sip_uri = "sip:alice@example.com"
+registrar = "sip:reg.example.com"
+
+[trace]
+exclude_options = true
+max_messages = 200
+
+[[trace.filters]]
+name = "failed calls"
+methods = ["INVITE"]
+status = ["4xx", "5xx", "603"]
+
+[[trace.filters]]
+name = "from sbc"
+direction = "recv"
+remote = "192.0.2.10"
+regex = "(?i)^user-agent: .*asterisk"
+`
+	cfg, err := Load(writeTestConfig(t, tomlData))
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if !cfg.Trace.ExcludeOptions {
+		t.Error("ExcludeOptions = false, want true")
+	}
+	if cfg.Trace.MaxMessages != 200 || cfg.Trace.MaxBytes != 16<<20 {
+		t.Errorf("store limits = %d messages, %d bytes; want 200, 16 MiB default", cfg.Trace.MaxMessages, cfg.Trace.MaxBytes)
+	}
+	if len(cfg.Trace.Filters) != 2 {
+		t.Fatalf("got %d filters, want 2", len(cfg.Trace.Filters))
+	}
+	f := cfg.Trace.Filters[0]
+	if f.Name != "failed calls" || len(f.Methods) != 1 || len(f.Status) != 3 {
+		t.Errorf("filter = %+v", f)
+	}
+	if cfg.Trace.Filters[1].Direction != "recv" {
+		t.Errorf("direction = %q, want recv", cfg.Trace.Filters[1].Direction)
+	}
+}
+
+func TestHEPSettings(t *testing.T) {
+	tomlData := `
+[[accounts]]
+name = "ok"
+sip_uri = "sip:alice@example.com"
+registrar = "sip:reg.example.com"
+
+[trace.hep]
+address = "homer.example.com:9060"
+capture_id = 2001
+node_name = "siptty-lab"
+`
+	cfg, err := Load(writeTestConfig(t, tomlData))
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	h := cfg.Trace.HEP
+	if h.Address != "homer.example.com:9060" || h.Transport != "udp" || h.CaptureID != 2001 || h.NodeName != "siptty-lab" {
+		t.Errorf("hep = %+v, want udp default and the configured values", h)
+	}
+}
+
+func TestTraceLogSettings(t *testing.T) {
+	tomlData := `
+[[accounts]]
+name = "ok"
+sip_uri = "sip:alice@example.com"
+registrar = "sip:reg.example.com"
+
+[trace.log]
+path = "/var/log/siptty/trace.jsonl"
+format = "jsonl"
+rotate_interval = 3600
+compress = true
+`
+	cfg, err := Load(writeTestConfig(t, tomlData))
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	l := cfg.Trace.Log
+	if l.Format != "jsonl" || l.RotateInterval != 3600 || !l.Compress {
+		t.Errorf("log = %+v", l)
+	}
+	if l.MaxSize != 10<<20 || l.Keep != 10 {
+		t.Errorf("log limits = %d bytes, %d files; want 10 MiB and 10 by default", l.MaxSize, l.Keep)
+	}
+}
+
+func TestTraceFilterValidationErrors(t *testing.T) {
+	tests := []struct {
+		name, filters, wantErr string
+	}{
+		{"no name", "[[trace.filters]]\nmethods = [\"INVITE\"]", "name is required"},
+		{"duplicate", "[[trace.filters]]\nname = \"a\"\n[[trace.filters]]\nname = \"a\"", "duplicate name"},
+		{"bad status", "[[trace.filters]]\nname = \"a\"\nstatus = [\"7xx\"]", "invalid status"},
+		{"bad direction", "[[trace.filters]]\nname = \"a\"\ndirection = \"both\"", "invalid direction"},
+		{"bad regex", "[[trace.filters]]\nname = \"a\"\nregex = \"(\"", "invalid regex"},
+		{"negative max_bytes", "[trace]\nmax_bytes = -1", "max_bytes must be positive"},
+		{"hep without port", "[trace.hep]\naddress = \"homer.example.com\"", "invalid address"},
+		{"hep transport", "[trace.hep]\naddress = \"homer.example.com:9060\"\ntransport = \"tls\"", "invalid transport"},
+		{"log format", "[trace.log]\npath = \"trace.log\"\nformat = \"xml\"", "invalid format"},
+		{"log keep", "[trace.log]\npath = \"trace.log\"\nkeep = -1", "keep must be positive"},
+		{"hep capture_id", "[trace.hep]\naddress = \"homer.example.com:9060\"\ncapture_id = -1", "capture_id"},
+	}
+	for _, tt := range tests {
+		tomlData := `
+[[accounts]]
+name = "ok"
+sip_uri = "sip:alice@example.com"
+registrar = "sip:reg.example.com"
+
+` + tt.filters + "\n"
+		_, err := Load(writeTestConfig(t, tomlData))
+		if err == nil {
+			t.Errorf("%s: expected error", tt.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error %q should contain %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestQualityReportSettings(t *testing.T) {
+	tomlData := `
+[[accounts]]
+name = "ok"
+sip_uri = "sip:alice@example.com"
+registrar = "sip:reg.example.com"
+
+[accounts.quality_report]
+collector = "sip:collector@pbx.example.com"
+rtcp_xr = true
+`
+	cfg, err := Load(writeTestConfig(t, tomlData))
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	q := cfg.Accounts[0].QualityReport
+	if q.Collector != "sip:collector@pbx.example.com" || !q.RTCPXR {
+		t.Errorf("quality_report = %+v", q)
+	}
+
+	_, err = Load(writeTestConfig(t, strings.Replace(tomlData, "sip:collector@", "collector@", 1)))
+	if err == nil || !strings.Contains(err.Error(), "quality_report: collector") {
+		t.Errorf("Load() error = %v, want a quality_report collector error", err)
+	}
+}
+
+func TestImpairmentSettings(t *testing.T) {
+	tomlData := `
+[[accounts]]
+name = "lab"
+sip_uri = "sip:alice@example.com"
+registrar = "sip:reg.example.com"
+
+[accounts.impairment]
+loss = 5
+burst = 3
+delay = 80
+jitter = 20
+reorder = 1
+duplicate = 0.5
+sip_drop = 10
+sip_delay = 200
+`
+	cfg, err := Load(writeTestConfig(t, tomlData))
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	want := ImpairmentConfig{Loss: 5, Burst: 3, Delay: 80, Jitter: 20, Reorder: 1, Duplicate: 0.5, SIPDrop: 10, SIPDelay: 200}
+	if got := cfg.Accounts[0].Impairment; got != want {
+		t.Errorf("impairment = %+v, want %+v", got, want)
+	}
+
+	base := tomlData[:strings.Index(tomlData, "loss")]
+	for _, bad := range []string{"loss = 150", "sip_drop = -1", "jitter = -20", "burst = -2"} {
+		_, err := Load(writeTestConfig(t, base+bad+"\n"))
+		if err == nil || !strings.Contains(err.Error(), "impairment: ") {
+			t.Errorf("%s: Load() error = %v, want an impairment error", bad, err)
+		}
+	}
+
+	// SIP is only impaired over UDP; RTP is on any transport.
+	tcp := strings.Replace(base, `registrar = "sip:reg.example.com"`, `registrar = "sip:reg.example.com"
+transport = "tcp"`, 1)
+	if _, err := Load(writeTestConfig(t, tcp+"loss = 5\n")); err != nil {
+		t.Errorf("loss over tcp: Load() error = %v", err)
+	}
+	_, err = Load(writeTestConfig(t, tcp+"sip_delay = 200\n"))
+	if err == nil || !strings.Contains(err.Error(), "need transport udp") {
+		t.Errorf("sip_delay over tcp: Load() error = %v, want a transport error", err)
+	}
+}
+
+func TestCodecSettings(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, `
+[[accounts]]
+name = "default"
+sip_uri = "sip:alice@example.com"
+registrar = "sip:reg.example.com"
+`))
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	def := cfg.Accounts[0]
+	if strings.Join(def.Codecs, ",") != "PCMU,PCMA,telephone-event" || def.Ptime != 20 || def.Opus.PayloadType != 96 {
+		t.Errorf("default codecs = %v, ptime %d, opus PT %d", def.Codecs, def.Ptime, def.Opus.PayloadType)
+	}
+
+	tomlData := `
+[[accounts]]
+name = "wideband"
+sip_uri = "sip:bob@example.com"
+registrar = "sip:reg.example.com"
+codecs = ["opus", "g722", "PCMU", "telephone-event"]
+ptime = 40
+
+[accounts.opus]
+payload_type = 111
+maxaveragebitrate = 24000
+stereo = true
+useinbandfec = true
+maxplaybackrate = 16000
+`
+	cfg, err = Load(writeTestConfig(t, tomlData))
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	wb := cfg.Accounts[0]
+	if strings.Join(wb.Codecs, ",") != "opus,g722,PCMU,telephone-event" || wb.Ptime != 40 {
+		t.Errorf("codecs = %v, ptime %d", wb.Codecs, wb.Ptime)
+	}
+	wantOpus := OpusConfig{PayloadType: 111, MaxAverageBitrate: 24000, Stereo: true, UseInbandFEC: true, MaxPlaybackRate: 16000}
+	if wb.Opus != wantOpus {
+		t.Errorf("opus = %+v, want %+v", wb.Opus, wantOpus)
+	}
+
+	for _, tc := range []struct{ old, new, want string }{
+		{`"g722"`, `"g729"`, "unknown codec"},
+		{`"g722"`, `"pcmu"`, "listed twice"},
+		{`codecs = ["opus", "g722", "PCMU", "telephone-event"]`, `codecs = ["telephone-event"]`, "no audio codec"},
+		{"ptime = 40", "ptime = 25", "invalid ptime"},
+		{"payload_type = 111", "payload_type = 8", "opus: payload_type"},
+		{"payload_type = 111", "payload_type = 101", "telephone-event's"},
+		{"maxaveragebitrate = 24000", "maxaveragebitrate = 1000", "opus: maxaveragebitrate 1000"},
+		{"maxplaybackrate = 16000", "maxplaybackrate = 96000", "opus: maxplaybackrate 96000"},
+	} {
+		_, err := Load(writeTestConfig(t, strings.Replace(tomlData, tc.old, tc.new, 1)))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: Load() error = %v, want %q", tc.new, err, tc.want)
+		}
+	}
+}
+
+// TestCodecsPerAccount checks that each account keeps its own codecs,
+// ptime and opus settings.
+func TestCodecsPerAccount(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, `
+[[accounts]]
+name = "a"
+sip_uri = "sip:a@example.com"
+registrar = "sip:reg.example.com"
+codecs = ["opus", "PCMU"]
+ptime = 40
+
+[accounts.opus]
+payload_type = 111
+
+[[accounts]]
+name = "b"
+sip_uri = "sip:b@example.com"
+registrar = "sip:reg.example.com"
+codecs = ["PCMA"]
+`))
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	a, b := cfg.Accounts[0], cfg.Accounts[1]
+	if strings.Join(a.Codecs, ",") != "opus,PCMU" || a.Ptime != 40 || a.Opus.PayloadType != 111 {
+		t.Errorf("account a: codecs %v, ptime %d, opus PT %d", a.Codecs, a.Ptime, a.Opus.PayloadType)
+	}
+	if strings.Join(b.Codecs, ",") != "PCMA" || b.Ptime != 20 || b.Opus.PayloadType != 96 {
+		t.Errorf("account b: codecs %v, ptime %d, opus PT %d", b.Codecs, b.Ptime, b.Opus.PayloadType)
+	}
+}
@@ -0,0 +1,320 @@
+// Package dns resolves SIP URIs to transport addresses per RFC 3263
+// (NAPTR, then SRV, then A/AAAA). It talks to a DNS server directly,
+// because the standard library has no NAPTR lookup, and so that a
+// configured server — such as a local stub in tests — can be used.
+// Without one, addresses are left to the system resolver.
+package dns
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Record types.
+const (
+	TypeA     uint16 = 1
+	TypeAAAA  uint16 = 28
+	TypeSRV   uint16 = 33
+	TypeNAPTR uint16 = 35
+)
+
+const classIN = 1
+
+// ErrNotFound is returned when a name has no records of the requested type.
+var ErrNotFound = errors.New("no such record")
+
+// Record is a decoded resource record. Only the fields for its Type are set.
+type Record struct {
+	Name string
+	Type uint16
+	TTL  uint32
+
+	IP net.IP // A, AAAA
+
+	Priority uint16 // SRV
+	Weight   uint16
+	Port     uint16
+	Target   string
+
+	Order       uint16 // NAPTR
+	Preference  uint16
+	Flags       string
+	Service     string
+	Regexp      string
+	Replacement string
+}
+
+// Client sends queries to a single DNS server over UDP, retrying over TCP
+// when the answer is truncated.
+type Client struct {
+	Server  string // host:port
+	Timeout time.Duration
+}
+
+// NewClient returns a client for server ("host" or "host:port"); an empty
+// server means the first nameserver in /etc/resolv.conf.
+func NewClient(server string) *Client {
+	if server == "" {
+		server = systemNameserver()
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+	}
+	return &Client{Server: server, Timeout: 2 * time.Second}
+}
+
+// Lookup queries name for records of qtype, following nothing: CNAMEs and
+// other record types in the answer are skipped.
+func (c *Client) Lookup(ctx context.Context, name string, qtype uint16) ([]Record, error) {
+	query, id, err := buildQuery(name, qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.exchange(ctx, "udp", query)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) > 2 && resp[2]&0x02 != 0 { // TC: truncated
+		if resp, err = c.exchange(ctx, "tcp", query); err != nil {
+			return nil, err
+		}
+	}
+	return parseResponse(resp, id, qtype)
+}
+
+func (c *Client) exchange(ctx context.Context, network string, query []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, c.Server)
+	if err != nil {
+		return nil, fmt.Errorf("dns: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if network == "tcp" {
+		msg := make([]byte, 2+len(query))
+		binary.BigEndian.PutUint16(msg, uint16(len(query)))
+		copy(msg[2:], query)
+		if _, err := conn.Write(msg); err != nil {
+			return nil, fmt.Errorf("dns: %w", err)
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, fmt.Errorf("dns: %w", err)
+		}
+		resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, resp); err != nil {
+			return nil, fmt.Errorf("dns: %w", err)
+		}
+		return resp, nil
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, fmt.Errorf("dns: %w", err)
+	}
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, fmt.Errorf("dns: %w", err)
+	}
+	return buf[:n], nil
+}
+
+// buildQuery encodes a recursive query for name/qtype.
+func buildQuery(name string, qtype uint16) ([]byte, uint16, error) {
+	var idb [2]byte
+	_, _ = rand.Read(idb[:])
+	id := binary.BigEndian.Uint16(idb[:])
+
+	msg := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	msg[2] = 0x01                          // RD
+	binary.BigEndian.PutUint16(msg[4:], 1) // QDCOUNT
+
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, 0, fmt.Errorf("dns: invalid name %q", name)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, classIN)
+	return msg, id, nil
+}
+
+// parseResponse decodes the answer section, keeping records of qtype.
+func parseResponse(msg []byte, id, qtype uint16) ([]Record, error) {
+	if len(msg) < 12 {
+		return nil, errors.New("dns: short response")
+	}
+	if binary.BigEndian.Uint16(msg[0:]) != id {
+		return nil, errors.New("dns: response ID mismatch")
+	}
+	switch rcode := msg[3] & 0x0f; rcode {
+	case 0:
+	case 3:
+		return nil, ErrNotFound // NXDOMAIN
+	default:
+		return nil, fmt.Errorf("dns: server returned rcode %d", rcode)
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+
+	off := 12
+	for range qdcount {
+		_, n, err := readName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = n + 4
+	}
+
+	var out []Record
+	for range ancount {
+		name, n, err := readName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = n
+		if off+10 > len(msg) {
+			return nil, errors.New("dns: truncated record")
+		}
+		r := Record{
+			Name: name,
+			Type: binary.BigEndian.Uint16(msg[off:]),
+			TTL:  binary.BigEndian.Uint32(msg[off+4:]),
+		}
+		rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+rdlen > len(msg) {
+			return nil, errors.New("dns: truncated record data")
+		}
+		if r.Type == qtype {
+			if err := parseRData(msg, off, rdlen, &r); err != nil {
+				return nil, err
+			}
+			out = append(out, r)
+		}
+		off += rdlen
+	}
+	if len(out) == 0 {
+		return nil, ErrNotFound
+	}
+	return out, nil
+}
+
+func parseRData(msg []byte, off, rdlen int, r *Record) error {
+	data := msg[off : off+rdlen]
+	var err error
+	switch r.Type {
+	case TypeA, TypeAAAA:
+		if (r.Type == TypeA && rdlen != 4) || (r.Type == TypeAAAA && rdlen != 16) {
+			return errors.New("dns: bad address length")
+		}
+		r.IP = append(net.IP(nil), data...)
+	case TypeSRV:
+		if rdlen < 7 {
+			return errors.New("dns: short SRV record")
+		}
+		r.Priority = binary.BigEndian.Uint16(data[0:])
+		r.Weight = binary.BigEndian.Uint16(data[2:])
+		r.Port = binary.BigEndian.Uint16(data[4:])
+		r.Target, _, err = readName(msg, off+6)
+	case TypeNAPTR:
+		if rdlen < 7 {
+			return errors.New("dns: short NAPTR record")
+		}
+		r.Order = binary.BigEndian.Uint16(data[0:])
+		r.Preference = binary.BigEndian.Uint16(data[2:])
+		p := off + 4
+		if r.Flags, p, err = readString(msg, p); err != nil {
+			return err
+		}
+		if r.Service, p, err = readString(msg, p); err != nil {
+			return err
+		}
+		if r.Regexp, p, err = readString(msg, p); err != nil {
+			return err
+		}
+		r.Replacement, _, err = readName(msg, p)
+	}
+	return err
+}
+
+// readName decodes a possibly compressed domain name at off and returns it
+// with the offset just past it.
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errors.New("dns: truncated name")
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case l&0xc0 == 0xc0:
+			if off+1 >= len(msg) || jumps > 10 {
+				return "", 0, errors.New("dns: bad name pointer")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, errors.New("dns: truncated label")
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
+
+// readString decodes a <character-string>.
+func readString(msg []byte, off int) (string, int, error) {
+	if off >= len(msg) || off+1+int(msg[off]) > len(msg) {
+		return "", 0, errors.New("dns: truncated string")
+	}
+	l := int(msg[off])
+	return string(msg[off+1 : off+1+l]), off + 1 + l, nil
+}
+
+// systemNameserver returns the first nameserver from /etc/resolv.conf.
+func systemNameserver() string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "127.0.0.1:53"
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53")
+		}
+	}
+	return "127.0.0.1:53"
+}
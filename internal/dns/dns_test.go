@@ -0,0 +1,221 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type zoneKey struct {
+	name  string
+	qtype uint16
+}
+
+// startServer runs a stub DNS server on loopback answering from zone. Answer
+// owner names are compressed to point at the question, as real servers do.
+func startServer(t *testing.T, zone map[zoneKey][][]byte) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			q := buf[:n]
+			name, end, err := readName(q, 12)
+			if err != nil {
+				continue
+			}
+			qtype := binary.BigEndian.Uint16(q[end:])
+			answers := zone[zoneKey{strings.ToLower(name), qtype}]
+
+			resp := append([]byte(nil), q[:end+4]...)
+			resp[2] |= 0x80 // QR
+			binary.BigEndian.PutUint16(resp[6:], uint16(len(answers)))
+			for _, rdata := range answers {
+				resp = append(resp, 0xc0, 12)
+				resp = binary.BigEndian.AppendUint16(resp, qtype)
+				resp = binary.BigEndian.AppendUint16(resp, classIN)
+				resp = binary.BigEndian.AppendUint32(resp, 60)
+				resp = binary.BigEndian.AppendUint16(resp, uint16(len(rdata)))
+				resp = append(resp, rdata...)
+			}
+			_, _ = conn.WriteTo(resp, from)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func encodeName(name string) []byte {
+	var b []byte
+	for _, label := range strings.Split(name, ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+func aRecord(ip string) []byte { return net.ParseIP(ip).To4() }
+
+func srvRecord(priority, weight, port uint16, target string) []byte {
+	b := binary.BigEndian.AppendUint16(nil, priority)
+	b = binary.BigEndian.AppendUint16(b, weight)
+	b = binary.BigEndian.AppendUint16(b, port)
+	return append(b, encodeName(target)...)
+}
+
+func naptrRecord(order, pref uint16, flags, service, replacement string) []byte {
+	b := binary.BigEndian.AppendUint16(nil, order)
+	b = binary.BigEndian.AppendUint16(b, pref)
+	for _, s := range []string{flags, service, ""} {
+		b = append(b, byte(len(s)))
+		b = append(b, s...)
+	}
+	return append(b, encodeName(replacement)...)
+}
+
+func addrs(targets []Target) []string {
+	var out []string
+	for _, t := range targets {
+		out = append(out, t.Addr)
+	}
+	return out
+}
+
+func TestResolveNAPTR(t *testing.T) {
+	server := startServer(t, map[zoneKey][][]byte{
+		{"example.com", TypeNAPTR}: {
+			naptrRecord(20, 10, "s", "SIP+D2U", "_sip._udp.example.com"),
+			naptrRecord(10, 10, "s", "SIP+D2T", "_sip._tcp.example.com"),
+		},
+		{"_sip._tcp.example.com", TypeSRV}: {
+			srvRecord(20, 0, 5070, "backup.example.com"),
+			srvRecord(10, 0, 5080, "primary.example.com"),
+		},
+		{"primary.example.com", TypeA}: {aRecord("192.0.2.1")},
+		{"backup.example.com", TypeA}:  {aRecord("192.0.2.2")},
+	})
+
+	got, err := NewResolver(server).Resolve(context.Background(), "example.com", 0, "tcp")
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	want := []string{"192.0.2.1:5080", "192.0.2.2:5070"}
+	if !slices.Equal(addrs(got), want) {
+		t.Errorf("targets = %v, want %v", addrs(got), want)
+	}
+	if got[0].Source != "NAPTR" || got[0].Host != "primary.example.com" || got[0].Transport != "tcp" {
+		t.Errorf("target = %+v", got[0])
+	}
+}
+
+func TestResolveSRVWithoutNAPTR(t *testing.T) {
+	server := startServer(t, map[zoneKey][][]byte{
+		{"_sips._tcp.example.com", TypeSRV}: {srvRecord(10, 0, 5061, "tls.example.com")},
+		{"tls.example.com", TypeA}:          {aRecord("192.0.2.5")},
+	})
+
+	got, err := NewResolver(server).Resolve(context.Background(), "example.com", 0, "tls")
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if !slices.Equal(addrs(got), []string{"192.0.2.5:5061"}) || got[0].Source != "SRV" {
+		t.Errorf("targets = %+v", got)
+	}
+}
+
+func TestResolveAddressFallback(t *testing.T) {
+	server := startServer(t, map[zoneKey][][]byte{
+		{"pbx.example.com", TypeA}: {aRecord("192.0.2.7"), aRecord("192.0.2.8")},
+	})
+	r := NewResolver(server)
+
+	got, err := r.Resolve(context.Background(), "pbx.example.com", 0, "udp")
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if want := []string{"192.0.2.7:5060", "192.0.2.8:5060"}; !slices.Equal(addrs(got), want) {
+		t.Errorf("targets = %v, want %v", addrs(got), want)
+	}
+
+	// An explicit port means no NAPTR or SRV lookups.
+	got, err = r.Resolve(context.Background(), "pbx.example.com", 5099, "udp")
+	if err != nil {
+		t.Fatalf("Resolve with port: %v", err)
+	}
+	if got[0].Addr != "192.0.2.7:5099" {
+		t.Errorf("target = %s, want 192.0.2.7:5099", got[0].Addr)
+	}
+}
+
+func TestResolveIP(t *testing.T) {
+	// No server is needed for an IP literal.
+	got, err := NewResolver("127.0.0.1:1").Resolve(context.Background(), "198.51.100.1", 0, "tls")
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if len(got) != 1 || got[0].Addr != "198.51.100.1:5061" || got[0].Source != "IP" {
+		t.Errorf("targets = %+v", got)
+	}
+}
+
+func TestResolveSystemAddresses(t *testing.T) {
+	// Without a server, addresses come from the system resolver and so
+	// from /etc/hosts; an explicit port skips NAPTR and SRV.
+	got, err := NewResolver("").Resolve(context.Background(), "localhost", 5070, "udp")
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if len(got) == 0 || got[0].Addr != "127.0.0.1:5070" || got[0].Source != "A" {
+		t.Errorf("targets = %+v, want 127.0.0.1:5070 via A first", got)
+	}
+}
+
+func TestResolveNotFound(t *testing.T) {
+	server := startServer(t, nil)
+	if _, err := NewResolver(server).Resolve(context.Background(), "missing.example.com", 0, "udp"); err == nil {
+		t.Fatal("expected error for a name with no records")
+	}
+}
+
+func TestOrderSRVWeights(t *testing.T) {
+	recs := []Record{
+		{Priority: 10, Weight: 0, Target: "zero-weight"},
+		{Priority: 10, Weight: 100, Target: "heavy"},
+		{Priority: 5, Weight: 1, Target: "preferred"},
+	}
+	first := map[string]int{}
+	for range 200 {
+		out := orderSRV(recs)
+		if out[0].Target != "preferred" {
+			t.Fatalf("order = %v, want priority 5 first", out)
+		}
+		first[out[1].Target]++
+	}
+	if first["heavy"] < 150 {
+		t.Errorf("heavy target chosen first %d/200 times, want most", first["heavy"])
+	}
+}
+
+func TestResolveWebSocketDefaultPorts(t *testing.T) {
+	r := NewResolver("127.0.0.1:1")
+	for transport, want := range map[string]string{"ws": "198.51.100.1:80", "wss": "198.51.100.1:443"} {
+		got, err := r.Resolve(context.Background(), "198.51.100.1", 0, transport)
+		if err != nil {
+			t.Fatalf("Resolve %s: %v", transport, err)
+		}
+		if got[0].Addr != want {
+			t.Errorf("%s target = %s, want %s", transport, got[0].Addr, want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Target is one address to send a request to. Resolve returns targets in the
+// order they should be tried.
+type Target struct {
+	Addr      string // "ip:port"
+	Transport string // "udp", "tcp", "tls", "ws" or "wss"
+	Host      string // name the address was resolved from
+	Source    string // record type that produced the port: "NAPTR", "SRV", "A" or "IP"
+}
+
+func (t Target) String() string {
+	return fmt.Sprintf("%s (%s, %s via %s)", t.Addr, t.Transport, t.Host, t.Source)
+}
+
+// Resolver implements the RFC 3263 §4 lookups for a SIP next hop.
+type Resolver struct {
+	client *Client
+	system *net.Resolver // for A/AAAA when no server is configured
+}
+
+// NewResolver returns a resolver that queries server; see NewClient. With
+// no server, addresses come from the system resolver, so /etc/hosts and
+// search domains apply as for any other program, and only NAPTR and SRV
+// go to the first resolv.conf nameserver.
+func NewResolver(server string) *Resolver {
+	r := &Resolver{client: NewClient(server)}
+	if server == "" {
+		r.system = net.DefaultResolver
+	}
+	return r
+}
+
+// naptrService and srvPrefix map a transport to its NAPTR service field and
+// SRV owner-name prefix (RFC 3263 §4.1, RFC 7118 §7 for WebSocket).
+var naptrService = map[string]string{
+	"udp": "SIP+D2U", "tcp": "SIP+D2T", "tls": "SIPS+D2T",
+	"ws": "SIP+D2W", "wss": "SIPS+D2W",
+}
+var srvPrefix = map[string]string{
+	"udp": "_sip._udp.", "tcp": "_sip._tcp.", "tls": "_sips._tcp.",
+	"ws": "_sip._ws.", "wss": "_sips._ws.",
+}
+
+// defaultPorts are the ports used when neither the URI nor SRV give one;
+// WebSocket runs on the HTTP ports (RFC 7118 §4).
+var defaultPorts = map[string]int{"tls": 5061, "ws": 80, "wss": 443}
+
+// Resolve finds the targets for host with the given transport. An IP host is
+// used as is. An explicit port skips NAPTR and SRV and only looks up
+// addresses (§4.2). Otherwise NAPTR records for the transport point at SRV
+// names; without usable NAPTR the transport's SRV name is queried directly;
+// without SRV the host's addresses are used on the default port.
+func (r *Resolver) Resolve(ctx context.Context, host string, port int, transport string) ([]Target, error) {
+	defaultPort := 5060
+	if p, ok := defaultPorts[transport]; ok {
+		defaultPort = p
+	}
+
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		if port == 0 {
+			port = defaultPort
+		}
+		return []Target{{Addr: net.JoinHostPort(ip.String(), strconv.Itoa(port)), Transport: transport, Host: host, Source: "IP"}}, nil
+	}
+	if port != 0 {
+		return r.addresses(ctx, host, port, transport, "A")
+	}
+
+	srvNames, source := r.naptr(ctx, host, transport), "NAPTR"
+	if len(srvNames) == 0 {
+		srvNames, source = []string{srvPrefix[transport] + host}, "SRV"
+	}
+	var targets []Target
+	for _, name := range srvNames {
+		recs, err := r.client.Lookup(ctx, name, TypeSRV)
+		if err != nil {
+			continue
+		}
+		for _, srv := range orderSRV(recs) {
+			if srv.Target == "" || srv.Target == "." {
+				continue // "service not available" (RFC 2782)
+			}
+			found, err := r.addresses(ctx, srv.Target, int(srv.Port), transport, source)
+			if err == nil {
+				targets = append(targets, found...)
+			}
+		}
+	}
+	if len(targets) > 0 {
+		return targets, nil
+	}
+	return r.addresses(ctx, host, defaultPort, transport, "A")
+}
+
+// naptr returns the SRV names from host's NAPTR records for transport, in
+// order/preference order.
+func (r *Resolver) naptr(ctx context.Context, host, transport string) []string {
+	recs, err := r.client.Lookup(ctx, host, TypeNAPTR)
+	if err != nil {
+		return nil
+	}
+	slices.SortStableFunc(recs, func(a, b Record) int {
+		if a.Order != b.Order {
+			return int(a.Order) - int(b.Order)
+		}
+		return int(a.Preference) - int(b.Preference)
+	})
+	var names []string
+	for _, rec := range recs {
+		if strings.EqualFold(rec.Flags, "s") && strings.EqualFold(rec.Service, naptrService[transport]) && rec.Replacement != "" {
+			names = append(names, rec.Replacement)
+		}
+	}
+	return names
+}
+
+// addresses looks up the A and AAAA records for host.
+func (r *Resolver) addresses(ctx context.Context, host string, port int, transport, source string) ([]Target, error) {
+	if r.system != nil {
+		return r.systemAddresses(ctx, host, port, transport, source)
+	}
+	var targets []Target
+	var lastErr error
+	for _, qtype := range []uint16{TypeA, TypeAAAA} {
+		recs, err := r.client.Lookup(ctx, host, qtype)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, rec := range recs {
+			targets = append(targets, Target{
+				Addr:      net.JoinHostPort(rec.IP.String(), strconv.Itoa(port)),
+				Transport: transport,
+				Host:      host,
+				Source:    source,
+			})
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("resolve %s: %w", host, lastErr)
+	}
+	return targets, nil
+}
+
+// systemAddresses is addresses through the system resolver, IPv4 first
+// like the A-then-AAAA queries.
+func (r *Resolver) systemAddresses(ctx context.Context, host string, port int, transport, source string) ([]Target, error) {
+	ips, err := r.system.LookupIPAddr(ctx, strings.TrimSuffix(host, "."))
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", host, err)
+	}
+	slices.SortStableFunc(ips, func(a, b net.IPAddr) int {
+		return min(len(b.IP.To4()), 1) - min(len(a.IP.To4()), 1)
+	})
+	var targets []Target
+	for _, ip := range ips {
+		targets = append(targets, Target{
+			Addr:      net.JoinHostPort(ip.String(), strconv.Itoa(port)),
+			Transport: transport,
+			Host:      host,
+			Source:    source,
+		})
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("resolve %s: no addresses", host)
+	}
+	return targets, nil
+}
+
+// orderSRV sorts SRV records by priority and, within a priority, by the
+// weighted random selection of RFC 2782.
+func orderSRV(recs []Record) []Record {
+	recs = slices.Clone(recs)
+	slices.SortStableFunc(recs, func(a, b Record) int {
+		if a.Priority != b.Priority {
+			return int(a.Priority) - int(b.Priority)
+		}
+		// Zero-weight records go first so they keep a small chance of selection.
+		return min(int(a.Weight), 1) - min(int(b.Weight), 1)
+	})
+
+	out := make([]Record, 0, len(recs))
+	for start := 0; start < len(recs); {
+		end := start
+		for end < len(recs) && recs[end].Priority == recs[start].Priority {
+			end++
+		}
+		group := recs[start:end]
+		for len(group) > 0 {
+			total := 0
+			for _, rec := range group {
+				total += int(rec.Weight)
+			}
+			i := 0
+			if total > 0 {
+				pick := rand.IntN(total + 1)
+				for sum := 0; i < len(group)-1; i++ {
+					if sum += int(group[i].Weight); sum >= pick {
+						break
+					}
+				}
+			}
+			out = append(out, group[i])
+			group = slices.Delete(group, i, i+1)
+		}
+		start = end
+	}
+	return out
+}
@@ -4,38 +4,63 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/emiago/diago"
 	"github.com/emiago/sipgo/sip"
 	"github.com/siptty/siptty/internal/config"
+	"github.com/siptty/siptty/internal/dns"
+	"github.com/siptty/siptty/internal/impair"
 )
 
 // Account holds registration state for a SIP account.
 type Account struct {
 	ID     string
 	Config config.AccountConfig
-	State  string // "registered", "unregistered", "failed"
+	State  string // "registered", "unregistered", "failed"; guarded by mu
 
-	regTx  *diago.RegisterTransaction
-	cancel context.CancelFunc
+	regTx    *diago.RegisterTransaction
+	cancel   context.CancelFunc
+	resolver *dns.Resolver // RFC 3263 lookups for the registrar's next hop
+	tracer   *sipTracer    // for DNS notes in the trace
+	tlsPeers *tlsPeers     // TLS hops, for verifying with the account's settings
+	wsHost   string        // .invalid Contact host on ws/wss (RFC 7118 §5.2)
+	aor      string        // lowercase "user@host" of sip_uri, for attributing traced messages
+	nat      accountNAT    // public addresses from the account's NAT settings
+
+	sipImpair *impair.Model // network impairment of the SIP received over UDP outside calls
+
+	mu           sync.Mutex
+	codecs       []Codec  // codec priority list, as configured or changed in the TUI
+	publicAddr   string   // host:port learned from Via received/rport
+	serviceRoute []string // Service-Route from the last REGISTER 2xx (RFC 3608)
+	path         []string // Path from the last REGISTER 2xx (RFC 3327)
 }
 
 // register performs SIP registration for this account using the provided diago instance.
 // It pushes RegStateEvents onto the events channel.
 func (a *Account) register(ctx context.Context, dg *diago.Diago, events chan<- Event) {
 	regCtx, cancel := context.WithCancel(ctx)
+	a.mu.Lock()
+	if a.cancel != nil {
+		a.cancel() // re-registration: drop the previous attempt's context
+	}
 	a.cancel = cancel
+	a.mu.Unlock()
 
-	registrarStr := a.Config.Registrar
-	var registrar sip.Uri
-	if err := sip.ParseUri(registrarStr, &registrar); err != nil {
+	registrar, err := a.registrarURI()
+	if err != nil {
 		slog.Error("invalid registrar URI", "account", a.ID, "error", err)
-		a.State = "failed"
+		a.setState("failed")
 		events <- RegStateEvent{
 			AccountID: a.ID,
 			State:     "failed",
-			Reason:    fmt.Sprintf("invalid registrar URI: %v", err),
+			Reason:    err.Error(),
 		}
 		return
 	}
@@ -49,7 +74,7 @@ func (a *Account) register(ctx context.Context, dg *diago.Diago, events chan<- E
 	})
 	if err != nil {
 		slog.Error("register transaction failed", "account", a.ID, "error", err)
-		a.State = "failed"
+		a.setState("failed")
 		events <- RegStateEvent{
 			AccountID: a.ID,
 			State:     "failed",
@@ -57,11 +82,30 @@ func (a *Account) register(ctx context.Context, dg *diago.Diago, events chan<- E
 		}
 		return
 	}
+	if regTx.Origin != nil {
+		for _, h := range routeHeaders(a.registerRoutes()) {
+			regTx.Origin.AppendHeader(h)
+		}
+		regTx.Origin.AppendHeader(sip.NewHeader("Supported", "path"))
+		if contact := regTx.Origin.Contact(); contact != nil && config.IsWebSocket(a.Config.Transport) {
+			wsContact(contact, a.wsHost, a.Config.Transport)
+		} else if contact != nil {
+			a.applyNAT(contact)
+		}
+	}
+	a.mu.Lock()
 	a.regTx = regTx
+	a.mu.Unlock()
 
-	if err := regTx.Register(regCtx); err != nil {
+	err = a.registerTargets(regCtx, regTx)
+	// Over WebSocket the edge answers on the open connection, so there is
+	// no NAT binding to learn.
+	if err == nil && a.Config.NAT.RewriteContact && !config.IsWebSocket(a.Config.Transport) {
+		err = a.reregisterBehindNAT(regCtx, regTx)
+	}
+	if err != nil {
 		slog.Error("registration failed", "account", a.ID, "error", err)
-		a.State = "failed"
+		a.setState("failed")
 		events <- RegStateEvent{
 			AccountID: a.ID,
 			State:     "failed",
@@ -70,25 +114,193 @@ func (a *Account) register(ctx context.Context, dg *diago.Diago, events chan<- E
 		return
 	}
 
-	a.State = "registered"
-	slog.Info("registered", "account", a.ID)
+	a.setState("registered")
+	serviceRoute, path := a.registrationRoutes()
+	slog.Info("registered", "account", a.ID, "service_route", serviceRoute, "path", path)
 	events <- RegStateEvent{
-		AccountID: a.ID,
-		State:     "registered",
+		AccountID:    a.ID,
+		State:        "registered",
+		ServiceRoute: serviceRoute,
+		Path:         path,
+	}
+}
+
+// registerTargets sends the REGISTER to the NAPTR/SRV targets of the first
+// hop in turn until one answers, failing over on a timeout, transport error
+// or 503. Without such targets, sipgo resolves the hop itself.
+func (a *Account) registerTargets(ctx context.Context, regTx *diago.RegisterTransaction) error {
+	secure := config.IsSecureTransport(a.Config.Transport)
+	var targets []dns.Target
+	hop, err := a.nextHop()
+	if err == nil {
+		targets = pinTargets(resolveHop(ctx, a.resolver, hop, a.Config.Transport, a.Config.IPFamily, a.tracer), secure)
+	}
+	if len(targets) == 0 || regTx.Origin == nil {
+		return regTx.Register(ctx)
+	}
+	for i, t := range targets {
+		regTx.Origin.SetDestination(t.Addr)
+		if secure {
+			a.tlsPeers.add(t.Host, hop.Host, a.ID)
+		}
+		if !config.IsWebSocket(a.Config.Transport) {
+			a.matchContactFamily(regTx.Origin, t.Addr)
+		}
+		err := regTx.Register(ctx)
+		if err == nil || i == len(targets)-1 || ctx.Err() != nil || !failover(err, 0) {
+			return err
+		}
+		traceFailover(a.tracer, t, targets[i+1], err)
+	}
+	return nil
+}
+
+// matchContactFamily points the REGISTER Contact at a local address of the
+// target's family when the two differ, so a dual-stack client registering
+// over IPv6 does not hand the registrar an IPv4 Contact, or vice versa.
+func (a *Account) matchContactFamily(req *sip.Request, target string) {
+	contact := req.Contact()
+	if contact == nil {
+		return
+	}
+	family := addrFamily(target)
+	if family == "" || addrFamily(contact.Address.Host) == family {
+		return
+	}
+	ip, err := localIPFor(target)
+	if err != nil {
+		slog.Warn("no local address to reach target", "account", a.ID, "target", target, "error", err)
+		return
+	}
+	slog.Info("contact follows target address family", "account", a.ID, "family", family, "contact", ip)
+	contact.Address.Host = uriHost(ip.String())
+}
+
+// reregisterBehindNAT checks whether the REGISTER just answered taught us a
+// public address different from the Contact we sent. If so, the private
+// binding is removed and the account registers again with the Contact
+// rewritten to the public address, so the registrar can reach us through the NAT.
+func (a *Account) reregisterBehindNAT(ctx context.Context, regTx *diago.RegisterTransaction) error {
+	a.mu.Lock()
+	addr := a.publicAddr
+	a.mu.Unlock()
+	if addr == "" || regTx.Origin == nil {
+		return nil
 	}
+	contact := regTx.Origin.Contact()
+	if contact == nil {
+		return nil
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil
+	}
+	port, _ := strconv.Atoi(portStr)
+	if bareHost(contact.Address.Host) == host && contact.Address.Port == port {
+		return nil
+	}
+
+	slog.Info("rewriting contact for NAT", "account", a.ID, "from", contact.Address.HostPort(), "to", addr)
+	if err := regTx.Unregister(ctx); err != nil {
+		slog.Warn("unregister of private contact failed", "account", a.ID, "error", err)
+	}
+	contact.Address.Host = uriHost(host)
+	contact.Address.Port = port
+	return regTx.Register(ctx)
+}
+
+// setPublicAddr records the public address learned for the account and
+// reports whether it changed.
+func (a *Account) setPublicAddr(addr string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.publicAddr == addr {
+		return false
+	}
+	a.publicAddr = addr
+	return true
+}
+
+// registerRequest returns the REGISTER request of the account's registration, if any.
+func (a *Account) registerRequest() *sip.Request {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.regTx == nil {
+		return nil
+	}
+	return a.regTx.Origin
+}
+
+// registrarURI parses the configured registrar and applies the account's transport.
+func (a *Account) registrarURI() (sip.Uri, error) {
+	var registrar sip.Uri
+	if err := sip.ParseUri(a.Config.Registrar, &registrar); err != nil {
+		return sip.Uri{}, fmt.Errorf("invalid registrar URI: %w", err)
+	}
+	if err := applyTransport(&registrar, a.Config.Transport); err != nil {
+		return sip.Uri{}, err
+	}
+	return registrar, nil
+}
+
+// tlsHosts returns the host names the account's TLS connections are made
+// to before any DNS lookup: the first hop (outbound proxy, ws_url edge or
+// first route), the registrar and, since a lone TLS account sends it as
+// SNI, the configured server_name.
+func (a *Account) tlsHosts() []string {
+	var hosts []string
+	if hop, err := a.nextHop(); err == nil {
+		hosts = append(hosts, bareHost(hop.Host))
+	}
+	var registrar sip.Uri
+	if err := sip.ParseUri(a.Config.Registrar, &registrar); err == nil {
+		hosts = append(hosts, bareHost(registrar.Host))
+	}
+	if a.Config.TLS.ServerName != "" {
+		hosts = append(hosts, a.Config.TLS.ServerName)
+	}
+	return hosts
+}
+
+// accountAOR returns the lowercase "user@host" of a SIP URI, or "" when it
+// does not parse.
+func accountAOR(sipURI string) string {
+	var uri sip.Uri
+	if err := sip.ParseUri(sipURI, &uri); err != nil {
+		return ""
+	}
+	return strings.ToLower(uri.User + "@" + uri.Host)
 }
 
 // unregister sends a SIP unregistration.
 func (a *Account) unregister() {
-	if a.regTx != nil {
+	a.mu.Lock()
+	regTx, regCancel := a.regTx, a.cancel
+	a.mu.Unlock()
+	if regTx != nil {
 		unregCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-		if err := a.regTx.Unregister(unregCtx); err != nil {
+		if err := regTx.Unregister(unregCtx); err != nil {
 			slog.Warn("unregister failed", "account", a.ID, "error", err)
 		}
 	}
-	if a.cancel != nil {
-		a.cancel()
+	if regCancel != nil {
+		regCancel()
 	}
-	a.State = "unregistered"
+	a.setState("unregistered")
+}
+
+// codecList returns a copy of the account's codec priority list.
+func (a *Account) codecList() []Codec {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.codecs)
+}
+
+// setState records the registration state; register runs on the
+// account's keepalive goroutine while the TUI may unregister.
+func (a *Account) setState(state string) {
+	a.mu.Lock()
+	a.State = state
+	a.mu.Unlock()
 }
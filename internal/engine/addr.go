@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/siptty/siptty/internal/config"
+	"github.com/siptty/siptty/internal/dns"
+	"github.com/siptty/siptty/internal/sdp"
+)
+
+// uriHost formats host for a SIP URI, Via or Contact, bracketing IPv6
+// literals (RFC 3261 §25.1, RFC 5118).
+func uriHost(host string) string {
+	if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// bareHost strips the brackets sipgo keeps around IPv6 hosts in parsed URIs
+// and Via headers, for comparing with and resolving as plain addresses.
+func bareHost(host string) string {
+	return strings.Trim(host, "[]")
+}
+
+// addrFamily returns "ipv4" or "ipv6" for a host or host:port holding an IP
+// literal, and "" for a name.
+func addrFamily(addr string) string {
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(bareHost(host))
+	switch {
+	case ip == nil:
+		return ""
+	case ip.To4() != nil:
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// filterFamily keeps the targets in the account's ip_family; "auto" keeps
+// them all, in resolution order.
+func filterFamily(targets []dns.Target, family string) []dns.Target {
+	if family != "ipv4" && family != "ipv6" {
+		return targets
+	}
+	var out []dns.Target
+	for _, t := range targets {
+		if addrFamily(t.Addr) == family {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// localIPFor returns the local address the routing table picks to reach
+// addr. Connecting a UDP socket sends nothing.
+func localIPFor(addr string) (net.IP, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// globalIPv6 returns the first global unicast IPv6 address of the host, for
+// advertising in Contact and SDP when bound to the unspecified address.
+func globalIPv6() (net.IP, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if ok && ipnet.IP.To4() == nil && ipnet.IP.IsGlobalUnicast() {
+			return ipnet.IP, nil
+		}
+	}
+	return nil, fmt.Errorf("no global IPv6 address")
+}
+
+// peerMediaAddr returns the RTP address (IPv4 or IPv6) in the peer's SDP, or
+// "" when there is no usable audio stream.
+func peerMediaAddr(body []byte) string {
+	sess, err := sdp.Parse(body)
+	if err != nil || sess.Audio() == nil {
+		return ""
+	}
+	addr, err := sess.MediaAddr(sess.Audio())
+	if err != nil {
+		return ""
+	}
+	return addr
+}
+
+// allFamily reports whether every enabled account has ip_family family.
+func allFamily(accounts []config.AccountConfig, family string) bool {
+	found := false
+	for _, a := range accounts {
+		if !a.Enabled {
+			continue
+		}
+		if a.IPFamily != family {
+			return false
+		}
+		found = true
+	}
+	return found
+}
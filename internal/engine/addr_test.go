@@ -0,0 +1,111 @@
+package engine
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/siptty/siptty/internal/config"
+	"github.com/siptty/siptty/internal/dns"
+)
+
+func TestURIHost(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.1":       "192.0.2.1",
+		"2001:db8::1":     "[2001:db8::1]",
+		"[2001:db8::1]":   "[2001:db8::1]",
+		"pbx.example.com": "pbx.example.com",
+	}
+	for in, want := range tests {
+		if got := uriHost(in); got != want {
+			t.Errorf("uriHost(%q) = %q, want %q", in, got, want)
+		}
+		if got := bareHost(uriHost(in)); got != bareHost(in) {
+			t.Errorf("bareHost(uriHost(%q)) = %q", in, got)
+		}
+	}
+}
+
+func TestAddrFamily(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.1:5060":     "ipv4",
+		"[2001:db8::1]:5060": "ipv6",
+		"[2001:db8::1]":      "ipv6",
+		"2001:db8::1":        "ipv6",
+		"pbx.example.com":    "",
+	}
+	for in, want := range tests {
+		if got := addrFamily(in); got != want {
+			t.Errorf("addrFamily(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestFilterFamily(t *testing.T) {
+	targets := []dns.Target{{Addr: "192.0.2.1:5060"}, {Addr: "[2001:db8::1]:5060"}, {Addr: "192.0.2.2:5060"}}
+	addrs := func(ts []dns.Target) []string {
+		var out []string
+		for _, t := range ts {
+			out = append(out, t.Addr)
+		}
+		return out
+	}
+	if got := addrs(filterFamily(targets, "ipv6")); !slices.Equal(got, []string{"[2001:db8::1]:5060"}) {
+		t.Errorf("ipv6 targets = %v", got)
+	}
+	if got := addrs(filterFamily(targets, "ipv4")); !slices.Equal(got, []string{"192.0.2.1:5060", "192.0.2.2:5060"}) {
+		t.Errorf("ipv4 targets = %v", got)
+	}
+	if got := filterFamily(targets, "auto"); len(got) != 3 {
+		t.Errorf("auto kept %d targets, want 3", len(got))
+	}
+}
+
+func TestAllFamily(t *testing.T) {
+	accounts := []config.AccountConfig{
+		{Enabled: true, IPFamily: "ipv6"},
+		{Enabled: false, IPFamily: "ipv4"},
+		{Enabled: true, IPFamily: "ipv6"},
+	}
+	if !allFamily(accounts, "ipv6") {
+		t.Error("allFamily(ipv6) = false, want true: the ipv4 account is disabled")
+	}
+	accounts[1].Enabled = true
+	if allFamily(accounts, "ipv6") {
+		t.Error("allFamily(ipv6) = true with an enabled ipv4 account")
+	}
+	if allFamily(nil, "ipv6") {
+		t.Error("allFamily(nil) = true")
+	}
+}
+
+func TestPeerMediaAddr(t *testing.T) {
+	body := "v=0\r\no=- 1 1 IN IP6 2001:db8::7\r\ns=-\r\nc=IN IP6 2001:db8::7\r\nt=0 0\r\nm=audio 4002 RTP/AVP 0\r\n"
+	if got := peerMediaAddr([]byte(body)); got != "[2001:db8::7]:4002" {
+		t.Errorf("peerMediaAddr = %q, want [2001:db8::7]:4002", got)
+	}
+	if got := peerMediaAddr(nil); got != "" {
+		t.Errorf("peerMediaAddr(nil) = %q, want empty", got)
+	}
+}
+
+func TestDialTarget(t *testing.T) {
+	v4 := &Account{ID: "v4", Config: config.AccountConfig{Transport: "udp", IPFamily: "ipv4"}}
+	dual := &Account{ID: "dual", Config: config.AccountConfig{Transport: "udp", IPFamily: "auto"}}
+
+	target, err := dialTarget(dual, "sip:bob@[2001:db8::5]:5070")
+	if err != nil {
+		t.Fatalf("dialTarget: %v", err)
+	}
+	if target.Host != "[2001:db8::5]" || target.Port != 5070 {
+		t.Errorf("target = %s, want [2001:db8::5]:5070", target.HostPort())
+	}
+	if _, err := dialTarget(dual, "sip:bob@2001:db8::5"); err == nil {
+		t.Error("expected error for an unbracketed IPv6 host")
+	}
+	if _, err := dialTarget(v4, "sip:bob@[2001:db8::5]"); err == nil {
+		t.Error("expected error dialing IPv6 from an ipv4 account")
+	}
+	if _, err := dialTarget(v4, "sip:bob@pbx.example.com"); err != nil {
+		t.Errorf("hostname on ipv4 account: %v", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/emiago/diago"
+	"github.com/siptty/siptty/internal/impair"
 )
 
 // Call wraps a diago dialog session with metadata for the TUI.
@@ -14,11 +15,25 @@ type Call struct {
 	RemoteURI string
 	State     string // "calling", "incoming", "early", "confirmed", "disconnected"
 	StartTime time.Time
+	SIPCallID string // Call-ID of the INVITE dialog, to find its messages in the trace
+
+	Encryption string // SRTP suite or "DTLS-SRTP"; empty for plain RTP
+
+	account *Account // the account the call belongs to; nil if none matched
+
+	// rtpImpair and sipImpair are the call's network impairment, first
+	// its account's; set by Engine.addCall.
+	rtpImpair, sipImpair *impair.Model
 
 	mu       sync.Mutex
 	client   *diago.DialogClientSession
 	server   *diago.DialogServerSession
 	answerCh chan struct{} // signals the inbound handler to accept
+	media    *mediaStats   // set by startMedia
+	dialog   dialogBuilder // fed by Engine.addCall and Engine.trackDialog
+
+	report     func(mediaOpen bool) // sends the end-of-call quality reports; set by startMedia
+	reportOnce sync.Once
 }
 
 // newOutboundCall creates a Call for an outgoing INVITE.
@@ -29,6 +44,7 @@ func newOutboundCall(id, remoteURI string, client *diago.DialogClientSession) *C
 		RemoteURI: remoteURI,
 		State:     "calling",
 		StartTime: time.Now(),
+		SIPCallID: client.InviteRequest.CallID().Value(),
 		client:    client,
 	}
 }
@@ -41,6 +57,7 @@ func newInboundCall(id, remoteURI string, server *diago.DialogServerSession) *Ca
 		RemoteURI: remoteURI,
 		State:     "incoming",
 		StartTime: time.Now(),
+		SIPCallID: server.InviteRequest.CallID().Value(),
 		server:    server,
 		answerCh:  make(chan struct{}, 1),
 	}
@@ -53,6 +70,18 @@ func (c *Call) setState(state string) {
 	c.State = state
 }
 
+// sendQualityReports sends the call's end-of-call quality reports, if any
+// are configured, the first time it is called. mediaOpen tells whether the
+// media session can still carry an RTCP XR packet.
+func (c *Call) sendQualityReports(mediaOpen bool) {
+	c.mu.Lock()
+	report := c.report
+	c.mu.Unlock()
+	if report != nil {
+		c.reportOnce.Do(func() { report(mediaOpen) })
+	}
+}
+
 // ValidDTMFDigit returns true if r is a valid DTMF digit (0-9, *, #, A-D).
 func ValidDTMFDigit(r rune) bool {
 	switch {
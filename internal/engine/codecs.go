@@ -0,0 +1,197 @@
+package engine
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emiago/diago/media"
+	"github.com/siptty/siptty/internal/config"
+)
+
+// codecTable maps the lower-case names of config.Codecs to diago codecs.
+// G722 keeps the 8000 Hz RTP clock of RFC 3551 despite sampling at 16 kHz.
+var codecTable = map[string]media.Codec{
+	"pcmu":            media.CodecAudioUlaw,
+	"pcma":            media.CodecAudioAlaw,
+	"g722":            {Name: "G722", PayloadType: 9, SampleRate: 8000, NumChannels: 1},
+	"opus":            media.CodecAudioOpus,
+	"telephone-event": media.CodecTelephoneEvent8000,
+}
+
+// Codec is one entry of an account's codec priority list.
+type Codec struct {
+	Name        string // e.g. "PCMU", "opus", "telephone-event"
+	PayloadType uint8
+	ClockRate   uint32
+	Channels    int
+	Ptime       time.Duration
+	Fmtp        string // format parameters offered, e.g. "useinbandfec=1"; "" for none
+	Enabled     bool   // offered; a disabled codec keeps its place in the list
+}
+
+// String writes c as an rtpmap encoding, e.g. "opus/48000/2".
+func (c Codec) String() string {
+	s := fmt.Sprintf("%s/%d", c.Name, c.ClockRate)
+	if c.Channels > 1 {
+		s += fmt.Sprintf("/%d", c.Channels)
+	}
+	return s
+}
+
+// mediaCodec returns the diago codec named name, with the account's ptime
+// and opus payload type applied.
+func mediaCodec(name string, cfg config.AccountConfig) (media.Codec, bool) {
+	c, ok := codecTable[strings.ToLower(name)]
+	if !ok {
+		return media.Codec{}, false
+	}
+	c.SampleDur = time.Duration(cfg.Ptime) * time.Millisecond
+	if c.Name == media.CodecAudioOpus.Name {
+		c.PayloadType = uint8(cfg.Opus.PayloadType)
+	}
+	return c, true
+}
+
+// codecEntry returns the entry of an account's codec list for name.
+func codecEntry(name string, cfg config.AccountConfig, enabled bool) (Codec, bool) {
+	c, ok := mediaCodec(name, cfg)
+	if !ok {
+		return Codec{}, false
+	}
+	entry := codecInfo(c)
+	if c.Name == media.CodecAudioOpus.Name {
+		entry.Fmtp = opusFmtp(cfg.Opus)
+	}
+	entry.Enabled = enabled
+	return entry, true
+}
+
+// codecList returns an account's codec priority list as configured: its
+// codecs, enabled and in order, then the others siptty knows, disabled.
+func codecList(cfg config.AccountConfig) []Codec {
+	var list []Codec
+	for i, names := range [][]string{cfg.Codecs, config.Codecs} {
+		for _, name := range names {
+			c, ok := codecEntry(name, cfg, i == 0)
+			if ok && !slices.ContainsFunc(list, func(l Codec) bool { return l.Name == c.Name }) {
+				list = append(list, c)
+			}
+		}
+	}
+	return list
+}
+
+// opusFmtp writes the opus format parameters of an offer (RFC 7587 §6.1),
+// leaving out the unset ones.
+func opusFmtp(o config.OpusConfig) string {
+	var params []string
+	if o.MaxPlaybackRate != 0 {
+		params = append(params, fmt.Sprintf("maxplaybackrate=%d", o.MaxPlaybackRate))
+	}
+	if o.MaxAverageBitrate != 0 {
+		params = append(params, fmt.Sprintf("maxaveragebitrate=%d", o.MaxAverageBitrate))
+	}
+	if o.Stereo {
+		params = append(params, "stereo=1")
+	}
+	if o.UseInbandFEC {
+		params = append(params, "useinbandfec=1")
+	}
+	return strings.Join(params, ";")
+}
+
+// offerCodecs returns the diago codecs and the extra a=fmtp attributes of
+// the enabled entries of an account's codec list, in order.
+func offerCodecs(list []Codec, cfg config.AccountConfig) ([]media.Codec, []string) {
+	var codecs []media.Codec
+	var fmtp []string
+	for _, l := range list {
+		if !l.Enabled {
+			continue
+		}
+		if c, ok := mediaCodec(l.Name, cfg); ok {
+			codecs = append(codecs, c)
+		}
+		if l.Fmtp != "" {
+			fmtp = append(fmtp, fmt.Sprintf("fmtp:%d %s", l.PayloadType, l.Fmtp))
+		}
+	}
+	return codecs, fmtp
+}
+
+// audioCodec returns the codec a media session carries audio with: the
+// first negotiated one that is not telephone-event.
+func audioCodec(ms *media.MediaSession) (media.Codec, bool) {
+	for _, c := range ms.Codecs {
+		if c.Name != media.CodecTelephoneEvent8000.Name {
+			return c, true
+		}
+	}
+	return media.Codec{}, false
+}
+
+// negotiatedCodec names the audio codec of a call's media session for
+// CallStateEvent, e.g. "PCMU/8000"; "" when there is none.
+func negotiatedCodec(ms *media.MediaSession) string {
+	if ms == nil {
+		return ""
+	}
+	c, ok := audioCodec(ms)
+	if !ok {
+		return ""
+	}
+	return codecInfo(c).String()
+}
+
+func codecInfo(c media.Codec) Codec {
+	return Codec{Name: c.Name, PayloadType: c.PayloadType, ClockRate: c.SampleRate, Channels: c.NumChannels, Ptime: c.SampleDur}
+}
+
+// Codecs returns the codec priority list of an account, most preferred
+// first, with the disabled codecs in their places.
+func (e *Engine) Codecs(accountID string) []Codec {
+	e.mu.RLock()
+	acct := e.accounts[accountID]
+	e.mu.RUnlock()
+	if acct == nil {
+		return nil
+	}
+	return acct.codecList()
+}
+
+// SetCodecs changes the order of an account's codec list and which of its
+// codecs are enabled, from the next offer or answer on. Only the names and
+// Enabled of codecs are used.
+func (e *Engine) SetCodecs(accountID string, codecs []Codec) error {
+	e.mu.RLock()
+	acct := e.accounts[accountID]
+	e.mu.RUnlock()
+	if acct == nil {
+		return fmt.Errorf("account %q not found", accountID)
+	}
+
+	cfg := acct.Config
+	cfg.Codecs = nil
+	var list []Codec
+	for _, c := range codecs {
+		entry, ok := codecEntry(c.Name, acct.Config, c.Enabled)
+		if !ok {
+			return fmt.Errorf("unknown codec %q", c.Name)
+		}
+		list = append(list, entry)
+		if c.Enabled {
+			cfg.Codecs = append(cfg.Codecs, c.Name)
+		}
+	}
+	if err := config.ValidateCodecs(cfg); err != nil {
+		return err
+	}
+	acct.mu.Lock()
+	acct.codecs = list
+	acct.mu.Unlock()
+	slog.Info("codecs changed", "account", accountID, "codecs", cfg.Codecs)
+	return nil
+}
@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/emiago/diago/media"
+	"github.com/siptty/siptty/internal/config"
+)
+
+func TestCodecList(t *testing.T) {
+	cfg := config.AccountConfig{
+		Codecs: []string{"opus", "g722", "pcmu", "telephone-event"},
+		Ptime:  40,
+		Opus:   config.OpusConfig{PayloadType: 111, MaxAverageBitrate: 24000, UseInbandFEC: true},
+	}
+	list := codecList(cfg)
+	var got []string
+	for _, c := range list {
+		got = append(got, fmt.Sprintf("%s %v", c, c.Enabled))
+	}
+	want := []string{"opus/48000/2 true", "G722/8000 true", "PCMU/8000 true", "telephone-event/8000 true", "PCMA/8000 false"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("codecList() = %q, want %q", got, want)
+	}
+
+	codecs, fmtp := offerCodecs(list, cfg)
+	if len(codecs) != 4 {
+		t.Fatalf("offerCodecs() = %d codecs, want the 4 enabled", len(codecs))
+	}
+	for _, c := range codecs {
+		if c.SampleDur != 40*time.Millisecond {
+			t.Errorf("%s ptime = %v, want 40ms", c.Name, c.SampleDur)
+		}
+	}
+	if codecs[0].PayloadType != 111 || codecs[1].PayloadType != 9 || codecs[3].PayloadType != 101 {
+		t.Errorf("payload types %d, %d, %d; want 111, 9, 101", codecs[0].PayloadType, codecs[1].PayloadType, codecs[3].PayloadType)
+	}
+	if want := []string{"fmtp:111 maxaveragebitrate=24000;useinbandfec=1"}; !slices.Equal(fmtp, want) {
+		t.Errorf("fmtp = %q, want %q", fmtp, want)
+	}
+
+	list[0].Enabled = false
+	if _, fmtp := offerCodecs(list, cfg); len(fmtp) != 0 {
+		t.Errorf("fmtp of disabled opus = %q, want none", fmtp)
+	}
+}
+
+// TestSetCodecs reorders and disables the codecs of one account, leaving
+// the other's alone.
+func TestSetCodecs(t *testing.T) {
+	cfgA := config.AccountConfig{Name: "a", Codecs: []string{"PCMU", "PCMA"}, Ptime: 20, Opus: config.OpusConfig{PayloadType: 96}}
+	cfgB := cfgA
+	cfgB.Name = "b"
+	e := &Engine{accounts: map[string]*Account{
+		"a": {ID: "a", Config: cfgA, codecs: codecList(cfgA)},
+		"b": {ID: "b", Config: cfgB, codecs: codecList(cfgB)},
+	}}
+
+	codecs := e.Codecs("a")
+	codecs[0], codecs[1] = codecs[1], codecs[0]
+	codecs[1].Enabled = false // PCMU
+	codecs[2].Enabled = true  // G722
+	if err := e.SetCodecs("a", codecs); err != nil {
+		t.Fatalf("SetCodecs: %v", err)
+	}
+	var got []string
+	for _, c := range e.Codecs("a") {
+		got = append(got, fmt.Sprintf("%s %v", c.Name, c.Enabled))
+	}
+	want := []string{"PCMA true", "PCMU false", "G722 true", "opus false", "telephone-event false"}
+	if !slices.Equal(got, want) {
+		t.Errorf("codecs = %q, want %q", got, want)
+	}
+	offered, _ := offerCodecs(e.Codecs("a"), cfgA)
+	if len(offered) != 2 || offered[0].Name != "PCMA" || offered[1].Name != "G722" {
+		t.Errorf("offer = %v, want PCMA then G722", offered)
+	}
+	if first := e.Codecs("b")[0]; first.Name != "PCMU" || !first.Enabled {
+		t.Errorf("account b's first codec = %+v, want PCMU still", first)
+	}
+
+	for i := range codecs {
+		codecs[i].Enabled = codecs[i].Name == "telephone-event"
+	}
+	if err := e.SetCodecs("a", codecs); err == nil {
+		t.Error("SetCodecs with only telephone-event enabled succeeded")
+	}
+	if err := e.SetCodecs("c", codecs); err == nil {
+		t.Error("SetCodecs of an unknown account succeeded")
+	}
+}
+
+func TestNegotiatedCodec(t *testing.T) {
+	ms := &media.MediaSession{Codecs: []media.Codec{media.CodecTelephoneEvent8000, media.CodecAudioAlaw}}
+	if c := negotiatedCodec(ms); c != "PCMA/8000" {
+		t.Errorf("negotiatedCodec() = %q, want PCMA/8000", c)
+	}
+	ms.Codecs = ms.Codecs[:1]
+	if c := negotiatedCodec(ms); c != "" {
+		t.Errorf("negotiatedCodec(telephone-event only) = %q", c)
+	}
+	if c := negotiatedCodec(nil); c != "" {
+		t.Errorf("negotiatedCodec(nil) = %q", c)
+	}
+}
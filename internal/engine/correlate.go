@@ -0,0 +1,156 @@
+package engine
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// EngineCallHeader carries the engine's call ID on every INVITE it sends
+// for a call, so the attempts of one call (DNS failover, retries) correlate
+// in the dialog list even though each has its own Call-ID.
+const EngineCallHeader = "X-Siptty-Call-ID"
+
+// correlationHeaders name another leg's Call-ID, as B2BUAs and SBCs add them.
+var correlationHeaders = []string{"X-Call-ID", "X-CID"}
+
+// maxRefers bounds the REFERs remembered for matching the INVITEs they cause.
+const maxRefers = 50
+
+// referral is a REFER seen in a dialog: the INVITE it asks for will carry
+// Referred-By and go to target.
+type referral struct {
+	callID string
+	target string // user@host of the Refer-To URI
+}
+
+// correlate files the correlation keys ev names for dialog d. A dialog's own
+// key is "id:" and its Call-ID; other keys name the Call-ID of a related leg
+// or, with "engine:", the engine call it belongs to.
+func (t *DialogTracker) correlate(d *SipDialog, ev SipTraceEvent) {
+	t.link(d, "id:"+d.CallID)
+	for _, h := range correlationHeaders {
+		if v := ev.Header(h, ""); v != "" {
+			t.link(d, "id:"+v)
+		}
+	}
+	if v := ev.Header(EngineCallHeader, ""); v != "" {
+		t.link(d, "engine:"+v)
+	}
+	if id := replacesCallID(ev.Header("Replaces", "")); id != "" {
+		t.link(d, "id:"+id)
+	}
+	if ev.StatusCode() != 0 {
+		return
+	}
+
+	switch ev.Method() {
+	case "REFER":
+		referTo := ev.Header("Refer-To", "r")
+		target, _, _ := strings.Cut(headerAOR(referTo), "?")
+		_, headers, _ := strings.Cut(referTo, "?")
+		if id := referReplaces(headers); id != "" {
+			t.link(d, "id:"+id) // attended transfer: the leg being replaced
+		}
+		if target != "" {
+			t.refers = append(t.refers, referral{callID: d.CallID, target: strings.ToLower(target)})
+			if len(t.refers) > maxRefers {
+				t.refers = t.refers[1:]
+			}
+		}
+	case "INVITE":
+		if ev.Header("Referred-By", "b") == "" {
+			return
+		}
+		to := strings.ToLower(headerAOR(ev.Header("To", "t")))
+		for _, r := range slices.Backward(t.refers) {
+			if r.target == to && r.callID != d.CallID {
+				t.link(d, "id:"+r.callID)
+				return
+			}
+		}
+	}
+}
+
+// link adds key to d's correlation keys.
+func (t *DialogTracker) link(d *SipDialog, key string) {
+	if slices.Contains(d.keys, key) {
+		return
+	}
+	d.keys = append(d.keys, key)
+	if t.byKey[key] == nil {
+		t.byKey[key] = make(map[string]bool)
+	}
+	t.byKey[key][d.CallID] = true
+}
+
+// unlink removes d from the key index when it is dropped.
+func (t *DialogTracker) unlink(d *SipDialog) {
+	for _, key := range d.keys {
+		delete(t.byKey[key], d.CallID)
+		if len(t.byKey[key]) == 0 {
+			delete(t.byKey, key)
+		}
+	}
+}
+
+// Related returns the dialog with callID and every dialog correlated with
+// it, directly or through other legs, oldest first. It returns nil for an
+// unknown Call-ID.
+func (t *DialogTracker) Related(callID string) []*SipDialog {
+	if t.byID[callID] == nil {
+		return nil
+	}
+	seen := map[string]bool{callID: true}
+	queue := []string{callID}
+	for len(queue) > 0 {
+		d := t.byID[queue[0]]
+		queue = queue[1:]
+		// Dialogs sharing any key with d, and the dialog a key names.
+		for _, key := range d.keys {
+			ids := make([]string, 0, len(t.byKey[key])+1)
+			for id := range t.byKey[key] {
+				ids = append(ids, id)
+			}
+			if id, ok := strings.CutPrefix(key, "id:"); ok {
+				ids = append(ids, id)
+			}
+			for _, id := range ids {
+				if !seen[id] && t.byID[id] != nil {
+					seen[id] = true
+					queue = append(queue, id)
+				}
+			}
+		}
+	}
+
+	out := make([]*SipDialog, 0, len(seen))
+	for id := range seen {
+		out = append(out, t.byID[id])
+	}
+	sortDialogs(out)
+	return out
+}
+
+// replacesCallID returns the Call-ID of a Replaces header value
+// ("callid;to-tag=...;from-tag=...").
+func replacesCallID(v string) string {
+	id, _, _ := strings.Cut(v, ";")
+	return strings.TrimSpace(id)
+}
+
+// referReplaces returns the Call-ID of the Replaces header embedded in the
+// headers part of a Refer-To URI ("Replaces=abc%40host%3Bto-tag%3D1").
+func referReplaces(headers string) string {
+	headers, _, _ = strings.Cut(headers, ">")
+	for _, h := range strings.Split(headers, "&") {
+		name, value, ok := strings.Cut(h, "=")
+		if !ok || !strings.EqualFold(name, "Replaces") {
+			continue
+		}
+		if v, err := url.QueryUnescape(value); err == nil {
+			return replacesCallID(v)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withHeaders inserts extra header lines after a message's start line.
+func withHeaders(msg string, headers ...string) string {
+	line, rest, _ := strings.Cut(msg, "\r\n")
+	return line + "\r\n" + strings.Join(headers, "\r\n") + "\r\n" + rest
+}
+
+// relatedIDs returns the Call-IDs related to callID, oldest first.
+func relatedIDs(tr *DialogTracker, callID string) string {
+	var ids []string
+	for _, d := range tr.Related(callID) {
+		ids = append(ids, d.CallID)
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestDialogCorrelation(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(s int) time.Time { return t0.Add(time.Duration(s) * time.Second) }
+	tr := NewDialogTracker()
+
+	// An SBC leg naming the A leg, and an unrelated call.
+	tr.Add(traceMsg("send", request("INVITE", "a-leg", 1), at(0)))
+	tr.Add(traceMsg("recv", withHeaders(request("INVITE", "b-leg", 1), "X-Call-ID: a-leg"), at(1)))
+	tr.Add(traceMsg("send", request("INVITE", "other", 1), at(2)))
+
+	// Two failover attempts of one engine call.
+	tr.Add(traceMsg("send", withHeaders(request("INVITE", "try-1", 1), EngineCallHeader+": 7"), at(3)))
+	tr.Add(traceMsg("send", withHeaders(request("INVITE", "try-2", 1), EngineCallHeader+": 7"), at(4)))
+
+	// A blind transfer of the B leg to 300, and the INVITE it causes.
+	tr.Add(traceMsg("recv", withHeaders(request("REFER", "b-leg", 2), "Refer-To: <sip:300@pbx.example.com>"), at(5)))
+	invite := strings.Replace(request("INVITE", "x-leg", 1), "To: <sip:200@", "To: <sip:300@", 1)
+	tr.Add(traceMsg("send", withHeaders(invite, "Referred-By: <sip:200@pbx.example.com>"), at(6)))
+
+	// An attended transfer replacing the unrelated call.
+	tr.Add(traceMsg("recv", withHeaders(request("INVITE", "replacer", 1), "Replaces: other;to-tag=1;from-tag=2"), at(7)))
+
+	tests := map[string]string{
+		"a-leg":    "a-leg b-leg x-leg",
+		"x-leg":    "a-leg b-leg x-leg",
+		"try-2":    "try-1 try-2",
+		"other":    "other replacer",
+		"replacer": "other replacer",
+	}
+	for id, want := range tests {
+		if got := relatedIDs(tr, id); got != want {
+			t.Errorf("Related(%s) = %s, want %s", id, got, want)
+		}
+	}
+	if tr.Related("unknown") != nil {
+		t.Error("Related(unknown) is not nil")
+	}
+}
+
+func TestReferReplacesCorrelation(t *testing.T) {
+	tr := NewDialogTracker()
+	now := time.Now()
+	tr.Add(traceMsg("send", request("INVITE", "held", 1), now))
+	tr.Add(traceMsg("send", request("INVITE", "consult", 1), now.Add(time.Second)))
+	tr.Add(traceMsg("send", withHeaders(request("REFER", "consult", 2),
+		"Refer-To: <sip:300@pbx.example.com?Replaces=held%3Bto-tag%3D1%3Bfrom-tag%3D2>"), now.Add(2*time.Second)))
+	if got := relatedIDs(tr, "held"); got != "held consult" {
+		t.Errorf("Related(held) = %s, want held consult", got)
+	}
+}
+
+func TestDroppedDialogLeavesCorrelation(t *testing.T) {
+	tr := NewDialogTracker()
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tr.Add(traceMsg("send", withHeaders(request("INVITE", "first", 1), EngineCallHeader+": 1"), t0))
+	for i := range maxDialogs {
+		tr.Add(traceMsg("send", withHeaders(request("INVITE", "c"+strconv.Itoa(i), 1), EngineCallHeader+": 1"), t0.Add(time.Duration(i+1)*time.Second)))
+	}
+	if tr.Get("first") != nil {
+		t.Fatal("oldest dialog was not dropped")
+	}
+	for _, d := range tr.Related(tr.Dialogs()[0].CallID) {
+		if d == nil || d.CallID == "first" {
+			t.Fatal("dropped dialog is still related")
+		}
+	}
+	if n := len(tr.byKey["engine:1"]); n != maxDialogs {
+		t.Errorf("key index holds %d dialogs, want %d", n, maxDialogs)
+	}
+}
@@ -0,0 +1,52 @@
+package engine
+
+import "github.com/emiago/diago"
+
+// dialogMedia returns the media setup diago builds a dialog's SDP offer or
+// answer from, taken from the settings of the account the dialog belongs
+// to: its codec list, opus parameters, SRTP mode and NAT address. acct is
+// nil for an inbound call no account claims, which gets diago's codecs and
+// plain RTP at the local address. The media goes through socks, which
+// siptty binds and reads, and the SDP carries their ICE and rtcp-mux
+// attributes.
+func dialogMedia(acct *Account, socks *dialogSockets) *diago.MediaConfig {
+	m := &diago.MediaConfig{
+		RTPConn:       socks.rtp,
+		RTCPConn:      socks.rtcp,
+		SDPAttributes: socks.sdpAttributes(),
+	}
+	if acct == nil {
+		return m
+	}
+	var fmtp []string
+	m.Codecs, fmtp = offerCodecs(acct.codecList(), acct.Config)
+	m.SDPAttributes = append(m.SDPAttributes, fmtp...)
+	m.SecureRTP = diagoSRTPMode(acct.Config.MediaEncryption)
+	m.ExternalIP = acct.nat.mediaIP
+	return m
+}
+
+// openMedia binds the media sockets of a new dialog of acct. offer is the
+// peer's SDP offer when answering, nil when siptty makes the offer. An
+// offer does ICE when the account has ice_enabled, and then asks for
+// rtcp-mux; an answer does ICE only if the offer does too, and multiplexes
+// RTCP only if the offer asked for it.
+func (e *Engine) openMedia(acct *Account, offer []byte) (*dialogSockets, error) {
+	family := "auto"
+	if acct != nil {
+		family = acct.Config.IPFamily
+	}
+	cfg := iceConfig(acct, offer == nil)
+	var mux bool
+	if offer != nil {
+		var ok bool
+		if _, _, _, mux, ok = remoteICE(offer); !ok {
+			cfg = nil
+		}
+	}
+	socks, err := openDialogSockets(e.config.General.BindHost, family, cfg)
+	if socks != nil && offer != nil {
+		socks.mux = mux
+	}
+	return socks, err
+}
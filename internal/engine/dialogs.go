@@ -0,0 +1,206 @@
+package engine
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxDialogs bounds the dialogs a DialogTracker keeps; the least recently
+// active ones are dropped first.
+const maxDialogs = 500
+
+// SipDialog summarizes the traced messages of one Call-ID, the unit of the
+// sngrep-style dialog list: a call, a registration, a subscription or a
+// standalone request such as OPTIONS. The messages themselves stay in the
+// TraceStore.
+type SipDialog struct {
+	CallID string
+	Method string // method of the first request seen
+	From   string // user@host of the first message's From
+	To     string // user@host of the first message's To
+	State  string
+	Start  time.Time
+	Last   time.Time
+	Count  int // messages seen
+
+	sawRequest bool
+	keys       []string // correlation keys, see DialogTracker.correlate
+}
+
+// add counts ev in the dialog and advances its state.
+func (d *SipDialog) add(ev SipTraceEvent) {
+	if d.Count == 0 {
+		d.Start = ev.Timestamp
+		d.From = headerAOR(ev.Header("From", "f"))
+		d.To = headerAOR(ev.Header("To", "t"))
+	}
+	d.Count++
+	d.Last = ev.Timestamp
+
+	method, code := ev.Method(), ev.StatusCode()
+	switch {
+	case code == 0 && !d.sawRequest:
+		d.Method = method
+		d.sawRequest = true
+	case d.Method == "":
+		d.Method = method // only responses so far: their CSeq method
+	}
+	if s := nextDialogState(d.Method, d.State, ev, method, code); s != "" {
+		d.State = s
+	}
+}
+
+// nextDialogState returns the state a dialog moves to on a message, or ""
+// to stay. INVITE dialogs follow sngrep's call states; SUBSCRIBE dialogs are
+// active until a NOTIFY ends them; anything else completes on its final response.
+func nextDialogState(dialogMethod, state string, ev SipTraceEvent, method string, code int) string {
+	switch dialogMethod {
+	case "INVITE":
+		switch {
+		case code == 0 && method == "INVITE" && state == "":
+			return "Calling"
+		case code == 0 && method == "CANCEL":
+			return "Cancelled"
+		case code == 0 && method == "BYE":
+			return "Completed"
+		case method != "INVITE" || state == "Completed" || state == "Cancelled":
+			return ""
+		case code == 180 || code == 183:
+			if state == "Calling" {
+				return "Ringing"
+			}
+		case code >= 200 && code < 300:
+			return "In call"
+		case code >= 300 && code < 400:
+			return "Diverted"
+		case code == 401 || code == 407:
+			return "" // challenge; the INVITE is resent
+		case code == 486 || code == 600:
+			return "Busy"
+		case code == 487:
+			return "Cancelled"
+		case code >= 400:
+			if state != "In call" { // a failed re-INVITE keeps the call
+				return "Rejected"
+			}
+		}
+	case "SUBSCRIBE":
+		switch {
+		case code == 0 && method == "SUBSCRIBE" && state == "":
+			return "Subscribing"
+		case code == 0 && method == "NOTIFY" &&
+			strings.HasPrefix(strings.ToLower(ev.Header("Subscription-State", "")), "terminated"):
+			return "Terminated"
+		case method == "SUBSCRIBE" && code >= 200 && code < 300 && state != "Terminated":
+			return "Active"
+		case method == "SUBSCRIBE" && code >= 300 && code != 401 && code != 407:
+			return "Failed"
+		}
+	default:
+		switch {
+		case code == 0 && state == "":
+			return "Pending"
+		case method != dialogMethod || code < 200 || code == 401 || code == 407:
+			return ""
+		case code < 300:
+			return "Completed"
+		default:
+			return "Failed"
+		}
+	}
+	return ""
+}
+
+// headerAOR reduces a From/To value such as `"Alice" <sip:alice@pbx>;tag=1`
+// to "alice@pbx".
+func headerAOR(v string) string {
+	if i := strings.Index(v, "<"); i >= 0 {
+		v = v[i+1:]
+		v, _, _ = strings.Cut(v, ">")
+	} else {
+		v, _, _ = strings.Cut(v, ";")
+	}
+	v = strings.TrimSpace(v)
+	for _, scheme := range []string{"sip:", "sips:", "tel:"} {
+		if len(v) >= len(scheme) && strings.EqualFold(v[:len(scheme)], scheme) {
+			v = v[len(scheme):]
+			break
+		}
+	}
+	v, _, _ = strings.Cut(v, ";")
+	return v
+}
+
+// DialogTracker groups trace events into dialogs by Call-ID and correlates
+// the dialogs that are legs of one call (see Related). It is not safe for
+// concurrent use.
+type DialogTracker struct {
+	byID   map[string]*SipDialog
+	byKey  map[string]map[string]bool // Call-IDs by correlation key
+	refers []referral                 // recent REFERs, oldest first
+}
+
+// NewDialogTracker returns an empty tracker.
+func NewDialogTracker() *DialogTracker {
+	return &DialogTracker{byID: make(map[string]*SipDialog), byKey: make(map[string]map[string]bool)}
+}
+
+// Add files ev under its Call-ID and returns the dialog, or nil for events
+// without a Call-ID such as DNS notes.
+func (t *DialogTracker) Add(ev SipTraceEvent) *SipDialog {
+	callID := ev.CallID()
+	if callID == "" {
+		return nil
+	}
+	d, ok := t.byID[callID]
+	if !ok {
+		if len(t.byID) >= maxDialogs {
+			t.dropOldest()
+		}
+		d = &SipDialog{CallID: callID}
+		t.byID[callID] = d
+	}
+	d.add(ev)
+	t.correlate(d, ev)
+	return d
+}
+
+// dropOldest forgets the least recently active dialog.
+func (t *DialogTracker) dropOldest() {
+	var oldest *SipDialog
+	for _, d := range t.byID {
+		if oldest == nil || d.Last.Before(oldest.Last) {
+			oldest = d
+		}
+	}
+	if oldest != nil {
+		t.unlink(oldest)
+		delete(t.byID, oldest.CallID)
+	}
+}
+
+// Get returns the dialog with callID, or nil.
+func (t *DialogTracker) Get(callID string) *SipDialog {
+	return t.byID[callID]
+}
+
+// Dialogs returns all dialogs, oldest first.
+func (t *DialogTracker) Dialogs() []*SipDialog {
+	out := make([]*SipDialog, 0, len(t.byID))
+	for _, d := range t.byID {
+		out = append(out, d)
+	}
+	sortDialogs(out)
+	return out
+}
+
+// sortDialogs sorts dialogs by start time, then Call-ID.
+func sortDialogs(dialogs []*SipDialog) {
+	slices.SortFunc(dialogs, func(a, b *SipDialog) int {
+		if c := a.Start.Compare(b.Start); c != 0 {
+			return c
+		}
+		return strings.Compare(a.CallID, b.CallID)
+	})
+}
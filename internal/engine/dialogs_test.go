@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func traceMsg(dir, msg string, at time.Time) SipTraceEvent {
+	return SipTraceEvent{
+		Timestamp:  at,
+		Direction:  dir,
+		LocalAddr:  "192.0.2.1:5060",
+		RemoteAddr: "192.0.2.10:5060",
+		Message:    msg,
+	}
+}
+
+func request(method, callID string, cseq int) string {
+	return method + " sip:200@pbx.example.com SIP/2.0\r\n" +
+		"From: \"Alice\" <sip:100@pbx.example.com>;tag=a1\r\n" +
+		"To: <sip:200@pbx.example.com>\r\n" +
+		"Call-ID: " + callID + "\r\n" +
+		"CSeq: " + strconv.Itoa(cseq) + " " + method + "\r\n\r\n"
+}
+
+func response(status, method, callID string, cseq int) string {
+	return "SIP/2.0 " + status + "\r\n" +
+		"From: \"Alice\" <sip:100@pbx.example.com>;tag=a1\r\n" +
+		"To: <sip:200@pbx.example.com>;tag=b2\r\n" +
+		"Call-ID: " + callID + "\r\n" +
+		"CSeq: " + strconv.Itoa(cseq) + " " + method + "\r\n\r\n"
+}
+
+func TestDialogTrackerInviteStates(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	steps := []struct {
+		ev    SipTraceEvent
+		state string
+	}{
+		{traceMsg("send", request("INVITE", "c1", 1), t0), "Calling"},
+		{traceMsg("recv", response("407 Proxy Authentication Required", "INVITE", "c1", 1), t0), "Calling"},
+		{traceMsg("send", request("INVITE", "c1", 2), t0), "Calling"},
+		{traceMsg("recv", response("180 Ringing", "INVITE", "c1", 2), t0), "Ringing"},
+		{traceMsg("recv", response("200 OK", "INVITE", "c1", 2), t0), "In call"},
+		{traceMsg("send", request("ACK", "c1", 2), t0), "In call"},
+		{traceMsg("send", request("INVITE", "c1", 3), t0), "In call"},
+		{traceMsg("recv", response("491 Request Pending", "INVITE", "c1", 3), t0), "In call"},
+		{traceMsg("send", request("BYE", "c1", 4), t0.Add(time.Minute)), "Completed"},
+		{traceMsg("recv", response("200 OK", "BYE", "c1", 4), t0.Add(time.Minute)), "Completed"},
+	}
+	tr := NewDialogTracker()
+	for i, s := range steps {
+		d := tr.Add(s.ev)
+		if d.State != s.state {
+			t.Fatalf("after message %d: state %q, want %q", i, d.State, s.state)
+		}
+	}
+	d := tr.Get("c1")
+	if d.Method != "INVITE" || d.From != "100@pbx.example.com" || d.To != "200@pbx.example.com" {
+		t.Errorf("dialog = %s %s -> %s", d.Method, d.From, d.To)
+	}
+	if d.Count != len(steps) || !d.Last.Equal(t0.Add(time.Minute)) {
+		t.Errorf("messages = %d, last = %v", d.Count, d.Last)
+	}
+}
+
+func TestDialogTrackerFinalStates(t *testing.T) {
+	t0 := time.Now()
+	tests := []struct {
+		name string
+		msgs []string
+		want string
+	}{
+		{"busy", []string{request("INVITE", "b", 1), response("486 Busy Here", "INVITE", "b", 1)}, "Busy"},
+		{"cancel", []string{request("INVITE", "b", 1), request("CANCEL", "b", 1), response("487 Request Terminated", "INVITE", "b", 1)}, "Cancelled"},
+		{"rejected", []string{request("INVITE", "b", 1), response("404 Not Found", "INVITE", "b", 1)}, "Rejected"},
+		{"diverted", []string{request("INVITE", "b", 1), response("302 Moved Temporarily", "INVITE", "b", 1)}, "Diverted"},
+		{"register", []string{request("REGISTER", "b", 1), response("401 Unauthorized", "REGISTER", "b", 1), request("REGISTER", "b", 2), response("200 OK", "REGISTER", "b", 2)}, "Completed"},
+		{"options failed", []string{request("OPTIONS", "b", 1), response("503 Service Unavailable", "OPTIONS", "b", 1)}, "Failed"},
+		{"subscribe", []string{request("SUBSCRIBE", "b", 1), response("200 OK", "SUBSCRIBE", "b", 1)}, "Active"},
+		{"response first", []string{response("200 OK", "OPTIONS", "b", 1)}, "Completed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := NewDialogTracker()
+			var d *SipDialog
+			for _, m := range tt.msgs {
+				d = tr.Add(traceMsg("recv", m, t0))
+			}
+			if d.State != tt.want {
+				t.Errorf("state = %q, want %q", d.State, tt.want)
+			}
+		})
+	}
+}
+
+func TestDialogTrackerIgnoresDNS(t *testing.T) {
+	tr := NewDialogTracker()
+	if d := tr.Add(traceDNSNote); d != nil {
+		t.Errorf("DNS note filed as dialog %+v", d)
+	}
+	tr.Add(traceOptions)
+	tr.Add(traceInvite)
+	if n := len(tr.Dialogs()); n != 2 {
+		t.Errorf("Dialogs() = %d, want 2", n)
+	}
+}
+
+func TestHeaderAOR(t *testing.T) {
+	tests := map[string]string{
+		`"Alice" <sip:alice@pbx.example.com;transport=tcp>;tag=1`: "alice@pbx.example.com",
+		"sip:bob@192.0.2.1;tag=2":                                 "bob@192.0.2.1",
+		"<tel:+15551234>":                                         "+15551234",
+		"":                                                        "",
+	}
+	for in, want := range tests {
+		if got := headerAOR(in); got != want {
+			t.Errorf("headerAOR(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
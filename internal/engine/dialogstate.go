@@ -0,0 +1,383 @@
+package engine
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/siptty/siptty/internal/sdp"
+)
+
+// DialogState is the state of an INVITE dialog as siptty's side of it sees
+// it (RFC 3261 section 12), built from the traced messages of its Call-ID.
+// Building it from the trace, rather than reading the SIP stack, keeps it
+// current across re-INVITEs and UPDATEs and works for any traced dialog.
+// A Call follows its own as messages arrive (see Engine.DialogState), so
+// it outlives the trace history.
+type DialogState struct {
+	CallID    string
+	Direction string // "outbound" if siptty sent the INVITE, else "inbound"
+	Confirmed bool   // a 2xx to the INVITE has been seen
+
+	LocalURI     string
+	LocalTag     string
+	RemoteURI    string
+	RemoteTag    string
+	LocalCSeq    int      // highest CSeq siptty sent in a request
+	RemoteCSeq   int      // highest CSeq the peer sent in a request
+	RouteSet     []string // Route headers of in-dialog requests, in order
+	RemoteTarget string   // the peer's latest Contact URI
+
+	SessionExpires int    // session interval in seconds (RFC 4028); 0 without timers
+	Refresher      string // "uac" or "uas"
+	MinSE          int    // seconds
+
+	LocalSDP  *MediaState // the latest SDP siptty sent
+	RemoteSDP *MediaState // the latest SDP the peer sent
+
+	Invite    SipTraceEvent // the INVITE that established the dialog
+	Answer    SipTraceEvent // its 2xx; zero until Confirmed
+	Refreshes int           // re-INVITEs and UPDATEs accepted since
+}
+
+// MediaState summarizes the audio stream of a session description.
+type MediaState struct {
+	Addr      string   // RTP address, e.g. "10.0.0.5:4000"
+	Codec     string   // first payload format, e.g. "PCMU/8000"
+	Codecs    []string // every payload format offered, in order
+	Ptime     int      // milliseconds; 0 when unset
+	Direction string   // "sendrecv", "sendonly", "recvonly" or "inactive"
+}
+
+// BuildDialogState builds the state of the INVITE dialog in events, the
+// traced messages of one Call-ID oldest first. It returns nil when they do
+// not include the initial INVITE.
+func BuildDialogState(events []SipTraceEvent) *DialogState {
+	var b dialogBuilder
+	for _, ev := range events {
+		b.add(ev)
+	}
+	return b.st
+}
+
+// dialogBuilder follows an INVITE dialog one traced message at a time.
+type dialogBuilder struct {
+	st     *DialogState          // nil until the initial INVITE
+	offers map[int]SipTraceEvent // INVITE and UPDATE offers by CSeq
+	seq    int                   // Seq of the last message added
+}
+
+// add advances the dialog by ev. Messages from the trace store at or
+// before the last one added are skipped, so a Call can be seeded from the
+// store while new messages are fed to it.
+func (b *dialogBuilder) add(ev SipTraceEvent) {
+	if ev.Direction == "dns" {
+		return
+	}
+	if ev.Seq != 0 {
+		if ev.Seq <= b.seq {
+			return
+		}
+		b.seq = ev.Seq
+	}
+	ours := ev.Direction == "send"
+	method, code := ev.Method(), ev.StatusCode()
+	cseq := cseqNumber(ev.Header("CSeq", ""))
+
+	if b.st == nil {
+		if method != "INVITE" || code != 0 || headerTag(ev.Header("To", "t")) != "" {
+			return
+		}
+		b.st = newDialogState(ev)
+		b.offers = make(map[int]SipTraceEvent)
+	}
+	st := b.st
+
+	if code == 0 {
+		if ours {
+			st.LocalCSeq = max(st.LocalCSeq, cseq)
+		} else {
+			st.RemoteCSeq = max(st.RemoteCSeq, cseq)
+		}
+		switch method {
+		case "INVITE", "UPDATE":
+			b.offers[cseq] = ev
+			if !ours && st.Confirmed {
+				st.updateTarget(ev)
+			}
+		case "ACK":
+			st.applySDP(ev) // answer to an offer in a 2xx
+		}
+		return
+	}
+
+	if method != "INVITE" && method != "UPDATE" {
+		return
+	}
+	if code > 100 && code < 200 && method == "INVITE" && !st.Confirmed {
+		st.applySDP(ev) // early media
+	}
+	if code < 200 || code >= 300 {
+		return
+	}
+	offer, ok := b.offers[cseq]
+	if !ok {
+		return
+	}
+	delete(b.offers, cseq)
+	if method == "INVITE" && !st.Confirmed {
+		st.confirm(offer, ev)
+	} else {
+		st.Refreshes++
+	}
+	st.applySDP(offer)
+	st.applySDP(ev)
+	st.applyTimer(offer, ev)
+	if !ours {
+		st.updateTarget(ev)
+	}
+}
+
+// state returns a copy of the dialog state, or nil before the INVITE.
+// Fields are replaced rather than changed in place, so a shallow copy is
+// enough.
+func (b *dialogBuilder) state() *DialogState {
+	if b.st == nil {
+		return nil
+	}
+	st := *b.st
+	return &st
+}
+
+// DialogState returns the state of a call's INVITE dialog, or nil if the
+// call is unknown or its INVITE was never traced.
+func (e *Engine) DialogState(callID string) *DialogState {
+	e.mu.RLock()
+	call, ok := e.calls[callID]
+	e.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	call.mu.Lock()
+	defer call.mu.Unlock()
+	return call.dialog.state()
+}
+
+// addCall registers a new call, with its account's network impairment, and
+// seeds its dialog state with the messages traced before it existed, such
+// as the INVITE and its answer.
+// Holding call.mu until then makes trackDialog wait, so nothing is missed
+// or fed out of order.
+func (e *Engine) addCall(call *Call) {
+	call.mu.Lock()
+	defer call.mu.Unlock()
+	call.rtpImpair, call.sipImpair = callImpairment(call.account)
+	e.mu.Lock()
+	e.calls[call.ID] = call
+	e.mu.Unlock()
+	if call.SIPCallID == "" || e.traces == nil {
+		return
+	}
+	for _, ev := range e.traces.Query(TraceQuery{CallID: call.SIPCallID}) {
+		call.dialog.add(ev)
+	}
+}
+
+// trackDialog feeds a traced message to the dialog state of its call, if
+// any. The tracer calls it after filing the message in the trace store.
+func (e *Engine) trackDialog(ev SipTraceEvent) {
+	call := e.callBySIPCallID(ev.CallID())
+	if call == nil {
+		return
+	}
+	call.mu.Lock()
+	call.dialog.add(ev)
+	call.mu.Unlock()
+}
+
+// callBySIPCallID returns the call whose INVITE dialog has Call-ID id.
+func (e *Engine) callBySIPCallID(id string) *Call {
+	if id == "" {
+		return nil
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for _, c := range e.calls {
+		if c.SIPCallID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// newDialogState starts a dialog at its initial INVITE.
+func newDialogState(invite SipTraceEvent) *DialogState {
+	st := &DialogState{
+		CallID:    invite.CallID(),
+		Direction: "inbound",
+		Invite:    invite,
+	}
+	from, to := invite.Header("From", "f"), invite.Header("To", "t")
+	if invite.Direction == "send" {
+		st.Direction = "outbound"
+		st.LocalURI, st.LocalTag = headerURI(from), headerTag(from)
+		st.RemoteURI = headerURI(to)
+	} else {
+		st.LocalURI = headerURI(to)
+		st.RemoteURI, st.RemoteTag = headerURI(from), headerTag(from)
+		st.updateTarget(invite)
+		st.RouteSet = headerValues(invite.Message, "Record-Route", "")
+	}
+	return st
+}
+
+// confirm records the 2xx that established the dialog.
+func (st *DialogState) confirm(invite, answer SipTraceEvent) {
+	st.Confirmed = true
+	st.Invite, st.Answer = invite, answer
+	tag := headerTag(answer.Header("To", "t"))
+	if st.Direction == "outbound" {
+		st.RemoteTag = tag
+		// A UAC uses the 2xx's Record-Route in reverse (RFC 3261 12.1.2).
+		st.RouteSet = headerValues(answer.Message, "Record-Route", "")
+		slices.Reverse(st.RouteSet)
+	} else {
+		st.LocalTag = tag
+	}
+}
+
+// updateTarget takes the remote target from the peer's Contact.
+func (st *DialogState) updateTarget(ev SipTraceEvent) {
+	if contact := ev.Header("Contact", "m"); contact != "" {
+		st.RemoteTarget = headerURI(contact)
+	}
+}
+
+// applySDP records the session description in ev, if any, as the latest
+// one of the side that sent it.
+func (st *DialogState) applySDP(ev SipTraceEvent) {
+	m := parseMediaState(messageBody(ev.Message))
+	if m == nil {
+		return
+	}
+	if ev.Direction == "send" {
+		st.LocalSDP = m
+	} else {
+		st.RemoteSDP = m
+	}
+}
+
+// applyTimer takes the session timer negotiated by an accepted INVITE or
+// UPDATE: the 2xx's Session-Expires, and Min-SE from either message.
+func (st *DialogState) applyTimer(req, res SipTraceEvent) {
+	st.SessionExpires, st.Refresher = 0, ""
+	if v := res.Header("Session-Expires", "x"); v != "" {
+		interval, params, _ := strings.Cut(v, ";")
+		st.SessionExpires, _ = strconv.Atoi(strings.TrimSpace(interval))
+		for _, p := range strings.Split(params, ";") {
+			if name, value, _ := strings.Cut(strings.TrimSpace(p), "="); strings.EqualFold(name, "refresher") {
+				st.Refresher = strings.ToLower(value)
+			}
+		}
+	}
+	for _, ev := range []SipTraceEvent{res, req} {
+		if v := ev.Header("Min-SE", ""); v != "" {
+			n, _, _ := strings.Cut(v, ";")
+			st.MinSE, _ = strconv.Atoi(strings.TrimSpace(n))
+			break
+		}
+	}
+}
+
+// parseMediaState summarizes the audio stream of an SDP body, or returns
+// nil when there is none.
+func parseMediaState(body string) *MediaState {
+	if body == "" {
+		return nil
+	}
+	sess, err := sdp.Parse([]byte(body))
+	if err != nil || sess.Audio() == nil {
+		return nil
+	}
+	audio := sess.Audio()
+	m := &MediaState{
+		Ptime:     sess.Ptime(audio),
+		Direction: sess.Direction(audio),
+	}
+	m.Addr, _ = sess.MediaAddr(audio)
+	for _, pt := range audio.Formats {
+		codec := audio.Codec(pt)
+		if codec == "" {
+			codec = pt
+		}
+		m.Codecs = append(m.Codecs, codec)
+	}
+	if len(m.Codecs) > 0 {
+		m.Codec = m.Codecs[0]
+	}
+	return m
+}
+
+// headerValues returns the entries of every header called name (or its
+// compact form) in a raw SIP message, splitting comma-separated values.
+func headerValues(msg, name, compact string) []string {
+	var out []string
+	_, rest, _ := strings.Cut(msg, "\n")
+	for rest != "" {
+		var line string
+		line, rest, _ = strings.Cut(rest, "\n")
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			break
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		if strings.EqualFold(key, name) || (compact != "" && strings.EqualFold(key, compact)) {
+			out = append(out, splitAddressList(value)...)
+		}
+	}
+	return out
+}
+
+// messageBody returns the body of a raw SIP message.
+func messageBody(msg string) string {
+	for _, sep := range []string{"\r\n\r\n", "\n\n"} {
+		if _, body, ok := strings.Cut(msg, sep); ok {
+			return body
+		}
+	}
+	return ""
+}
+
+// headerURI returns the URI of a name-addr or addr-spec header value.
+func headerURI(v string) string {
+	if _, rest, ok := strings.Cut(v, "<"); ok {
+		uri, _, _ := strings.Cut(rest, ">")
+		return uri
+	}
+	uri, _, _ := strings.Cut(v, ";")
+	return strings.TrimSpace(uri)
+}
+
+// headerTag returns the tag parameter of a From or To header value.
+func headerTag(v string) string {
+	if i := strings.LastIndexByte(v, '>'); i >= 0 {
+		v = v[i+1:]
+	}
+	for _, p := range strings.Split(v, ";") {
+		if name, value, _ := strings.Cut(strings.TrimSpace(p), "="); strings.EqualFold(name, "tag") {
+			return value
+		}
+	}
+	return ""
+}
+
+// cseqNumber returns the sequence number of a CSeq header value.
+func cseqNumber(v string) int {
+	n, _, _ := strings.Cut(strings.TrimSpace(v), " ")
+	num, _ := strconv.Atoi(n)
+	return num
+}
@@ -0,0 +1,169 @@
+package engine
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withSDP adds an SDP body to a message built by request or response.
+func withSDP(msg, body string) string {
+	return withHeaders(msg, "Content-Type: application/sdp") + body
+}
+
+func testSDP(addr, port, dir string, formats ...string) string {
+	return "v=0\r\n" +
+		"o=- 1 1 IN IP4 " + addr + "\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 " + addr + "\r\n" +
+		"t=0 0\r\n" +
+		"m=audio " + port + " RTP/AVP " + strings.Join(formats, " ") + "\r\n" +
+		"a=rtpmap:101 telephone-event/8000\r\n" +
+		"a=ptime:20\r\n" +
+		"a=" + dir + "\r\n"
+}
+
+func TestBuildDialogStateOutbound(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	offer := testSDP("192.0.2.1", "4000", "sendrecv", "0", "8", "101")
+	answer := testSDP("198.51.100.7", "30000", "sendrecv", "8", "101")
+	established := withSDP(withHeaders(response("200 OK", "INVITE", "c1", 2),
+		"Record-Route: <sip:p2.example.com;lr>, <sip:p1.example.com;lr>",
+		"Contact: <sip:200@198.51.100.7:5060>",
+		"Session-Expires: 1800;refresher=uac"), answer)
+	events := []SipTraceEvent{
+		traceMsg("send", withSDP(request("INVITE", "c1", 1), offer), t0),
+		traceMsg("recv", response("407 Proxy Authentication Required", "INVITE", "c1", 1), t0),
+		traceMsg("send", withSDP(withHeaders(request("INVITE", "c1", 2), "Min-SE: 90"), offer), t0),
+		traceMsg("recv", response("180 Ringing", "INVITE", "c1", 2), t0),
+		traceMsg("recv", established, t0),
+		traceMsg("send", request("ACK", "c1", 2), t0),
+	}
+
+	st := BuildDialogState(events)
+	if st == nil {
+		t.Fatal("BuildDialogState = nil")
+	}
+	if !st.Confirmed || st.Direction != "outbound" || st.CallID != "c1" {
+		t.Fatalf("state = %+v, want confirmed outbound c1", st)
+	}
+	if st.LocalTag != "a1" || st.RemoteTag != "b2" {
+		t.Errorf("tags = %q/%q, want a1/b2", st.LocalTag, st.RemoteTag)
+	}
+	if st.LocalURI != "sip:100@pbx.example.com" || st.RemoteURI != "sip:200@pbx.example.com" {
+		t.Errorf("URIs = %q/%q", st.LocalURI, st.RemoteURI)
+	}
+	if st.LocalCSeq != 2 || st.RemoteCSeq != 0 {
+		t.Errorf("CSeq = %d/%d, want 2/0", st.LocalCSeq, st.RemoteCSeq)
+	}
+	if want := []string{"<sip:p1.example.com;lr>", "<sip:p2.example.com;lr>"}; !slices.Equal(st.RouteSet, want) {
+		t.Errorf("RouteSet = %q, want reversed Record-Route %q", st.RouteSet, want)
+	}
+	if st.RemoteTarget != "sip:200@198.51.100.7:5060" {
+		t.Errorf("RemoteTarget = %q", st.RemoteTarget)
+	}
+	if st.SessionExpires != 1800 || st.Refresher != "uac" || st.MinSE != 90 {
+		t.Errorf("session timer = %d %q min %d, want 1800 uac min 90", st.SessionExpires, st.Refresher, st.MinSE)
+	}
+	if st.Invite.Message != events[2].Message || st.Answer.Message != established {
+		t.Error("Invite/Answer are not the authenticated INVITE and its 200")
+	}
+	if m := st.LocalSDP; m == nil || m.Codec != "PCMU/8000" || m.Addr != "192.0.2.1:4000" || m.Ptime != 20 {
+		t.Errorf("LocalSDP = %+v", m)
+	}
+	if m := st.RemoteSDP; m == nil || m.Codec != "PCMA/8000" || !slices.Equal(m.Codecs, []string{"PCMA/8000", "telephone-event/8000"}) {
+		t.Errorf("RemoteSDP = %+v", m)
+	}
+
+	// The peer puts the call on hold with a re-INVITE from a new Contact.
+	hold := withSDP(withHeaders(request("INVITE", "c1", 7), "Contact: <sip:200@198.51.100.8:5060>"),
+		testSDP("198.51.100.7", "30000", "sendonly", "8"))
+	events = append(events,
+		traceMsg("recv", hold, t0),
+		traceMsg("send", withSDP(withHeaders(response("200 OK", "INVITE", "c1", 7), "Session-Expires: 900;refresher=uas"),
+			testSDP("192.0.2.1", "4000", "recvonly", "8")), t0),
+		traceMsg("recv", request("ACK", "c1", 7), t0),
+		// A rejected UPDATE offer changes nothing.
+		traceMsg("send", withSDP(request("UPDATE", "c1", 3), testSDP("192.0.2.1", "4002", "inactive", "0")), t0),
+		traceMsg("recv", response("488 Not Acceptable Here", "UPDATE", "c1", 3), t0),
+	)
+	st = BuildDialogState(events)
+	if st.Refreshes != 1 {
+		t.Errorf("Refreshes = %d, want 1", st.Refreshes)
+	}
+	if st.LocalCSeq != 3 || st.RemoteCSeq != 7 {
+		t.Errorf("CSeq = %d/%d, want 3/7", st.LocalCSeq, st.RemoteCSeq)
+	}
+	if st.RemoteTarget != "sip:200@198.51.100.8:5060" {
+		t.Errorf("RemoteTarget = %q, want the re-INVITE's Contact", st.RemoteTarget)
+	}
+	if st.RemoteSDP.Direction != "sendonly" || st.LocalSDP.Direction != "recvonly" || st.LocalSDP.Addr != "192.0.2.1:4000" {
+		t.Errorf("SDP after hold = %+v / %+v", st.LocalSDP, st.RemoteSDP)
+	}
+	if st.SessionExpires != 900 || st.Refresher != "uas" {
+		t.Errorf("session timer = %d %q, want 900 uas", st.SessionExpires, st.Refresher)
+	}
+	if st.Answer.Message != established {
+		t.Error("Answer replaced by the re-INVITE's 200")
+	}
+}
+
+func TestBuildDialogStateInbound(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	invite := withHeaders(request("INVITE", "c2", 5),
+		"Record-Route: <sip:p1.example.com;lr>",
+		"Record-Route: <sip:p2.example.com;lr>",
+		"m: <sip:100@203.0.113.5>")
+	st := BuildDialogState([]SipTraceEvent{
+		traceMsg("recv", invite, t0),
+		traceMsg("send", response("180 Ringing", "INVITE", "c2", 5), t0),
+	})
+	if st == nil || st.Confirmed || st.Direction != "inbound" {
+		t.Fatalf("state = %+v, want unconfirmed inbound", st)
+	}
+	if st.RemoteTag != "a1" || st.LocalTag != "" || st.RemoteCSeq != 5 {
+		t.Errorf("tags %q/%q CSeq %d", st.LocalTag, st.RemoteTag, st.RemoteCSeq)
+	}
+	if want := []string{"<sip:p1.example.com;lr>", "<sip:p2.example.com;lr>"}; !slices.Equal(st.RouteSet, want) {
+		t.Errorf("RouteSet = %q, want Record-Route in order %q", st.RouteSet, want)
+	}
+	if st.RemoteTarget != "sip:100@203.0.113.5" {
+		t.Errorf("RemoteTarget = %q", st.RemoteTarget)
+	}
+
+	if BuildDialogState([]SipTraceEvent{traceMsg("send", request("OPTIONS", "c3", 1), t0)}) != nil {
+		t.Error("BuildDialogState of an OPTIONS transaction is not nil")
+	}
+}
+
+func TestCallDialogStateOutlivesTrace(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	e := &Engine{calls: make(map[string]*Call), traces: NewTraceStore(3, 0)}
+	tracer := &sipTracer{events: make(chan Event, 16), store: e.traces, dialog: e.trackDialog}
+
+	// The INVITE and its 200 are traced before the call exists.
+	tracer.send(traceMsg("send", request("INVITE", "c1", 1), t0))
+	tracer.send(traceMsg("recv", response("200 OK", "INVITE", "c1", 1), t0))
+	e.addCall(&Call{ID: "1", SIPCallID: "c1"})
+	tracer.send(traceMsg("send", request("ACK", "c1", 1), t0))
+
+	// Enough later messages to evict the INVITE from the trace history.
+	for cseq := 2; cseq <= 5; cseq++ {
+		tracer.send(traceMsg("send", request("INFO", "c1", cseq), t0))
+	}
+	if BuildDialogState(e.traces.Query(TraceQuery{CallID: "c1"})) != nil {
+		t.Fatal("INVITE still in the trace history")
+	}
+
+	st := e.DialogState("1")
+	if st == nil || !st.Confirmed {
+		t.Fatalf("DialogState = %+v, want the confirmed dialog", st)
+	}
+	if st.LocalCSeq != 5 || st.Invite.Method() != "INVITE" || st.Answer.StatusCode() != 200 {
+		t.Errorf("LocalCSeq %d, Invite %q, Answer %d", st.LocalCSeq, st.Invite.Method(), st.Answer.StatusCode())
+	}
+	if e.DialogState("2") != nil {
+		t.Error("DialogState of an unknown call is not nil")
+	}
+}
@@ -1,9 +1,14 @@
 package engine
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log/slog"
+	"net"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -12,16 +17,31 @@ import (
 	"github.com/emiago/sipgo"
 	"github.com/emiago/sipgo/sip"
 	"github.com/siptty/siptty/internal/config"
+	"github.com/siptty/siptty/internal/dns"
+	"github.com/siptty/siptty/internal/impair"
 )
 
 // Engine owns the diago instance and provides a clean API to the TUI.
 type Engine struct {
-	dg     *diago.Diago
-	ua     *sipgo.UserAgent
-	config *config.Config
-	events chan Event
+	dg       *diago.Diago
+	ua       *sipgo.UserAgent
+	client   *sipgo.Client // for siptty's own requests (keepalive OPTIONS)
+	resolver *dns.Resolver // RFC 3263 next-hop lookups
+	config   *config.Config
+	events   chan Event
+	tracer   *sipTracer
+	traces   *TraceStore
+	rtp      map[string]*rtpCapture // captured RTP by SIP Call-ID, with capture_rtp
+	hep      *hepMirror             // with a trace.hep collector configured
+	traceLog *traceLog              // with a trace.log path configured
+
+	tlsAccounts  map[string]*accountTLS // TLS settings of the tls and wss accounts
+	tlsPeers     *tlsPeers              // which account each TLS connection is for
+	listenSecure bool                   // the listener serves tls or wss
+	sipConn      *impairedConn          // the listener's socket, when it serves udp
 
 	accounts map[string]*Account
+	order    []string // account IDs in config order
 	calls    map[string]*Call
 	mu       sync.RWMutex
 
@@ -31,11 +51,31 @@ type Engine struct {
 
 // sipTracer implements sipgo's sip.SIPTracer interface to capture raw SIP messages.
 type sipTracer struct {
-	events chan<- Event
-	once   sync.Once
+	events  chan<- Event
+	store   *TraceStore
+	once    sync.Once
+	observe func(msg []byte)    // called for every received message, before it is traced
+	account func(string) string // attributes a message to an account
+	hep     *hepMirror          // copies messages to a HEP collector
+	log     *traceLog           // writes messages to the trace log file
+	dialog  func(SipTraceEvent) // follows the dialogs of calls, after the store
+
+	// secure reports whether the stream a message went over is TLS or WSS:
+	// sipgo traces those as "TCP" and "WS".
+	secure func(laddr, accountID string) bool
 }
 
+// secureTransports maps the labels sipgo traces TLS and WSS streams with to
+// their real names.
+var secureTransports = map[string]string{"TCP": "TLS", "WS": "WSS"}
+
 func (t *sipTracer) SIPTraceRead(transport, laddr, raddr string, msg []byte) {
+	if isKeepalive(msg) {
+		return
+	}
+	if t.observe != nil {
+		t.observe(msg)
+	}
 	ev := SipTraceEvent{
 		Direction:  "recv",
 		Message:    string(msg),
@@ -44,21 +84,43 @@ func (t *sipTracer) SIPTraceRead(transport, laddr, raddr string, msg []byte) {
 		LocalAddr:  laddr,
 		RemoteAddr: raddr,
 	}
-	select {
-	case t.events <- ev:
-	default:
-		t.dropWarn()
-	}
+	t.send(ev)
 }
 
 func (t *sipTracer) SIPTraceWrite(transport, laddr, raddr string, msg []byte) {
-	ev := SipTraceEvent{
+	if isKeepalive(msg) {
+		return
+	}
+	t.send(SipTraceEvent{
 		Direction:  "send",
 		Message:    string(msg),
 		Timestamp:  time.Now(),
 		Transport:  transport,
 		LocalAddr:  laddr,
 		RemoteAddr: raddr,
+	})
+}
+
+// send attributes ev to an account, files it in the trace store and queues
+// it without blocking sipgo.
+func (t *sipTracer) send(ev SipTraceEvent) {
+	if t.account != nil && ev.Direction != "dns" {
+		ev.AccountID = t.account(ev.Message)
+	}
+	if secure, ok := secureTransports[ev.Transport]; ok && t.secure != nil && t.secure(ev.LocalAddr, ev.AccountID) {
+		ev.Transport = secure
+	}
+	if t.store != nil {
+		ev = t.store.Add(ev)
+	}
+	if t.dialog != nil {
+		t.dialog(ev)
+	}
+	if t.log != nil {
+		t.log.write(ev)
+	}
+	if t.hep != nil {
+		t.hep.mirror(ev)
 	}
 	select {
 	case t.events <- ev:
@@ -67,12 +129,94 @@ func (t *sipTracer) SIPTraceWrite(transport, laddr, raddr string, msg []byte) {
 	}
 }
 
+// isKeepalive reports whether msg is a CRLF keepalive or pong rather than a SIP message.
+func isKeepalive(msg []byte) bool {
+	return len(bytes.TrimSpace(msg)) == 0
+}
+
 func (t *sipTracer) dropWarn() {
 	t.once.Do(func() {
-		slog.Warn("SIP trace events dropped: channel full, TUI too slow")
+		slog.Warn("events dropped: channel full, TUI too slow")
 	})
 }
 
+// observeMessage is fed every received SIP message by the tracer, before
+// sipgo handles it. A BYE sends the quality reports of its call while the
+// media session is still open. Responses to an account's REGISTER are
+// mined for what the registrar tells us: the public address (Via
+// received/rport) and the Service-Route and Path sets.
+func (e *Engine) observeMessage(msg []byte) {
+	if bytes.HasPrefix(msg, []byte("BYE ")) {
+		if call := e.callBySIPCallID(headerValue(string(msg), "Call-ID", "i")); call != nil {
+			call.sendQualityReports(true)
+		}
+		return
+	}
+	if !bytes.HasPrefix(msg, []byte("SIP/2.0 ")) || !bytes.Contains(msg, []byte("REGISTER")) {
+		return
+	}
+	parsed, err := sip.ParseMessage(msg)
+	if err != nil {
+		return
+	}
+	res, ok := parsed.(*sip.Response)
+	if !ok || res.CSeq() == nil || res.CSeq().MethodName != sip.REGISTER || res.CallID() == nil {
+		return
+	}
+
+	acct := e.accountForRegister(res.CallID().Value())
+	if acct == nil {
+		return
+	}
+	acct.learnRoutes(res)
+	e.learnNATAddress(acct, res)
+}
+
+// accountForRegister returns the account whose REGISTER uses callID.
+func (e *Engine) accountForRegister(callID string) *Account {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for _, acct := range e.accounts {
+		if origin := acct.registerRequest(); origin != nil && origin.CallID() != nil && origin.CallID().Value() == callID {
+			return acct
+		}
+	}
+	return nil
+}
+
+// accountForMessage returns the ID of the account whose AOR appears in the
+// From or To header of msg: From for what it sends, To for what it receives.
+func (e *Engine) accountForMessage(msg string) string {
+	from := strings.ToLower(headerValue(msg, "From", "f"))
+	to := strings.ToLower(headerValue(msg, "To", "t"))
+	if from == "" && to == "" {
+		return ""
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for _, id := range e.order {
+		aor := e.accounts[id].aor
+		if aor != "" && (strings.Contains(from, aor) || strings.Contains(to, aor)) {
+			return id
+		}
+	}
+	return ""
+}
+
+// secureConn reports whether a stream connection is TLS or WSS: that of
+// the account a message belongs to, or for one no account claims, that of
+// the listener when laddr is on its port.
+func (e *Engine) secureConn(laddr, accountID string) bool {
+	e.mu.RLock()
+	acct := e.accounts[accountID]
+	e.mu.RUnlock()
+	if acct != nil {
+		return config.IsSecureTransport(acct.Config.Transport)
+	}
+	_, port, err := net.SplitHostPort(laddr)
+	return err == nil && e.listenSecure && port == strconv.Itoa(e.config.General.BindPort)
+}
+
 // NewEngine creates a new engine from the config.
 // The sipgo UA name is set to the first account's extension (user part of SIP URI)
 // because Asterisk validates digest auth against the From header user part.
@@ -82,64 +226,253 @@ func NewEngine(cfg *config.Config) (*Engine, error) {
 		events:   make(chan Event, 256),
 		accounts: make(map[string]*Account),
 		calls:    make(map[string]*Call),
+		traces:   NewTraceStore(cfg.Trace.MaxMessages, cfg.Trace.MaxBytes),
+		rtp:      make(map[string]*rtpCapture),
+
+		tlsAccounts: make(map[string]*accountTLS),
+		tlsPeers:    newTLSPeers(),
 	}
 
 	// Install SIP tracer before creating UA so all messages are captured.
+	if cfg.Trace.HEP.Address != "" {
+		e.hep = newHEPMirror(cfg.Trace.HEP)
+	}
+	if cfg.Trace.Log.Path != "" {
+		var err error
+		if e.traceLog, err = openTraceLog(cfg.Trace.Log); err != nil {
+			return nil, err
+		}
+	}
+	e.tracer = &sipTracer{
+		events:  e.events,
+		store:   e.traces,
+		observe: e.observeMessage,
+		account: e.accountForMessage,
+		hep:     e.hep,
+		log:     e.traceLog,
+		dialog:  e.trackDialog,
+		secure:  e.secureConn,
+	}
 	sip.SIPDebug = true
-	sip.SIPDebugTracer(&sipTracer{events: e.events})
+	sip.SIPDebugTracer(e.tracer)
 
 	// UA name must be the SIP extension for digest auth to work with Asterisk.
 	uaName := deriveExtension(cfg)
-	ua, err := sipgo.NewUA(
+	uaOpts := []sipgo.UserAgentOption{
 		sipgo.WithUserAgent(uaName),
 		sipgo.WithUserAgentHostname("localhost"),
-	)
+	}
+
+	// sipgo keeps one TLS client config per UA; it checks each connection
+	// with the settings of the account it is made for (see verifyTLS).
+	var tlsConf *tls.Config
+	var secure []*accountTLS
+	for _, acctCfg := range cfg.Accounts {
+		if !acctCfg.Enabled || !config.IsSecureTransport(acctCfg.Transport) {
+			continue
+		}
+		p, err := loadAccountTLS(acctCfg.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("account %q: %w", acctCfg.Name, err)
+		}
+		e.tlsAccounts[acctCfg.Name] = p
+		secure = append(secure, p)
+	}
+	if len(secure) > 0 {
+		tlsConf = newTLSConfig(secure, e.verifyTLS)
+		if len(secure) == 1 {
+			// With a single TLS account its server_name can be the SNI of
+			// every handshake too.
+			tlsConf.ServerName = secure[0].serverName
+		}
+		if len(tlsConf.Certificates) > 1 {
+			slog.Warn("several accounts have TLS client certificates; each server gets the first its certificate request accepts")
+		}
+		uaOpts = append(uaOpts, sipgo.WithUserAgenTLSConfig(tlsConf))
+	}
+
+	// Likewise sipgo has one WebSocket dialer, which the config holds all
+	// ws/wss accounts to.
+	restoreWS := func() {}
+	for _, acctCfg := range cfg.Accounts {
+		if !acctCfg.Enabled || !config.IsWebSocket(acctCfg.Transport) {
+			continue
+		}
+		var err error
+		if restoreWS, err = configureWebSocket(acctCfg.WSURL); err != nil {
+			return nil, fmt.Errorf("account %q: %w", acctCfg.Name, err)
+		}
+		break
+	}
+	wsHost := invalidHost()
+
+	ua, err := sipgo.NewUA(uaOpts...)
+	restoreWS()
 	if err != nil {
 		return nil, fmt.Errorf("creating sipgo UA: %w", err)
 	}
 	e.ua = ua
 
+	client, err := sipgo.NewClient(ua, sipgo.WithClientNAT())
+	if err != nil {
+		return nil, fmt.Errorf("creating sipgo client: %w", err)
+	}
+	e.client = client
+
 	// Configure diago transport from config.
 	transport := "udp"
 	if len(cfg.Accounts) > 0 {
 		transport = cfg.Accounts[0].Transport
 	}
-	e.dg = diago.NewDiago(ua, diago.WithTransport(diago.Transport{
+	diagoTransport := diago.Transport{
 		Transport: transport,
 		BindHost:  cfg.General.BindHost,
 		BindPort:  cfg.General.BindPort,
-	}))
+	}
+	if len(cfg.Accounts) > 0 {
+		// NAT addresses are each account's own (see natAddresses); the
+		// transport advertises the local ones.
+		if net.ParseIP(cfg.General.BindHost).IsUnspecified() && allFamily(cfg.Accounts, "ipv6") {
+			// Bound to "::", diago would advertise an IPv4 self address.
+			// It advertises one address for all accounts, so only when
+			// every account is IPv6-only.
+			if ip, err := globalIPv6(); err != nil {
+				slog.Warn("no IPv6 address to advertise", "error", err)
+			} else {
+				diagoTransport.ExternalHost = uriHost(ip.String())
+				diagoTransport.MediaExternalIP = ip
+			}
+		}
+		if config.IsWebSocket(cfg.Accounts[0].Transport) {
+			// A WebSocket client is reached over its connection only; Via
+			// and Contact carry an .invalid name (RFC 7118 §5.2).
+			diagoTransport.ExternalHost = wsHost
+		}
+	}
+	if config.IsSecureTransport(transport) {
+		diagoTransport.TLSConf = tlsConf
+		e.listenSecure = true
+	}
+	if transport == "udp" {
+		// siptty binds the UDP listener itself, to impair the SIP
+		// received on it (see impairedConn).
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(cfg.General.BindHost), Port: cfg.General.BindPort})
+		if err != nil {
+			return nil, fmt.Errorf("binding SIP listener: %w", err)
+		}
+		e.sipConn = newImpairedConn(conn, e.sipImpairment)
+		diagoTransport.PacketConn = e.sipConn
+	}
+	e.dg = diago.NewDiago(ua, diago.WithTransport(diagoTransport))
 
 	// Set up account structs.
+	e.resolver = dns.NewResolver(cfg.General.DNSServer)
+	discover := discoverOnce(func(server string) (*net.UDPAddr, error) {
+		return stunDiscover(server, cfg.General.BindHost, cfg.General.BindPort)
+	})
 	for _, acctCfg := range cfg.Accounts {
 		if !acctCfg.Enabled {
 			continue
 		}
 		a := &Account{
-			ID:     acctCfg.Name,
-			Config: acctCfg,
-			State:  "unregistered",
+			ID:       acctCfg.Name,
+			Config:   acctCfg,
+			State:    "unregistered",
+			resolver: e.resolver,
+			tracer:   e.tracer,
+			tlsPeers: e.tlsPeers,
+			wsHost:   wsHost,
+			aor:      accountAOR(acctCfg.SipURI),
+			nat:      natAddresses(acctCfg, cfg.General.BindPort, discover),
+			codecs:   codecList(acctCfg),
+
+			sipImpair: impair.NewModel(sipProfile(acctCfg.Impairment)),
 		}
 		e.accounts[acctCfg.Name] = a
+		e.order = append(e.order, acctCfg.Name)
+		if config.ImpairsSIP(acctCfg.Impairment) && e.sipConn == nil {
+			slog.Warn("SIP impairment ignored: the listener is not udp", "account", a.ID)
+		}
+		if a.nat.source != "" {
+			e.notify(NATStateEvent{AccountID: a.ID, PublicAddr: a.nat.publicAddr(), Source: a.nat.source})
+		}
+		if config.IsSecureTransport(acctCfg.Transport) {
+			for _, host := range a.tlsHosts() {
+				e.tlsPeers.add(host, host, a.ID)
+			}
+		}
 	}
 
 	return e, nil
 }
 
+// verifyTLS checks a completed TLS handshake with the settings of the
+// account whose hop it was made to, or the defaults when no account claims
+// its server name, and reports it to the accounts sending there. It runs
+// inside the handshake, so it must not block.
+func (e *Engine) verifyTLS(cs tls.ConnectionState) error {
+	settings, hosts := defaultTLS, []string{cs.ServerName}
+	peer, ok := e.tlsPeers.lookup(cs.ServerName)
+	if ok {
+		hosts = peer.hosts
+		if p := e.tlsAccounts[peer.accounts[0]]; p != nil {
+			settings = p
+		}
+	}
+	if err := settings.verify(cs, hosts); err != nil {
+		slog.Warn("TLS verification failed", "server_name", cs.ServerName, "hosts", hosts, "error", err)
+		return err
+	}
+	for _, id := range peer.accounts {
+		e.notify(newTLSStateEvent(id, cs))
+	}
+	return nil
+}
+
+// notify queues ev without blocking, for callers on sipgo's read path or
+// inside a TLS handshake that must not wait for the TUI.
+func (e *Engine) notify(ev Event) {
+	select {
+	case e.events <- ev:
+	default:
+		e.tracer.dropWarn()
+	}
+}
+
 // Events returns the read-only event channel for the TUI.
 func (e *Engine) Events() <-chan Event {
 	return e.events
 }
 
-// Accounts returns the IDs of all configured accounts.
+// TraceStore returns the store holding the recent SIP trace.
+func (e *Engine) TraceStore() *TraceStore {
+	return e.traces
+}
+
+// Accounts returns the IDs of all configured accounts, in config order.
 func (e *Engine) Accounts() []string {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
-	ids := make([]string, 0, len(e.accounts))
-	for id := range e.accounts {
-		ids = append(ids, id)
+	return slices.Clone(e.order)
+}
+
+// accountForRequest picks the account an inbound request is addressed to:
+// the one whose SIP URI user matches the To user, else the first account.
+func (e *Engine) accountForRequest(req *sip.Request) *Account {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if to := req.To(); to != nil {
+		for _, id := range e.order {
+			var uri sip.Uri
+			if err := sip.ParseUri(e.accounts[id].Config.SipURI, &uri); err == nil && uri.User == to.Address.User {
+				return e.accounts[id]
+			}
+		}
+	}
+	if len(e.order) == 0 {
+		return nil
 	}
-	return ids
+	return e.accounts[e.order[0]]
 }
 
 // Start begins serving (for inbound calls) and registers all accounts.
@@ -148,15 +481,22 @@ func (e *Engine) Start(ctx context.Context) error {
 	serveCtx, serveCancel := context.WithCancel(ctx)
 	e.serveCancel = serveCancel
 
+	if e.sipConn != nil {
+		go e.sipConn.run()
+	}
 	if err := e.dg.ServeBackground(serveCtx, e.inboundHandler); err != nil {
 		serveCancel()
 		return fmt.Errorf("serve background: %w", err)
 	}
 
+	if e.hep != nil {
+		go e.hep.run(serveCtx)
+	}
+
 	// Register all enabled accounts in goroutines.
 	for _, acct := range e.accounts {
 		if acct.Config.Register {
-			go acct.register(ctx, e.dg, e.events)
+			go e.maintainRegistration(ctx, acct)
 		}
 	}
 
@@ -174,6 +514,12 @@ func (e *Engine) Stop() {
 	if e.ua != nil {
 		e.ua.Close()
 	}
+	if e.sipConn != nil {
+		_ = e.sipConn.Close()
+	}
+	if e.traceLog != nil {
+		e.traceLog.close()
+	}
 	close(e.events)
 }
 
@@ -186,17 +532,32 @@ func (e *Engine) Dial(accountID, uri string) error {
 		return fmt.Errorf("account %q not found", accountID)
 	}
 
-	go e.dialAsync(acct, uri)
+	target, err := dialTarget(acct, uri)
+	if err != nil {
+		return err
+	}
+	go e.dialAsync(acct, uri, target)
 	return nil
 }
 
-func (e *Engine) dialAsync(acct *Account, uri string) {
+// dialTarget parses a dial URI for acct. IPv6 hosts must be bracketed
+// ("sip:bob@[2001:db8::5]") and match the account's ip_family.
+func dialTarget(acct *Account, uri string) (sip.Uri, error) {
 	var target sip.Uri
 	if err := sip.ParseUri(uri, &target); err != nil {
-		slog.Error("invalid dial URI", "uri", uri, "error", err)
-		return
+		return sip.Uri{}, fmt.Errorf("invalid dial URI %q: %w", uri, err)
+	}
+	if err := applyTransport(&target, acct.Config.Transport); err != nil {
+		return sip.Uri{}, err
 	}
+	family := addrFamily(target.Host)
+	if family != "" && acct.Config.IPFamily != "auto" && family != acct.Config.IPFamily {
+		return sip.Uri{}, fmt.Errorf("%s is an %s address but account %q uses %s", target.Host, family, acct.ID, acct.Config.IPFamily)
+	}
+	return target, nil
+}
 
+func (e *Engine) dialAsync(acct *Account, uri string, target sip.Uri) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
@@ -212,11 +573,25 @@ func (e *Engine) dialAsync(acct *Account, uri string) {
 		Direction: "outbound",
 	}
 
-	dialog, err := e.dg.Invite(ctx, target, diago.InviteOptions{
-		Username: acct.Config.AuthUser,
-		Password: acct.Config.AuthPassword,
-	})
+	socks, err := e.openMedia(acct, nil)
+	if socks == nil {
+		slog.Error("media setup failed", "uri", uri, "error", err)
+		e.events <- CallStateEvent{
+			CallID:    callID,
+			State:     "disconnected",
+			RemoteURI: uri,
+			Direction: "outbound",
+			Reason:    err.Error(),
+		}
+		return
+	}
+	if err != nil {
+		slog.Warn("ICE gathering incomplete", "uri", uri, "error", err)
+	}
+
+	dialog, err := e.invite(ctx, acct, target, callID, socks)
 	if err != nil {
+		socks.Close()
 		slog.Error("invite failed", "uri", uri, "error", err)
 		e.events <- CallStateEvent{
 			CallID:    callID,
@@ -227,19 +602,46 @@ func (e *Engine) dialAsync(acct *Account, uri string) {
 		return
 	}
 
+	go func() {
+		<-dialog.Context().Done()
+		socks.Close()
+	}()
+
+	encryption, err := negotiateEncryption(acct.Config, dialog.InviteResponse.Body())
+	if err != nil {
+		slog.Error("media encryption rejected", "uri", uri, "error", err)
+		hangCtx, hangCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_ = dialog.Hangup(hangCtx)
+		hangCancel()
+		e.events <- CallStateEvent{
+			CallID:    callID,
+			State:     "disconnected",
+			RemoteURI: uri,
+			Direction: "outbound",
+			Reason:    err.Error(),
+		}
+		return
+	}
+
 	call := newOutboundCall(callID, uri, dialog)
+	call.Encryption = encryption
+	call.account = acct
 	call.setState("confirmed")
-
-	e.mu.Lock()
-	e.calls[callID] = call
-	e.mu.Unlock()
+	e.addCall(call)
 
 	e.events <- CallStateEvent{
-		CallID:    callID,
-		State:     "confirmed",
-		RemoteURI: uri,
-		Direction: "outbound",
-	}
+		CallID:     callID,
+		State:      "confirmed",
+		RemoteURI:  uri,
+		Direction:  "outbound",
+		Encryption: encryption,
+		MediaAddr:  peerMediaAddr(dialog.InviteResponse.Body()),
+		SIPCallID:  call.SIPCallID,
+		Codec:      negotiatedCodec(dialog.MediaSession()),
+	}
+
+	e.startICE(dialog.Context(), call, socks, dialog.InviteResponse.Body())
+	e.startMedia(dialog.Context(), call, acct, dialog.MediaSession(), socks)
 }
 
 // Answer accepts an incoming call.
@@ -273,6 +675,9 @@ func (e *Engine) Hangup(callID string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// Report while the media session is still open.
+	call.sendQualityReports(true)
+
 	var err error
 	if call.client != nil {
 		err = call.client.Hangup(ctx)
@@ -374,24 +779,73 @@ func (e *Engine) inboundHandler(d *diago.DialogServerSession) {
 	e.mu.Lock()
 	e.nextCallID++
 	callID := fmt.Sprintf("%d", e.nextCallID)
-	call := newInboundCall(callID, remoteURI, d)
-	e.calls[callID] = call
 	e.mu.Unlock()
 
+	var encryption string
+	acct := e.accountForRequest(d.InviteRequest)
+	if acct != nil {
+		var err error
+		encryption, err = negotiateEncryption(acct.Config, d.InviteRequest.Body())
+		if err != nil {
+			slog.Warn("rejecting incoming call", "id", callID, "from", remoteURI, "error", err)
+			if err := d.Respond(488, "Not Acceptable Here", nil); err != nil {
+				slog.Error("reject failed", "id", callID, "error", err)
+			}
+			e.events <- CallStateEvent{
+				CallID:    callID,
+				State:     "disconnected",
+				RemoteURI: remoteURI,
+				Direction: "inbound",
+				Reason:    err.Error(),
+			}
+			return
+		}
+	}
+
+	call := newInboundCall(callID, remoteURI, d)
+	call.Encryption = encryption
+	call.account = acct
+	e.addCall(call)
+
 	slog.Info("incoming call", "id", callID, "from", remoteURI)
 
 	e.events <- CallStateEvent{
-		CallID:    callID,
-		State:     "incoming",
-		RemoteURI: remoteURI,
-		Direction: "inbound",
+		CallID:     callID,
+		State:      "incoming",
+		RemoteURI:  remoteURI,
+		Direction:  "inbound",
+		Encryption: encryption,
+		MediaAddr:  peerMediaAddr(d.InviteRequest.Body()),
+		SIPCallID:  call.SIPCallID,
 	}
 
 	// Wait for answer signal or context cancellation.
 	ctx := d.Context()
 	select {
 	case <-call.answerCh:
-		if err := d.Answer(); err != nil {
+		socks, err := e.openMedia(acct, d.InviteRequest.Body())
+		if socks == nil {
+			slog.Error("answer failed", "id", callID, "error", err)
+			call.setState("disconnected")
+			e.events <- CallStateEvent{
+				CallID:    callID,
+				State:     "disconnected",
+				RemoteURI: remoteURI,
+				Direction: "inbound",
+				Reason:    err.Error(),
+			}
+			return
+		}
+		defer socks.Close()
+		if err != nil {
+			slog.Warn("ICE gathering incomplete", "id", callID, "error", err)
+		}
+
+		answer := diago.AnswerOptions{Media: dialogMedia(acct, socks)}
+		if contact := acct.natContact(); contact != nil {
+			answer.Headers = append(answer.Headers, contact)
+		}
+		if err := d.AnswerOptions(answer); err != nil {
 			slog.Error("answer failed", "id", callID, "error", err)
 			call.setState("disconnected")
 			e.events <- CallStateEvent{
@@ -404,11 +858,16 @@ func (e *Engine) inboundHandler(d *diago.DialogServerSession) {
 		}
 		call.setState("confirmed")
 		e.events <- CallStateEvent{
-			CallID:    callID,
-			State:     "confirmed",
-			RemoteURI: remoteURI,
-			Direction: "inbound",
+			CallID:     callID,
+			State:      "confirmed",
+			RemoteURI:  remoteURI,
+			Direction:  "inbound",
+			Encryption: encryption,
+			MediaAddr:  peerMediaAddr(d.InviteRequest.Body()),
+			Codec:      negotiatedCodec(d.MediaSession()),
 		}
+		e.startICE(ctx, call, socks, d.InviteRequest.Body())
+		e.startMedia(ctx, call, acct, d.MediaSession(), socks)
 
 		// Block until call ends.
 		<-ctx.Done()
@@ -3,6 +3,8 @@ package engine
 import (
 	"testing"
 	"time"
+
+	"github.com/siptty/siptty/internal/config"
 )
 
 func TestValidDTMFDigit(t *testing.T) {
@@ -40,7 +42,7 @@ func TestEventTypes(t *testing.T) {
 		Direction:  "send",
 		Message:    "INVITE sip:100@pbx.io SIP/2.0\r\n",
 		Timestamp:  time.Now(),
-		Transport:  "udp",
+		Transport:  "UDP",
 		LocalAddr:  "10.0.0.1:5060",
 		RemoteAddr: "10.0.0.2:5060",
 	})
@@ -73,3 +75,42 @@ func TestCallState(t *testing.T) {
 		t.Errorf("expected state 'disconnected', got %q", call.State)
 	}
 }
+
+func TestTracerTransport(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.General.BindPort = 5061
+	e := &Engine{
+		config:       cfg,
+		listenSecure: true,
+		accounts: map[string]*Account{
+			"tls": {aor: "sip:100@pbx.example.com", Config: config.AccountConfig{Transport: "tls"}},
+			"tcp": {aor: "sip:200@pbx.example.com", Config: config.AccountConfig{Transport: "tcp"}},
+			"wss": {aor: "sip:300@pbx.example.com", Config: config.AccountConfig{Transport: "wss"}},
+		},
+		order: []string{"tls", "tcp", "wss"},
+	}
+	events := make(chan Event, 1)
+	tracer := &sipTracer{events: events, account: e.accountForMessage, secure: e.secureConn}
+	msg := func(user string) []byte {
+		return []byte("OPTIONS sip:pbx.example.com SIP/2.0\r\nFrom: <sip:" + user + "@pbx.example.com>\r\nTo: <sip:pbx.example.com>\r\n\r\n")
+	}
+
+	// The labels are the ones sipgo traces with: TLS reuses its TCP
+	// connection and WSS its WS one.
+	tests := []struct {
+		label, laddr, user, want string
+	}{
+		{"TCP", "192.0.2.1:40000", "100", "TLS"},
+		{"TCP", "192.0.2.1:40001", "200", "TCP"},
+		{"WS", "192.0.2.1:40002", "300", "WSS"},
+		{"UDP", "192.0.2.1:5061", "100", "UDP"},
+		{"TCP", "192.0.2.1:5061", "999", "TLS"}, // on the TLS listener
+		{"TCP", "192.0.2.1:5060", "999", "TCP"},
+	}
+	for _, tt := range tests {
+		tracer.SIPTraceWrite(tt.label, tt.laddr, "192.0.2.10:5061", msg(tt.user))
+		if ev := (<-events).(SipTraceEvent); ev.Transport != tt.want {
+			t.Errorf("%s from %s for %s traced as %q, want %q", tt.label, tt.laddr, tt.user, ev.Transport, tt.want)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package engine
 
-import "time"
+import (
+	"time"
+
+	"github.com/siptty/siptty/internal/impair"
+)
 
 // Event is the interface for all engine-to-TUI events.
 type Event interface {
@@ -12,10 +16,43 @@ type RegStateEvent struct {
 	AccountID string
 	State     string // "registered", "unregistered", "failed"
 	Reason    string
+
+	ServiceRoute []string // learned from the REGISTER 200 OK; applied to new dialogs
+	Path         []string // learned from the REGISTER 200 OK
 }
 
 func (RegStateEvent) eventMarker() {}
 
+// KeepaliveEvent reports the result of a keepalive sent to an account's registrar.
+type KeepaliveEvent struct {
+	AccountID string
+	Reachable bool
+	RTT       time.Duration // OPTIONS round trip; zero for CRLF keepalives
+	Reason    string        // why the keepalive failed
+}
+
+func (KeepaliveEvent) eventMarker() {}
+
+// TLSStateEvent reports the result of a TLS handshake on an account's signaling connection.
+type TLSStateEvent struct {
+	AccountID   string
+	ServerName  string // SNI sent in the handshake
+	Version     string // e.g. "TLS 1.3"
+	CipherSuite string // e.g. "TLS_AES_128_GCM_SHA256"
+	PeerSubject string // subject of the server's leaf certificate
+}
+
+func (TLSStateEvent) eventMarker() {}
+
+// NATStateEvent reports the public address an account is reachable at.
+type NATStateEvent struct {
+	AccountID  string
+	PublicAddr string // host:port, or host alone for a media-only address
+	Source     string // "stun", "rport" (learned from Via received/rport), "config"
+}
+
+func (NATStateEvent) eventMarker() {}
+
 // CallStateEvent reports call state transitions.
 type CallStateEvent struct {
 	CallID    string
@@ -23,18 +60,39 @@ type CallStateEvent struct {
 	RemoteURI string
 	Duration  time.Duration
 	Direction string // "inbound", "outbound"
+
+	Encryption string // SRTP suite or "DTLS-SRTP"; empty for plain RTP
+	Reason     string // why the call ended, when siptty ended it
+	MediaAddr  string // peer's RTP address from its SDP, e.g. "[2001:db8::2]:4000"
+	SIPCallID  string // Call-ID of the INVITE dialog, once known
+	Codec      string // negotiated audio codec, e.g. "PCMU/8000", once confirmed
 }
 
 func (CallStateEvent) eventMarker() {}
 
+// ICEStateEvent reports the outcome of ICE connectivity checks for a call.
+type ICEStateEvent struct {
+	CallID string
+	State  string // "connected", "failed"
+	Local  string // selected local candidate, e.g. "srflx 203.0.113.7:40000"
+	Remote string // selected remote candidate
+	Reason string // why checks failed, or why consent was lost
+}
+
+func (ICEStateEvent) eventMarker() {}
+
 // SipTraceEvent carries a raw SIP message captured by the sipgo SIPTracer.
+// Direction "dns" marks a next-hop resolution or failover note instead; its
+// Message is one line and RemoteAddr is the chosen target.
 type SipTraceEvent struct {
-	Direction  string    // "send", "recv"
-	Message    string    // full raw SIP message text
+	Direction  string // "send", "recv", "dns"
+	Message    string // full raw SIP message text
 	Timestamp  time.Time
-	Transport  string    // "udp", "tcp", "tls"
+	Transport  string // "UDP", "TCP", "TLS", "WS" or "WSS"
 	LocalAddr  string
 	RemoteAddr string
+	AccountID  string // account whose AOR is in From or To, or ""
+	Seq        int    // position in the engine's TraceStore, from 1
 }
 
 func (SipTraceEvent) eventMarker() {}
@@ -46,3 +104,40 @@ type DTMFEvent struct {
 }
 
 func (DTMFEvent) eventMarker() {}
+
+// MediaStatsEvent reports the media statistics of a call, periodically
+// while it is up and once more when it ends. Received counters come from the
+// RTP siptty reads; the send direction is described by the peer's RTCP
+// reception reports, since diago writes the outgoing media itself.
+type MediaStatsEvent struct {
+	CallID string
+	Codec  string // e.g. "PCMU"
+
+	RxPackets   uint64
+	RxBytes     uint64
+	TxPackets   uint64 // RTP diago sent, header included in the bytes
+	TxBytes     uint64
+	Lost        int64 // received stream: expected minus received
+	LossPercent float64
+	Jitter      time.Duration
+	OutOfOrder  uint64
+	Duplicates  uint64
+
+	RemoteLossPercent float64       // sent stream, as the peer reports it
+	RemoteJitter      time.Duration // sent stream, as the peer reports it
+	PeerPackets       uint32        // packets the peer says it sent (RTCP SR)
+	PeerBytes         uint32        // payload bytes the peer says it sent (RTCP SR)
+	RTT               time.Duration // from RTCP; 0 until measured
+
+	RFactor float64 // E-model transmission rating, 0-93.2
+	MOS     float64 // estimated mean opinion score, 1-4.5
+
+	// Impairment is the network impairment applied to the call, as
+	// FormatImpairment writes it; "" for none. The received counters above
+	// are taken after it.
+	Impairment  string
+	Impaired    impair.Counters // what it did to the received RTP so far
+	SIPImpaired impair.Counters // what it did to the SIP messages of the call's dialog
+}
+
+func (MediaStatsEvent) eventMarker() {}
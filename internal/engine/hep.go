@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/siptty/siptty/internal/config"
+	"github.com/siptty/siptty/internal/hep"
+)
+
+// hepQueueSize bounds the packets waiting for the collector; more are dropped.
+const hepQueueSize = 1024
+
+// hepDialTimeout bounds connecting to a TCP collector and each write to it.
+const hepDialTimeout = 5 * time.Second
+
+// hepRedialInterval is how long a TCP collector that could not be reached
+// is left alone; packets for it are dropped meanwhile.
+const hepRedialInterval = 10 * time.Second
+
+// hepMirror copies every traced SIP message to a HEPv3 collector. The tracer
+// queues packets without blocking and one goroutine writes them, dialing
+// the collector and, for TCP, redialing it after errors.
+type hepMirror struct {
+	cfg   config.HEPConfig
+	queue chan []byte
+	once  sync.Once // logs the first dropped packet
+}
+
+func newHEPMirror(cfg config.HEPConfig) *hepMirror {
+	return &hepMirror{cfg: cfg, queue: make(chan []byte, hepQueueSize)}
+}
+
+// mirror queues ev for the collector. DNS notes and messages without usable
+// addresses are skipped.
+func (m *hepMirror) mirror(ev SipTraceEvent) {
+	local, err1 := netip.ParseAddrPort(ev.LocalAddr)
+	remote, err2 := netip.ParseAddrPort(ev.RemoteAddr)
+	if ev.Direction == "dns" || err1 != nil || err2 != nil {
+		return
+	}
+	p := hep.Packet{
+		Time:          ev.Timestamp,
+		Src:           local,
+		Dst:           remote,
+		TCP:           !strings.EqualFold(ev.Transport, "udp"),
+		Payload:       []byte(ev.Message),
+		CaptureID:     uint32(m.cfg.CaptureID),
+		NodeName:      m.cfg.NodeName,
+		CorrelationID: m.cfg.CorrelationID,
+	}
+	if ev.Direction == "recv" {
+		p.Src, p.Dst = remote, local
+	}
+	if p.CorrelationID == "" {
+		p.CorrelationID = ev.CallID()
+	}
+	b, err := hep.Encode(p)
+	if err != nil {
+		slog.Debug("HEP packet not sent", "error", err)
+		return
+	}
+	select {
+	case m.queue <- b:
+	default:
+		m.once.Do(func() {
+			slog.Warn("HEP packets dropped: collector too slow", "address", m.cfg.Address)
+		})
+	}
+}
+
+// run writes queued packets to the collector until ctx ends.
+func (m *hepMirror) run(ctx context.Context) {
+	var conn net.Conn
+	var lastDial time.Time
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for {
+		var b []byte
+		select {
+		case <-ctx.Done():
+			return
+		case b = <-m.queue:
+		}
+
+		if conn == nil {
+			if time.Since(lastDial) < hepRedialInterval {
+				continue
+			}
+			lastDial = time.Now()
+			d := net.Dialer{Timeout: hepDialTimeout}
+			var err error
+			conn, err = d.DialContext(ctx, m.cfg.Transport, m.cfg.Address)
+			if err != nil {
+				slog.Warn("HEP collector unreachable", "address", m.cfg.Address, "transport", m.cfg.Transport, "error", err)
+				conn = nil
+				continue
+			}
+		}
+		conn.SetWriteDeadline(time.Now().Add(hepDialTimeout))
+		if _, err := conn.Write(b); err != nil && m.cfg.Transport == "tcp" {
+			slog.Warn("HEP collector connection lost", "address", m.cfg.Address, "error", err)
+			conn.Close()
+			conn = nil
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/siptty/siptty/internal/config"
+)
+
+const hepInvite = "INVITE sip:bob@example.com SIP/2.0\r\nCall-ID: hep-1@host\r\nContent-Length: 0\r\n\r\n"
+
+func TestHEPMirrorUDP(t *testing.T) {
+	collector, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer collector.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := newHEPMirror(config.HEPConfig{Address: collector.LocalAddr().String(), Transport: "udp", CaptureID: 7})
+	go m.run(ctx)
+	tracer := &sipTracer{events: make(chan Event, 4), hep: m}
+
+	tracer.SIPTraceWrite("UDP", "192.0.2.1:5060", "192.0.2.10:5060", []byte(hepInvite))
+	tracer.SIPTraceRead("UDP", "192.0.2.1:5060", "192.0.2.10:5060", []byte("\r\n\r\n")) // keepalive, not mirrored
+	traceDNS(tracer, "udp", "192.0.2.10:5060", "resolved")                              // DNS note, not mirrored
+
+	collector.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 2048)
+	n, _, err := collector.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkt := buf[:n]
+	if !bytes.HasPrefix(pkt, []byte("HEP3")) || int(binary.BigEndian.Uint16(pkt[4:])) != n {
+		t.Fatalf("not a HEPv3 packet: % x", pkt[:min(n, 16)])
+	}
+	if !bytes.HasSuffix(pkt, []byte(hepInvite)) || !bytes.Contains(pkt, []byte("hep-1@host")) {
+		t.Errorf("packet lacks the message or its Call-ID as correlation ID: %q", pkt)
+	}
+
+	collector.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := collector.ReadFrom(buf); err == nil {
+		t.Error("keepalive or DNS note was mirrored")
+	}
+}
+
+func TestHEPMirrorTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := newHEPMirror(config.HEPConfig{Address: ln.Addr().String(), Transport: "tcp", CorrelationID: "run-42"})
+	go m.run(ctx)
+	for range 2 {
+		m.mirror(SipTraceEvent{Direction: "recv", Message: hepInvite, Timestamp: time.Now(), Transport: "TCP",
+			LocalAddr: "192.0.2.1:5060", RemoteAddr: "192.0.2.10:5060"})
+	}
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for i := range 2 {
+		head := make([]byte, 6)
+		if _, err := io.ReadFull(conn, head); err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		body := make([]byte, int(binary.BigEndian.Uint16(head[4:]))-len(head))
+		if _, err := io.ReadFull(conn, body); err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		if string(head[:4]) != "HEP3" || !bytes.Contains(body, []byte("run-42")) || !bytes.HasSuffix(body, []byte(hepInvite)) {
+			t.Errorf("packet %d = %q", i, body)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/siptty/siptty/internal/ice"
+	"github.com/siptty/siptty/internal/sdp"
+)
+
+// iceTimeout bounds connectivity checks for a call.
+const iceTimeout = 10 * time.Second
+
+// remoteICE extracts the peer's ICE credentials and RTP candidates from its
+// SDP, and whether it multiplexes RTCP. ok is false when the peer does not
+// do ICE.
+func remoteICE(body []byte) (ufrag, pwd string, cands []ice.Candidate, mux, ok bool) {
+	sess, err := sdp.Parse(body)
+	if err != nil {
+		return "", "", nil, false, false
+	}
+	audio := sess.Audio()
+	if audio == nil {
+		return "", "", nil, false, false
+	}
+	_, mux = audio.Attr("rtcp-mux")
+	ufrag, pwd = sess.ICECredentials(audio)
+	for _, v := range audio.Attrs("candidate") {
+		c, err := ice.ParseCandidate(v)
+		if err != nil || c.Component != 1 {
+			continue
+		}
+		cands = append(cands, c)
+	}
+	return ufrag, pwd, cands, mux, ufrag != "" && pwd != "" && len(cands) > 0
+}
+
+// iceConfig returns the agent settings of an account with ice_enabled, or
+// nil if the dialog does no ICE. The offerer is controlling.
+func iceConfig(acct *Account, controlling bool) *ice.Config {
+	if acct == nil || !acct.Config.NAT.ICEEnabled {
+		return nil
+	}
+	nat := acct.Config.NAT
+	return &ice.Config{
+		STUNServer:  nat.STUNServer,
+		TURNServer:  nat.TURNServer,
+		TURNUser:    nat.TURNUser,
+		TURNPass:    nat.TURNPass,
+		Controlling: controlling,
+	}
+}
+
+// startICE runs connectivity checks with the peer whose SDP is peerSDP, if
+// both sides do ICE, and moves the dialog's RTP onto the pair they select.
+// Until then, media goes to the address in the peer's SDP. The agent then
+// keeps the pair alive until the call ends; a peer that stops answering
+// its consent checks fails ICE and stops the media.
+func (e *Engine) startICE(ctx context.Context, call *Call, socks *dialogSockets, peerSDP []byte) {
+	ufrag, pwd, remote, mux, ok := remoteICE(peerSDP)
+	if mux && socks.mux {
+		socks.multiplexRTCP()
+	}
+	if socks.agent == nil || !ok {
+		return
+	}
+
+	go func() {
+		checkCtx, cancel := context.WithTimeout(ctx, iceTimeout)
+		pair, err := socks.agent.Connect(checkCtx, ufrag, pwd, remote)
+		cancel()
+		if err != nil {
+			slog.Warn("ICE failed", "call", call.ID, "error", err)
+			e.events <- ICEStateEvent{CallID: call.ID, State: "failed", Reason: err.Error()}
+			return
+		}
+
+		slog.Info("ICE pair selected", "call", call.ID, "pair", pair.String())
+		socks.rtp.agent.Store(socks.agent)
+		e.events <- ICEStateEvent{
+			CallID: call.ID,
+			State:  "connected",
+			Local:  pair.Local.Label(),
+			Remote: pair.Remote.Label(),
+		}
+
+		if err := socks.agent.Serve(ctx); err != nil {
+			slog.Warn("ICE consent lost", "call", call.ID, "error", err)
+			e.events <- ICEStateEvent{CallID: call.ID, State: "failed", Reason: err.Error()}
+		}
+	}()
+}
@@ -0,0 +1,40 @@
+package engine
+
+import "testing"
+
+func TestRemoteICE(t *testing.T) {
+	offer := "v=0\r\n" +
+		"o=- 1 1 IN IP4 10.0.0.9\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 10.0.0.9\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 6000 RTP/AVP 0\r\n" +
+		"a=ice-ufrag:F7gI\r\n" +
+		"a=ice-pwd:x9cml/YzichV2+XlhiMu8g\r\n" +
+		"a=candidate:1 1 UDP 2130706431 10.0.0.9 6000 typ host\r\n" +
+		"a=candidate:1 2 UDP 2130706430 10.0.0.9 6001 typ host\r\n" +
+		"a=candidate:2 1 UDP 1694498815 203.0.113.9 6000 typ srflx raddr 10.0.0.9 rport 6000\r\n" +
+		"a=rtcp-mux\r\n"
+
+	ufrag, pwd, cands, mux, ok := remoteICE([]byte(offer))
+	if !ok {
+		t.Fatal("remoteICE ok = false, want true")
+	}
+	if ufrag != "F7gI" || pwd != "x9cml/YzichV2+XlhiMu8g" {
+		t.Errorf("credentials = %q/%q", ufrag, pwd)
+	}
+	if !mux {
+		t.Error("mux = false, want true")
+	}
+	if len(cands) != 2 {
+		t.Fatalf("candidates = %d, want 2 (RTCP component skipped)", len(cands))
+	}
+	if cands[1].Addr.String() != "203.0.113.9:6000" {
+		t.Errorf("srflx addr = %s, want 203.0.113.9:6000", cands[1].Addr)
+	}
+
+	plain := "v=0\r\no=- 1 1 IN IP4 10.0.0.9\r\ns=-\r\nc=IN IP4 10.0.0.9\r\nt=0 0\r\nm=audio 6000 RTP/AVP 0\r\n"
+	if _, _, _, mux, ok := remoteICE([]byte(plain)); ok || mux {
+		t.Errorf("remoteICE = (mux %v, ok %v) for SDP without ICE, want false, false", mux, ok)
+	}
+}
@@ -0,0 +1,164 @@
+package engine
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/siptty/siptty/internal/config"
+	"github.com/siptty/siptty/internal/impair"
+)
+
+// impairmentKeys are the keys of ParseImpairment, in FormatImpairment order.
+var impairmentKeys = []string{"loss", "burst", "delay", "jitter", "reorder", "dup", "sip_drop", "sip_delay"}
+
+// ParseImpairment parses a network impairment typed in the calls panel:
+// space-separated key:value terms, e.g. "loss:5 burst:3 jitter:40
+// sip_drop:10". The keys are loss, burst, reorder, dup and sip_drop (in
+// percent, burst in packets) and delay, jitter and sip_delay (in ms). An
+// empty expression or "off" is no impairment.
+func ParseImpairment(expr string) (config.ImpairmentConfig, error) {
+	var c config.ImpairmentConfig
+	expr = strings.TrimSpace(expr)
+	if strings.EqualFold(expr, "off") {
+		return c, nil
+	}
+	for _, term := range strings.Fields(expr) {
+		key, value, ok := strings.Cut(term, ":")
+		if !ok || value == "" {
+			return config.ImpairmentConfig{}, fmt.Errorf("impairment term %q is not key:value", term)
+		}
+		n, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil {
+			return config.ImpairmentConfig{}, fmt.Errorf("impairment term %q: %q is not a number", term, value)
+		}
+		switch strings.ToLower(key) {
+		case "loss":
+			c.Loss = n
+		case "burst":
+			c.Burst = n
+		case "delay":
+			c.Delay = int(n)
+		case "jitter":
+			c.Jitter = int(n)
+		case "reorder":
+			c.Reorder = n
+		case "dup", "duplicate":
+			c.Duplicate = n
+		case "sip_drop":
+			c.SIPDrop = n
+		case "sip_delay":
+			c.SIPDelay = int(n)
+		default:
+			return config.ImpairmentConfig{}, fmt.Errorf("unknown impairment key %q (must be one of %s)", key, strings.Join(impairmentKeys, ", "))
+		}
+	}
+	if err := config.ValidateImpairment(c); err != nil {
+		return config.ImpairmentConfig{}, err
+	}
+	return c, nil
+}
+
+// FormatImpairment writes c the way ParseImpairment reads it, leaving out
+// what is zero. No impairment is "".
+func FormatImpairment(c config.ImpairmentConfig) string {
+	var terms []string
+	for i, v := range []float64{c.Loss, c.Burst, float64(c.Delay), float64(c.Jitter), c.Reorder, c.Duplicate, c.SIPDrop, float64(c.SIPDelay)} {
+		if v != 0 {
+			terms = append(terms, impairmentKeys[i]+":"+strconv.FormatFloat(v, 'f', -1, 64))
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+// rtpProfile and sipProfile pick the media and signaling parts of c.
+func rtpProfile(c config.ImpairmentConfig) impair.Profile {
+	return impair.Profile{
+		Loss:      c.Loss,
+		Burst:     c.Burst,
+		Delay:     time.Duration(c.Delay) * time.Millisecond,
+		Jitter:    time.Duration(c.Jitter) * time.Millisecond,
+		Reorder:   c.Reorder,
+		Duplicate: c.Duplicate,
+	}
+}
+
+func sipProfile(c config.ImpairmentConfig) impair.Profile {
+	return impair.Profile{Loss: c.SIPDrop, Delay: time.Duration(c.SIPDelay) * time.Millisecond}
+}
+
+// impairmentConfig is the inverse of rtpProfile and sipProfile.
+func impairmentConfig(rtp, sip impair.Profile) config.ImpairmentConfig {
+	return config.ImpairmentConfig{
+		Loss:      rtp.Loss,
+		Burst:     rtp.Burst,
+		Delay:     int(rtp.Delay / time.Millisecond),
+		Jitter:    int(rtp.Jitter / time.Millisecond),
+		Reorder:   rtp.Reorder,
+		Duplicate: rtp.Duplicate,
+		SIPDrop:   sip.Loss,
+		SIPDelay:  int(sip.Delay / time.Millisecond),
+	}
+}
+
+// callImpairment gives a new call the network impairment of its account,
+// which may be nil: models of its own, so that changing them leaves the
+// account and its other calls alone.
+func callImpairment(acct *Account) (rtp, sip *impair.Model) {
+	var c config.ImpairmentConfig
+	if acct != nil {
+		c = acct.Config.Impairment
+	}
+	return impair.NewModel(rtpProfile(c)), impair.NewModel(sipProfile(c))
+}
+
+// SetImpairment changes the network impairment of a call alone. The RTP
+// settings apply to the media received on the call; the SIP ones to the
+// messages of its dialog received over UDP.
+func (e *Engine) SetImpairment(callID string, c config.ImpairmentConfig) error {
+	if err := config.ValidateImpairment(c); err != nil {
+		return err
+	}
+	e.mu.RLock()
+	call, ok := e.calls[callID]
+	e.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("call %q not found", callID)
+	}
+	if config.ImpairsSIP(c) && (e.sipConn == nil || call.account != nil && call.account.Config.Transport != "udp") {
+		return fmt.Errorf("call %q: sip_drop and sip_delay need transport udp", callID)
+	}
+
+	call.rtpImpair.SetProfile(rtpProfile(c))
+	call.sipImpair.SetProfile(sipProfile(c))
+	slog.Info("network impairment changed", "id", callID, "impairment", FormatImpairment(c))
+	call.mu.Lock()
+	stats := call.media
+	call.mu.Unlock()
+	if stats != nil {
+		e.notify(stats.event(callID))
+	}
+	return nil
+}
+
+// sipImpairment picks the network impairment of a SIP message received over
+// UDP: that of the call whose dialog it belongs to, or else that of its
+// account. It is nil for keepalives and messages no account claims.
+func (e *Engine) sipImpairment(msg []byte) *impair.Model {
+	if isKeepalive(msg) {
+		return nil
+	}
+	s := string(msg)
+	if call := e.callBySIPCallID(headerValue(s, "Call-ID", "i")); call != nil {
+		return call.sipImpair
+	}
+	id := e.accountForMessage(s)
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if acct := e.accounts[id]; acct != nil {
+		return acct.sipImpair
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package engine
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/siptty/siptty/internal/config"
+	"github.com/siptty/siptty/internal/impair"
+)
+
+func TestParseImpairment(t *testing.T) {
+	c, err := ParseImpairment(" loss:5% burst:3 delay:80 jitter:20  reorder:1 duplicate:0.5 sip_drop:10 sip_delay:200 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := config.ImpairmentConfig{Loss: 5, Burst: 3, Delay: 80, Jitter: 20, Reorder: 1, Duplicate: 0.5, SIPDrop: 10, SIPDelay: 200}
+	if c != want {
+		t.Errorf("ParseImpairment() = %+v, want %+v", c, want)
+	}
+	s := FormatImpairment(c)
+	if s != "loss:5 burst:3 delay:80 jitter:20 reorder:1 dup:0.5 sip_drop:10 sip_delay:200" {
+		t.Errorf("FormatImpairment() = %q", s)
+	}
+	if again, err := ParseImpairment(s); err != nil || again != c {
+		t.Errorf("ParseImpairment(%q) = %+v, %v", s, again, err)
+	}
+
+	for _, off := range []string{"", "off", "OFF"} {
+		if c, err := ParseImpairment(off); err != nil || c != (config.ImpairmentConfig{}) {
+			t.Errorf("ParseImpairment(%q) = %+v, %v", off, c, err)
+		}
+	}
+	if s := FormatImpairment(config.ImpairmentConfig{}); s != "" {
+		t.Errorf("FormatImpairment(zero) = %q", s)
+	}
+
+	for _, bad := range []string{"loss", "loss:x", "speed:9", "loss:101"} {
+		if _, err := ParseImpairment(bad); err == nil {
+			t.Errorf("ParseImpairment(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestImpairmentProfiles(t *testing.T) {
+	c := config.ImpairmentConfig{Loss: 2, Delay: 50, Jitter: 10, SIPDrop: 30, SIPDelay: 500}
+	rtp, sip := rtpProfile(c), sipProfile(c)
+	if rtp.Delay != 50*time.Millisecond || rtp.Jitter != 10*time.Millisecond || rtp.Loss != 2 {
+		t.Errorf("rtpProfile() = %+v", rtp)
+	}
+	if sip != (impair.Profile{Loss: 30, Delay: 500 * time.Millisecond}) {
+		t.Errorf("sipProfile() = %+v", sip)
+	}
+	if back := impairmentConfig(rtp, sip); back != c {
+		t.Errorf("impairmentConfig() = %+v, want %+v", back, c)
+	}
+}
+
+// TestSetImpairment checks that a call's impairment is its own: changing it
+// leaves the account and the account's other calls alone, and picks the
+// model for the SIP of the call's dialog.
+func TestSetImpairment(t *testing.T) {
+	acctCfg := config.AccountConfig{Name: "lab", Transport: "udp", Impairment: config.ImpairmentConfig{Loss: 1, SIPDelay: 100}}
+	acct := &Account{ID: "lab", Config: acctCfg, aor: "alice@example.com", sipImpair: impair.NewModel(sipProfile(acctCfg.Impairment))}
+	e := &Engine{
+		calls:    make(map[string]*Call),
+		accounts: map[string]*Account{"lab": acct},
+		order:    []string{"lab"},
+		events:   make(chan Event, 4),
+		sipConn:  &impairedConn{},
+	}
+	e.addCall(&Call{ID: "1", SIPCallID: "c1", account: acct})
+	e.addCall(&Call{ID: "2", SIPCallID: "c2", account: acct})
+
+	c := config.ImpairmentConfig{Jitter: 40, SIPDrop: 10}
+	if err := e.SetImpairment("1", c); err != nil {
+		t.Fatalf("SetImpairment: %v", err)
+	}
+	one, two := e.calls["1"], e.calls["2"]
+	if got := impairmentConfig(one.rtpImpair.Profile(), one.sipImpair.Profile()); got != c {
+		t.Errorf("call 1 impairment = %+v, want %+v", got, c)
+	}
+	if got := impairmentConfig(two.rtpImpair.Profile(), two.sipImpair.Profile()); got != acctCfg.Impairment {
+		t.Errorf("call 2 impairment = %+v, want the account's %+v", got, acctCfg.Impairment)
+	}
+	if got := acct.sipImpair.Profile(); got != sipProfile(acctCfg.Impairment) {
+		t.Errorf("account SIP impairment = %+v, want it unchanged", got)
+	}
+
+	inCall := []byte("BYE sip:bob@example.com SIP/2.0\r\nCall-ID: c1\r\nFrom: <sip:alice@example.com>\r\n\r\n")
+	outside := []byte("OPTIONS sip:alice@example.com SIP/2.0\r\nCall-ID: x\r\nTo: <sip:alice@example.com>\r\n\r\n")
+	if m := e.sipImpairment(inCall); m != one.sipImpair {
+		t.Error("message of call 1 not given the call's impairment")
+	}
+	if m := e.sipImpairment(outside); m != acct.sipImpair {
+		t.Error("message outside calls not given the account's impairment")
+	}
+	if m := e.sipImpairment([]byte("\r\n\r\n")); m != nil {
+		t.Error("keepalive impaired")
+	}
+
+	e.sipConn = nil // the listener is not UDP
+	if err := e.SetImpairment("1", c); err == nil {
+		t.Error("SetImpairment with sip_drop succeeded without a UDP listener")
+	}
+	if err := e.SetImpairment("1", config.ImpairmentConfig{Loss: 5}); err != nil {
+		t.Errorf("SetImpairment of RTP only: %v", err)
+	}
+}
+
+// TestImpairedConn sends SIP datagrams through a wrapped listener: they
+// are dropped, passed on at once, or held and re-injected.
+func TestImpairedConn(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	drop := impair.NewModel(impair.Profile{Loss: 100})
+	hold := impair.NewModel(impair.Profile{Delay: 50 * time.Millisecond})
+	c := newImpairedConn(conn, func(msg []byte) *impair.Model {
+		switch string(msg) {
+		case "drop":
+			return drop
+		case "hold":
+			return hold
+		}
+		return nil
+	})
+	go c.run()
+	defer c.Close()
+
+	peer, err := net.DialUDP("udp4", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	start := time.Now()
+	for _, msg := range []string{"drop", "hold", "pass"} {
+		if _, err := peer.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 100)
+	var got []string
+	for range 2 {
+		n, from, err := c.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("ReadFrom: %v", err)
+		}
+		if from.String() != peer.LocalAddr().String() {
+			t.Errorf("from = %s, want %s", from, peer.LocalAddr())
+		}
+		got = append(got, string(buf[:n]))
+	}
+	if got[0] != "pass" || got[1] != "hold" {
+		t.Errorf("read %q, want the held message after the other", got)
+	}
+	if held := time.Since(start); held < 50*time.Millisecond {
+		t.Errorf("held message read after %v, want at least 50ms", held)
+	}
+	if drop.Counters().Dropped != 1 {
+		t.Errorf("drop counters = %+v", drop.Counters())
+	}
+
+	c.Close()
+	if _, _, err := c.ReadFrom(buf); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("ReadFrom after Close: err = %v, want net.ErrClosed", err)
+	}
+}
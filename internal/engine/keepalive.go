@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+// keepaliveFailures is how many keepalives in a row may fail before the
+// account re-registers.
+const keepaliveFailures = 2
+
+// keepaliveTimeout bounds a single OPTIONS ping.
+const keepaliveTimeout = 5 * time.Second
+
+// maintainRegistration registers acct and, if the account has keepalives
+// configured, keeps its registrar binding (and any NAT mapping in front of
+// it) alive until ctx ends. Each keepalive result is reported as a
+// KeepaliveEvent; after keepaliveFailures misses in a row the account
+// registers again, which also re-opens a dropped TCP/TLS connection.
+func (e *Engine) maintainRegistration(ctx context.Context, acct *Account) {
+	acct.register(ctx, e.dg, e.events)
+	if acct.Config.Keepalive == "none" {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(acct.Config.KeepaliveInterval) * time.Second)
+	defer ticker.Stop()
+	misses := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		rtt, err := e.keepalive(ctx, acct)
+		if err != nil {
+			misses++
+			slog.Warn("keepalive failed", "account", acct.ID, "misses", misses, "error", err)
+			e.events <- KeepaliveEvent{AccountID: acct.ID, Reachable: false, Reason: err.Error()}
+			if misses >= keepaliveFailures {
+				slog.Info("re-registering after keepalive failures", "account", acct.ID)
+				acct.register(ctx, e.dg, e.events)
+				misses = 0
+			}
+			continue
+		}
+		misses = 0
+		e.events <- KeepaliveEvent{AccountID: acct.ID, Reachable: true, RTT: rtt}
+	}
+}
+
+// keepalive sends one keepalive of the account's configured kind. The RTT is
+// only measured for OPTIONS; CRLF keepalives report zero.
+func (e *Engine) keepalive(ctx context.Context, acct *Account) (time.Duration, error) {
+	switch acct.Config.Keepalive {
+	case "options":
+		target, err := acct.registrarURI()
+		if err != nil {
+			return 0, err
+		}
+		return e.pingOptions(ctx, target, routeHeaders(acct.registerRoutes()))
+	case "crlf":
+		return 0, e.sendCRLF(ctx, acct)
+	}
+	return 0, fmt.Errorf("unknown keepalive %q", acct.Config.Keepalive)
+}
+
+// pingOptions sends OPTIONS to target via routes and measures the round trip.
+// Any final response counts: a registrar answering 401 or 405 is still reachable.
+func (e *Engine) pingOptions(ctx context.Context, target sip.Uri, routes []sip.Header) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, keepaliveTimeout)
+	defer cancel()
+
+	req := sip.NewRequest(sip.OPTIONS, target)
+	for _, h := range routes {
+		req.AppendHeader(h)
+	}
+	start := time.Now()
+	if _, err := e.client.Do(ctx, req); err != nil {
+		return 0, fmt.Errorf("OPTIONS: %w", err)
+	}
+	return time.Since(start), nil
+}
+
+// sendCRLF writes a CRLF keepalive (RFC 5626 §4.4) on the existing
+// connection to the account's first hop, which holds it: CRLFCRLF on
+// TCP/TLS, a single CRLF on UDP. The hop is resolved as for REGISTER and the
+// first of its addresses with a connection gets the ping. Having no
+// connection left to it counts as a failure.
+func (e *Engine) sendCRLF(ctx context.Context, acct *Account) error {
+	hop, err := acct.nextHop()
+	if err != nil {
+		return err
+	}
+	if acct.resolver == nil {
+		return fmt.Errorf("no resolver for %s", hop.Host)
+	}
+	transport := acct.Config.Transport
+	targets, err := acct.resolver.Resolve(ctx, hop.Host, hop.Port, transport)
+	if err != nil {
+		return fmt.Errorf("resolve %s: %w", hop.Host, err)
+	}
+	targets = filterFamily(targets, acct.Config.IPFamily)
+	for _, t := range targets {
+		conn, err := e.ua.TransportLayer().GetConnection(transport, t.Addr)
+		if err != nil || conn == nil {
+			continue
+		}
+		return writeCRLF(conn, t.Addr)
+	}
+	return fmt.Errorf("no %s connection to %s", transport, hop.Host)
+}
+
+// writeCRLF writes the keepalive straight to conn: a CRLF datagram to addr
+// on a UDP socket, CRLFCRLF on a stream.
+func writeCRLF(conn sip.Connection, addr string) error {
+	switch c := conn.(type) {
+	case *sip.UDPConnection:
+		raddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return err
+		}
+		_, err = c.WriteTo([]byte("\r\n"), raddr)
+		return err
+	case io.Writer:
+		_, err := c.Write([]byte("\r\n\r\n"))
+		return err
+	}
+	return fmt.Errorf("cannot write a keepalive on %T", conn)
+}
@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+func TestWriteCRLF(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go func() {
+		_ = writeCRLF(&sip.TCPConnection{Conn: client}, "192.0.2.10:5060")
+	}()
+	buf := make([]byte, 8)
+	n, err := io.ReadAtLeast(server, buf, 4)
+	if err != nil || string(buf[:n]) != "\r\n\r\n" {
+		t.Errorf("stream keepalive = %q, %v; want CRLFCRLF", buf[:n], err)
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	if err := writeCRLF(&sip.UDPConnection{PacketConn: pc}, peer.LocalAddr().String()); err != nil {
+		t.Fatalf("writeCRLF(UDP): %v", err)
+	}
+	_ = peer.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err = peer.ReadFrom(buf)
+	if err != nil || string(buf[:n]) != "\r\n" {
+		t.Errorf("datagram keepalive = %q, %v; want CRLF", buf[:n], err)
+	}
+}
+
+func TestIsKeepalive(t *testing.T) {
+	for _, msg := range []string{"\r\n", "\r\n\r\n"} {
+		if !isKeepalive([]byte(msg)) {
+			t.Errorf("isKeepalive(%q) = false, want true", msg)
+		}
+	}
+	if isKeepalive([]byte("OPTIONS sip:reg.example.com SIP/2.0\r\n\r\n")) {
+		t.Error("isKeepalive(OPTIONS) = true, want false")
+	}
+}
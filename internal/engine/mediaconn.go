@@ -0,0 +1,233 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/siptty/siptty/internal/ice"
+	"github.com/siptty/siptty/internal/impair"
+)
+
+// mediaQueueLen is how many received packets wait for diago's reader,
+// about a second of 20 ms audio; more are dropped, as a full socket buffer
+// would.
+const mediaQueueLen = 64
+
+// mediaConn is one of a dialog's media sockets, bound by siptty and handed
+// to diago, which reads and writes the dialog's RTP or RTCP through it. A
+// single reader demultiplexes what arrives (RFC 7983): STUN and TURN go to
+// the dialog's ICE agent, RTCP multiplexed onto the RTP port (RFC 5761) goes
+// to the RTCP conn, and the rest goes through the call's network impairment
+// to be queued for ReadFrom. Once ICE has
+// selected a pair, writes go over it instead of to the address diago took
+// from the SDP.
+type mediaConn struct {
+	*readQueue
+	conn *net.UDPConn
+
+	rtcp   *mediaConn                    // RTP conn: where RTCP received on it goes; nil on the RTCP conn
+	mux    atomic.Pointer[mediaConn]     // RTCP conn with rtcp-mux: the RTP conn to send through
+	agent  atomic.Pointer[ice.Agent]     // RTP conn, once ICE has selected a pair
+	dest   atomic.Pointer[net.UDPAddr]   // RTP conn: where diago last sent RTP
+	shaper atomic.Pointer[impair.Shaper] // RTP conn: the call's network impairment
+	tap    atomic.Pointer[mediaTap]
+}
+
+// mediaTap watches the media diago reads from and writes to a mediaConn,
+// without being a second reader of the socket.
+type mediaTap struct {
+	rx func(b []byte, from *net.UDPAddr, at time.Time) // a packet received for diago; b is reused afterwards
+	tx func(b []byte, at time.Time)                    // a packet diago sent
+}
+
+func newMediaConn(conn *net.UDPConn) *mediaConn {
+	return &mediaConn{readQueue: newReadQueue(mediaQueueLen), conn: conn}
+}
+
+// run reads the socket until it is closed, passing everything through agent
+// first if there is one. Gathering reads the socket too, so run starts
+// after it.
+func (c *mediaConn) run(agent *ice.Agent) {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		payload, peer := buf[:n], from
+		if agent != nil {
+			var media bool
+			if payload, peer, media = agent.Handle(payload, from); !media {
+				continue
+			}
+		}
+		if c.rtcp != nil && isRTCP(payload) {
+			c.rtcp.deliver(payload, peer, time.Now())
+			continue
+		}
+		if s := c.shaper.Load(); s != nil {
+			s.Send(payload, peer, time.Now())
+			continue
+		}
+		c.deliver(payload, peer, time.Now())
+	}
+}
+
+// deliver queues b for ReadFrom, once the tap has seen it.
+func (c *mediaConn) deliver(b []byte, from net.Addr, at time.Time) {
+	if t := c.tap.Load(); t != nil && t.rx != nil {
+		ua, _ := from.(*net.UDPAddr)
+		t.rx(b, ua, at)
+	}
+	c.push(b, from)
+}
+
+// isRTCP tells RTCP from RTP on a multiplexed port by the packet type
+// (RFC 5761 §4).
+func isRTCP(b []byte) bool {
+	return len(b) >= 2 && b[0]>>6 == 2 && b[1] >= 192 && b[1] <= 223
+}
+
+// WriteTo sends b to addr, or over the selected ICE pair once there is
+// one. With rtcp-mux, RTCP goes out of the RTP conn to where the RTP goes.
+func (c *mediaConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if t := c.tap.Load(); t != nil && t.tx != nil {
+		t.tx(b, time.Now())
+	}
+	if rtp := c.mux.Load(); rtp != nil {
+		if dest := rtp.dest.Load(); dest != nil {
+			return rtp.send(b, dest)
+		}
+	}
+	if ua, ok := addr.(*net.UDPAddr); ok && c.rtcp != nil {
+		c.dest.Store(ua)
+	}
+	return c.send(b, addr)
+}
+
+func (c *mediaConn) send(b []byte, addr net.Addr) (int, error) {
+	if a := c.agent.Load(); a != nil {
+		return a.Send(b)
+	}
+	return c.conn.WriteTo(b, addr)
+}
+
+// Close closes the socket and wakes a blocked ReadFrom.
+func (c *mediaConn) Close() error {
+	c.close()
+	return c.conn.Close()
+}
+
+func (c *mediaConn) LocalAddr() net.Addr { return c.conn.LocalAddr() }
+
+func (c *mediaConn) SetDeadline(t time.Time) error {
+	_ = c.SetReadDeadline(t)
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *mediaConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// dialogSockets are the RTP and RTCP sockets of one dialog, with the ICE
+// agent on the RTP one when the dialog does ICE. Only the RTP component
+// takes part in ICE: RTCP rides on it with rtcp-mux, and otherwise goes
+// plainly to the port after the peer's RTP port.
+type dialogSockets struct {
+	rtp, rtcp *mediaConn
+	agent     *ice.Agent
+	cands     []ice.Candidate
+	mux       bool // offer or answer rtcp-mux
+}
+
+// bindAttempts bounds the search for a free pair of adjacent ports.
+const bindAttempts = 10
+
+// openDialogSockets binds a dialog's RTP socket on host and the RTCP socket
+// on the next port. With agent config cfg, it gathers ICE candidates on
+// the RTP socket before starting the readers; a failed STUN or TURN server
+// only costs its candidate.
+func openDialogSockets(host, family string, cfg *ice.Config) (*dialogSockets, error) {
+	network := "udp"
+	switch family {
+	case "ipv4":
+		network = "udp4"
+	case "ipv6":
+		network = "udp6"
+	}
+	ip := net.ParseIP(host)
+
+	var rtp, rtcp *net.UDPConn
+	var err error
+	for range bindAttempts {
+		if rtp, err = net.ListenUDP(network, &net.UDPAddr{IP: ip}); err != nil {
+			return nil, fmt.Errorf("bind RTP socket: %w", err)
+		}
+		port := rtp.LocalAddr().(*net.UDPAddr).Port
+		if rtcp, err = net.ListenUDP(network, &net.UDPAddr{IP: ip, Port: port + 1}); err == nil {
+			break
+		}
+		rtp.Close()
+		rtp = nil
+	}
+	if rtp == nil {
+		return nil, fmt.Errorf("bind RTCP socket: %w", err)
+	}
+
+	s := &dialogSockets{rtp: newMediaConn(rtp), rtcp: newMediaConn(rtcp)}
+	s.rtp.rtcp = s.rtcp
+	if cfg != nil {
+		s.agent = ice.NewAgent(rtp, *cfg) // the raw socket: Gather reads it and Send must not loop back here
+		s.mux = true
+		s.cands, err = s.agent.Gather()
+		if len(s.cands) == 0 {
+			s.Close()
+			return nil, fmt.Errorf("ICE gathering: %w", err)
+		}
+	}
+	go s.rtp.run(s.agent)
+	go s.rtcp.run(nil)
+	return s, err
+}
+
+// sdpAttributes returns the a= lines the dialog's audio section needs
+// besides what diago writes: the ICE credentials and candidates, and
+// rtcp-mux.
+func (s *dialogSockets) sdpAttributes() []string {
+	var attrs []string
+	if s.agent != nil {
+		ufrag, pwd := s.agent.Credentials()
+		attrs = append(attrs, "ice-ufrag:"+ufrag, "ice-pwd:"+pwd)
+		for _, c := range s.cands {
+			attrs = append(attrs, "candidate:"+c.String())
+		}
+	}
+	if s.mux {
+		attrs = append(attrs, "rtcp-mux")
+	}
+	return attrs
+}
+
+// multiplexRTCP sends the dialog's RTCP out of the RTP socket from now on.
+func (s *dialogSockets) multiplexRTCP() {
+	s.rtcp.mux.Store(s.rtp)
+}
+
+// Close releases the TURN allocation, drops the RTP the impairment still
+// holds and closes both sockets.
+func (s *dialogSockets) Close() {
+	if s.agent != nil {
+		_ = s.agent.Close()
+	}
+	if sh := s.rtp.shaper.Load(); sh != nil {
+		sh.Close()
+	}
+	s.rtp.Close()
+	s.rtcp.Close()
+}
@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/siptty/siptty/internal/ice"
+	"github.com/siptty/siptty/internal/impair"
+)
+
+func openTestSockets(t *testing.T, cfg *ice.Config) *dialogSockets {
+	t.Helper()
+	s, err := openDialogSockets("127.0.0.1", "ipv4", cfg)
+	if err != nil {
+		t.Fatalf("openDialogSockets: %v", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func readWithin(t *testing.T, c *mediaConn) string {
+	t.Helper()
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1500)
+	n, _, err := c.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestDialogSocketsPlain(t *testing.T) {
+	a := openTestSockets(t, nil)
+	b := openTestSockets(t, nil)
+
+	rtpPort := a.rtp.LocalAddr().(*net.UDPAddr).Port
+	if got := a.rtcp.LocalAddr().(*net.UDPAddr).Port; got != rtpPort+1 {
+		t.Errorf("RTCP port = %d, want %d", got, rtpPort+1)
+	}
+	if attrs := a.sdpAttributes(); len(attrs) != 0 {
+		t.Errorf("sdpAttributes = %q, want none without ICE", attrs)
+	}
+
+	var sent, received []string
+	a.rtp.tap.Store(&mediaTap{tx: func(b []byte, _ time.Time) { sent = append(sent, string(b)) }})
+	b.rtp.tap.Store(&mediaTap{rx: func(b []byte, _ *net.UDPAddr, _ time.Time) { received = append(received, string(b)) }})
+
+	rtp := "\x80\x00rtp"
+	if _, err := a.rtp.WriteTo([]byte(rtp), b.rtp.LocalAddr()); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if got := readWithin(t, b.rtp); got != rtp {
+		t.Errorf("RTP read = %q, want %q", got, rtp)
+	}
+	if len(sent) != 1 || sent[0] != rtp || len(received) != 1 || received[0] != rtp {
+		t.Errorf("taps saw sent %q, received %q; want the one packet each", sent, received)
+	}
+
+	_ = b.rtp.SetReadDeadline(time.Now().Add(-time.Second))
+	if _, _, err := b.rtp.ReadFrom(make([]byte, 10)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("ReadFrom past deadline: err = %v, want ErrDeadlineExceeded", err)
+	}
+}
+
+// TestDialogSocketsICE connects two dialogs over ICE and checks that writes
+// follow the selected pair, whatever address they are given, and that
+// multiplexed RTCP reaches the peer's RTCP conn.
+func TestDialogSocketsICE(t *testing.T) {
+	offerer := openTestSockets(t, &ice.Config{Controlling: true})
+	answerer := openTestSockets(t, &ice.Config{})
+
+	attrs := offerer.sdpAttributes()
+	if len(attrs) < 4 || attrs[len(attrs)-1] != "rtcp-mux" {
+		t.Fatalf("sdpAttributes = %q, want credentials, candidates and rtcp-mux", attrs)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		ufrag, pwd := offerer.agent.Credentials()
+		_, err := answerer.agent.Connect(ctx, ufrag, pwd, offerer.cands)
+		errc <- err
+	}()
+	ufrag, pwd := answerer.agent.Credentials()
+	if _, err := offerer.agent.Connect(ctx, ufrag, pwd, answerer.cands); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("answerer Connect: %v", err)
+	}
+	offerer.rtp.agent.Store(offerer.agent)
+	offerer.multiplexRTCP()
+
+	nowhere := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 9}
+	rtp := "\x80\x00rtp"
+	if _, err := offerer.rtp.WriteTo([]byte(rtp), nowhere); err != nil {
+		t.Fatalf("WriteTo RTP: %v", err)
+	}
+	if got := readWithin(t, answerer.rtp); got != rtp {
+		t.Errorf("RTP read = %q, want %q", got, rtp)
+	}
+
+	rtcp := "\x80\xc8rtcp" // a sender report
+	if _, err := offerer.rtcp.WriteTo([]byte(rtcp), nowhere); err != nil {
+		t.Fatalf("WriteTo RTCP: %v", err)
+	}
+	if got := readWithin(t, answerer.rtcp); got != rtcp {
+		t.Errorf("RTCP read = %q, want %q", got, rtcp)
+	}
+}
+
+// TestDialogSocketsImpairment checks that received RTP goes through the
+// call's impairment before diago and the tap see it.
+func TestDialogSocketsImpairment(t *testing.T) {
+	a := openTestSockets(t, nil)
+	b := openTestSockets(t, nil)
+
+	model := impair.NewModel(impair.Profile{Delay: 30 * time.Millisecond, Duplicate: 100})
+	b.rtp.shaper.Store(impair.NewShaper(model, b.rtp.deliver))
+	var tapped int
+	b.rtp.tap.Store(&mediaTap{rx: func([]byte, *net.UDPAddr, time.Time) { tapped++ }})
+
+	start := time.Now()
+	rtp := "\x80\x00rtp"
+	if _, err := a.rtp.WriteTo([]byte(rtp), b.rtp.LocalAddr()); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	for range 2 {
+		if got := readWithin(t, b.rtp); got != rtp {
+			t.Errorf("RTP read = %q, want %q", got, rtp)
+		}
+	}
+	if held := time.Since(start); held < 30*time.Millisecond {
+		t.Errorf("RTP read after %v, want at least 30ms", held)
+	}
+	if c := model.Counters(); c.Packets != 1 || c.Duplicated != 1 || tapped != 2 {
+		t.Errorf("counters = %+v, tap saw %d; want one packet delivered twice", c, tapped)
+	}
+}
@@ -0,0 +1,212 @@
+package engine
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/emiago/diago/media"
+	"github.com/siptty/siptty/internal/impair"
+	"github.com/siptty/siptty/internal/rtpstats"
+)
+
+// mediaStatsInterval is how often a call's MediaStatsEvent is refreshed.
+const mediaStatsInterval = 5 * time.Second
+
+// mediaStats accumulates the statistics of one call's media. It is safe
+// for concurrent use by the RTP and RTCP readers.
+type mediaStats struct {
+	mu        sync.Mutex
+	codec     string
+	clockRate uint32
+	rx        *rtpstats.Receiver
+	remote    *rtpstats.ReportBlock // the peer's latest report on our stream
+	peer      *rtpstats.SenderInfo  // the peer's latest sender report
+	rtt       time.Duration
+
+	txPackets, txBytes uint64
+	txSSRC             uint32 // the SSRC diago sends with; 0 until it sent RTP
+
+	rtpImpair *impair.Model // the call's, applied to the received RTP before it is counted
+	sipImpair *impair.Model // the call's
+}
+
+func newMediaStats(codec string, clockRate uint32) *mediaStats {
+	return &mediaStats{
+		codec:     codec,
+		clockRate: max(clockRate, 1),
+		rx:        rtpstats.NewReceiver(clockRate),
+	}
+}
+
+// addRTP counts a received RTP packet.
+func (s *mediaStats) addRTP(pkt []byte, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rx.Add(pkt, at)
+}
+
+// addSent counts an RTP packet diago sent.
+func (s *mediaStats) addSent(pkt []byte) {
+	if len(pkt) < 12 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.txPackets++
+	s.txBytes += uint64(len(pkt))
+	s.txSSRC = binary.BigEndian.Uint32(pkt[8:12])
+}
+
+// addRTCP takes the peer's sender information and its report on our stream
+// from a received RTCP packet.
+func (s *mediaStats) addRTCP(pkt []byte, at time.Time) {
+	rep, err := rtpstats.ParseRTCP(pkt)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rep.Sender != nil {
+		s.peer = rep.Sender
+	}
+	rxSSRC := s.rx.Stats().SSRC
+	for _, b := range rep.Blocks {
+		if b.SSRC == rxSSRC && rxSSRC != 0 {
+			continue // a report on the stream we receive, e.g. looped back
+		}
+		s.remote = &b
+		if rtt, ok := b.RTT(at); ok {
+			s.rtt = rtt
+		}
+		break
+	}
+}
+
+// event returns the statistics so far as a MediaStatsEvent.
+func (s *mediaStats) event(callID string) MediaStatsEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rx := s.rx.Stats()
+	ev := MediaStatsEvent{
+		CallID:      callID,
+		Codec:       s.codec,
+		RxPackets:   rx.Packets,
+		RxBytes:     rx.Bytes,
+		TxPackets:   s.txPackets,
+		TxBytes:     s.txBytes,
+		Lost:        max(rx.Lost, 0),
+		LossPercent: rx.LossPercent(),
+		Jitter:      rx.Jitter,
+		OutOfOrder:  rx.OutOfOrder,
+		Duplicates:  rx.Duplicates,
+		RTT:         s.rtt,
+	}
+	if s.remote != nil {
+		ev.RemoteLossPercent = 100 * float64(s.remote.FractionLost) / 256
+		ev.RemoteJitter = time.Duration(uint64(s.remote.Jitter) * uint64(time.Second) / uint64(s.clockRate))
+	}
+	if s.peer != nil {
+		ev.PeerPackets, ev.PeerBytes = s.peer.Packets, s.peer.Octets
+	}
+
+	// Rate the worse direction: a call is as good as its worst leg.
+	q := rtpstats.Quality{Codec: s.codec, LossPercent: ev.LossPercent, RTT: ev.RTT, Jitter: ev.Jitter}
+	q.LossPercent = max(q.LossPercent, ev.RemoteLossPercent)
+	q.Jitter = max(q.Jitter, ev.RemoteJitter)
+	ev.RFactor = rtpstats.RFactor(q)
+	ev.MOS = rtpstats.MOS(ev.RFactor)
+
+	var rtp, sip impair.Profile
+	if s.rtpImpair != nil {
+		rtp, ev.Impaired = s.rtpImpair.Profile(), s.rtpImpair.Counters()
+	}
+	if s.sipImpair != nil {
+		sip, ev.SIPImpaired = s.sipImpair.Profile(), s.sipImpair.Counters()
+	}
+	ev.Impairment = FormatImpairment(impairmentConfig(rtp, sip))
+	return ev
+}
+
+// startMedia watches the RTP and RTCP of a call on its media sockets until
+// it ends, reporting a MediaStatsEvent every mediaStatsInterval and keeping
+// the received RTP when capture_rtp is set. The packets are seen as diago
+// reads and writes them, so nothing competes with diago for the socket.
+// With SRTP the RTCP reports are encrypted, so the peer's view of the sent
+// stream and the RTT stay unknown. The received RTP goes through the call's
+// network impairment before diago and the statistics see it. acct, which
+// may be nil, gives the quality reports sent when the call ends.
+func (e *Engine) startMedia(ctx context.Context, call *Call, acct *Account, ms *media.MediaSession, socks *dialogSockets) {
+	if ms == nil {
+		return
+	}
+	var codec string
+	var clockRate uint32 = 8000
+	if c, ok := audioCodec(ms); ok {
+		codec, clockRate = c.Name, c.SampleRate
+	}
+	stats := newMediaStats(codec, clockRate)
+	stats.rtpImpair, stats.sipImpair = call.rtpImpair, call.sipImpair
+	call.mu.Lock()
+	call.media = stats
+	call.mu.Unlock()
+	if acct != nil && (acct.Config.QualityReport.Collector != "" || acct.Config.QualityReport.RTCPXR) {
+		call.mu.Lock()
+		call.report = func(mediaOpen bool) { e.reportQuality(call, acct, ms, stats, mediaOpen) }
+		call.mu.Unlock()
+	}
+
+	var capture *rtpCapture
+	if e.config.Trace.CaptureRTP && call.SIPCallID != "" {
+		capture = &rtpCapture{}
+		e.mu.Lock()
+		e.rtp[call.SIPCallID] = capture
+		e.mu.Unlock()
+	}
+
+	local := socks.rtp.LocalAddr().String()
+	socks.rtp.tap.Store(&mediaTap{
+		rx: func(b []byte, from *net.UDPAddr, at time.Time) {
+			stats.addRTP(b, at)
+			if capture != nil {
+				capture.add(RTPPacket{
+					Timestamp:  at,
+					CallID:     call.SIPCallID,
+					LocalAddr:  local,
+					RemoteAddr: from.String(),
+					Data:       slices.Clone(b),
+				})
+			}
+		},
+		tx: func(b []byte, _ time.Time) { stats.addSent(b) },
+	})
+	if call.rtpImpair != nil {
+		socks.rtp.shaper.Store(impair.NewShaper(call.rtpImpair, socks.rtp.deliver))
+	}
+	if call.Encryption == "" {
+		socks.rtcp.tap.Store(&mediaTap{rx: func(b []byte, _ *net.UDPAddr, at time.Time) {
+			stats.addRTCP(b, at)
+		}})
+	}
+
+	go func() {
+		ticker := time.NewTicker(mediaStatsInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				e.events <- stats.event(call.ID)
+			case <-ctx.Done():
+				e.events <- stats.event(call.ID)
+				// Reports normally went before the BYE (see Hangup and
+				// observeMessage); this catches dialogs that ended
+				// without one, when the media session is already closed.
+				call.sendQualityReports(false)
+				return
+			}
+		}
+	}()
+}
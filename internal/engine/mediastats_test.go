@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/siptty/siptty/internal/rtpstats"
+)
+
+func TestMediaStatsEvent(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := newMediaStats("PCMU", 8000)
+	for i := range 100 {
+		if i == 50 {
+			continue // lost
+		}
+		pkt := make([]byte, 172)
+		pkt[0] = 0x80
+		binary.BigEndian.PutUint16(pkt[2:], uint16(i))
+		binary.BigEndian.PutUint32(pkt[4:], uint32(i*160))
+		binary.BigEndian.PutUint32(pkt[8:], 0xAAAA)
+		s.addRTP(pkt, t0.Add(time.Duration(i)*20*time.Millisecond))
+	}
+
+	// The peer's receiver report on our stream: 10% loss, 40 ms jitter,
+	// answering a sender report of ours 100 ms before.
+	at := t0.Add(2 * time.Second)
+	var rr []byte
+	rr = append(rr, 0x81, 201)
+	rr = binary.BigEndian.AppendUint16(rr, 7)
+	rr = binary.BigEndian.AppendUint32(rr, 0xAAAA)
+	rr = binary.BigEndian.AppendUint32(rr, 0xBBBB)
+	rr = binary.BigEndian.AppendUint32(rr, 26<<24|5)
+	rr = binary.BigEndian.AppendUint32(rr, 100)
+	rr = binary.BigEndian.AppendUint32(rr, 320)
+	rr = binary.BigEndian.AppendUint32(rr, uint32(rtpstats.NTPTime(at.Add(-100*time.Millisecond))>>16))
+	rr = binary.BigEndian.AppendUint32(rr, 0)
+	s.addRTCP(rr, at)
+	s.addRTCP([]byte("not rtcp"), at)
+
+	sent := make([]byte, 172)
+	sent[0] = 0x80
+	binary.BigEndian.PutUint32(sent[8:], 0xBBBB)
+	s.addSent(sent)
+	s.addSent(sent)
+
+	ev := s.event("3")
+	if ev.CallID != "3" || ev.Codec != "PCMU" {
+		t.Errorf("event = %+v", ev)
+	}
+	if ev.RxPackets != 99 || ev.RxBytes != 99*172 || ev.Lost != 1 || ev.LossPercent != 1 {
+		t.Errorf("received %d packets, %d bytes, lost %d (%.1f%%); want 99, %d, 1 (1%%)",
+			ev.RxPackets, ev.RxBytes, ev.Lost, ev.LossPercent, 99*172)
+	}
+	if ev.TxPackets != 2 || ev.TxBytes != 2*172 || s.txSSRC != 0xBBBB {
+		t.Errorf("sent %d packets, %d bytes, SSRC %#x; want 2, %d, 0xbbbb", ev.TxPackets, ev.TxBytes, s.txSSRC, 2*172)
+	}
+	if ev.RemoteLossPercent < 10 || ev.RemoteLossPercent > 10.2 || ev.RemoteJitter != 40*time.Millisecond {
+		t.Errorf("remote loss %.1f%%, jitter %v; want 10.2%%, 40ms", ev.RemoteLossPercent, ev.RemoteJitter)
+	}
+	if ev.RTT < 99*time.Millisecond || ev.RTT > 101*time.Millisecond {
+		t.Errorf("RTT = %v, want 100ms", ev.RTT)
+	}
+	// The sent stream's 10% loss dominates the rating.
+	want := rtpstats.MOS(rtpstats.RFactor(rtpstats.Quality{Codec: "PCMU", LossPercent: ev.RemoteLossPercent, RTT: ev.RTT, Jitter: ev.RemoteJitter}))
+	if ev.MOS != want || ev.MOS >= 4 {
+		t.Errorf("MOS = %.2f, want %.2f", ev.MOS, want)
+	}
+}
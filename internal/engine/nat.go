@@ -0,0 +1,172 @@
+package engine
+
+import (
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/siptty/siptty/internal/config"
+	"github.com/siptty/siptty/internal/stun"
+)
+
+// stunTimeout bounds STUN discovery at startup.
+const stunTimeout = 3 * time.Second
+
+// accountNAT is what an account advertises from behind a NAT.
+type accountNAT struct {
+	host    string // Contact host; "" leaves the listener's
+	port    int    // Contact port, with host; 0 keeps the listener's
+	mediaIP net.IP // SDP c= address; nil leaves the local one
+	source  string // "stun" or "config"; "" without NAT settings
+}
+
+// publicAddr is the address reported in NATStateEvent.
+func (n accountNAT) publicAddr() string {
+	if n.port == 0 {
+		return n.host
+	}
+	return net.JoinHostPort(n.host, strconv.Itoa(n.port))
+}
+
+// natAddresses works out what acct advertises: the mapping of the SIP
+// listener (bindPort) that a Binding request to the account's own
+// stun_server reveals, for Contact and SDP c=, and public_address, which
+// takes over the SDP c= address and, without a STUN mapping, the Contact
+// host. discover runs the Binding request. A STUN failure is logged and
+// leaves the local addresses in place.
+func natAddresses(acct config.AccountConfig, bindPort int, discover func(server string) (*net.UDPAddr, error)) accountNAT {
+	var n accountNAT
+	if acct.NAT.STUNServer != "" {
+		mapped, err := discover(acct.NAT.STUNServer)
+		if err != nil {
+			slog.Warn("STUN discovery failed", "account", acct.Name, "server", acct.NAT.STUNServer, "error", err)
+		} else {
+			n = accountNAT{host: mapped.IP.String(), port: mapped.Port, mediaIP: mapped.IP, source: "stun"}
+		}
+	}
+	if acct.NAT.PublicAddress != "" {
+		n.mediaIP = net.ParseIP(acct.NAT.PublicAddress)
+		if n.source == "" {
+			n.host, n.port, n.source = acct.NAT.PublicAddress, bindPort, "config"
+		}
+	}
+	return n
+}
+
+// discoverOnce wraps discover so that each STUN server is queried once,
+// for all the accounts sharing it.
+func discoverOnce(discover func(server string) (*net.UDPAddr, error)) func(server string) (*net.UDPAddr, error) {
+	type result struct {
+		addr *net.UDPAddr
+		err  error
+	}
+	seen := make(map[string]result)
+	return func(server string) (*net.UDPAddr, error) {
+		r, ok := seen[server]
+		if !ok {
+			r.addr, r.err = discover(server)
+			seen[server] = r
+		}
+		return r.addr, r.err
+	}
+}
+
+// applyNAT points contact at the account's public address, when it has
+// one. Over WebSocket the Contact keeps its .invalid host (RFC 7118 §5.2).
+func (a *Account) applyNAT(contact *sip.ContactHeader) {
+	if a.nat.host == "" || config.IsWebSocket(a.Config.Transport) {
+		return
+	}
+	contact.Address.Host = uriHost(a.nat.host)
+	if a.nat.port != 0 {
+		contact.Address.Port = a.nat.port
+	}
+}
+
+// natContact returns the Contact for a dialog of the account, which may be
+// nil, at its public address, or nil to leave diago's.
+func (a *Account) natContact() *sip.ContactHeader {
+	if a == nil || a.nat.host == "" || config.IsWebSocket(a.Config.Transport) {
+		return nil
+	}
+	var aor sip.Uri
+	_ = sip.ParseUri(a.Config.SipURI, &aor) // checked by config validation
+	contact := &sip.ContactHeader{Address: sip.Uri{Scheme: "sip", User: aor.User, Port: a.nat.port}}
+	if a.Config.Transport != "udp" {
+		contact.Address.UriParams = sip.NewParams()
+		contact.Address.UriParams.Add("transport", a.Config.Transport)
+	}
+	a.applyNAT(contact)
+	return contact
+}
+
+// stunDiscover queries server from the SIP bind address, so the mapping
+// learned is the one the UDP transport will get once it binds the same port.
+func stunDiscover(server, bindHost string, bindPort int) (*net.UDPAddr, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(bindHost), Port: bindPort})
+	if err != nil {
+		// Port already taken: an ephemeral port still tells us the public IP,
+		// but its mapped port says nothing about the SIP socket's.
+		mapped, err := stun.Discover(server, bindHost, stunTimeout)
+		if err != nil {
+			return nil, err
+		}
+		mapped.Port = 0
+		return mapped, nil
+	}
+	defer conn.Close()
+	return stun.DiscoverConn(conn, server, stunTimeout)
+}
+
+// viaPublicAddr returns the address the server saw a request come from, as
+// reported in the top Via of its response (received/rport, RFC 3261 §18.2.1
+// and RFC 3581). ok is false when it matches the sent-by we advertised, i.e.
+// there is no NAT in the way.
+func viaPublicAddr(res *sip.Response) (addr string, ok bool) {
+	via := res.Via()
+	if via == nil {
+		return "", false
+	}
+	host := bareHost(via.Host)
+	if received, found := via.Params.Get("received"); found && received != "" {
+		host = received
+	}
+	port := via.Port
+	if rport, found := via.Params.Get("rport"); found && rport != "" {
+		if p, err := strconv.Atoi(rport); err == nil {
+			port = p
+		}
+	}
+	if port == 0 {
+		port = 5060
+	}
+	sentBy := via.Port
+	if sentBy == 0 {
+		sentBy = 5060
+	}
+	if host == bareHost(via.Host) && port == sentBy {
+		return "", false
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port)), true
+}
+
+// learnNATAddress checks a REGISTER response for received/rport and, for
+// rewrite_contact accounts, records the public address it reveals.
+func (e *Engine) learnNATAddress(acct *Account, res *sip.Response) {
+	if !acct.Config.NAT.RewriteContact {
+		return
+	}
+	addr, ok := viaPublicAddr(res)
+	if !ok || !acct.setPublicAddr(addr) {
+		return
+	}
+	slog.Info("learned public address", "account", acct.ID, "addr", addr)
+	// Called from the tracer on sipgo's read path.
+	e.notify(NATStateEvent{
+		AccountID:  acct.ID,
+		PublicAddr: addr,
+		Source:     "rport",
+	})
+}
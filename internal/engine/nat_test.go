@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/siptty/siptty/internal/config"
+)
+
+func TestViaPublicAddr(t *testing.T) {
+	tests := []struct {
+		name   string
+		via    string
+		want   string
+		wantOK bool
+	}{
+		{"no NAT", "SIP/2.0/UDP 192.168.1.10:5060;branch=z9hG4bK1;rport=5060;received=192.168.1.10", "", false},
+		{"no params", "SIP/2.0/UDP 192.168.1.10:5060;branch=z9hG4bK1", "", false},
+		{"received and rport", "SIP/2.0/UDP 192.168.1.10:5060;branch=z9hG4bK1;rport=40123;received=203.0.113.7", "203.0.113.7:40123", true},
+		{"received only", "SIP/2.0/UDP 192.168.1.10:5060;branch=z9hG4bK1;received=203.0.113.7", "203.0.113.7:5060", true},
+		{"port rewritten only", "SIP/2.0/UDP 192.168.1.10:5060;branch=z9hG4bK1;rport=40123", "192.168.1.10:40123", true},
+		{"default port", "SIP/2.0/UDP 192.168.1.10;branch=z9hG4bK1;rport=5060", "", false},
+		{"IPv6 no NAT", "SIP/2.0/UDP [2001:db8::10]:5060;branch=z9hG4bK1;rport=5060;received=2001:db8::10", "", false},
+		{"IPv6 rport", "SIP/2.0/UDP [2001:db8::10]:5060;branch=z9hG4bK1;rport=40123", "[2001:db8::10]:40123", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := "SIP/2.0 200 OK\r\n" +
+				"Via: " + tt.via + "\r\n" +
+				"From: <sip:alice@example.com>;tag=a\r\n" +
+				"To: <sip:alice@example.com>;tag=b\r\n" +
+				"Call-ID: reg-1\r\n" +
+				"CSeq: 1 REGISTER\r\n" +
+				"Content-Length: 0\r\n\r\n"
+			msg, err := sip.ParseMessage([]byte(raw))
+			if err != nil {
+				t.Fatalf("ParseMessage: %v", err)
+			}
+			got, ok := viaPublicAddr(msg.(*sip.Response))
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("viaPublicAddr = (%q, %v), want (%q, %v)", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNATAddresses(t *testing.T) {
+	queried := map[string]int{}
+	discover := discoverOnce(func(server string) (*net.UDPAddr, error) {
+		queried[server]++
+		switch server {
+		case "stun1.example.com":
+			return &net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 40001}, nil
+		case "stun2.example.com":
+			return &net.UDPAddr{IP: net.ParseIP("198.51.100.2"), Port: 40002}, nil
+		}
+		return nil, errors.New("no response")
+	})
+
+	tests := []struct {
+		name      string
+		nat       config.NATConfig
+		want      string // publicAddr
+		wantMedia string
+		source    string
+	}{
+		{"stun", config.NATConfig{STUNServer: "stun1.example.com"}, "203.0.113.1:40001", "203.0.113.1", "stun"},
+		{"other stun", config.NATConfig{STUNServer: "stun2.example.com"}, "198.51.100.2:40002", "198.51.100.2", "stun"},
+		{"stun and media address", config.NATConfig{STUNServer: "stun2.example.com", PublicAddress: "192.0.2.99"}, "198.51.100.2:40002", "192.0.2.99", "stun"},
+		{"public address", config.NATConfig{PublicAddress: "192.0.2.7"}, "192.0.2.7:5070", "192.0.2.7", "config"},
+		{"stun fails", config.NATConfig{STUNServer: "down.example.com", PublicAddress: "192.0.2.7"}, "192.0.2.7:5070", "192.0.2.7", "config"},
+		{"none", config.NATConfig{}, "", "<nil>", ""},
+	}
+	for _, tt := range tests {
+		n := natAddresses(config.AccountConfig{Name: tt.name, NAT: tt.nat}, 5070, discover)
+		if n.publicAddr() != tt.want || n.mediaIP.String() != tt.wantMedia || n.source != tt.source {
+			t.Errorf("%s: got %s (media %s, %s), want %s (media %s, %s)", tt.name, n.publicAddr(), n.mediaIP, n.source, tt.want, tt.wantMedia, tt.source)
+		}
+	}
+	if queried["stun2.example.com"] != 1 {
+		t.Errorf("stun2 queried %d times, want once for both accounts using it", queried["stun2.example.com"])
+	}
+}
+
+func TestNATContact(t *testing.T) {
+	acct := &Account{
+		Config: config.AccountConfig{SipURI: "sip:alice@pbx.example.com", Transport: "tcp"},
+		nat:    accountNAT{host: "203.0.113.1", port: 40001, source: "stun"},
+	}
+	if got := acct.natContact().Value(); got != "<sip:alice@203.0.113.1:40001;transport=tcp>" {
+		t.Errorf("natContact = %s", got)
+	}
+	var none *Account
+	if none.natContact() != nil {
+		t.Error("natContact without an account")
+	}
+	acct.Config.Transport = "ws"
+	if acct.natContact() != nil {
+		t.Error("natContact over WebSocket, which keeps its .invalid host")
+	}
+}
@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/siptty/siptty/internal/pcap"
+)
+
+// sipPort is the port WritePcap shows the peer's end of TLS and WebSocket
+// flows on: the trace holds their messages decrypted and unframed, and
+// Wireshark decodes plain SIP over TCP only on the SIP port.
+const sipPort = 5060
+
+// WritePcap writes trace events and captured RTP to w as pcapng, in time
+// order, with IP and UDP/TCP headers synthesized from their addresses.
+// Messages over TLS or WebSocket are written as plain TCP to or from
+// sipPort, with a packet comment giving the real transport and port. DNS
+// notes and messages without usable addresses are skipped; it returns the
+// number of packets written.
+func WritePcap(w io.Writer, events []SipTraceEvent, rtp []RTPPacket) (int, error) {
+	var packets []pcap.Packet
+	for _, ev := range events {
+		local, err1 := netip.ParseAddrPort(ev.LocalAddr)
+		remote, err2 := netip.ParseAddrPort(ev.RemoteAddr)
+		if ev.Direction == "dns" || err1 != nil || err2 != nil {
+			continue
+		}
+		p := pcap.Packet{
+			Time:    ev.Timestamp,
+			Src:     local,
+			Dst:     remote,
+			TCP:     !strings.EqualFold(ev.Transport, "udp"),
+			Payload: []byte(ev.Message),
+		}
+		if transport := strings.ToUpper(ev.Transport); transport != "UDP" && transport != "TCP" && remote.Port() != sipPort {
+			p.Comment = fmt.Sprintf("SIP over %s from port %d, shown decrypted and unframed on port %d", transport, remote.Port(), sipPort)
+			p.Dst = netip.AddrPortFrom(remote.Addr(), sipPort)
+		}
+		if ev.Direction == "recv" {
+			p.Src, p.Dst = p.Dst, p.Src
+		}
+		packets = append(packets, p)
+	}
+	for _, r := range rtp {
+		local, err1 := netip.ParseAddrPort(r.LocalAddr)
+		remote, err2 := netip.ParseAddrPort(r.RemoteAddr)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		packets = append(packets, pcap.Packet{Time: r.Timestamp, Src: remote, Dst: local, Payload: r.Data})
+	}
+	slices.SortStableFunc(packets, func(a, b pcap.Packet) int {
+		return cmp.Compare(a.Time.UnixNano(), b.Time.UnixNano())
+	})
+
+	pw, err := pcap.NewWriter(w, "siptty")
+	if err != nil {
+		return 0, err
+	}
+	for i, p := range packets {
+		if err := pw.WritePacket(p); err != nil {
+			return i, err
+		}
+	}
+	return len(packets), nil
+}
+
+// WritePcapFile writes trace events and captured RTP to a pcapng file at path.
+func WritePcapFile(path string, events []SipTraceEvent, rtp []RTPPacket) (int, error) {
+	return writeFile(path, func(w io.Writer) (int, error) {
+		return WritePcap(w, events, rtp)
+	})
+}
+
+// writeFile creates path and fills it through a buffer with write, which
+// returns the number of items it wrote.
+func writeFile(path string, write func(io.Writer) (int, error)) (int, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	bw := bufio.NewWriter(f)
+	n, err := write(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return n, fmt.Errorf("writing %s: %w", path, err)
+	}
+	slog.Info("trace exported", "path", path, "items", n)
+	return n, nil
+}
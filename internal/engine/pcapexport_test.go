@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestWritePcap(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	invite := traceInvite
+	invite.Timestamp = t0
+	invite.Transport = "UDP"
+	invite.LocalAddr = "192.0.2.1:5060"
+	// sipgo traces a TLS stream as "TCP"; the tracer names it.
+	events := make(chan Event, 1)
+	tracer := &sipTracer{events: events, secure: func(string, string) bool { return true }}
+	tracer.SIPTraceRead("TCP", "192.0.2.1:40000", "192.0.2.10:5061", []byte(traceBusy.Message))
+	busy := (<-events).(SipTraceEvent)
+	busy.Timestamp = t0.Add(time.Second)
+	noAddr := traceOptions // no local address
+	rtp := []RTPPacket{{Timestamp: t0.Add(500 * time.Millisecond), LocalAddr: "192.0.2.1:4000", RemoteAddr: "192.0.2.10:5000", Data: []byte{0x80, 0}}}
+
+	var buf bytes.Buffer
+	n, err := WritePcap(&buf, []SipTraceEvent{invite, traceDNSNote, busy, noAddr}, rtp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("wrote %d packets, want 3", n)
+	}
+
+	// Walk to the enhanced packet blocks and check their order and headers.
+	b := buf.Bytes()
+	var protos []byte
+	var srcs []string
+	var tlsPort uint16
+	for len(b) > 0 {
+		typ, size := binary.LittleEndian.Uint32(b), binary.LittleEndian.Uint32(b[4:])
+		if typ == 6 {
+			pkt := b[28:]
+			protos = append(protos, pkt[9])
+			srcs = append(srcs, netip.AddrFrom4([4]byte(pkt[12:16])).String())
+			if pkt[9] == 6 {
+				tlsPort = binary.BigEndian.Uint16(pkt[20:])
+				if !bytes.Contains(b[:size], []byte("SIP over TLS from port 5061")) {
+					t.Error("TLS packet has no comment giving its real port")
+				}
+			}
+		}
+		b = b[size:]
+	}
+	if tlsPort != 5060 {
+		t.Errorf("TLS packet source port = %d, want 5060 so Wireshark decodes SIP", tlsPort)
+	}
+	if string(protos) != string([]byte{17, 17, 6}) {
+		t.Errorf("protocols = %v, want UDP, UDP (RTP), TCP (TLS)", protos)
+	}
+	if srcs[0] != "192.0.2.1" || srcs[1] != "192.0.2.10" || srcs[2] != "192.0.2.10" {
+		t.Errorf("sources = %v", srcs)
+	}
+}
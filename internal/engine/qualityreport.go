@@ -0,0 +1,245 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/emiago/diago/media"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/siptty/siptty/internal/rtpstats"
+)
+
+// publishTimeout bounds a vq-rtcpxr PUBLISH, authentication included.
+const publishTimeout = 10 * time.Second
+
+// qualityReport is the end-of-call summary of a call's media, sent to a
+// vq-rtcpxr collector (RFC 6035) and, as an RTCP XR VoIP metrics block
+// (RFC 3611), to the peer.
+type qualityReport struct {
+	callID   string // SIP Call-ID
+	localID  string // local and remote party, as SIP URIs
+	remoteID string
+	origID   string // the party that sent the INVITE
+	dialogID string // "callid;to-tag=..;from-tag=.." of the INVITE; "" if unknown
+
+	localAddr, remoteAddr net.UDPAddr
+	localSSRC, peerSSRC   uint32 // 0 when unknown
+
+	start, stop time.Time
+	payloadType uint8
+	clockRate   uint32
+	stats       MediaStatsEvent
+	peerReport  bool // the peer sent reception reports on our stream
+}
+
+// reportQuality sends the configured end-of-call quality reports of a call:
+// the RTCP XR packet right away if the media session is still open, the
+// PUBLISH in the background.
+func (e *Engine) reportQuality(call *Call, acct *Account, ms *media.MediaSession, stats *mediaStats, mediaOpen bool) {
+	cfg := acct.Config.QualityReport
+	r := e.newQualityReport(call, acct, ms, stats)
+	if cfg.RTCPXR {
+		// SRTCP would need the session's keys; diago does not lend them.
+		switch {
+		case !mediaOpen:
+			slog.Debug("RTCP XR not sent: media session closed", "id", call.ID)
+		case call.Encryption != "":
+			slog.Debug("RTCP XR not sent on an encrypted call", "id", call.ID)
+		case r.localSSRC == 0:
+			slog.Debug("RTCP XR not sent: no RTP sent, so no SSRC of our own", "id", call.ID)
+		default:
+			if _, err := ms.WriteRTCPRaw(voipMetrics(r).AppendXR(nil, r.localSSRC)); err != nil {
+				slog.Warn("RTCP XR send failed", "id", call.ID, "error", err)
+			}
+		}
+	}
+	if cfg.Collector != "" {
+		go e.publishQualityReport(acct, r)
+	}
+}
+
+// newQualityReport collects what the reports say about a call. The parties
+// and tags come from the traced dialog, the rest from the media statistics.
+func (e *Engine) newQualityReport(call *Call, acct *Account, ms *media.MediaSession, stats *mediaStats) qualityReport {
+	r := qualityReport{
+		callID:     call.SIPCallID,
+		localID:    acct.Config.SipURI,
+		remoteID:   call.RemoteURI,
+		localAddr:  ms.Laddr,
+		remoteAddr: ms.Raddr,
+		start:      call.StartTime,
+		stop:       time.Now(),
+		clockRate:  stats.clockRate,
+		stats:      stats.event(call.ID),
+	}
+	if c, ok := audioCodec(ms); ok {
+		r.payloadType = c.PayloadType
+	}
+	stats.mu.Lock()
+	r.peerSSRC = stats.rx.Stats().SSRC
+	r.localSSRC = stats.txSSRC
+	r.peerReport = stats.remote != nil
+	stats.mu.Unlock()
+
+	r.origID = r.localID
+	if call.Direction == "inbound" {
+		r.origID = r.remoteID
+	}
+	call.mu.Lock()
+	st := call.dialog.state()
+	call.mu.Unlock()
+	if st != nil {
+		r.localID, r.remoteID = st.LocalURI, st.RemoteURI
+		fromTag, toTag := st.LocalTag, st.RemoteTag
+		r.origID = st.LocalURI
+		if st.Direction == "inbound" {
+			fromTag, toTag = toTag, fromTag
+			r.origID = st.RemoteURI
+		}
+		r.dialogID = fmt.Sprintf("%s;to-tag=%s;from-tag=%s", st.CallID, toTag, fromTag)
+	}
+	return r
+}
+
+// voipMetrics returns the RTCP XR VoIP metrics of the stream received on a
+// call. Losses are not split into bursts, so the whole call counts as one
+// gap; levels, echo and the jitter buffer are not measured.
+func voipMetrics(r qualityReport) rtpstats.VoIPMetrics {
+	st := r.stats
+	m := rtpstats.VoIPMetrics{
+		SSRC:           r.peerSSRC,
+		LossRate:       uint8(min(math.Round(st.LossPercent*256/100), 255)),
+		GapDuration:    uint16(min(r.stop.Sub(r.start).Milliseconds(), math.MaxUint16)),
+		RoundTripDelay: uint16(min(st.RTT.Milliseconds(), math.MaxUint16)),
+		SignalLevel:    rtpstats.Unavailable,
+		NoiseLevel:     rtpstats.Unavailable,
+		RERL:           rtpstats.Unavailable,
+		Gmin:           16,
+		RFactor:        rtpstats.Unavailable,
+		ExtRFactor:     rtpstats.Unavailable,
+		MOSLQ:          rtpstats.Unavailable,
+		MOSCQ:          rtpstats.Unavailable,
+	}
+	m.GapDensity = m.LossRate
+	if st.RxPackets > 0 {
+		q := rtpstats.Quality{Codec: st.Codec, LossPercent: st.LossPercent, Jitter: st.Jitter}
+		m.MOSLQ = uint8(math.Round(10 * rtpstats.MOS(rtpstats.RFactor(q))))
+		q.RTT = st.RTT
+		rf := rtpstats.RFactor(q)
+		m.RFactor = uint8(math.Round(rf))
+		m.MOSCQ = uint8(math.Round(10 * rtpstats.MOS(rf)))
+	}
+	return m
+}
+
+// formatVQReport renders a call's quality report as an RFC 6035
+// application/vq-rtcpxr session report. LocalMetrics describe the stream
+// siptty received, RemoteMetrics the one it sent, as the peer reported it.
+func formatVQReport(r qualityReport) string {
+	var b strings.Builder
+	line := func(format string, args ...any) {
+		fmt.Fprintf(&b, format+"\r\n", args...)
+	}
+	addr := func(a net.UDPAddr, ssrc uint32) string {
+		s := fmt.Sprintf("IP=%s PORT=%d", a.IP, a.Port)
+		if ssrc != 0 {
+			s += fmt.Sprintf(" SSRC=0x%08x", ssrc)
+		}
+		return s
+	}
+	st := r.stats
+
+	line("VQSessionReport: CallTerm")
+	line("CallID: %s", r.callID)
+	line("LocalID: <%s>", r.localID)
+	line("RemoteID: <%s>", r.remoteID)
+	line("OrigID: <%s>", r.origID)
+	line("LocalAddr: %s", addr(r.localAddr, r.localSSRC))
+	line("RemoteAddr: %s", addr(r.remoteAddr, r.peerSSRC))
+
+	timestamps := fmt.Sprintf("START=%s STOP=%s", r.start.UTC().Format(time.RFC3339), r.stop.UTC().Format(time.RFC3339))
+	session := fmt.Sprintf("PT=%d PD=%s SR=%d", r.payloadType, st.Codec, r.clockRate)
+
+	line("LocalMetrics:")
+	line("Timestamps: %s", timestamps)
+	line("SessionDesc: %s", session)
+	line("PacketLoss: NLR=%.1f JDR=0.0", st.LossPercent)
+	delay := fmt.Sprintf("IAJ=%d", st.Jitter.Milliseconds())
+	if st.RTT > 0 {
+		delay = fmt.Sprintf("RTD=%d %s", st.RTT.Milliseconds(), delay)
+	}
+	line("Delay: %s", delay)
+	if m := voipMetrics(r); m.RFactor != rtpstats.Unavailable {
+		line("QualityEst: RCQ=%d MOSLQ=%.1f MOSCQ=%.1f QoEEstAlg=G.107", m.RFactor, float64(m.MOSLQ)/10, float64(m.MOSCQ)/10)
+	}
+
+	if r.peerReport {
+		line("RemoteMetrics:")
+		line("Timestamps: %s", timestamps)
+		line("SessionDesc: %s", session)
+		line("PacketLoss: NLR=%.1f JDR=0.0", st.RemoteLossPercent)
+		delay := fmt.Sprintf("IAJ=%d", st.RemoteJitter.Milliseconds())
+		if st.RTT > 0 {
+			delay = fmt.Sprintf("RTD=%d %s", st.RTT.Milliseconds(), delay)
+		}
+		line("Delay: %s", delay)
+	}
+
+	if r.dialogID != "" {
+		line("DialogID: %s", r.dialogID)
+	}
+	return b.String()
+}
+
+// publishQualityReport sends a call's quality report to the account's
+// collector in a PUBLISH (RFC 6035), authenticating if challenged.
+func (e *Engine) publishQualityReport(acct *Account, r qualityReport) {
+	var collector, aor sip.Uri
+	if err := sip.ParseUri(acct.Config.QualityReport.Collector, &collector); err != nil {
+		slog.Error("invalid quality report collector", "account", acct.ID, "error", err)
+		return
+	}
+	if err := applyTransport(&collector, acct.Config.Transport); err != nil {
+		slog.Error("invalid quality report collector", "account", acct.ID, "error", err)
+		return
+	}
+	if err := sip.ParseUri(acct.Config.SipURI, &aor); err != nil {
+		slog.Error("invalid sip_uri", "account", acct.ID, "error", err)
+		return
+	}
+
+	req := sip.NewRequest(sip.PUBLISH, collector)
+	from := sip.FromHeader{Address: aor}
+	from.Params.Add("tag", sip.GenerateTagN(16))
+	req.AppendHeader(&from)
+	for _, h := range routeHeaders(acct.dialogRoutes()) {
+		req.AppendHeader(h)
+	}
+	req.AppendHeader(sip.NewHeader("Event", "vq-rtcpxr"))
+	req.AppendHeader(sip.NewHeader("Content-Type", "application/vq-rtcpxr"))
+	req.SetBody([]byte(formatVQReport(r)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	res, err := e.client.Do(ctx, req)
+	if err == nil && (res.StatusCode == 401 || res.StatusCode == 407) {
+		res, err = e.client.DoDigestAuth(ctx, req, res, sipgo.DigestAuth{
+			Username: acct.Config.AuthUser,
+			Password: acct.Config.AuthPassword,
+		})
+	}
+	switch {
+	case err != nil:
+		slog.Warn("quality report PUBLISH failed", "account", acct.ID, "call_id", r.callID, "error", err)
+	case !res.IsSuccess():
+		slog.Warn("quality report rejected", "account", acct.ID, "call_id", r.callID, "status", res.StatusCode, "reason", res.Reason)
+	default:
+		slog.Info("quality report sent", "account", acct.ID, "call_id", r.callID)
+	}
+}
@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/siptty/siptty/internal/rtpstats"
+)
+
+func testQualityReport() qualityReport {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	return qualityReport{
+		callID:      "abc@host",
+		localID:     "sip:alice@example.com",
+		remoteID:    "sip:bob@example.com",
+		origID:      "sip:alice@example.com",
+		dialogID:    "abc@host;to-tag=b;from-tag=a",
+		localAddr:   net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4000},
+		remoteAddr:  net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 5000},
+		localSSRC:   0x1111,
+		peerSSRC:    0x2222,
+		start:       start,
+		stop:        start.Add(90 * time.Second),
+		payloadType: 0,
+		clockRate:   8000,
+		stats: MediaStatsEvent{
+			Codec:             "PCMU",
+			RxPackets:         4500,
+			LossPercent:       2,
+			Jitter:            10 * time.Millisecond,
+			RemoteLossPercent: 5,
+			RemoteJitter:      20 * time.Millisecond,
+			RTT:               80 * time.Millisecond,
+		},
+		peerReport: true,
+	}
+}
+
+func TestVoIPMetrics(t *testing.T) {
+	r := testQualityReport()
+	m := voipMetrics(r)
+	if m.SSRC != 0x2222 || m.LossRate != 5 || m.GapDensity != 5 {
+		t.Errorf("SSRC %#x, loss rate %d, gap density %d; want 0x2222, 5, 5", m.SSRC, m.LossRate, m.GapDensity)
+	}
+	if m.GapDuration != 65535 || m.RoundTripDelay != 80 {
+		t.Errorf("gap %d ms, RTD %d ms; want 65535, 80", m.GapDuration, m.RoundTripDelay)
+	}
+	if m.SignalLevel != rtpstats.Unavailable || m.RERL != rtpstats.Unavailable {
+		t.Errorf("unmeasured levels = %d, %d", m.SignalLevel, m.RERL)
+	}
+	if m.MOSLQ < m.MOSCQ || m.MOSCQ < 35 || m.RFactor > 93 {
+		t.Errorf("R %d, MOS-LQ %d, MOS-CQ %d", m.RFactor, m.MOSLQ, m.MOSCQ)
+	}
+
+	// Nothing received: no scores.
+	r.stats.RxPackets = 0
+	if m := voipMetrics(r); m.RFactor != rtpstats.Unavailable || m.MOSLQ != rtpstats.Unavailable {
+		t.Errorf("scores without media: R %d, MOS-LQ %d", m.RFactor, m.MOSLQ)
+	}
+}
+
+func TestFormatVQReport(t *testing.T) {
+	r := testQualityReport()
+	got := formatVQReport(r)
+	for _, want := range []string{
+		"VQSessionReport: CallTerm\r\n",
+		"CallID: abc@host\r\n",
+		"LocalID: <sip:alice@example.com>\r\n",
+		"RemoteID: <sip:bob@example.com>\r\n",
+		"OrigID: <sip:alice@example.com>\r\n",
+		"LocalAddr: IP=10.0.0.1 PORT=4000 SSRC=0x00001111\r\n",
+		"RemoteAddr: IP=10.0.0.2 PORT=5000 SSRC=0x00002222\r\n",
+		"Timestamps: START=2024-01-01T12:00:00Z STOP=2024-01-01T12:01:30Z\r\n",
+		"SessionDesc: PT=0 PD=PCMU SR=8000\r\n",
+		"PacketLoss: NLR=2.0 JDR=0.0\r\n",
+		"Delay: RTD=80 IAJ=10\r\n",
+		"QualityEst: RCQ=",
+		"RemoteMetrics:\r\nTimestamps:",
+		"PacketLoss: NLR=5.0 JDR=0.0\r\n",
+		"Delay: RTD=80 IAJ=20\r\n",
+		"DialogID: abc@host;to-tag=b;from-tag=a\r\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("report lacks %q:\n%s", want, got)
+		}
+	}
+	if strings.Index(got, "LocalMetrics:") > strings.Index(got, "RemoteMetrics:") {
+		t.Errorf("RemoteMetrics before LocalMetrics:\n%s", got)
+	}
+
+	// Without reports from the peer there is nothing to say about what it
+	// received.
+	r.peerReport, r.dialogID = false, ""
+	got = formatVQReport(r)
+	if strings.Contains(got, "RemoteMetrics:") || strings.Contains(got, "DialogID:") {
+		t.Errorf("report without peer reports or dialog:\n%s", got)
+	}
+}
+
+func TestQualityReportBeforeBye(t *testing.T) {
+	e := &Engine{calls: make(map[string]*Call)}
+	tracer := &sipTracer{events: make(chan Event, 4), observe: e.observeMessage}
+	var reports []bool
+	call := &Call{ID: "1", SIPCallID: "c1"}
+	call.report = func(mediaOpen bool) { reports = append(reports, mediaOpen) }
+	e.addCall(call)
+
+	// The tracer sees the peer's BYE before sipgo hands it to diago, which
+	// closes the media session on it.
+	tracer.SIPTraceRead("UDP", "192.0.2.1:5060", "192.0.2.10:5060", []byte(request("BYE", "c2", 3)))
+	if len(reports) != 0 {
+		t.Fatalf("BYE of another dialog sent reports")
+	}
+	tracer.SIPTraceRead("UDP", "192.0.2.1:5060", "192.0.2.10:5060", []byte(request("BYE", "c1", 3)))
+	if len(reports) != 1 || !reports[0] {
+		t.Fatalf("reports after BYE = %v, want one sent with the media open", reports)
+	}
+
+	// The end of the dialog context, after teardown, sends nothing more.
+	call.sendQualityReports(false)
+	if len(reports) != 1 {
+		t.Errorf("reports sent again after teardown: %v", reports)
+	}
+}
@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// datagram is a received packet waiting for ReadFrom.
+type datagram struct {
+	b    []byte
+	from net.Addr
+}
+
+// readQueue holds the datagrams a wrapped socket's own reader received,
+// for the ReadFrom of whoever the socket was handed to. It honours read
+// deadlines as the socket would.
+type readQueue struct {
+	queue     chan datagram
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu          sync.Mutex
+	deadline    time.Time
+	deadlineSet chan struct{} // closed and replaced when deadline changes
+}
+
+func newReadQueue(n int) *readQueue {
+	return &readQueue{
+		queue:       make(chan datagram, n),
+		closed:      make(chan struct{}),
+		deadlineSet: make(chan struct{}),
+	}
+}
+
+// push queues a copy of b, dropping it if the queue is full, as a full
+// socket buffer would.
+func (q *readQueue) push(b []byte, from net.Addr) {
+	select {
+	case q.queue <- datagram{b: append([]byte(nil), b...), from: from}:
+	default:
+	}
+}
+
+// close wakes a blocked ReadFrom, and makes it and later ones fail.
+func (q *readQueue) close() {
+	q.closeOnce.Do(func() { close(q.closed) })
+}
+
+// ReadFrom returns the next queued datagram.
+func (q *readQueue) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		q.mu.Lock()
+		deadline, changed := q.deadline, q.deadlineSet
+		q.mu.Unlock()
+
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return 0, nil, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(wait)
+			expired = timer.C
+		}
+		n, from, err := 0, net.Addr(nil), error(nil)
+		woken := false
+		select {
+		case d := <-q.queue:
+			n, from = copy(b, d.b), d.from
+		case <-q.closed:
+			err = net.ErrClosed
+		case <-expired:
+			err = os.ErrDeadlineExceeded
+		case <-changed:
+			woken = true
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if !woken {
+			return n, from, err
+		}
+	}
+}
+
+func (q *readQueue) SetReadDeadline(t time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.deadline = t
+	close(q.deadlineSet)
+	q.deadlineSet = make(chan struct{})
+	return nil
+}
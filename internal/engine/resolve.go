@@ -0,0 +1,219 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/emiago/diago"
+	"github.com/emiago/sipgo/sip"
+	"github.com/siptty/siptty/internal/config"
+	"github.com/siptty/siptty/internal/dns"
+)
+
+// inviteAttemptTimeout is how long an INVITE may go without any response
+// before the next resolved target is tried.
+const inviteAttemptTimeout = 8 * time.Second
+
+// resolveHop resolves a next hop per RFC 3263, keeping the addresses of the
+// account's ip_family, and reports the result in the trace. A transport
+// parameter on the hop overrides the account transport. It returns nil when
+// the hop does not resolve, leaving the lookup to sipgo.
+func resolveHop(ctx context.Context, r *dns.Resolver, hop sip.Uri, transport, family string, tracer *sipTracer) []dns.Target {
+	if r == nil {
+		return nil
+	}
+	if hop.UriParams != nil {
+		if tp, ok := hop.UriParams.Get("transport"); ok && tp != "" {
+			transport = strings.ToLower(tp)
+		}
+	}
+	targets, err := r.Resolve(ctx, hop.Host, hop.Port, transport)
+	if err == nil {
+		if targets = filterFamily(targets, family); len(targets) == 0 {
+			err = fmt.Errorf("no %s address", family)
+		}
+	}
+	if err != nil {
+		slog.Warn("DNS resolution failed", "host", hop.Host, "error", err)
+		traceDNS(tracer, transport, "", fmt.Sprintf("DNS %s: %v", hop.Host, err))
+		return nil
+	}
+	addrs := make([]string, len(targets))
+	for i, t := range targets {
+		addrs[i] = t.Addr
+	}
+	slog.Info("resolved next hop", "host", hop.Host, "targets", addrs, "source", targets[0].Source)
+	traceDNS(tracer, transport, targets[0].Addr,
+		fmt.Sprintf("DNS %s -> %s (%s)", hop.Host, strings.Join(addrs, ", "), targets[0].Source))
+	return targets
+}
+
+// invite sends the INVITE for a new call to the resolved targets of its first
+// hop in turn until one answers, failing over when a target sends nothing
+// within inviteAttemptTimeout, fails at the transport or returns 503. Every
+// attempt carries the engine's callID in EngineCallHeader and offers the
+// media of socks.
+func (e *Engine) invite(ctx context.Context, acct *Account, target sip.Uri, callID string, socks *dialogSockets) (*diago.DialogClientSession, error) {
+	routes := acct.dialogRoutes()
+	dialogHeaders := []sip.Header{sip.NewHeader(EngineCallHeader, callID)}
+	if contact := acct.natContact(); contact != nil {
+		dialogHeaders = append(dialogHeaders, contact)
+	}
+	opts := diago.InviteOptions{
+		Username: acct.Config.AuthUser,
+		Password: acct.Config.AuthPassword,
+		Headers:  append(routeHeaders(routes), dialogHeaders...),
+		Media:    dialogMedia(acct, socks),
+	}
+
+	secure := config.IsSecureTransport(acct.Config.Transport)
+	if len(routes) == 0 {
+		// Only a Route can point the INVITE elsewhere than its target,
+		// and none is added of our own: sipgo resolves the target.
+		if secure {
+			acct.tlsPeers.add(target.Host, target.Host, acct.ID)
+		}
+		return e.dg.Invite(ctx, target, opts)
+	}
+	hop, err := looseRoute(routes[0])
+	if err != nil {
+		return e.dg.Invite(ctx, target, opts)
+	}
+	if secure {
+		acct.tlsPeers.add(hop.Host, hop.Host, acct.ID)
+	}
+	targets := pinTargets(resolveHop(ctx, e.resolver, hop, acct.Config.Transport, acct.Config.IPFamily, e.tracer), secure)
+	if len(targets) == 0 {
+		return e.dg.Invite(ctx, target, opts)
+	}
+	for _, t := range targets {
+		if secure {
+			acct.tlsPeers.add(t.Host, hop.Host, acct.ID)
+		}
+	}
+
+	for i, t := range targets {
+		last := i == len(targets)-1
+		attemptCtx, cancel := context.WithCancel(ctx)
+		var timer *time.Timer
+		if !last {
+			timer = time.AfterFunc(inviteAttemptTimeout, cancel)
+		}
+		var status atomic.Int32
+		opts.Headers = append(pinnedRoutes(routes, t), dialogHeaders...)
+		opts.OnResponse = func(res *sip.Response) error {
+			if timer != nil {
+				timer.Stop()
+			}
+			if res.StatusCode >= 200 {
+				status.Store(int32(res.StatusCode))
+			}
+			return nil
+		}
+
+		dialog, err := e.dg.Invite(attemptCtx, target, opts)
+		if timer != nil {
+			timer.Stop()
+		}
+		cancel()
+		if err == nil || last || ctx.Err() != nil || !failover(err, int(status.Load())) {
+			return dialog, err
+		}
+		traceFailover(e.tracer, t, targets[i+1], err)
+	}
+	return nil, fmt.Errorf("no targets for %s", hop.Host)
+}
+
+// traceFailover reports in the trace that from failed and to is tried next.
+func traceFailover(tracer *sipTracer, from, to dns.Target, err error) {
+	slog.Warn("failing over to next target", "from", from.Addr, "to", to.Addr, "error", err)
+	traceDNS(tracer, to.Transport, to.Addr, fmt.Sprintf("DNS failover %s: %v; trying %s", from.Addr, err, to.Addr))
+}
+
+func traceDNS(tracer *sipTracer, transport, raddr, msg string) {
+	tracer.send(SipTraceEvent{
+		Direction:  "dns",
+		Message:    msg,
+		Timestamp:  time.Now(),
+		Transport:  strings.ToUpper(transport),
+		RemoteAddr: raddr,
+	})
+}
+
+// failover reports whether a request that failed with err should be retried
+// at the next target (RFC 3263 §4.3): on a 503, on a transport error or when
+// nothing answered. status is the final response code seen, or 0 for none.
+func failover(err error, status int) bool {
+	switch {
+	case status == 503:
+		return true
+	case status != 0:
+		return false
+	}
+	var opErr *net.OpError
+	return errors.Is(err, sip.ErrTransactionTimeout) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, context.Canceled) ||
+		errors.As(err, &opErr) ||
+		strings.Contains(err.Error(), "503 ")
+}
+
+// pinTargets picks the resolved targets requests are pinned to, or nil to
+// leave the hop to sipgo. Only NAPTR and SRV results are pinned: for a bare
+// A/AAAA lookup sipgo finds the same addresses itself and keeps the name.
+// Over TLS the pin is the SRV target's name, not its addresses, so that
+// sipgo sends it as SNI; consecutive addresses of one name collapse.
+func pinTargets(targets []dns.Target, secure bool) []dns.Target {
+	if len(targets) == 0 || (targets[0].Source != "NAPTR" && targets[0].Source != "SRV") {
+		return nil
+	}
+	if !secure {
+		return targets
+	}
+	var pinned []dns.Target
+	for _, t := range targets {
+		_, port, err := net.SplitHostPort(t.Addr)
+		if err != nil {
+			continue
+		}
+		t.Addr = net.JoinHostPort(strings.TrimSuffix(t.Host, "."), port)
+		if n := len(pinned); n > 0 && pinned[n-1].Addr == t.Addr {
+			continue
+		}
+		pinned = append(pinned, t)
+	}
+	return pinned
+}
+
+// pinnedRoutes returns the Route headers for a request sent to target. sipgo
+// sends to the top Route, so that route is pointed at the target; without a
+// route set there is nothing to point.
+func pinnedRoutes(routes []string, target dns.Target) []sip.Header {
+	host, portStr, err := net.SplitHostPort(target.Addr)
+	if err != nil || len(routes) == 0 {
+		return routeHeaders(routes)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	top, err := looseRoute(routes[0])
+	if err != nil {
+		return routeHeaders(routes)
+	}
+	rest := routes[1:]
+	if !top.UriParams.Has("lr") {
+		top.UriParams.Add("lr", "")
+	}
+	if !top.UriParams.Has("transport") && target.Transport != "udp" {
+		top.UriParams.Add("transport", target.Transport)
+	}
+	top.Host = uriHost(host)
+	top.Port = port
+	return append([]sip.Header{sip.NewHeader("Route", "<"+top.String()+">")}, routeHeaders(rest)...)
+}
@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/siptty/siptty/internal/dns"
+)
+
+func TestPinnedRoutes(t *testing.T) {
+	target := dns.Target{Addr: "192.0.2.10:5080", Transport: "tcp"}
+
+	if got := pinnedRoutes(nil, target); len(got) != 0 {
+		t.Errorf("pinned route without route set = %v, want none", got)
+	}
+
+	got := pinnedRoutes([]string{"sip:sbc.example.com;transport=tcp", "sip:edge.example.com;lr"}, target)
+	if len(got) != 2 {
+		t.Fatalf("got %d routes, want 2", len(got))
+	}
+	if v := got[0].Value(); v != "<sip:192.0.2.10:5080;transport=tcp;lr>" {
+		t.Errorf("top route = %q, want the proxy pinned to the target", v)
+	}
+	if v := got[1].Value(); v != "<sip:edge.example.com;lr>" {
+		t.Errorf("second route = %q, want it unchanged", v)
+	}
+
+	got = pinnedRoutes([]string{"sip:sbc.example.com;lr"}, dns.Target{Addr: "[2001:db8::1]:5060", Transport: "udp"})
+	if len(got) != 1 || got[0].Value() != "<sip:[2001:db8::1]:5060;lr>" {
+		t.Errorf("IPv6 pinned route = %v, want bracketed host", got)
+	}
+}
+
+func TestPinTargets(t *testing.T) {
+	srv := []dns.Target{
+		{Addr: "192.0.2.10:5061", Transport: "tls", Host: "sip1.example.com.", Source: "SRV"},
+		{Addr: "192.0.2.11:5061", Transport: "tls", Host: "sip1.example.com.", Source: "SRV"},
+		{Addr: "192.0.2.20:5061", Transport: "tls", Host: "sip2.example.com.", Source: "SRV"},
+	}
+	if got := pinTargets(srv, false); len(got) != 3 || got[0].Addr != "192.0.2.10:5061" {
+		t.Errorf("pinTargets(SRV) = %v, want the addresses", got)
+	}
+	got := pinTargets(srv, true)
+	if len(got) != 2 || got[0].Addr != "sip1.example.com:5061" || got[1].Addr != "sip2.example.com:5061" {
+		t.Errorf("pinTargets(SRV, tls) = %v, want one name per target", got)
+	}
+
+	a := []dns.Target{{Addr: "192.0.2.10:5060", Transport: "udp", Host: "pbx.example.com", Source: "A"}}
+	if got := pinTargets(a, false); got != nil {
+		t.Errorf("pinTargets(A) = %v, want none: sipgo resolves the name", got)
+	}
+	ip := []dns.Target{{Addr: "192.0.2.10:5060", Transport: "udp", Host: "192.0.2.10", Source: "IP"}}
+	if got := pinTargets(ip, false); got != nil {
+		t.Errorf("pinTargets(IP) = %v, want none", got)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		want   bool
+	}{
+		{errors.New("invite failed"), 503, true},
+		{errors.New("invite failed"), 486, false},
+		{fmt.Errorf("register: %w", sip.ErrTransactionTimeout), 0, true},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, 0, true},
+		{context.Canceled, 0, true},
+		{errors.New("register failed: 503 Service Unavailable"), 0, true},
+		{errors.New("register failed: 403 Forbidden"), 0, false},
+	}
+	for _, tt := range tests {
+		if got := failover(tt.err, tt.status); got != tt.want {
+			t.Errorf("failover(%v, %d) = %v, want %v", tt.err, tt.status, got, tt.want)
+		}
+	}
+}
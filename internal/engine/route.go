@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/siptty/siptty/internal/config"
+)
+
+// looseRoute parses a configured route URI (bare or in angle brackets) and
+// makes sure it carries ;lr, since siptty does not do strict routing.
+func looseRoute(raw string) (sip.Uri, error) {
+	var uri sip.Uri
+	if err := sip.ParseUri(strings.Trim(strings.TrimSpace(raw), "<>"), &uri); err != nil {
+		return sip.Uri{}, fmt.Errorf("invalid route %q: %w", raw, err)
+	}
+	if uri.UriParams == nil {
+		uri.UriParams = sip.NewParams()
+	}
+	if !uri.UriParams.Has("lr") {
+		uri.UriParams.Add("lr", "")
+	}
+	return uri, nil
+}
+
+// outboundProxy returns the configured outbound proxy or, for a ws/wss
+// account without one, the WebSocket edge from ws_url.
+func (a *Account) outboundProxy() string {
+	if a.Config.OutboundProxy == "" && config.IsWebSocket(a.Config.Transport) {
+		return wsEdgeRoute(a.Config.WSURL)
+	}
+	return a.Config.OutboundProxy
+}
+
+// registerRoutes returns the route set for REGISTER and other out-of-dialog
+// requests to the registrar: the outbound proxy, then the preloaded routes
+// (RFC 3261 §8.1.2). Service-Route does not apply to REGISTER (RFC 3608).
+func (a *Account) registerRoutes() []string {
+	var routes []string
+	if proxy := a.outboundProxy(); proxy != "" {
+		routes = append(routes, proxy)
+	}
+	return append(routes, a.Config.Routes...)
+}
+
+// dialogRoutes returns the route set for new dialogs: the outbound proxy,
+// then the Service-Route learned at registration, or else the preloaded routes.
+func (a *Account) dialogRoutes() []string {
+	var routes []string
+	if proxy := a.outboundProxy(); proxy != "" {
+		routes = append(routes, proxy)
+	}
+	a.mu.Lock()
+	serviceRoute := a.serviceRoute
+	a.mu.Unlock()
+	if len(serviceRoute) > 0 {
+		return append(routes, serviceRoute...)
+	}
+	return append(routes, a.Config.Routes...)
+}
+
+// routeHeaders turns a route set into Route headers, in order. Entries that
+// do not parse are skipped; the config has already checked their scheme.
+func routeHeaders(routes []string) []sip.Header {
+	var hdrs []sip.Header
+	for _, r := range routes {
+		uri, err := looseRoute(r)
+		if err != nil {
+			continue
+		}
+		hdrs = append(hdrs, sip.NewHeader("Route", "<"+uri.String()+">"))
+	}
+	return hdrs
+}
+
+// nextHop returns the first hop for requests to the registrar: the top of
+// the REGISTER route set, or the registrar itself.
+func (a *Account) nextHop() (sip.Uri, error) {
+	if routes := a.registerRoutes(); len(routes) > 0 {
+		return looseRoute(routes[0])
+	}
+	return a.registrarURI()
+}
+
+// learnRoutes stores the Service-Route and Path a registrar returned in a
+// 2xx to REGISTER. A 2xx without Service-Route clears a previous one.
+func (a *Account) learnRoutes(res *sip.Response) {
+	if !res.IsSuccess() {
+		return
+	}
+	serviceRoute := headerList(res, "Service-Route")
+	path := headerList(res, "Path")
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.serviceRoute = serviceRoute
+	a.path = path
+}
+
+// registrationRoutes returns the learned Service-Route and Path.
+func (a *Account) registrationRoutes() (serviceRoute, path []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.serviceRoute), slices.Clone(a.path)
+}
+
+// headerList returns the entries of every header called name, splitting
+// comma-separated values outside angle brackets.
+func headerList(msg sip.Message, name string) []string {
+	var out []string
+	for _, h := range msg.GetHeaders(name) {
+		out = append(out, splitAddressList(h.Value())...)
+	}
+	return out
+}
+
+func splitAddressList(v string) []string {
+	var out []string
+	depth, start := 0, 0
+	for i, c := range v {
+		switch c {
+		case '<':
+			depth++
+		case '>':
+			depth--
+		case ',':
+			if depth == 0 {
+				if s := strings.TrimSpace(v[start:i]); s != "" {
+					out = append(out, s)
+				}
+				start = i + 1
+			}
+		}
+	}
+	if s := strings.TrimSpace(v[start:]); s != "" {
+		out = append(out, s)
+	}
+	return out
+}
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/siptty/siptty/internal/config"
+)
+
+func TestLooseRoute(t *testing.T) {
+	tests := map[string]string{
+		"sip:sbc.example.com":              "sip:sbc.example.com;lr",
+		"<sip:sbc.example.com:5070;lr>":    "sip:sbc.example.com:5070;lr",
+		"sip:p1.example.com;transport=tcp": "sip:p1.example.com;transport=tcp;lr",
+		" sips:edge.example.com;lr ":       "sips:edge.example.com;lr",
+	}
+	for in, want := range tests {
+		uri, err := looseRoute(in)
+		if err != nil {
+			t.Errorf("looseRoute(%q): %v", in, err)
+			continue
+		}
+		if got := uri.String(); got != want {
+			t.Errorf("looseRoute(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRouteSets(t *testing.T) {
+	a := &Account{Config: config.AccountConfig{
+		OutboundProxy: "sip:sbc.example.com",
+		Routes:        []string{"sip:edge.example.com;lr"},
+	}}
+	want := []string{"sip:sbc.example.com", "sip:edge.example.com;lr"}
+	if got := a.registerRoutes(); !slices.Equal(got, want) {
+		t.Errorf("registerRoutes = %q, want %q", got, want)
+	}
+	if got := a.dialogRoutes(); !slices.Equal(got, want) {
+		t.Errorf("dialogRoutes before registration = %q, want %q", got, want)
+	}
+
+	res := parseResponse(t, "SIP/2.0 200 OK\r\n"+
+		"Via: SIP/2.0/UDP 10.0.0.5:5060;branch=z9hG4bK1\r\n"+
+		"From: <sip:alice@ims.example.com>;tag=a\r\n"+
+		"To: <sip:alice@ims.example.com>;tag=b\r\n"+
+		"Call-ID: reg-1\r\n"+
+		"CSeq: 2 REGISTER\r\n"+
+		"Service-Route: <sip:orig@scscf.ims.example.com;lr>, <sip:as.ims.example.com;lr>\r\n"+
+		"Path: <sip:term@pcscf.ims.example.com;lr>\r\n"+
+		"Content-Length: 0\r\n\r\n")
+	a.learnRoutes(res)
+
+	want = []string{"sip:sbc.example.com", "<sip:orig@scscf.ims.example.com;lr>", "<sip:as.ims.example.com;lr>"}
+	if got := a.dialogRoutes(); !slices.Equal(got, want) {
+		t.Errorf("dialogRoutes after registration = %q, want %q", got, want)
+	}
+	if got := a.registerRoutes(); len(got) != 2 {
+		t.Errorf("registerRoutes = %q; Service-Route must not apply to REGISTER", got)
+	}
+	if _, path := a.registrationRoutes(); !slices.Equal(path, []string{"<sip:term@pcscf.ims.example.com;lr>"}) {
+		t.Errorf("Path = %q", path)
+	}
+
+	hdrs := routeHeaders(a.dialogRoutes())
+	if len(hdrs) != 3 || hdrs[0].Value() != "<sip:sbc.example.com;lr>" {
+		t.Errorf("routeHeaders = %v", hdrs)
+	}
+}
+
+func TestNextHop(t *testing.T) {
+	a := &Account{Config: config.AccountConfig{Registrar: "sip:reg.example.com", Transport: "udp"}}
+	hop, err := a.nextHop()
+	if err != nil || hop.Host != "reg.example.com" {
+		t.Errorf("nextHop without proxy = %v, %v; want the registrar", hop.Host, err)
+	}
+	a.Config.OutboundProxy = "sip:sbc.example.com:5070"
+	hop, err = a.nextHop()
+	if err != nil || hop.Host != "sbc.example.com" || hop.Port != 5070 {
+		t.Errorf("nextHop with proxy = %v:%d, %v; want sbc.example.com:5070", hop.Host, hop.Port, err)
+	}
+}
+
+func parseResponse(t *testing.T, raw string) *sip.Response {
+	t.Helper()
+	msg, err := sip.ParseMessage([]byte(raw))
+	if err != nil {
+		t.Fatalf("ParseMessage: %v", err)
+	}
+	return msg.(*sip.Response)
+}
@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxRTPPackets bounds the packets kept per call, about five minutes of
+// 20 ms audio; the oldest are dropped first.
+const maxRTPPackets = 15000
+
+// RTPPacket is a media packet captured on a call for pcap export.
+type RTPPacket struct {
+	Timestamp  time.Time
+	CallID     string // SIP Call-ID of the call
+	LocalAddr  string
+	RemoteAddr string
+	Data       []byte // RTP header and payload as received (still encrypted with SRTP)
+}
+
+// rtpCapture holds the captured packets of one call.
+type rtpCapture struct {
+	mu      sync.Mutex
+	packets []RTPPacket
+}
+
+func (c *rtpCapture) add(p RTPPacket) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.packets) == maxRTPPackets {
+		c.packets = slices.Delete(c.packets, 0, maxRTPPackets/10)
+	}
+	c.packets = append(c.packets, p)
+}
+
+// RTPPackets returns the captured RTP of the call with the given SIP
+// Call-ID, or of every call for "", oldest first per call.
+func (e *Engine) RTPPackets(sipCallID string) []RTPPacket {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	var out []RTPPacket
+	for id, c := range e.rtp {
+		if sipCallID != "" && id != sipCallID {
+			continue
+		}
+		c.mu.Lock()
+		out = append(out, c.packets...)
+		c.mu.Unlock()
+	}
+	return out
+}
@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/siptty/siptty/internal/impair"
+)
+
+// sipQueueLen is how many received SIP datagrams wait for sipgo's reader.
+const sipQueueLen = 256
+
+// impairedConn is the UDP socket of the SIP listener, bound by siptty and
+// handed to diago. Its reader passes each datagram through the network
+// impairment impair picks for it: the datagram is dropped, queued for
+// sipgo at once, or queued again when its delay is up. sipgo traces what
+// it reads, so a dropped message is never traced and a held one is traced
+// when it arrives. Only UDP is impaired: dropping or holding part of a
+// stream would corrupt it.
+type impairedConn struct {
+	*readQueue
+	conn   *net.UDPConn
+	impair func(msg []byte) *impair.Model // nil leaves the message alone
+}
+
+func newImpairedConn(conn *net.UDPConn, model func(msg []byte) *impair.Model) *impairedConn {
+	return &impairedConn{readQueue: newReadQueue(sipQueueLen), conn: conn, impair: model}
+}
+
+// run reads the socket until it is closed.
+func (c *impairedConn) run() {
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				c.close()
+				return
+			}
+			continue
+		}
+		msg := buf[:n]
+		m := c.impair(msg)
+		if m == nil || !m.Profile().Active() {
+			c.push(msg, from)
+			continue
+		}
+		delays := m.Next()
+		if len(delays) == 0 {
+			slog.Debug("SIP message dropped by impairment", "from", from)
+			continue
+		}
+		for _, d := range delays {
+			if d <= 0 {
+				c.push(msg, from)
+				continue
+			}
+			held := slices.Clone(msg)
+			time.AfterFunc(d, func() { c.push(held, from) })
+		}
+	}
+}
+
+func (c *impairedConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.conn.WriteTo(b, addr)
+}
+
+// Close closes the socket and wakes a blocked ReadFrom.
+func (c *impairedConn) Close() error {
+	c.close()
+	return c.conn.Close()
+}
+
+func (c *impairedConn) LocalAddr() net.Addr { return c.conn.LocalAddr() }
+
+func (c *impairedConn) SetDeadline(t time.Time) error {
+	_ = c.SetReadDeadline(t)
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *impairedConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
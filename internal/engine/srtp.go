@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/siptty/siptty/internal/config"
+	"github.com/siptty/siptty/internal/sdp"
+)
+
+// srtpSuites are the SDES crypto suites diago's SRTP stack accepts, in preference order.
+var srtpSuites = []string{
+	"AES_CM_128_HMAC_SHA1_80",
+	"AES_CM_128_HMAC_SHA1_32",
+}
+
+// dtlsSRTP is the Encryption label for calls keyed via DTLS-SRTP.
+const dtlsSRTP = "DTLS-SRTP"
+
+// The values of diago's MediaConfig.SecureRTP.
+const (
+	srtpModeNone = 0
+	srtpModeSDES = 1
+	srtpModeDTLS = 2
+)
+
+// diagoSRTPMode maps an account's media_encryption onto the SecureRTP of
+// the media setup of its dialogs (see dialogMedia).
+func diagoSRTPMode(mediaEncryption string) int {
+	switch mediaEncryption {
+	case "sdes":
+		return srtpModeSDES
+	case "dtls":
+		return srtpModeDTLS
+	}
+	return srtpModeNone
+}
+
+// negotiateEncryption inspects the peer's SDP (offer or answer) and returns the
+// media encryption in effect for the call: an SDES suite name, "DTLS-SRTP", or
+// "" for plain RTP. It fails when the account's media_encryption_mode is
+// "mandatory" and the peer offers nothing usable, so the caller can reject
+// (488) or hang up the call.
+func negotiateEncryption(acct config.AccountConfig, body []byte) (string, error) {
+	if acct.MediaEncryption == "none" || acct.MediaEncryption == "" {
+		return "", nil
+	}
+	mandatory := acct.MediaEncryptionMode == "mandatory"
+
+	var audio *sdp.Media
+	var sess *sdp.Session
+	if len(body) > 0 {
+		if s, err := sdp.Parse(body); err == nil {
+			sess = s
+			audio = s.Audio()
+		}
+	}
+	if audio == nil {
+		if mandatory {
+			return "", fmt.Errorf("no audio SDP to negotiate %s encryption", acct.MediaEncryption)
+		}
+		return "", nil
+	}
+
+	switch acct.MediaEncryption {
+	case "sdes":
+		for _, c := range audio.Crypto() {
+			if slices.Contains(srtpSuites, c.Suite) {
+				return c.Suite, nil
+			}
+		}
+		if mandatory {
+			return "", fmt.Errorf("peer offered no supported SDES crypto suite")
+		}
+	case "dtls":
+		if audio.IsDTLS() && sess.Fingerprint(audio) != "" {
+			return dtlsSRTP, nil
+		}
+		if mandatory {
+			return "", fmt.Errorf("peer does not support DTLS-SRTP")
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/siptty/siptty/internal/config"
+)
+
+const plainSDP = "v=0\r\no=- 1 1 IN IP4 10.0.0.5\r\ns=-\r\nc=IN IP4 10.0.0.5\r\nt=0 0\r\n" +
+	"m=audio 4000 RTP/AVP 0\r\n"
+
+const sdesSDP = "v=0\r\no=- 1 1 IN IP4 10.0.0.5\r\ns=-\r\nc=IN IP4 10.0.0.5\r\nt=0 0\r\n" +
+	"m=audio 4000 RTP/SAVP 0\r\n" +
+	"a=crypto:1 AES_CM_128_HMAC_SHA1_32 inline:WVNfX19zZW1jdGwgKCkgewkyMjA7fQp9CnVubGVz\r\n"
+
+const dtlsSDP = "v=0\r\no=- 1 1 IN IP4 10.0.0.5\r\ns=-\r\nc=IN IP4 10.0.0.5\r\nt=0 0\r\n" +
+	"m=audio 4000 UDP/TLS/RTP/SAVP 0\r\n" +
+	"a=fingerprint:sha-256 4A:AD:B9:B1\r\na=setup:actpass\r\n"
+
+func TestNegotiateEncryption(t *testing.T) {
+	tests := []struct {
+		name    string
+		enc     string
+		mode    string
+		sdp     string
+		want    string
+		wantErr bool
+	}{
+		{name: "none ignores crypto", enc: "none", mode: "optional", sdp: sdesSDP, want: ""},
+		{name: "sdes accepted", enc: "sdes", mode: "mandatory", sdp: sdesSDP, want: "AES_CM_128_HMAC_SHA1_32"},
+		{name: "sdes optional fallback", enc: "sdes", mode: "optional", sdp: plainSDP, want: ""},
+		{name: "sdes mandatory rejects", enc: "sdes", mode: "mandatory", sdp: plainSDP, wantErr: true},
+		{name: "dtls accepted", enc: "dtls", mode: "mandatory", sdp: dtlsSDP, want: dtlsSRTP},
+		{name: "dtls mandatory rejects sdes", enc: "dtls", mode: "mandatory", sdp: sdesSDP, wantErr: true},
+		{name: "mandatory without sdp", enc: "sdes", mode: "mandatory", sdp: "", wantErr: true},
+		{name: "optional without sdp", enc: "dtls", mode: "optional", sdp: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acct := config.AccountConfig{MediaEncryption: tt.enc, MediaEncryptionMode: tt.mode}
+			got, err := negotiateEncryption(acct, []byte(tt.sdp))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("negotiateEncryption: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("negotiateEncryption = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDialogMediaSRTP(t *testing.T) {
+	sdes := &Account{Config: config.AccountConfig{MediaEncryption: "sdes"}}
+	dtls := &Account{Config: config.AccountConfig{MediaEncryption: "dtls"}}
+	plain := &Account{Config: config.AccountConfig{MediaEncryption: "none"}}
+	socks := &dialogSockets{}
+	tests := []struct {
+		name string
+		acct *Account
+		want int
+	}{
+		{"sdes", sdes, srtpModeSDES},
+		{"dtls", dtls, srtpModeDTLS},
+		{"none", plain, srtpModeNone},
+		{"no account", nil, srtpModeNone},
+	}
+	for _, tt := range tests {
+		if got := dialogMedia(tt.acct, socks).SecureRTP; got != tt.want {
+			t.Errorf("%s: SecureRTP = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
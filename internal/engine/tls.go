@@ -0,0 +1,219 @@
+package engine
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/siptty/siptty/internal/config"
+)
+
+// accountTLS is an account's [accounts.tls] table, loaded.
+type accountTLS struct {
+	roots      *x509.CertPool   // nil: system roots
+	cert       *tls.Certificate // client certificate for mutual TLS
+	serverName string           // name the server certificate must carry, if set
+	insecure   bool
+	minVersion uint16
+}
+
+// defaultTLS checks connections no account claims: system roots, the
+// connection's own server name, TLS 1.2 or later.
+var defaultTLS = &accountTLS{minVersion: tls.VersionTLS12}
+
+// loadAccountTLS reads the files an account's TLS settings point at.
+func loadAccountTLS(c config.TLSConfig) (*accountTLS, error) {
+	minVersion, err := tlsVersion(c.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	p := &accountTLS{
+		serverName: c.ServerName,
+		insecure:   c.InsecureSkipVerify,
+		minVersion: minVersion,
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca_file %s: no PEM certificates found", c.CAFile)
+		}
+		p.roots = pool
+	}
+
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		p.cert = &cert
+	}
+	return p, nil
+}
+
+// verify checks a completed handshake against the settings: the server
+// certificate must chain to the roots and be valid for one of hosts, the
+// names the connection may have been made for, or for server_name if set.
+func (p *accountTLS) verify(cs tls.ConnectionState, hosts []string) error {
+	if cs.Version < p.minVersion {
+		return fmt.Errorf("tls: server negotiated %s, below min_version", tls.VersionName(cs.Version))
+	}
+	if p.insecure {
+		return nil
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("tls: server sent no certificate")
+	}
+	leaf := cs.PeerCertificates[0]
+	opts := x509.VerifyOptions{Roots: p.roots, Intermediates: x509.NewCertPool()}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := leaf.Verify(opts); err != nil {
+		return err
+	}
+	if p.serverName != "" {
+		hosts = []string{p.serverName}
+	}
+	for _, host := range hosts {
+		if leaf.VerifyHostname(bareHost(host)) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("tls: certificate is not valid for %s", strings.Join(hosts, ", "))
+}
+
+// newTLSConfig builds the client config sipgo dials every tls and wss
+// connection with; sipgo keeps one per UA. crypto/tls's own verification is
+// turned off in favor of verify, which applies the settings of the account
+// the connection is made for. The lowest min_version is allowed in the
+// handshake and verify enforces each account's. crypto/tls picks the first
+// client certificate, in account order, that the server's certificate
+// request accepts.
+func newTLSConfig(accounts []*accountTLS, verify func(tls.ConnectionState) error) *tls.Config {
+	tlsConf := &tls.Config{
+		InsecureSkipVerify: true, // replaced by VerifyConnection
+		MinVersion:         tls.VersionTLS12,
+		VerifyConnection:   verify,
+	}
+	for _, p := range accounts {
+		tlsConf.MinVersion = min(tlsConf.MinVersion, p.minVersion)
+		if p.cert != nil {
+			tlsConf.Certificates = append(tlsConf.Certificates, *p.cert)
+		}
+	}
+	return tlsConf
+}
+
+// tlsPeer is what a TLS connection is checked against: the accounts that
+// send to its server name, the first of which has its settings applied,
+// and the hosts its certificate may be issued for.
+type tlsPeer struct {
+	accounts []string
+	hosts    []string
+}
+
+// tlsPeers maps the server name a TLS handshake sees to its tlsPeer. The
+// name is the host of the hop, or "" for a hop that is an IP address —
+// the registrar's, or one a NAPTR/SRV lookup pinned — since crypto/tls
+// leaves an IP out of SNI and so out of the handshake state.
+type tlsPeers struct {
+	mu    sync.Mutex
+	names map[string]tlsPeer
+}
+
+func newTLSPeers() *tlsPeers {
+	return &tlsPeers{names: make(map[string]tlsPeer)}
+}
+
+// add records that accountID makes TLS connections to name, whose
+// certificate must be valid for host.
+func (p *tlsPeers) add(name, host, accountID string) {
+	if p == nil || name == "" {
+		return
+	}
+	key := strings.TrimSuffix(strings.ToLower(bareHost(name)), ".")
+	if addrFamily(key) != "" {
+		key = ""
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peer := p.names[key]
+	if !slices.Contains(peer.accounts, accountID) {
+		peer.accounts = append(peer.accounts, accountID)
+	}
+	if !slices.Contains(peer.hosts, host) {
+		peer.hosts = append(peer.hosts, host)
+	}
+	p.names[key] = peer
+}
+
+func (p *tlsPeers) lookup(serverName string) (tlsPeer, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peer, ok := p.names[strings.ToLower(serverName)]
+	return peer, ok
+}
+
+func tlsVersion(v string) (uint16, error) {
+	switch v {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q", v)
+}
+
+// newTLSStateEvent summarizes a handshake for display in the account details.
+func newTLSStateEvent(accountID string, cs tls.ConnectionState) TLSStateEvent {
+	ev := TLSStateEvent{
+		AccountID:   accountID,
+		ServerName:  cs.ServerName,
+		Version:     tls.VersionName(cs.Version),
+		CipherSuite: tls.CipherSuiteName(cs.CipherSuite),
+	}
+	if len(cs.PeerCertificates) > 0 {
+		ev.PeerSubject = cs.PeerCertificates[0].Subject.String()
+	}
+	return ev
+}
+
+// applyTransport forces uri onto the account's transport. sips: URIs are only
+// allowed over TLS (tls or wss), and URIs on a tls, ws or wss account get a
+// matching ;transport so sipgo does not fall back to its UDP default when it
+// picks the connection.
+func applyTransport(uri *sip.Uri, transport string) error {
+	if uri.IsEncrypted() && !config.IsSecureTransport(transport) {
+		return fmt.Errorf("sips: URI %s requires tls or wss transport (account uses %s)", uri.Addr(), transport)
+	}
+	if transport != "tls" && !config.IsWebSocket(transport) {
+		return nil
+	}
+	if uri.UriParams == nil {
+		uri.UriParams = sip.NewParams()
+	}
+	tp, ok := uri.UriParams.Get("transport")
+	if !ok {
+		uri.UriParams.Add("transport", transport)
+		return nil
+	}
+	if !strings.EqualFold(tp, transport) {
+		return fmt.Errorf("URI %s requests transport=%s on a %s account", uri.Addr(), tp, transport)
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package engine
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/siptty/siptty/internal/config"
+)
+
+// writeTestCert writes a self-signed certificate and key to dir and returns their paths.
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pbx.example.com"},
+		DNSNames:              []string{"pbx.example.com"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshaling key: %v", err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestLoadAccountTLS(t *testing.T) {
+	certFile, keyFile := writeTestCert(t, t.TempDir())
+
+	p, err := loadAccountTLS(config.TLSConfig{
+		CAFile:     certFile,
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		ServerName: "sbc.example.com",
+		MinVersion: "1.3",
+	})
+	if err != nil {
+		t.Fatalf("loadAccountTLS: %v", err)
+	}
+	if p.minVersion != tls.VersionTLS13 {
+		t.Errorf("minVersion = %x, want TLS 1.3", p.minVersion)
+	}
+	if p.serverName != "sbc.example.com" {
+		t.Errorf("serverName = %q, want sbc.example.com", p.serverName)
+	}
+	if p.roots == nil {
+		t.Error("roots not set from ca_file")
+	}
+	if p.cert == nil {
+		t.Error("client certificate not loaded")
+	}
+
+	tlsConf := newTLSConfig([]*accountTLS{p, {minVersion: tls.VersionTLS11}}, nil)
+	if tlsConf.MinVersion != tls.VersionTLS11 {
+		t.Errorf("MinVersion = %x, want the lowest of the accounts'", tlsConf.MinVersion)
+	}
+	if len(tlsConf.Certificates) != 1 {
+		t.Errorf("len(Certificates) = %d, want 1", len(tlsConf.Certificates))
+	}
+}
+
+func TestLoadAccountTLSErrors(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []config.TLSConfig{
+		{CAFile: filepath.Join(dir, "missing.pem")},
+		{CAFile: notPEM},
+		{CertFile: notPEM, KeyFile: notPEM},
+		{MinVersion: "2.0"},
+	}
+	for _, c := range cases {
+		if _, err := loadAccountTLS(c); err == nil {
+			t.Errorf("loadAccountTLS(%+v) expected error", c)
+		}
+	}
+}
+
+// handshake runs a TLS handshake between a client with clientConf, dialing
+// serverName, and a server presenting the certificate in certFile.
+func handshake(t *testing.T, clientConf *tls.Config, serverName, certFile, keyFile string) error {
+	t.Helper()
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	server := tls.Server(s, &tls.Config{Certificates: []tls.Certificate{cert}, MaxVersion: tls.VersionTLS12})
+	go func() {
+		_ = server.Handshake()
+		s.Close()
+	}()
+
+	conf := clientConf.Clone()
+	conf.ServerName = serverName
+	return tls.Client(c, conf).Handshake()
+}
+
+func TestVerifyTLSPerAccount(t *testing.T) {
+	certFile, keyFile := writeTestCert(t, t.TempDir())
+	withCA, err := loadAccountTLS(config.TLSConfig{CAFile: certFile, MinVersion: "1.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &Engine{
+		events: make(chan Event, 8),
+		tracer: &sipTracer{},
+		tlsAccounts: map[string]*accountTLS{
+			"alice": withCA,
+			"bob":   {insecure: true, minVersion: tls.VersionTLS12},
+			"carol": {roots: withCA.roots, minVersion: tls.VersionTLS13},
+		},
+		tlsPeers: newTLSPeers(),
+	}
+	e.tlsPeers.add("pbx.example.com", "pbx.example.com", "alice")
+	e.tlsPeers.add("sip1.example.com.", "pbx.example.com", "alice") // its SRV target
+	e.tlsPeers.add("192.0.2.10", "pbx.example.com", "alice")        // registrar given as an IP
+	e.tlsPeers.add("lab.example.com", "lab.example.com", "bob")
+	e.tlsPeers.add("new.example.com", "new.example.com", "carol")
+	tlsConf := newTLSConfig(nil, e.verifyTLS)
+
+	tests := []struct {
+		serverName string
+		ok         bool
+	}{
+		{"pbx.example.com", true},  // alice's ca_file
+		{"sip1.example.com", true}, // checked against the domain it serves
+		{"192.0.2.10", true},       // checked against the account's host
+		{"lab.example.com", true},  // bob skips verification
+		{"new.example.com", false}, // carol requires TLS 1.3
+		{"other.example.com", false},
+	}
+	for _, tt := range tests {
+		err := handshake(t, tlsConf, tt.serverName, certFile, keyFile)
+		if (err == nil) != tt.ok {
+			t.Errorf("handshake with %s: err = %v, want ok %v", tt.serverName, err, tt.ok)
+		}
+	}
+
+	ev := (<-e.events).(TLSStateEvent)
+	if ev.AccountID != "alice" || ev.PeerSubject != "CN=pbx.example.com" {
+		t.Errorf("first TLS state = %+v, want alice's", ev)
+	}
+}
+
+func TestApplyTransport(t *testing.T) {
+	tests := []struct {
+		uri       string
+		transport string
+		want      string
+		wantErr   bool
+	}{
+		{uri: "sip:100@pbx.io", transport: "udp", want: "sip:100@pbx.io"},
+		{uri: "sip:100@pbx.io", transport: "tls", want: "sip:100@pbx.io;transport=tls"},
+		{uri: "sips:100@pbx.io", transport: "tls", want: "sips:100@pbx.io;transport=tls"},
+		{uri: "sip:100@pbx.io;transport=TLS", transport: "tls", want: "sip:100@pbx.io;transport=TLS"},
+		{uri: "sips:100@pbx.io", transport: "udp", wantErr: true},
+		{uri: "sip:100@pbx.io;transport=udp", transport: "tls", wantErr: true},
+		{uri: "sip:100@pbx.io", transport: "ws", want: "sip:100@pbx.io;transport=ws"},
+		{uri: "sips:100@pbx.io", transport: "wss", want: "sips:100@pbx.io;transport=wss"},
+		{uri: "sips:100@pbx.io", transport: "ws", wantErr: true},
+		{uri: "sip:100@pbx.io;transport=tls", transport: "wss", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		var uri sip.Uri
+		if err := sip.ParseUri(tt.uri, &uri); err != nil {
+			t.Fatalf("ParseUri(%q): %v", tt.uri, err)
+		}
+		err := applyTransport(&uri, tt.transport)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("applyTransport(%q, %s) expected error", tt.uri, tt.transport)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("applyTransport(%q, %s): %v", tt.uri, tt.transport, err)
+			continue
+		}
+		if got := uri.String(); got != tt.want {
+			t.Errorf("applyTransport(%q, %s) = %q, want %q", tt.uri, tt.transport, got, tt.want)
+		}
+	}
+}
+
+func TestNewTLSStateEvent(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "pbx.example.com"}}
+	ev := newTLSStateEvent("alice", tls.ConnectionState{
+		Version:          tls.VersionTLS13,
+		CipherSuite:      tls.TLS_AES_128_GCM_SHA256,
+		ServerName:       "pbx.example.com",
+		PeerCertificates: []*x509.Certificate{cert},
+	})
+	if ev.Version != "TLS 1.3" {
+		t.Errorf("Version = %q, want TLS 1.3", ev.Version)
+	}
+	if ev.CipherSuite != "TLS_AES_128_GCM_SHA256" {
+		t.Errorf("CipherSuite = %q, want TLS_AES_128_GCM_SHA256", ev.CipherSuite)
+	}
+	if ev.PeerSubject != "CN=pbx.example.com" {
+		t.Errorf("PeerSubject = %q, want CN=pbx.example.com", ev.PeerSubject)
+	}
+}
@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// WriteTraceText writes events in the trace log's sngrep-style text format
+// and returns the number written.
+func WriteTraceText(w io.Writer, events []SipTraceEvent) (int, error) {
+	for i, ev := range events {
+		if _, err := w.Write(formatTraceText(ev)); err != nil {
+			return i, err
+		}
+	}
+	return len(events), nil
+}
+
+// WriteTraceJSON writes events as JSON Lines, one object per message as in
+// the trace log, and returns the number written.
+func WriteTraceJSON(w io.Writer, events []SipTraceEvent) (int, error) {
+	for i, ev := range events {
+		if _, err := w.Write(formatTraceJSON(ev)); err != nil {
+			return i, err
+		}
+	}
+	return len(events), nil
+}
+
+// ExportTraceFile writes events to path in the format its extension names:
+// pcapng with the captured RTP for ".pcap" and ".pcapng", JSON Lines for
+// ".json" and ".jsonl", and text otherwise. It returns the number of packets
+// or messages written.
+func ExportTraceFile(path string, events []SipTraceEvent, rtp []RTPPacket) (int, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".pcap", ".pcapng":
+		return WritePcapFile(path, events, rtp)
+	case ".json", ".jsonl":
+		return writeFile(path, func(w io.Writer) (int, error) {
+			return WriteTraceJSON(w, events)
+		})
+	default:
+		return writeFile(path, func(w io.Writer) (int, error) {
+			return WriteTraceText(w, events)
+		})
+	}
+}
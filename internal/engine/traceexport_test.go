@@ -0,0 +1,39 @@
+package engine
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportTraceFile(t *testing.T) {
+	dir := t.TempDir()
+	events := []SipTraceEvent{traceInvite, traceBusy}
+	tests := []struct {
+		name  string
+		check func([]byte) bool
+	}{
+		{"trace.txt", func(b []byte) bool {
+			return bytes.Count(b, []byte(" -> ")) == 2 && bytes.Contains(b, []byte("INVITE sip:"))
+		}},
+		{"trace.jsonl", func(b []byte) bool {
+			return bytes.Count(b, []byte("\n")) == 2 && bytes.HasPrefix(b, []byte(`{"time":`))
+		}},
+		{"trace.PCAPNG", func(b []byte) bool { return bytes.HasPrefix(b, []byte{0x0A, 0x0D, 0x0D, 0x0A}) }},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if _, err := ExportTraceFile(path, events, nil); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !tt.check(b) {
+			t.Errorf("%s has the wrong format:\n%s", tt.name, strings.ToValidUTF8(string(b[:min(len(b), 200)]), "?"))
+		}
+	}
+}
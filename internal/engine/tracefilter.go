@@ -0,0 +1,180 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/siptty/siptty/internal/config"
+)
+
+// headerValue returns the value of the first header called name (or its
+// compact form) in a raw SIP message, or "" if there is none.
+func headerValue(msg, name, compact string) string {
+	_, rest, _ := strings.Cut(msg, "\n")
+	for rest != "" {
+		var line string
+		line, rest, _ = strings.Cut(rest, "\n")
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			break
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		if strings.EqualFold(key, name) || (compact != "" && strings.EqualFold(key, compact)) {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// Header returns the value of the named header of the traced message (with
+// its compact form, e.g. "i" for Call-ID), or "".
+func (ev SipTraceEvent) Header(name, compact string) string {
+	return headerValue(ev.Message, name, compact)
+}
+
+// CallID returns the Call-ID of the traced message, or "" for DNS notes.
+func (ev SipTraceEvent) CallID() string {
+	return ev.Header("Call-ID", "i")
+}
+
+// StatusCode returns the status code of a traced response, or 0 for a request.
+func (ev SipTraceEvent) StatusCode() int {
+	rest, ok := strings.CutPrefix(ev.Message, "SIP/2.0 ")
+	if !ok || len(rest) < 3 {
+		return 0
+	}
+	code, err := strconv.Atoi(rest[:3])
+	if err != nil {
+		return 0
+	}
+	return code
+}
+
+// Method returns the method of a traced request, or the CSeq method of a
+// response. It is "" for DNS notes.
+func (ev SipTraceEvent) Method() string {
+	if ev.Direction == "dns" {
+		return ""
+	}
+	if ev.StatusCode() != 0 {
+		_, method, _ := strings.Cut(ev.Header("CSeq", ""), " ")
+		return strings.TrimSpace(method)
+	}
+	method, _, _ := strings.Cut(ev.Message, " ")
+	return method
+}
+
+// TraceFilter selects trace events by the fields of a config.TraceFilter.
+type TraceFilter struct {
+	cfg     config.TraceFilter
+	methods map[string]bool
+	re      *regexp.Regexp
+}
+
+// NewTraceFilter compiles a trace filter.
+func NewTraceFilter(cfg config.TraceFilter) (*TraceFilter, error) {
+	if err := config.ValidateTraceFilter(cfg); err != nil {
+		return nil, err
+	}
+	f := &TraceFilter{cfg: cfg}
+	if len(cfg.Methods) > 0 {
+		f.methods = make(map[string]bool)
+		for _, m := range cfg.Methods {
+			f.methods[strings.ToUpper(m)] = true
+		}
+	}
+	if cfg.Regex != "" {
+		f.re = regexp.MustCompile(cfg.Regex)
+	}
+	return f, nil
+}
+
+// Name returns the name of a saved filter, or "" for one typed in.
+func (f *TraceFilter) Name() string {
+	return f.cfg.Name
+}
+
+// Match reports whether ev passes every condition of the filter.
+func (f *TraceFilter) Match(ev SipTraceEvent) bool {
+	c := f.cfg
+	switch {
+	case f.methods != nil && !f.methods[strings.ToUpper(ev.Method())]:
+		return false
+	case len(c.Status) > 0 && !matchStatus(c.Status, ev.StatusCode()):
+		return false
+	case c.CallID != "" && !strings.Contains(ev.CallID(), c.CallID):
+		return false
+	case c.Direction != "" && ev.Direction != c.Direction:
+		return false
+	case c.Remote != "" && !strings.Contains(ev.RemoteAddr, c.Remote):
+		return false
+	case c.Account != "" && ev.AccountID != c.Account:
+		return false
+	case f.re != nil && !f.re.MatchString(ev.Message):
+		return false
+	}
+	return true
+}
+
+// matchStatus reports whether code is one of the classes ("4xx") or codes
+// ("486") in status. Requests (code 0) never match.
+func matchStatus(status []string, code int) bool {
+	if code == 0 {
+		return false
+	}
+	s := strconv.Itoa(code)
+	for _, want := range status {
+		if (strings.EqualFold(want[1:], "xx") && want[0] == s[0]) || want == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTraceFilter parses a filter typed in the trace panel: space-separated
+// key:value terms, with comma-separated lists for method and status, e.g.
+// "method:INVITE,BYE status:4xx dir:recv". The keys are method, status,
+// callid, dir, remote and account; re: takes the rest of the line as a
+// regular expression.
+func ParseTraceFilter(expr string) (config.TraceFilter, error) {
+	var f config.TraceFilter
+	expr = strings.TrimSpace(expr)
+	for expr != "" {
+		var term string
+		term, expr, _ = strings.Cut(expr, " ")
+		expr = strings.TrimSpace(expr)
+		key, value, ok := strings.Cut(term, ":")
+		if !ok || value == "" && key != "re" {
+			return config.TraceFilter{}, fmt.Errorf("filter term %q is not key:value", term)
+		}
+		switch strings.ToLower(key) {
+		case "method":
+			f.Methods = append(f.Methods, strings.Split(value, ",")...)
+		case "status":
+			f.Status = append(f.Status, strings.Split(value, ",")...)
+		case "callid", "call-id":
+			f.CallID = value
+		case "dir", "direction":
+			f.Direction = value
+		case "remote":
+			f.Remote = value
+		case "account":
+			f.Account = value
+		case "re", "regex":
+			f.Regex = strings.TrimSpace(value + " " + expr)
+			expr = ""
+		default:
+			return config.TraceFilter{}, fmt.Errorf("unknown filter key %q", key)
+		}
+	}
+	if err := config.ValidateTraceFilter(f); err != nil {
+		return config.TraceFilter{}, err
+	}
+	return f, nil
+}
@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/siptty/siptty/internal/config"
+)
+
+var (
+	traceInvite = SipTraceEvent{
+		Direction:  "send",
+		RemoteAddr: "192.0.2.10:5060",
+		AccountID:  "ext100",
+		Message: "INVITE sip:200@pbx.example.com SIP/2.0\r\n" +
+			"Call-ID: abc123@host\r\n" +
+			"CSeq: 1 INVITE\r\n" +
+			"User-Agent: siptty\r\n\r\n",
+	}
+	traceBusy = SipTraceEvent{
+		Direction:  "recv",
+		RemoteAddr: "192.0.2.10:5060",
+		AccountID:  "ext100",
+		Message: "SIP/2.0 486 Busy Here\r\n" +
+			"i: abc123@host\r\n" +
+			"CSeq: 1 INVITE\r\n\r\n",
+	}
+	traceOptions = SipTraceEvent{
+		Direction:  "recv",
+		RemoteAddr: "198.51.100.7:5060",
+		Message:    "OPTIONS sip:siptty@192.0.2.1 SIP/2.0\r\nCall-ID: ping1\r\nCSeq: 7 OPTIONS\r\n\r\n",
+	}
+	traceDNSNote = SipTraceEvent{Direction: "dns", Message: "DNS pbx.example.com -> 192.0.2.10:5060 (A)"}
+)
+
+func TestTraceEventFields(t *testing.T) {
+	if m := traceInvite.Method(); m != "INVITE" {
+		t.Errorf("request Method() = %q", m)
+	}
+	if m, code := traceBusy.Method(), traceBusy.StatusCode(); m != "INVITE" || code != 486 {
+		t.Errorf("response Method(), StatusCode() = %q, %d; want INVITE, 486", m, code)
+	}
+	if id := traceBusy.CallID(); id != "abc123@host" {
+		t.Errorf("compact Call-ID = %q", id)
+	}
+	if m := traceDNSNote.Method(); m != "" {
+		t.Errorf("DNS note Method() = %q, want empty", m)
+	}
+}
+
+func TestTraceFilterMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter config.TraceFilter
+		match  []SipTraceEvent
+		reject []SipTraceEvent
+	}{
+		{"empty", config.TraceFilter{}, []SipTraceEvent{traceInvite, traceOptions, traceDNSNote}, nil},
+		{"method", config.TraceFilter{Methods: []string{"invite"}}, []SipTraceEvent{traceInvite, traceBusy}, []SipTraceEvent{traceOptions, traceDNSNote}},
+		{"status class", config.TraceFilter{Status: []string{"4xx"}}, []SipTraceEvent{traceBusy}, []SipTraceEvent{traceInvite}},
+		{"status code", config.TraceFilter{Status: []string{"486"}}, []SipTraceEvent{traceBusy}, nil},
+		{"call-id", config.TraceFilter{CallID: "abc123"}, []SipTraceEvent{traceInvite, traceBusy}, []SipTraceEvent{traceOptions}},
+		{"direction", config.TraceFilter{Direction: "recv"}, []SipTraceEvent{traceBusy, traceOptions}, []SipTraceEvent{traceInvite}},
+		{"remote", config.TraceFilter{Remote: "198.51.100."}, []SipTraceEvent{traceOptions}, []SipTraceEvent{traceInvite}},
+		{"account", config.TraceFilter{Account: "ext100"}, []SipTraceEvent{traceInvite}, []SipTraceEvent{traceOptions}},
+		{"regex", config.TraceFilter{Regex: "(?m)^User-Agent: siptty"}, []SipTraceEvent{traceInvite}, []SipTraceEvent{traceBusy}},
+		{"combined", config.TraceFilter{Methods: []string{"INVITE"}, Direction: "recv"}, []SipTraceEvent{traceBusy}, []SipTraceEvent{traceInvite}},
+	}
+	for _, tt := range tests {
+		f, err := NewTraceFilter(tt.filter)
+		if err != nil {
+			t.Fatalf("%s: NewTraceFilter: %v", tt.name, err)
+		}
+		for _, ev := range tt.match {
+			if !f.Match(ev) {
+				t.Errorf("%s: should match %q", tt.name, firstLine(ev.Message))
+			}
+		}
+		for _, ev := range tt.reject {
+			if f.Match(ev) {
+				t.Errorf("%s: should not match %q", tt.name, firstLine(ev.Message))
+			}
+		}
+	}
+}
+
+func firstLine(msg string) string {
+	line, _, _ := strings.Cut(msg, "\r\n")
+	return line
+}
+
+func TestParseTraceFilter(t *testing.T) {
+	f, err := ParseTraceFilter("method:INVITE,BYE status:4xx,5xx dir:recv remote:10.0.0.1 account:ext100 callid:abc re:^SIP/2.0 48[67]")
+	if err != nil {
+		t.Fatalf("ParseTraceFilter: %v", err)
+	}
+	if len(f.Methods) != 2 || len(f.Status) != 2 || f.Direction != "recv" || f.Remote != "10.0.0.1" ||
+		f.Account != "ext100" || f.CallID != "abc" || f.Regex != "^SIP/2.0 48[67]" {
+		t.Errorf("filter = %+v", f)
+	}
+
+	for _, bad := range []string{"INVITE", "color:red", "status:9xx", "dir:up", "re:("} {
+		if _, err := ParseTraceFilter(bad); err == nil {
+			t.Errorf("ParseTraceFilter(%q) expected error", bad)
+		}
+	}
+}
+
+func TestAccountAOR(t *testing.T) {
+	if got := accountAOR("sip:100@PBX.example.com:5060;transport=tcp"); got != "100@pbx.example.com" {
+		t.Errorf("accountAOR = %q", got)
+	}
+	e := &Engine{
+		accounts: map[string]*Account{"ext100": {ID: "ext100", aor: "100@pbx.example.com"}},
+		order:    []string{"ext100"},
+	}
+	msg := "INVITE sip:100@192.0.2.1 SIP/2.0\r\nFrom: <sip:300@pbx.example.com>;tag=1\r\nTo: <sip:100@pbx.example.com>\r\n\r\n"
+	if id := e.accountForMessage(msg); id != "ext100" {
+		t.Errorf("accountForMessage = %q, want ext100", id)
+	}
+	if id := e.accountForMessage(traceOptions.Message); id != "" {
+		t.Errorf("accountForMessage without From/To = %q, want none", id)
+	}
+}
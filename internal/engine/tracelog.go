@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/siptty/siptty/internal/config"
+	"github.com/siptty/siptty/internal/rotate"
+)
+
+// traceLog writes every traced message to a rotating file, either as
+// sngrep-style text or as one JSON object per line.
+type traceLog struct {
+	file   *rotate.File
+	format string
+	once   sync.Once // logs the first write error
+}
+
+func openTraceLog(cfg config.TraceLogConfig) (*traceLog, error) {
+	f, err := rotate.Open(cfg.Path, rotate.Options{
+		MaxSize:  int64(cfg.MaxSize),
+		MaxAge:   time.Duration(cfg.RotateInterval) * time.Second,
+		Compress: cfg.Compress,
+		Keep:     cfg.Keep,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("opening trace log: %w", err)
+	}
+	return &traceLog{file: f, format: cfg.Format}, nil
+}
+
+func (l *traceLog) write(ev SipTraceEvent) {
+	var b []byte
+	if l.format == "jsonl" {
+		b = formatTraceJSON(ev)
+	} else {
+		b = formatTraceText(ev)
+	}
+	if _, err := l.file.Write(b); err != nil && !errors.Is(err, os.ErrClosed) {
+		l.once.Do(func() {
+			slog.Warn("writing trace log", "error", err)
+		})
+	}
+}
+
+func (l *traceLog) close() {
+	if err := l.file.Close(); err != nil {
+		slog.Warn("closing trace log", "error", err)
+	}
+}
+
+// formatTraceText formats ev the way sngrep saves text: a line with the
+// time and "source -> destination", the message, and a blank line. DNS
+// notes name the chosen target instead.
+func formatTraceText(ev SipTraceEvent) []byte {
+	ts := ev.Timestamp.Format("2006/01/02 15:04:05.000000")
+	var head string
+	switch ev.Direction {
+	case "send":
+		head = fmt.Sprintf("%s %s -> %s", ts, ev.LocalAddr, ev.RemoteAddr)
+	case "recv":
+		head = fmt.Sprintf("%s %s -> %s", ts, ev.RemoteAddr, ev.LocalAddr)
+	default:
+		head = fmt.Sprintf("%s DNS %s", ts, ev.RemoteAddr)
+	}
+	return []byte(head + "\n" + strings.TrimRight(ev.Message, "\r\n") + "\n\n")
+}
+
+// traceRecord is the JSON Lines form of a trace event.
+type traceRecord struct {
+	Time      time.Time `json:"time"`
+	Seq       int       `json:"seq,omitempty"`
+	Direction string    `json:"direction"`
+	Transport string    `json:"transport"`
+	Local     string    `json:"local"`
+	Remote    string    `json:"remote"`
+	Account   string    `json:"account,omitempty"`
+	CallID    string    `json:"call_id,omitempty"`
+	Message   string    `json:"message"`
+}
+
+func formatTraceJSON(ev SipTraceEvent) []byte {
+	b, _ := json.Marshal(traceRecord{
+		Time:      ev.Timestamp,
+		Seq:       ev.Seq,
+		Direction: ev.Direction,
+		Transport: ev.Transport,
+		Local:     ev.LocalAddr,
+		Remote:    ev.RemoteAddr,
+		Account:   ev.AccountID,
+		CallID:    ev.CallID(),
+		Message:   ev.Message,
+	})
+	return append(b, '\n')
+}
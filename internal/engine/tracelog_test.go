@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/siptty/siptty/internal/config"
+)
+
+func TestFormatTraceText(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 123456000, time.UTC)
+	ev := SipTraceEvent{Direction: "recv", Message: "SIP/2.0 200 OK\r\nCall-ID: a\r\n\r\n", Timestamp: at,
+		LocalAddr: "192.0.2.1:5060", RemoteAddr: "192.0.2.10:5060"}
+	want := "2024/01/01 12:00:00.123456 192.0.2.10:5060 -> 192.0.2.1:5060\nSIP/2.0 200 OK\r\nCall-ID: a\n\n"
+	if got := string(formatTraceText(ev)); got != want {
+		t.Errorf("recv = %q, want %q", got, want)
+	}
+	dns := SipTraceEvent{Direction: "dns", Message: "NAPTR example.com: udp", Timestamp: at, RemoteAddr: "192.0.2.10:5060"}
+	if got := string(formatTraceText(dns)); got != "2024/01/01 12:00:00.123456 DNS 192.0.2.10:5060\nNAPTR example.com: udp\n\n" {
+		t.Errorf("dns = %q", got)
+	}
+}
+
+func TestTraceLogJSONL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	l, err := openTraceLog(config.TraceLogConfig{Path: path, Format: "jsonl", MaxSize: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer := &sipTracer{events: make(chan Event, 4), store: NewTraceStore(10, 0), log: l}
+	tracer.SIPTraceWrite("UDP", "192.0.2.1:5060", "192.0.2.10:5060", []byte("BYE sip:b SIP/2.0\r\nCall-ID: c1\r\n\r\n"))
+	l.close()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec traceRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		t.Fatalf("%q: %v", b, err)
+	}
+	if rec.Seq != 1 || rec.Direction != "send" || rec.CallID != "c1" || rec.Remote != "192.0.2.10:5060" || rec.Transport != "UDP" {
+		t.Errorf("record = %+v", rec)
+	}
+}
@@ -0,0 +1,166 @@
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+// TraceStore keeps the most recent trace events in a ring buffer bounded by
+// a message count and a total message size, indexed by Call-ID. The engine
+// files every traced message and DNS note in it before announcing it with a
+// SipTraceEvent, so the TUI, the dialog viewer and the exporters all read
+// the same history. It is safe for concurrent use.
+type TraceStore struct {
+	mu          sync.RWMutex
+	buf         []SipTraceEvent
+	head        int // index of the oldest event in buf
+	n           int
+	bytes       int
+	maxMessages int
+	maxBytes    int
+	nextSeq     int
+	byCallID    map[string][]int // seqs of each Call-ID's events, oldest first
+}
+
+// TraceQuery selects events from a TraceStore. Zero fields match everything.
+type TraceQuery struct {
+	CallID    string
+	AccountID string
+	Since     time.Time // inclusive
+	Until     time.Time // exclusive
+}
+
+// NewTraceStore returns a store holding at most maxMessages events whose
+// messages add up to at most maxBytes. A maxBytes of 0 means no size limit.
+func NewTraceStore(maxMessages, maxBytes int) *TraceStore {
+	maxMessages = max(maxMessages, 1)
+	return &TraceStore{
+		buf:         make([]SipTraceEvent, maxMessages),
+		maxMessages: maxMessages,
+		maxBytes:    maxBytes,
+		nextSeq:     1,
+		byCallID:    make(map[string][]int),
+	}
+}
+
+// Add stores ev, evicting the oldest events to make room, and returns it
+// with its Seq set.
+func (s *TraceStore) Add(ev SipTraceEvent) SipTraceEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.n > 0 && (s.n == s.maxMessages || s.maxBytes > 0 && s.bytes+len(ev.Message) > s.maxBytes) {
+		s.evict()
+	}
+	ev.Seq = s.nextSeq
+	s.nextSeq++
+	s.buf[(s.head+s.n)%len(s.buf)] = ev
+	s.n++
+	s.bytes += len(ev.Message)
+	if id := ev.CallID(); id != "" {
+		s.byCallID[id] = append(s.byCallID[id], ev.Seq)
+	}
+	return ev
+}
+
+// evict drops the oldest event.
+func (s *TraceStore) evict() {
+	ev := s.buf[s.head]
+	s.buf[s.head] = SipTraceEvent{}
+	s.head = (s.head + 1) % len(s.buf)
+	s.n--
+	s.bytes -= len(ev.Message)
+	if id := ev.CallID(); id != "" {
+		if seqs := s.byCallID[id]; len(seqs) > 1 {
+			s.byCallID[id] = seqs[1:]
+		} else {
+			delete(s.byCallID, id)
+		}
+	}
+}
+
+// at returns the event with seq, which must be in the store.
+func (s *TraceStore) at(seq int) SipTraceEvent {
+	return s.buf[(s.head+seq-s.oldest())%len(s.buf)]
+}
+
+// oldest returns the seq of the oldest event, or the next seq when empty.
+func (s *TraceStore) oldest() int {
+	return s.nextSeq - s.n
+}
+
+// Len returns the number of stored events.
+func (s *TraceStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.n
+}
+
+// Size returns the total length of the stored messages in bytes.
+func (s *TraceStore) Size() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.bytes
+}
+
+// Oldest returns the seq of the oldest stored event; events with a lower
+// seq have been evicted. For an empty store it is the seq of the next event.
+func (s *TraceStore) Oldest() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.oldest()
+}
+
+// Get returns the event with seq, if it is still stored.
+func (s *TraceStore) Get(seq int) (SipTraceEvent, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if seq < s.oldest() || seq >= s.nextSeq {
+		return SipTraceEvent{}, false
+	}
+	return s.at(seq), true
+}
+
+// Since returns the stored events with a seq above seq, oldest first.
+func (s *TraceStore) Since(seq int) []SipTraceEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	from := max(seq+1, s.oldest())
+	out := make([]SipTraceEvent, 0, max(s.nextSeq-from, 0))
+	for i := from; i < s.nextSeq; i++ {
+		out = append(out, s.at(i))
+	}
+	return out
+}
+
+// All returns every stored event, oldest first.
+func (s *TraceStore) All() []SipTraceEvent {
+	return s.Since(0)
+}
+
+// Query returns the stored events that match q, oldest first. A Call-ID
+// query is answered from the index.
+func (s *TraceStore) Query(q TraceQuery) []SipTraceEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []SipTraceEvent
+	add := func(ev SipTraceEvent) {
+		switch {
+		case q.AccountID != "" && ev.AccountID != q.AccountID:
+		case !q.Since.IsZero() && ev.Timestamp.Before(q.Since):
+		case !q.Until.IsZero() && !ev.Timestamp.Before(q.Until):
+		default:
+			out = append(out, ev)
+		}
+	}
+	if q.CallID != "" {
+		for _, seq := range s.byCallID[q.CallID] {
+			add(s.at(seq))
+		}
+		return out
+	}
+	for seq := s.oldest(); seq < s.nextSeq; seq++ {
+		add(s.at(seq))
+	}
+	return out
+}
@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func storeEvent(callID string, at time.Time) SipTraceEvent {
+	return SipTraceEvent{
+		Direction: "send",
+		Timestamp: at,
+		Message:   "OPTIONS sip:pbx SIP/2.0\r\nCall-ID: " + callID + "\r\n\r\n",
+	}
+}
+
+func TestTraceStoreRing(t *testing.T) {
+	s := NewTraceStore(3, 0)
+	t0 := time.Now()
+	for i := range 5 {
+		ev := s.Add(storeEvent(fmt.Sprintf("c%d", i%2), t0))
+		if ev.Seq != i+1 {
+			t.Fatalf("event %d got seq %d", i, ev.Seq)
+		}
+	}
+	if s.Len() != 3 || s.Oldest() != 3 {
+		t.Fatalf("Len() = %d, Oldest() = %d; want 3, 3", s.Len(), s.Oldest())
+	}
+	if _, ok := s.Get(2); ok {
+		t.Error("evicted seq 2 still returned")
+	}
+	if ev, ok := s.Get(4); !ok || ev.CallID() != "c1" {
+		t.Errorf("Get(4) = %v, %v", ev.CallID(), ok)
+	}
+	var seqs []int
+	for _, ev := range s.Since(3) {
+		seqs = append(seqs, ev.Seq)
+	}
+	if fmt.Sprint(seqs) != "[4 5]" {
+		t.Errorf("Since(3) seqs = %v", seqs)
+	}
+	if n := len(s.All()); n != 3 {
+		t.Errorf("All() = %d events", n)
+	}
+	// c0 was sent as seqs 1, 3 and 5; only 3 and 5 remain.
+	if got := s.Query(TraceQuery{CallID: "c0"}); len(got) != 2 || got[0].Seq != 3 {
+		t.Errorf("Call-ID query = %d events", len(got))
+	}
+}
+
+func TestTraceStoreByteLimit(t *testing.T) {
+	ev := storeEvent("c", time.Now())
+	size := len(ev.Message)
+	s := NewTraceStore(100, 2*size+1)
+	for range 4 {
+		s.Add(ev)
+	}
+	if s.Len() != 2 || s.Size() != 2*size {
+		t.Errorf("Len() = %d, Size() = %d; want 2, %d", s.Len(), s.Size(), 2*size)
+	}
+
+	// A message larger than the limit is kept on its own.
+	big := ev
+	big.Message += strings.Repeat("x", 3*size)
+	s.Add(big)
+	if s.Len() != 1 {
+		t.Errorf("after oversized message Len() = %d, want 1", s.Len())
+	}
+}
+
+func TestTraceStoreQuery(t *testing.T) {
+	s := NewTraceStore(10, 0)
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := range 4 {
+		ev := storeEvent(fmt.Sprintf("c%d", i), t0.Add(time.Duration(i)*time.Second))
+		if i%2 == 1 {
+			ev.AccountID = "ext100"
+		}
+		s.Add(ev)
+	}
+	s.Add(traceDNSNote)
+
+	tests := []struct {
+		name string
+		q    TraceQuery
+		want int
+	}{
+		{"all", TraceQuery{}, 5},
+		{"account", TraceQuery{AccountID: "ext100"}, 2},
+		{"time range", TraceQuery{Since: t0.Add(time.Second), Until: t0.Add(3 * time.Second)}, 2},
+		{"call-id and account", TraceQuery{CallID: "c2", AccountID: "ext100"}, 0},
+		{"unknown call-id", TraceQuery{CallID: "nope"}, 0},
+	}
+	for _, tt := range tests {
+		if got := s.Query(tt.q); len(got) != tt.want {
+			t.Errorf("%s: %d events, want %d", tt.name, len(got), tt.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/gobwas/ws"
+)
+
+// wsEdgeRoute returns the loose route to the WebSocket edge in wsURL, used as
+// the outbound proxy of ws/wss accounts that do not configure one: the edge
+// is the only hop a WebSocket client can reach (RFC 7118 §5). It returns ""
+// when the URL does not parse; the config has already checked it.
+func wsEdgeRoute(wsURL string) string {
+	u, err := url.Parse(wsURL)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	hostport := uriHost(u.Hostname())
+	if port := u.Port(); port != "" {
+		hostport += ":" + port
+	}
+	return "sip:" + hostport + ";transport=" + u.Scheme + ";lr"
+}
+
+// configureWebSocket makes sipgo's WebSocket handshakes match wsURL. sipgo
+// dials "ws://ip:port/" with a copy of gobwas/ws's DefaultDialer taken when
+// the UA is created, so the Host header and the request path are set on that
+// dialer just for the creation, the path by rewriting the handshake's request
+// line; restore puts DefaultDialer back. The config holds all ws/wss accounts
+// to one host and path, and wss, whose TLS sipgo dials without this hook, to
+// the root path.
+func configureWebSocket(wsURL string) (restore func(), err error) {
+	u, err := url.Parse(wsURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ws_url %q: %w", wsURL, err)
+	}
+	saved := ws.DefaultDialer
+	restore = func() { ws.DefaultDialer = saved }
+
+	ws.DefaultDialer.Host = u.Host
+	ws.DefaultDialer.Protocols = sip.WebSocketProtocols
+	if path := u.RequestURI(); path != "/" {
+		var d net.Dialer
+		ws.DefaultDialer.NetDial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := d.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return &pathConn{Conn: conn, path: path}, nil
+		}
+	}
+	return restore, nil
+}
+
+// pathConn rewrites the request line of the WebSocket handshake, the first
+// write on the connection, to ask for path instead of "/".
+type pathConn struct {
+	net.Conn
+	path    string
+	written bool
+}
+
+func (c *pathConn) Write(b []byte) (int, error) {
+	if c.written {
+		return c.Conn.Write(b)
+	}
+	c.written = true
+	root := []byte("GET / HTTP/1.1")
+	if !bytes.HasPrefix(b, root) {
+		return c.Conn.Write(b)
+	}
+	req := append([]byte("GET "+c.path+" HTTP/1.1"), b[len(root):]...)
+	if _, err := c.Conn.Write(req); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// invalidHost returns a random host in the .invalid domain, which RFC 7118
+// §5.2 has WebSocket clients put in Via and Contact: the client has no
+// address the server could reach it at except the open connection.
+func invalidHost() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b) + ".invalid"
+}
+
+// wsContact points a Contact at host, the client's .invalid name, with the
+// account's WebSocket transport (RFC 7118 §5.2).
+func wsContact(contact *sip.ContactHeader, host, transport string) {
+	contact.Address.Host = host
+	contact.Address.Port = 0
+	if contact.Address.UriParams == nil {
+		contact.Address.UriParams = sip.NewParams()
+	}
+	contact.Address.UriParams.Add("transport", transport)
+}
@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/gobwas/ws"
+	"github.com/siptty/siptty/internal/config"
+)
+
+func TestWSEdgeRoute(t *testing.T) {
+	tests := []struct {
+		url, want string
+	}{
+		{"wss://edge.example.com:8443/ws", "sip:edge.example.com:8443;transport=wss;lr"},
+		{"ws://pbx.example.com/", "sip:pbx.example.com;transport=ws;lr"},
+		{"ws://[2001:db8::1]:8088/ws", "sip:[2001:db8::1]:8088;transport=ws;lr"},
+		{"ws:///ws", ""},
+	}
+	for _, tt := range tests {
+		if got := wsEdgeRoute(tt.url); got != tt.want {
+			t.Errorf("wsEdgeRoute(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestWebSocketNextHop(t *testing.T) {
+	a := &Account{Config: config.AccountConfig{
+		Registrar: "sip:example.com",
+		Transport: "ws",
+		WSURL:     "ws://edge.example.com:8088/ws",
+	}}
+	hop, err := a.nextHop()
+	if err != nil || hop.Host != "edge.example.com" || hop.Port != 8088 {
+		t.Errorf("nextHop = %v:%d, %v; want the WebSocket edge", hop.Host, hop.Port, err)
+	}
+	if tp, _ := hop.UriParams.Get("transport"); tp != "ws" {
+		t.Errorf("edge transport = %q, want ws", tp)
+	}
+
+	a.Config.OutboundProxy = "sip:sbc.example.com;transport=ws"
+	if hop, _ := a.nextHop(); hop.Host != "sbc.example.com" {
+		t.Errorf("nextHop = %v, want the configured outbound proxy", hop.Host)
+	}
+}
+
+func TestWSContact(t *testing.T) {
+	var contact sip.ContactHeader
+	if _, err := sip.ParseAddressValue("<sip:alice@192.0.2.1:5060>", &contact.Address, &contact.Params); err != nil {
+		t.Fatalf("parse contact: %v", err)
+	}
+	host := invalidHost()
+	if !strings.HasSuffix(host, ".invalid") || host == invalidHost() {
+		t.Fatalf("invalidHost() = %q, want a random .invalid name", host)
+	}
+	wsContact(&contact, host, "wss")
+	if want := "sip:alice@" + host + ";transport=wss"; contact.Address.String() != want {
+		t.Errorf("contact = %q, want %q", contact.Address.String(), want)
+	}
+}
+
+func TestConfigureWebSocket(t *testing.T) {
+	before := ws.DefaultDialer
+	restore, err := configureWebSocket("ws://edge.example.com:8088/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws.DefaultDialer.Host != "edge.example.com:8088" || ws.DefaultDialer.NetDial == nil {
+		t.Errorf("DefaultDialer = %+v, want the edge host and a path rewrite", ws.DefaultDialer)
+	}
+	restore()
+	if ws.DefaultDialer.Host != before.Host || ws.DefaultDialer.NetDial != nil {
+		t.Errorf("DefaultDialer not restored: %+v", ws.DefaultDialer)
+	}
+}
+
+func TestPathConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := &pathConn{Conn: client, path: "/ws?token=1"}
+
+	handshake := "GET / HTTP/1.1\r\nHost: edge.example.com\r\n\r\n"
+	got := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, 256)
+		n, _ := server.Read(buf)
+		got <- buf[:n]
+	}()
+	n, err := conn.Write([]byte(handshake))
+	if err != nil || n != len(handshake) {
+		t.Fatalf("Write = %d, %v; want %d", n, err, len(handshake))
+	}
+	if want := "GET /ws?token=1 HTTP/1.1\r\nHost: edge.example.com\r\n\r\n"; !bytes.Equal(<-got, []byte(want)) {
+		t.Errorf("handshake not rewritten to the ws_url path")
+	}
+
+	// Frames after the handshake pass through untouched.
+	go func() {
+		buf := make([]byte, 256)
+		n, _ := server.Read(buf)
+		got <- buf[:n]
+	}()
+	if _, err := conn.Write([]byte("GET / HTTP/1.1")); err != nil {
+		t.Fatal(err)
+	}
+	if b := <-got; string(b) != "GET / HTTP/1.1" {
+		t.Errorf("second write = %q, want it unchanged", b)
+	}
+}
@@ -0,0 +1,107 @@
+// Package hep encodes HEPv3 (EEP) packets, the encapsulation SIP capture
+// servers such as Homer collect: the captured message plus its addresses,
+// time and capture agent in a list of typed chunks.
+package hep
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"time"
+)
+
+// Chunk types of the generic vendor (0), and the values used for them.
+const (
+	chunkIPFamily      = 0x0001
+	chunkIPProto       = 0x0002
+	chunkIPv4Src       = 0x0003
+	chunkIPv4Dst       = 0x0004
+	chunkIPv6Src       = 0x0005
+	chunkIPv6Dst       = 0x0006
+	chunkSrcPort       = 0x0007
+	chunkDstPort       = 0x0008
+	chunkTimeSec       = 0x0009
+	chunkTimeUsec      = 0x000a
+	chunkProtoType     = 0x000b
+	chunkCaptureID     = 0x000c
+	chunkPayload       = 0x000f
+	chunkCorrelationID = 0x0011
+	chunkNodeName      = 0x0013
+
+	familyIPv4 = 2
+	familyIPv6 = 10
+	protoTCP   = 6
+	protoUDP   = 17
+	protoSIP   = 1
+	headerLen  = 6 // "HEP3" and the total length
+	chunkLen   = 6 // vendor, type and length of each chunk
+)
+
+// Packet is one captured SIP message.
+type Packet struct {
+	Time          time.Time
+	Src           netip.AddrPort
+	Dst           netip.AddrPort
+	TCP           bool // stream transport; UDP otherwise
+	Payload       []byte
+	CaptureID     uint32
+	NodeName      string // optional
+	CorrelationID string // optional, usually the Call-ID
+}
+
+// Encode returns p as a HEPv3 packet. Mixed address families are sent in
+// their IPv6 form.
+func Encode(p Packet) ([]byte, error) {
+	src, dst := p.Src.Addr().Unmap(), p.Dst.Addr().Unmap()
+	if !src.IsValid() || !dst.IsValid() {
+		return nil, errors.New("packet without addresses")
+	}
+	if src.Is4() != dst.Is4() {
+		src, dst = netip.AddrFrom16(src.As16()), netip.AddrFrom16(dst.As16())
+	}
+
+	b := []byte("HEP3\x00\x00")
+	if src.Is4() {
+		b = appendChunk(b, chunkIPFamily, []byte{familyIPv4})
+	} else {
+		b = appendChunk(b, chunkIPFamily, []byte{familyIPv6})
+	}
+	proto := byte(protoUDP)
+	if p.TCP {
+		proto = protoTCP
+	}
+	b = appendChunk(b, chunkIPProto, []byte{proto})
+	if src.Is4() {
+		b = appendChunk(b, chunkIPv4Src, src.AsSlice())
+		b = appendChunk(b, chunkIPv4Dst, dst.AsSlice())
+	} else {
+		b = appendChunk(b, chunkIPv6Src, src.AsSlice())
+		b = appendChunk(b, chunkIPv6Dst, dst.AsSlice())
+	}
+	b = appendChunk(b, chunkSrcPort, binary.BigEndian.AppendUint16(nil, p.Src.Port()))
+	b = appendChunk(b, chunkDstPort, binary.BigEndian.AppendUint16(nil, p.Dst.Port()))
+	b = appendChunk(b, chunkTimeSec, binary.BigEndian.AppendUint32(nil, uint32(p.Time.Unix())))
+	b = appendChunk(b, chunkTimeUsec, binary.BigEndian.AppendUint32(nil, uint32(p.Time.Nanosecond()/1000)))
+	b = appendChunk(b, chunkProtoType, []byte{protoSIP})
+	b = appendChunk(b, chunkCaptureID, binary.BigEndian.AppendUint32(nil, p.CaptureID))
+	if p.NodeName != "" {
+		b = appendChunk(b, chunkNodeName, []byte(p.NodeName))
+	}
+	if p.CorrelationID != "" {
+		b = appendChunk(b, chunkCorrelationID, []byte(p.CorrelationID))
+	}
+	b = appendChunk(b, chunkPayload, p.Payload)
+	if len(b) > 0xFFFF {
+		return nil, errors.New("message too large for a HEP packet")
+	}
+	binary.BigEndian.PutUint16(b[4:], uint16(len(b)))
+	return b, nil
+}
+
+// appendChunk appends a chunk of the generic vendor.
+func appendChunk(b []byte, typ uint16, value []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, 0)
+	b = binary.BigEndian.AppendUint16(b, typ)
+	b = binary.BigEndian.AppendUint16(b, uint16(chunkLen+len(value)))
+	return append(b, value...)
+}
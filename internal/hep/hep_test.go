@@ -0,0 +1,102 @@
+package hep
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// chunks splits a HEPv3 packet into its chunk values by type.
+func chunks(t *testing.T, b []byte) map[uint16][]byte {
+	t.Helper()
+	if string(b[:4]) != "HEP3" || int(binary.BigEndian.Uint16(b[4:])) != len(b) {
+		t.Fatalf("bad HEP header % x", b[:min(len(b), 6)])
+	}
+	out := make(map[uint16][]byte)
+	for b = b[headerLen:]; len(b) > 0; {
+		if len(b) < chunkLen {
+			t.Fatalf("truncated chunk % x", b)
+		}
+		typ, n := binary.BigEndian.Uint16(b[2:]), int(binary.BigEndian.Uint16(b[4:]))
+		if n < chunkLen || n > len(b) {
+			t.Fatalf("chunk %#x has length %d", typ, n)
+		}
+		out[typ] = b[chunkLen:n]
+		b = b[n:]
+	}
+	return out
+}
+
+func TestEncodeIPv4(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 250_000_000, time.UTC)
+	b, err := Encode(Packet{
+		Time:          at,
+		Src:           netip.MustParseAddrPort("192.0.2.1:5060"),
+		Dst:           netip.MustParseAddrPort("192.0.2.10:5080"),
+		Payload:       []byte("OPTIONS sip:pbx SIP/2.0\r\n\r\n"),
+		CaptureID:     2001,
+		NodeName:      "lab",
+		CorrelationID: "abc@host",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := chunks(t, b)
+	checks := []struct {
+		typ  uint16
+		want []byte
+	}{
+		{chunkIPFamily, []byte{familyIPv4}},
+		{chunkIPProto, []byte{protoUDP}},
+		{chunkIPv4Src, []byte{192, 0, 2, 1}},
+		{chunkIPv4Dst, []byte{192, 0, 2, 10}},
+		{chunkSrcPort, []byte{0x13, 0xc4}},
+		{chunkDstPort, []byte{0x13, 0xd8}},
+		{chunkTimeSec, binary.BigEndian.AppendUint32(nil, uint32(at.Unix()))},
+		{chunkTimeUsec, binary.BigEndian.AppendUint32(nil, 250_000)},
+		{chunkProtoType, []byte{protoSIP}},
+		{chunkCaptureID, binary.BigEndian.AppendUint32(nil, 2001)},
+		{chunkNodeName, []byte("lab")},
+		{chunkCorrelationID, []byte("abc@host")},
+		{chunkPayload, []byte("OPTIONS sip:pbx SIP/2.0\r\n\r\n")},
+	}
+	for _, ck := range checks {
+		if got := c[ck.typ]; string(got) != string(ck.want) {
+			t.Errorf("chunk %#x = % x, want % x", ck.typ, got, ck.want)
+		}
+	}
+}
+
+func TestEncodeIPv6AndTCP(t *testing.T) {
+	b, err := Encode(Packet{
+		Src:     netip.MustParseAddrPort("192.0.2.1:5060"),
+		Dst:     netip.MustParseAddrPort("[2001:db8::1]:5060"),
+		TCP:     true,
+		Payload: []byte("x"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := chunks(t, b)
+	if c[chunkIPFamily][0] != familyIPv6 || c[chunkIPProto][0] != protoTCP || len(c[chunkIPv6Src]) != 16 {
+		t.Errorf("chunks = %v, want IPv6 TCP", c)
+	}
+	if _, ok := c[chunkNodeName]; ok {
+		t.Error("empty node name was sent")
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	if _, err := Encode(Packet{Payload: []byte("x")}); err == nil {
+		t.Error("Encode without addresses succeeded")
+	}
+	big := Packet{
+		Src:     netip.MustParseAddrPort("192.0.2.1:5060"),
+		Dst:     netip.MustParseAddrPort("192.0.2.10:5060"),
+		Payload: make([]byte, 70000),
+	}
+	if _, err := Encode(big); err == nil {
+		t.Error("Encode of an oversized message succeeded")
+	}
+}
@@ -0,0 +1,570 @@
+package ice
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	mathrand "math/rand/v2"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/siptty/siptty/internal/stun"
+)
+
+// Ta is the pacing interval between connectivity checks (RFC 8445 §14.2).
+const Ta = 20 * time.Millisecond
+
+// nominationDelay is how long the controlling agent keeps checking after the
+// first pair succeeds, so a better pair still in flight can win.
+const nominationDelay = 200 * time.Millisecond
+
+// maxPairs bounds the checklist (RFC 8445 §6.1.2.5 suggests 100).
+const maxPairs = 100
+
+// Consent freshness (RFC 7675 §5.1): a check every five seconds, give or
+// take 20%, and no more media once none has been answered for 30 seconds.
+const (
+	consentInterval = 5 * time.Second
+	consentTimeout  = 30 * time.Second
+)
+
+// permissionInterval refreshes TURN permissions before their five minutes
+// run out (RFC 5766 §8).
+const permissionInterval = 4 * time.Minute
+
+// ErrNoPair is returned by Send before a pair is selected.
+var ErrNoPair = errors.New("ice: no selected pair")
+
+// ErrConsentLost is returned by Serve, and by Send from then on, once the
+// peer stopped answering consent checks.
+var ErrConsentLost = errors.New("ice: consent lost")
+
+// Config configures an Agent.
+type Config struct {
+	STUNServer  string // for the server-reflexive candidate; optional
+	TURNServer  string // for the relayed candidate; optional
+	TURNUser    string
+	TURNPass    string
+	Controlling bool          // the offerer is controlling (RFC 8445 §6.1.1)
+	Timeout     time.Duration // per STUN/TURN server during gathering
+}
+
+// Agent runs ICE for one component on a UDP socket it shares with the
+// media: whoever reads the socket passes every datagram to Handle, which
+// keeps the STUN and TURN traffic and gives back the media. Once a pair is
+// selected, Send carries the media over it.
+type Agent struct {
+	conn net.PacketConn
+	cfg  Config
+
+	ufrag, pwd string
+	tieBreaker []byte
+	local      []Candidate
+	relay      *stun.Allocation
+
+	mu          sync.Mutex
+	remoteUfrag string
+	remotePwd   string
+	pairs       []*pairState
+	triggered   []*pairState
+	pending     map[[12]byte]transaction
+	next        int
+	firstOK     time.Time
+	selected    *pairState
+	done        chan struct{} // closed once a pair is selected
+	consentOK   time.Time     // last answered consent check
+	consentLost bool
+
+	permissions map[string]*net.UDPAddr // peers the relay lets send to us
+}
+
+// NewAgent creates an agent on conn with fresh local credentials.
+func NewAgent(conn net.PacketConn, cfg Config) *Agent {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 3 * time.Second
+	}
+	tb := make([]byte, 8)
+	_, _ = rand.Read(tb)
+	return &Agent{
+		conn:        conn,
+		cfg:         cfg,
+		ufrag:       randomString(8),
+		pwd:         randomString(24),
+		tieBreaker:  tb,
+		pending:     make(map[[12]byte]transaction),
+		done:        make(chan struct{}),
+		permissions: make(map[string]*net.UDPAddr),
+	}
+}
+
+// Credentials returns the local ice-ufrag and ice-pwd.
+func (a *Agent) Credentials() (ufrag, pwd string) {
+	return a.ufrag, a.pwd
+}
+
+// Gather collects local candidates: one host candidate per usable interface
+// address, then the server-reflexive and relayed ones if servers are
+// configured. It reads the socket itself, so it must finish before anyone
+// else starts reading. STUN/TURN failures do not stop gathering; they are
+// returned joined alongside whatever candidates were found.
+func (a *Agent) Gather() ([]Candidate, error) {
+	base, ok := a.conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return nil, fmt.Errorf("ice: socket is not UDP")
+	}
+	for _, ip := range hostIPs(base.IP) {
+		addr := &net.UDPAddr{IP: ip, Port: base.Port}
+		a.local = append(a.local, newCandidate(Host, addr, addr, ""))
+	}
+	if len(a.local) == 0 {
+		return nil, fmt.Errorf("ice: no usable local address")
+	}
+	hostBase := a.local[0].Addr
+
+	var errs []error
+	var srflx *net.UDPAddr
+	if a.cfg.STUNServer != "" {
+		mapped, err := stun.DiscoverConn(a.conn, a.cfg.STUNServer, a.cfg.Timeout)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			srflx = mapped
+		}
+	}
+	if a.cfg.TURNServer != "" {
+		alloc, err := stun.Allocate(a.conn, a.cfg.TURNServer, a.cfg.TURNUser, a.cfg.TURNPass, a.cfg.Timeout)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			a.relay = alloc
+			if srflx == nil {
+				srflx = alloc.Mapped
+			}
+			a.local = append(a.local, newCandidate(Relayed, alloc.Relayed, alloc.Mapped, a.cfg.TURNServer))
+		}
+	}
+	if srflx != nil && !slices.ContainsFunc(a.local, func(c Candidate) bool { return c.Addr.String() == srflx.String() }) {
+		a.local = append(a.local, newCandidate(ServerReflexive, srflx, hostBase, a.cfg.STUNServer))
+	}
+	return slices.Clone(a.local), errors.Join(errs...)
+}
+
+// Close releases the TURN allocation, if any. The socket belongs to the caller.
+func (a *Agent) Close() error {
+	if a.relay != nil {
+		return a.relay.Close()
+	}
+	return nil
+}
+
+// hostIPs returns the addresses host candidates are gathered on: the bound
+// address, or every global unicast interface address if bound to a wildcard.
+func hostIPs(bound net.IP) []net.IP {
+	if !bound.IsUnspecified() {
+		return []net.IP{bound}
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || !ipnet.IP.IsGlobalUnicast() {
+			continue
+		}
+		if bound.To4() != nil && ipnet.IP.To4() == nil {
+			continue // an IPv4 wildcard socket cannot use IPv6 addresses
+		}
+		ips = append(ips, ipnet.IP)
+	}
+	return ips
+}
+
+// pairState tracks one checklist entry.
+type pairState struct {
+	Pair
+	prio      uint64
+	succeeded bool
+	nominated bool // USE-CANDIDATE seen (controlled) or nomination sent (controlling)
+}
+
+// transaction is an outstanding connectivity or consent check.
+type transaction struct {
+	pair     *pairState
+	nominate bool
+	consent  bool
+}
+
+// Connect runs connectivity checks against the remote candidates until a
+// pair is selected or ctx ends, and returns the selected pair. Only
+// component 1 (RTP, with RTCP multiplexed) is checked. Checks the peer sent
+// before Connect was called have already been answered by Handle and are
+// retried from here as triggered checks.
+func (a *Agent) Connect(ctx context.Context, remoteUfrag, remotePwd string, remote []Candidate) (Pair, error) {
+	a.mu.Lock()
+	a.remoteUfrag, a.remotePwd = remoteUfrag, remotePwd
+	for _, r := range remote {
+		if r.Component != 1 {
+			continue
+		}
+		for _, l := range a.local {
+			if l.Type == ServerReflexive {
+				continue // checks are sent from the base, i.e. the host candidate
+			}
+			a.add(Pair{Local: l, Remote: r})
+		}
+	}
+	empty := len(a.pairs) == 0
+	a.mu.Unlock()
+	if empty {
+		return Pair{}, fmt.Errorf("ice: no candidate pairs to check")
+	}
+
+	ticker := time.NewTicker(Ta)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.done:
+			pair, _ := a.Selected()
+			return pair, nil
+		case <-ctx.Done():
+			return Pair{}, fmt.Errorf("ice: no connectivity: %w", ctx.Err())
+		case <-ticker.C:
+			a.mu.Lock()
+			a.tick()
+			a.mu.Unlock()
+		}
+	}
+}
+
+// Selected returns the pair media goes over, once there is one.
+func (a *Agent) Selected() (Pair, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.selected == nil {
+		return Pair{}, false
+	}
+	return a.selected.Pair, true
+}
+
+// Send writes b to the peer on the selected pair, through the relay if the
+// local candidate is a relayed one.
+func (a *Agent) Send(b []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch {
+	case a.consentLost:
+		return 0, ErrConsentLost
+	case a.selected == nil:
+		return 0, ErrNoPair
+	}
+	if err := a.write(a.selected.Local, a.selected.Remote.Addr, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Serve keeps the selected pair alive until ctx ends: it sends consent
+// checks (RFC 7675) and refreshes the TURN allocation and its permissions.
+// It returns ErrConsentLost, and Send fails from then on, when the peer
+// stops answering; the answers themselves arrive through Handle.
+func (a *Agent) Serve(ctx context.Context) error {
+	a.mu.Lock()
+	a.consentOK = time.Now()
+	a.mu.Unlock()
+
+	consent := time.NewTimer(jitter(consentInterval))
+	defer consent.Stop()
+	permissions := time.NewTicker(permissionInterval)
+	defer permissions.Stop()
+	var refresh <-chan time.Time
+	if a.relay != nil {
+		lifetime := a.relay.Lifetime
+		if lifetime <= 0 {
+			lifetime = 10 * time.Minute // the default of RFC 5766 §2.2
+		}
+		t := time.NewTicker(lifetime / 2)
+		defer t.Stop()
+		refresh = t.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-consent.C:
+			a.mu.Lock()
+			if time.Since(a.consentOK) > consentTimeout {
+				a.consentLost = true
+				a.mu.Unlock()
+				return ErrConsentLost
+			}
+			if a.selected != nil {
+				a.send(a.selected, false, true)
+			}
+			a.mu.Unlock()
+			consent.Reset(jitter(consentInterval))
+		case <-permissions.C:
+			a.mu.Lock()
+			for _, peer := range a.permissions {
+				_ = a.relay.CreatePermission(peer)
+			}
+			a.mu.Unlock()
+		case <-refresh:
+			_ = a.relay.Refresh()
+		}
+	}
+}
+
+// jitter spreads d by ±20%.
+func jitter(d time.Duration) time.Duration {
+	return d*8/10 + time.Duration(mathrand.Int64N(int64(d*4/10)))
+}
+
+// add inserts a pair in priority order, ignoring duplicates and mixed families.
+func (a *Agent) add(p Pair) *pairState {
+	if (p.Local.Addr.IP.To4() == nil) != (p.Remote.Addr.IP.To4() == nil) {
+		return nil
+	}
+	for _, ps := range a.pairs {
+		if ps.Local.Addr.String() == p.Local.Addr.String() && ps.Remote.Addr.String() == p.Remote.Addr.String() {
+			return ps
+		}
+	}
+	if len(a.pairs) >= maxPairs {
+		return nil
+	}
+	ps := &pairState{Pair: p, prio: p.priority(a.cfg.Controlling)}
+	i, _ := slices.BinarySearchFunc(a.pairs, ps, func(x, y *pairState) int {
+		switch {
+		case x.prio > y.prio:
+			return -1
+		case x.prio < y.prio:
+			return 1
+		}
+		return 0
+	})
+	a.pairs = slices.Insert(a.pairs, i, ps)
+	return ps
+}
+
+// tick sends the next check: a triggered one, the nomination once it is due,
+// or else the next pair that has not succeeded yet.
+func (a *Agent) tick() {
+	if a.selected != nil {
+		return
+	}
+	if len(a.triggered) > 0 {
+		ps := a.triggered[0]
+		a.triggered = a.triggered[1:]
+		a.send(ps, a.cfg.Controlling && ps.nominated, false)
+		return
+	}
+	if a.cfg.Controlling && !a.firstOK.IsZero() && time.Since(a.firstOK) >= nominationDelay {
+		for _, ps := range a.pairs {
+			if ps.succeeded {
+				ps.nominated = true
+				a.send(ps, true, false)
+				return
+			}
+		}
+	}
+	for range a.pairs {
+		ps := a.pairs[a.next%len(a.pairs)]
+		a.next++
+		if !ps.succeeded {
+			a.send(ps, false, false)
+			return
+		}
+	}
+}
+
+// send issues a Binding request on ps.
+func (a *Agent) send(ps *pairState, nominate, consent bool) {
+	m := stun.NewMessage(stun.TypeBindingRequest)
+	m.Add(stun.AttrUsername, []byte(a.remoteUfrag+":"+a.ufrag))
+	prio := make([]byte, 4)
+	putUint32(prio, Priority(PeerReflexive, ps.Local.Priority>>8&0xffff, 1))
+	m.Add(stun.AttrPriority, prio)
+	if a.cfg.Controlling {
+		m.Add(stun.AttrICEControlling, a.tieBreaker)
+	} else {
+		m.Add(stun.AttrICEControlled, a.tieBreaker)
+	}
+	if nominate {
+		m.Add(stun.AttrUseCandidate, nil)
+	}
+	a.pending[m.TxID] = transaction{pair: ps, nominate: nominate, consent: consent}
+	_ = a.write(ps.Local, ps.Remote.Addr, m.Encode([]byte(a.remotePwd), true))
+}
+
+// Handle takes a datagram read from the socket. STUN checks and TURN
+// control traffic are consumed; media, unwrapped from a TURN Data
+// indication if it came through the relay, is returned with media set and
+// the peer that sent it.
+func (a *Agent) Handle(raw []byte, from *net.UDPAddr) (payload []byte, peer *net.UDPAddr, media bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.local) == 0 {
+		return raw, from, true // not gathered: nothing to check yet
+	}
+	local := a.hostFor(from)
+	if a.relay != nil && from.String() == a.relay.Server().String() {
+		peer, data, ok := stun.ParseData(raw)
+		if !ok {
+			if m, err := stun.Decode(raw); err == nil {
+				a.relay.Handle(m)
+			}
+			return nil, nil, false
+		}
+		raw, from, local = data, peer, a.relayCandidate()
+	}
+	if !stun.IsMessage(raw) {
+		return raw, from, true
+	}
+	m, err := stun.Decode(raw)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	switch m.Type {
+	case stun.TypeBindingRequest:
+		a.handleRequest(m, raw, local, from)
+	case stun.TypeBindingSuccess:
+		tx, ok := a.pending[m.TxID]
+		if !ok || !stun.CheckIntegrity(raw, []byte(a.remotePwd)) {
+			break
+		}
+		delete(a.pending, m.TxID)
+		if tx.consent {
+			a.consentOK = time.Now()
+			break
+		}
+		tx.pair.succeeded = true
+		if a.firstOK.IsZero() {
+			a.firstOK = time.Now()
+		}
+		if tx.nominate || (!a.cfg.Controlling && tx.pair.nominated) {
+			a.selectPair(tx.pair)
+		}
+	}
+	return nil, nil, false
+}
+
+// handleRequest answers a peer's check and, per RFC 8445 §7.3.1, triggers a
+// check of our own on the same pair; USE-CANDIDATE from a controlling peer
+// nominates it. Once a pair is selected the peer's checks are consent
+// checks, which only need the answer.
+func (a *Agent) handleRequest(m *stun.Message, raw []byte, local Candidate, from *net.UDPAddr) {
+	user, _ := m.Get(stun.AttrUsername)
+	if len(user) <= len(a.ufrag) || string(user[:len(a.ufrag)+1]) != a.ufrag+":" {
+		return
+	}
+	if !stun.CheckIntegrity(raw, []byte(a.pwd)) {
+		return
+	}
+
+	res := &stun.Message{Type: stun.TypeBindingSuccess, TxID: m.TxID}
+	res.AddAddress(stun.AttrXORMappedAddress, from)
+	_ = a.write(local, from, res.Encode([]byte(a.pwd), true))
+
+	if a.selected != nil {
+		return
+	}
+
+	remote := Candidate{Component: 1, Addr: from, Type: PeerReflexive}
+	if val, ok := m.Get(stun.AttrPriority); ok && len(val) == 4 {
+		remote.Priority = uint32(val[0])<<24 | uint32(val[1])<<16 | uint32(val[2])<<8 | uint32(val[3])
+	}
+	ps := a.find(local, from)
+	if ps == nil {
+		if ps = a.add(Pair{Local: local, Remote: remote}); ps == nil {
+			return
+		}
+	}
+	if _, ok := m.Get(stun.AttrUseCandidate); ok && !a.cfg.Controlling {
+		ps.nominated = true
+		if ps.succeeded {
+			a.selectPair(ps)
+			return
+		}
+	}
+	if !ps.succeeded {
+		a.triggered = append(a.triggered, ps)
+	}
+}
+
+// selectPair makes ps the pair media goes over and wakes Connect.
+func (a *Agent) selectPair(ps *pairState) {
+	if a.selected != nil {
+		return
+	}
+	a.selected = ps
+	close(a.done)
+}
+
+// find returns the pair with the given local candidate and remote address.
+func (a *Agent) find(local Candidate, from *net.UDPAddr) *pairState {
+	for _, ps := range a.pairs {
+		if ps.Local.Addr.String() == local.Addr.String() && ps.Remote.Addr.String() == from.String() {
+			return ps
+		}
+	}
+	return nil
+}
+
+// write sends b to peer from local, through the relay for relayed candidates.
+func (a *Agent) write(local Candidate, peer *net.UDPAddr, b []byte) error {
+	if local.Type != Relayed || a.relay == nil {
+		_, err := a.conn.WriteTo(b, peer)
+		return err
+	}
+	if _, ok := a.permissions[peer.IP.String()]; !ok {
+		a.permissions[peer.IP.String()] = peer
+		_ = a.relay.CreatePermission(peer)
+	}
+	return a.relay.Send(peer, b)
+}
+
+// hostFor returns the host candidate a datagram from peer arrived on: the one
+// of the same address family. A wildcard socket cannot tell which interface
+// address was used, so the first match stands in.
+func (a *Agent) hostFor(peer *net.UDPAddr) Candidate {
+	v4 := peer.IP.To4() != nil
+	for _, c := range a.local {
+		if c.Type == Host && (c.Addr.IP.To4() != nil) == v4 {
+			return c
+		}
+	}
+	return a.local[0]
+}
+
+// relayCandidate returns the relayed candidate.
+func (a *Agent) relayCandidate() Candidate {
+	for _, c := range a.local {
+		if c.Type == Relayed {
+			return c
+		}
+	}
+	return Candidate{}
+}
+
+func putUint32(b []byte, v uint32) {
+	b[0], b[1], b[2], b[3] = byte(v>>24), byte(v>>16), byte(v>>8), byte(v)
+}
+
+// iceChars is the ice-char alphabet (RFC 8839 §5.4).
+const iceChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	for i := range b {
+		b[i] = iceChars[int(b[i])%len(iceChars)]
+	}
+	return string(b)
+}
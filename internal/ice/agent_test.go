@@ -0,0 +1,126 @@
+package ice
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// newLoopbackAgent gathers on a loopback socket, then reads it the way a
+// media socket is read: everything goes through Handle, and what it gives
+// back as media is passed on to the returned channel.
+func newLoopbackAgent(t *testing.T, controlling bool) (*Agent, []Candidate, <-chan []byte) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	a := NewAgent(conn, Config{Controlling: controlling})
+	cands, err := a.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+
+	media := make(chan []byte, 16)
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if payload, _, ok := a.Handle(buf[:n], from.(*net.UDPAddr)); ok {
+				select {
+				case media <- bytes.Clone(payload):
+				default:
+				}
+			}
+		}
+	}()
+	return a, cands, media
+}
+
+func TestConnect(t *testing.T) {
+	offerer, offerCands, _ := newLoopbackAgent(t, true)
+	answerer, answerCands, answererMedia := newLoopbackAgent(t, false)
+
+	if _, err := offerer.Send([]byte("early")); err != ErrNoPair {
+		t.Errorf("Send before selection: err = %v, want ErrNoPair", err)
+	}
+	if len(offerCands) != 1 || offerCands[0].Type != Host {
+		t.Fatalf("candidates = %v, want one host candidate", offerCands)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	type result struct {
+		pair Pair
+		err  error
+	}
+	answered := make(chan result, 1)
+	go func() {
+		ufrag, pwd := offerer.Credentials()
+		p, err := answerer.Connect(ctx, ufrag, pwd, offerCands)
+		answered <- result{p, err}
+	}()
+
+	ufrag, pwd := answerer.Credentials()
+	got, err := offerer.Connect(ctx, ufrag, pwd, answerCands)
+	if err != nil {
+		t.Fatalf("controlling Connect: %v", err)
+	}
+	if got.Remote.Addr.String() != answerCands[0].Addr.String() {
+		t.Errorf("controlling selected %s, want remote %s", got, answerCands[0].Addr)
+	}
+
+	r := <-answered
+	if r.err != nil {
+		t.Fatalf("controlled Connect: %v", r.err)
+	}
+	if r.pair.Remote.Addr.String() != offerCands[0].Addr.String() {
+		t.Errorf("controlled selected %s, want remote %s", r.pair, offerCands[0].Addr)
+	}
+
+	// Media goes over the selected pair and comes out of Handle.
+	if _, err := offerer.Send([]byte("rtp")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	select {
+	case got := <-answererMedia:
+		if string(got) != "rtp" {
+			t.Errorf("media = %q, want %q", got, "rtp")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("media sent on the selected pair was not received")
+	}
+}
+
+func TestConnectWrongPassword(t *testing.T) {
+	offerer, offerCands, _ := newLoopbackAgent(t, true)
+	answerer, answerCands, _ := newLoopbackAgent(t, false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	go func() {
+		ufrag, pwd := offerer.Credentials()
+		_, _ = answerer.Connect(ctx, ufrag, pwd, offerCands)
+	}()
+
+	ufrag, _ := answerer.Credentials()
+	if _, err := offerer.Connect(ctx, ufrag, "not-the-password", answerCands); err == nil {
+		t.Fatal("expected Connect to fail with the wrong remote password")
+	}
+}
+
+func TestConnectNoPairs(t *testing.T) {
+	a, _, _ := newLoopbackAgent(t, true)
+	v6 := Candidate{Component: 1, Addr: &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5000}, Type: Host}
+	if _, err := a.Connect(context.Background(), "u", "p", []Candidate{v6}); err == nil {
+		t.Fatal("expected error when no pair shares an address family")
+	}
+}
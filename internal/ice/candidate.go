@@ -0,0 +1,169 @@
+// Package ice implements an ICE agent (RFC 8445) for a single RTP
+// component: candidate gathering (host, server-reflexive via STUN, relayed
+// via TURN), connectivity checks and nomination of the pair media will use.
+package ice
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// CandidateType is the kind of a candidate.
+type CandidateType string
+
+const (
+	Host            CandidateType = "host"
+	ServerReflexive CandidateType = "srflx"
+	PeerReflexive   CandidateType = "prflx"
+	Relayed         CandidateType = "relay"
+)
+
+// typePreference is the recommended type preference (RFC 8445 §5.1.2.2).
+func (t CandidateType) typePreference() uint32 {
+	switch t {
+	case Host:
+		return 126
+	case PeerReflexive:
+		return 110
+	case ServerReflexive:
+		return 100
+	}
+	return 0
+}
+
+// Candidate is a transport address an agent can be reached at.
+type Candidate struct {
+	Foundation string
+	Component  int
+	Priority   uint32
+	Addr       *net.UDPAddr
+	Type       CandidateType
+	Related    *net.UDPAddr // base of a reflexive or relayed candidate (raddr/rport)
+}
+
+// Priority computes a candidate priority (RFC 8445 §5.1.2.1).
+func Priority(typ CandidateType, localPref uint32, component int) uint32 {
+	return typ.typePreference()<<24 | (localPref&0xffff)<<8 | uint32(256-component)
+}
+
+// newCandidate fills in priority and foundation. The foundation groups
+// candidates of the same type from the same base and server.
+func newCandidate(typ CandidateType, addr, base *net.UDPAddr, server string) Candidate {
+	localPref := uint32(65535)
+	if addr.IP.To4() == nil {
+		localPref = 65534 // prefer IPv4 slightly; many PBXs are v4-only
+	}
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s|%s|%s", typ, base.IP, server)
+	c := Candidate{
+		Foundation: strconv.FormatUint(uint64(h.Sum32()), 10),
+		Component:  1,
+		Priority:   Priority(typ, localPref, 1),
+		Addr:       addr,
+		Type:       typ,
+	}
+	if typ != Host {
+		c.Related = base
+	}
+	return c
+}
+
+// String formats the candidate as the value of an SDP a=candidate attribute
+// (RFC 8839 §5.1).
+func (c Candidate) String() string {
+	s := fmt.Sprintf("%s %d UDP %d %s %d typ %s", c.Foundation, c.Component, c.Priority, c.Addr.IP, c.Addr.Port, c.Type)
+	if c.Related != nil {
+		s += fmt.Sprintf(" raddr %s rport %d", c.Related.IP, c.Related.Port)
+	}
+	return s
+}
+
+// Label is a short human-readable form, e.g. "srflx 203.0.113.7:40000".
+func (c Candidate) Label() string {
+	return fmt.Sprintf("%s %s", c.Type, c.Addr)
+}
+
+// ParseCandidate parses the value of an a=candidate attribute. Only UDP
+// candidates are supported.
+func ParseCandidate(val string) (Candidate, error) {
+	fields := strings.Fields(strings.TrimPrefix(val, "candidate:"))
+	if len(fields) < 8 || fields[6] != "typ" {
+		return Candidate{}, fmt.Errorf("ice: malformed candidate %q", val)
+	}
+	if !strings.EqualFold(fields[2], "udp") {
+		return Candidate{}, fmt.Errorf("ice: unsupported candidate transport %q", fields[2])
+	}
+	component, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return Candidate{}, fmt.Errorf("ice: bad component in %q", val)
+	}
+	prio, err := strconv.ParseUint(fields[3], 10, 32)
+	if err != nil {
+		return Candidate{}, fmt.Errorf("ice: bad priority in %q", val)
+	}
+	addr, err := udpAddr(fields[4], fields[5])
+	if err != nil {
+		return Candidate{}, fmt.Errorf("ice: bad address in %q", val)
+	}
+	c := Candidate{
+		Foundation: fields[0],
+		Component:  component,
+		Priority:   uint32(prio),
+		Addr:       addr,
+		Type:       CandidateType(fields[7]),
+	}
+
+	// Extension attributes come in name/value pairs.
+	var raddr, rport string
+	for i := 8; i+1 < len(fields); i += 2 {
+		switch fields[i] {
+		case "raddr":
+			raddr = fields[i+1]
+		case "rport":
+			rport = fields[i+1]
+		}
+	}
+	if raddr != "" && rport != "" {
+		c.Related, _ = udpAddr(raddr, rport)
+	}
+	return c, nil
+}
+
+func udpAddr(ip, port string) (*net.UDPAddr, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, fmt.Errorf("bad IP %q", ip)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 0 || p > 65535 {
+		return nil, fmt.Errorf("bad port %q", port)
+	}
+	return &net.UDPAddr{IP: addr, Port: p}, nil
+}
+
+// Pair is a local/remote candidate pair.
+type Pair struct {
+	Local  Candidate
+	Remote Candidate
+}
+
+// priority computes the pair priority (RFC 8445 §6.1.2.3).
+func (p Pair) priority(controlling bool) uint64 {
+	g, d := uint64(p.Local.Priority), uint64(p.Remote.Priority)
+	if !controlling {
+		g, d = d, g
+	}
+	prio := 1<<32*min(g, d) + 2*max(g, d)
+	if g > d {
+		prio++
+	}
+	return prio
+}
+
+// String renders the pair as "local -> remote".
+func (p Pair) String() string {
+	return p.Local.Label() + " -> " + p.Remote.Label()
+}
@@ -0,0 +1,72 @@
+package ice
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPriority(t *testing.T) {
+	// RFC 8445 §5.1.2.1 with the recommended type preferences.
+	if got, want := Priority(Host, 65535, 1), uint32(2130706431); got != want {
+		t.Errorf("Priority(host) = %d, want %d", got, want)
+	}
+	if got, want := Priority(Relayed, 65535, 1), uint32(16777215); got != want {
+		t.Errorf("Priority(relay) = %d, want %d", got, want)
+	}
+	if Priority(ServerReflexive, 65535, 1) >= Priority(PeerReflexive, 65535, 1) {
+		t.Error("srflx should rank below prflx")
+	}
+}
+
+func TestCandidateRoundTrip(t *testing.T) {
+	base := &net.UDPAddr{IP: net.ParseIP("192.168.1.10"), Port: 40000}
+	mapped := &net.UDPAddr{IP: net.ParseIP("203.0.113.7"), Port: 61000}
+	c := newCandidate(ServerReflexive, mapped, base, "stun.example.com")
+
+	got, err := ParseCandidate(c.String())
+	if err != nil {
+		t.Fatalf("ParseCandidate(%q): %v", c.String(), err)
+	}
+	if got.Foundation != c.Foundation || got.Priority != c.Priority || got.Type != ServerReflexive {
+		t.Errorf("parsed = %+v, want %+v", got, c)
+	}
+	if got.Addr.String() != mapped.String() || got.Related.String() != base.String() {
+		t.Errorf("addresses = %s / %s, want %s / %s", got.Addr, got.Related, mapped, base)
+	}
+}
+
+func TestParseCandidate(t *testing.T) {
+	c, err := ParseCandidate("candidate:1467250027 1 udp 2122260223 192.168.0.196 46243 typ host generation 0")
+	if err != nil {
+		t.Fatalf("ParseCandidate: %v", err)
+	}
+	if c.Component != 1 || c.Priority != 2122260223 || c.Type != Host || c.Addr.String() != "192.168.0.196:46243" {
+		t.Errorf("parsed = %+v", c)
+	}
+	if c.Related != nil {
+		t.Errorf("Related = %s, want nil", c.Related)
+	}
+
+	for _, bad := range []string{
+		"1 1 UDP 2130706431 10.0.0.1 5000",           // no typ
+		"1 1 TCP 2130706431 10.0.0.1 5000 typ host",  // TCP
+		"1 1 UDP 2130706431 not-an-ip 5000 typ host", // bad address
+		"1 x UDP 2130706431 10.0.0.1 5000 typ host",  // bad component
+	} {
+		if _, err := ParseCandidate(bad); err == nil {
+			t.Errorf("ParseCandidate(%q): expected error", bad)
+		}
+	}
+}
+
+func TestPairPriority(t *testing.T) {
+	p := Pair{
+		Local:  Candidate{Priority: Priority(Host, 65535, 1)},
+		Remote: Candidate{Priority: Priority(Relayed, 65535, 1)},
+	}
+	// Both agents must compute the same priority for the same pair.
+	mirrored := Pair{Local: p.Remote, Remote: p.Local}
+	if p.priority(true) != mirrored.priority(false) {
+		t.Errorf("controlling %d != controlled %d", p.priority(true), mirrored.priority(false))
+	}
+}
@@ -0,0 +1,141 @@
+// Package impair degrades packet streams on purpose, the way a bad network
+// would: it drops packets at random or in bursts (the Gilbert model),
+// delays them, adds jitter, reorders and duplicates them. It lets siptty
+// reproduce network problems without tc/netem.
+package impair
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// reorderHold is how much longer a reordered packet is held than the
+// others: two packets at the usual 20 ms ptime.
+const reorderHold = 40 * time.Millisecond
+
+// Profile describes what is done to a stream. The zero Profile leaves it
+// alone.
+type Profile struct {
+	Loss      float64       // percent of packets dropped
+	Burst     float64       // mean length of a loss burst, in packets; above 1 selects the Gilbert model
+	Delay     time.Duration // added to every packet
+	Jitter    time.Duration // up to this much more, at random
+	Reorder   float64       // percent of packets held back behind later ones
+	Duplicate float64       // percent of packets delivered twice
+}
+
+// Active reports whether p does anything to a stream.
+func (p Profile) Active() bool {
+	return p != Profile{}
+}
+
+// Counters tell what a Model did so far.
+type Counters struct {
+	Packets    uint64 // packets seen
+	Dropped    uint64
+	Duplicated uint64
+	Reordered  uint64
+}
+
+// Model decides the fate of each packet of a stream. It is safe for
+// concurrent use.
+type Model struct {
+	mu       sync.Mutex
+	profile  Profile
+	rng      *rand.Rand
+	bad      bool    // Gilbert model: in a loss burst
+	enter    float64 // Gilbert model: chance to start a burst
+	leave    float64 // Gilbert model: chance to end a burst
+	counters Counters
+}
+
+// NewModel returns a Model applying p.
+func NewModel(p Profile) *Model {
+	m := &Model{rng: rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))}
+	m.SetProfile(p)
+	return m
+}
+
+// SetProfile changes what the Model does from the next packet on. The
+// counters carry on.
+func (m *Model) SetProfile(p Profile) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.profile = p
+	m.bad = false
+
+	// A burst ends with chance 1/Burst per packet, so bursts last Burst
+	// packets on average; bursts start often enough to lose Loss percent
+	// overall.
+	loss := min(max(p.Loss, 0), 100) / 100
+	m.leave, m.enter = 1, 0
+	if p.Burst > 1 && loss < 1 {
+		m.leave = 1 / p.Burst
+		m.enter = min(m.leave*loss/(1-loss), 1)
+	}
+}
+
+// Profile returns what the Model does.
+func (m *Model) Profile() Profile {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.profile
+}
+
+// Counters returns what the Model did so far.
+func (m *Model) Counters() Counters {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counters
+}
+
+// Next decides the fate of the next packet: the delays after which copies
+// of it are delivered. There are none when it is dropped and two when it
+// is duplicated.
+func (m *Model) Next() []time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.profile
+	m.counters.Packets++
+	if m.lose() {
+		m.counters.Dropped++
+		return nil
+	}
+	delays := []time.Duration{m.delay()}
+	if m.chance(p.Reorder) {
+		m.counters.Reordered++
+		delays[0] += reorderHold
+	}
+	if m.chance(p.Duplicate) {
+		m.counters.Duplicated++
+		delays = append(delays, m.delay())
+	}
+	return delays
+}
+
+// lose decides whether the next packet is dropped.
+func (m *Model) lose() bool {
+	if m.profile.Burst <= 1 {
+		return m.chance(m.profile.Loss)
+	}
+	if m.bad {
+		m.bad = m.rng.Float64() >= m.leave
+	} else {
+		m.bad = m.rng.Float64() < m.enter
+	}
+	return m.bad
+}
+
+func (m *Model) delay() time.Duration {
+	d := m.profile.Delay
+	if m.profile.Jitter > 0 {
+		d += time.Duration(m.rng.Int64N(int64(m.profile.Jitter) + 1))
+	}
+	return d
+}
+
+// chance returns true with the given chance, in percent.
+func (m *Model) chance(percent float64) bool {
+	return percent > 0 && m.rng.Float64()*100 < percent
+}
@@ -0,0 +1,82 @@
+package impair
+
+import (
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+// seeded returns a Model with a fixed random sequence.
+func seeded(p Profile) *Model {
+	m := NewModel(p)
+	m.rng = rand.New(rand.NewPCG(1, 2))
+	return m
+}
+
+func TestModelRandomLoss(t *testing.T) {
+	m := seeded(Profile{Loss: 10, Delay: 50 * time.Millisecond, Jitter: 10 * time.Millisecond})
+	for range 10000 {
+		for _, d := range m.Next() {
+			if d < 50*time.Millisecond || d > 60*time.Millisecond {
+				t.Fatalf("delay %v outside 50-60ms", d)
+			}
+		}
+	}
+	c := m.Counters()
+	if c.Packets != 10000 || c.Dropped < 900 || c.Dropped > 1100 {
+		t.Errorf("dropped %d of %d packets, want about 1000", c.Dropped, c.Packets)
+	}
+	if c.Duplicated != 0 || c.Reordered != 0 {
+		t.Errorf("counters = %+v", c)
+	}
+}
+
+func TestModelBurstLoss(t *testing.T) {
+	m := seeded(Profile{Loss: 5, Burst: 4})
+	var bursts, run int
+	for range 100000 {
+		if m.Next() == nil {
+			run++
+			continue
+		}
+		if run > 0 {
+			bursts++
+		}
+		run = 0
+	}
+	c := m.Counters()
+	if c.Dropped < 4500 || c.Dropped > 5500 {
+		t.Errorf("dropped %d of %d packets, want about 5000", c.Dropped, c.Packets)
+	}
+	if mean := float64(c.Dropped) / float64(bursts); mean < 3.5 || mean > 4.5 {
+		t.Errorf("mean burst %.1f packets, want about 4", mean)
+	}
+}
+
+func TestModelReorderDuplicate(t *testing.T) {
+	m := seeded(Profile{Reorder: 20, Duplicate: 10})
+	var held, copies int
+	for range 10000 {
+		delays := m.Next()
+		copies += len(delays)
+		if delays[0] == reorderHold {
+			held++
+		}
+	}
+	c := m.Counters()
+	if c.Dropped != 0 || int(c.Reordered) != held || held < 1800 || held > 2200 {
+		t.Errorf("reordered %d (%d held), want about 2000", c.Reordered, held)
+	}
+	if int(c.Duplicated) != copies-10000 || c.Duplicated < 900 || c.Duplicated > 1100 {
+		t.Errorf("duplicated %d (%d copies), want about 1000", c.Duplicated, copies)
+	}
+
+	// Without a profile every packet goes through untouched.
+	m.SetProfile(Profile{})
+	if d := m.Next(); len(d) != 1 || d[0] != 0 {
+		t.Errorf("Next() without profile = %v", d)
+	}
+	if m.Counters().Packets != 10001 {
+		t.Errorf("counters reset by SetProfile: %+v", m.Counters())
+	}
+}
@@ -0,0 +1,125 @@
+package impair
+
+import (
+	"container/heap"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Shaper passes the packets of a stream through a Model, delivering each
+// one when its delay is up. Packets without delay are delivered at once.
+type Shaper struct {
+	model   *Model
+	deliver func(pkt []byte, from net.Addr, at time.Time)
+
+	mu     sync.Mutex
+	queue  packetQueue
+	seq    uint64 // keeps packets due at the same time in order
+	timer  *time.Timer
+	closed bool
+
+	flushMu sync.Mutex // one flush at a time, so deliveries stay in order
+}
+
+// NewShaper returns a Shaper that hands the packets m lets through to
+// deliver, with where they came from and the time they arrive. deliver may
+// be called from the goroutine calling Send or from the Shaper's own.
+func NewShaper(m *Model, deliver func(pkt []byte, from net.Addr, at time.Time)) *Shaper {
+	return &Shaper{model: m, deliver: deliver}
+}
+
+// Model returns the Model the Shaper applies.
+func (s *Shaper) Model() *Model {
+	return s.model
+}
+
+// Send passes on a packet from the given address that arrived at the given
+// time. pkt is copied if it is held.
+func (s *Shaper) Send(pkt []byte, from net.Addr, at time.Time) {
+	for _, d := range s.model.Next() {
+		if d <= 0 {
+			s.deliver(pkt, from, at)
+			continue
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		s.seq++
+		heap.Push(&s.queue, heldPacket{due: at.Add(d), seq: s.seq, pkt: slices.Clone(pkt), from: from})
+		s.schedule()
+		s.mu.Unlock()
+	}
+}
+
+// Close drops the packets still held.
+func (s *Shaper) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.queue = nil
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// schedule sets the timer for the first packet due. s.mu must be held.
+func (s *Shaper) schedule() {
+	if len(s.queue) == 0 {
+		return
+	}
+	wait := time.Until(s.queue[0].due)
+	if s.timer == nil {
+		s.timer = time.AfterFunc(wait, s.flush)
+	} else {
+		s.timer.Reset(wait)
+	}
+}
+
+// flush delivers the packets that are due.
+func (s *Shaper) flush() {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	now := time.Now()
+	var due []heldPacket
+	s.mu.Lock()
+	for len(s.queue) > 0 && !s.queue[0].due.After(now) {
+		due = append(due, heap.Pop(&s.queue).(heldPacket))
+	}
+	s.schedule()
+	s.mu.Unlock()
+
+	for _, p := range due {
+		s.deliver(p.pkt, p.from, p.due)
+	}
+}
+
+type heldPacket struct {
+	due  time.Time
+	seq  uint64
+	pkt  []byte
+	from net.Addr
+}
+
+// packetQueue is a heap of held packets, the first due on top.
+type packetQueue []heldPacket
+
+func (q packetQueue) Len() int { return len(q) }
+func (q packetQueue) Less(i, j int) bool {
+	if q[i].due.Equal(q[j].due) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].due.Before(q[j].due)
+}
+func (q packetQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *packetQueue) Push(x any)   { *q = append(*q, x.(heldPacket)) }
+func (q *packetQueue) Pop() any {
+	old := *q
+	p := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return p
+}
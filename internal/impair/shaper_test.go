@@ -0,0 +1,63 @@
+package impair
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShaper(t *testing.T) {
+	var mu sync.Mutex
+	var got []byte
+	var at []time.Time
+	done := make(chan struct{})
+	s := NewShaper(seeded(Profile{Delay: 20 * time.Millisecond}), func(pkt []byte, _ net.Addr, t time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, pkt[0])
+		at = append(at, t)
+		if len(got) == 3 {
+			close(done)
+		}
+	})
+
+	t0 := time.Now()
+	buf := make([]byte, 1)
+	for i := range 3 {
+		buf[0] = byte(i) // the buffer is reused, as a socket reader would
+		s.Send(buf, nil, t0)
+	}
+	mu.Lock()
+	if len(got) != 0 {
+		t.Errorf("delivered %v before the delay", got)
+	}
+	mu.Unlock()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("packets not delivered")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if string(got) != "\x00\x01\x02" {
+		t.Errorf("delivered %v, want 0 1 2", got)
+	}
+	for _, a := range at {
+		if !a.Equal(t0.Add(20 * time.Millisecond)) {
+			t.Errorf("arrival %v, want %v", a.Sub(t0), 20*time.Millisecond)
+		}
+	}
+	s.Close()
+	s.Send(buf, nil, t0) // dropped once closed
+}
+
+func TestShaperPassThrough(t *testing.T) {
+	var n int
+	s := NewShaper(NewModel(Profile{}), func([]byte, net.Addr, time.Time) { n++ })
+	s.Send([]byte{1}, nil, time.Now())
+	if n != 1 {
+		t.Errorf("packet without delay not delivered at once")
+	}
+}
@@ -0,0 +1,241 @@
+// Package ladder lays out SIP messages as a call-flow (ladder) diagram: one
+// column per network address and one arrow per message, as in sngrep.
+package ladder
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/siptty/siptty/internal/engine"
+)
+
+const (
+	minColumnWidth = 24
+	maxColumnWidth = 44
+	timeWidth      = len("15:04:05.000 ")
+)
+
+// Arrow is one message in the diagram, from column From to column To.
+type Arrow struct {
+	From, To int
+	Label    string
+	Event    engine.SipTraceEvent
+}
+
+// Diagram is a laid-out call flow.
+type Diagram struct {
+	Participants []string // addresses, in order of first appearance
+	Arrows       []Arrow
+
+	colWidth int
+}
+
+// Build lays out events (in capture order) as a diagram. DNS notes are
+// skipped. A message sent goes from its local to its remote address; a
+// message received, the other way.
+func Build(events []engine.SipTraceEvent) *Diagram {
+	d := &Diagram{}
+	index := make(map[string]int)
+	column := func(addr string) int {
+		if addr == "" {
+			addr = "?"
+		}
+		i, ok := index[addr]
+		if !ok {
+			i = len(d.Participants)
+			index[addr] = i
+			d.Participants = append(d.Participants, addr)
+		}
+		return i
+	}
+
+	longest := 0
+	for _, ev := range events {
+		var from, to int
+		switch ev.Direction {
+		case "send":
+			from, to = column(ev.LocalAddr), column(ev.RemoteAddr)
+		case "recv":
+			from, to = column(ev.RemoteAddr), column(ev.LocalAddr)
+		default:
+			continue
+		}
+		label := Label(ev)
+		longest = max(longest, utf8.RuneCountInString(label))
+		d.Arrows = append(d.Arrows, Arrow{From: from, To: to, Label: label, Event: ev})
+	}
+	for _, p := range d.Participants {
+		longest = max(longest, utf8.RuneCountInString(p))
+	}
+	d.colWidth = min(max(longest+8, minColumnWidth), maxColumnWidth)
+	return d
+}
+
+// Label returns the arrow label for a message: the method and Request-URI
+// of a request, or the status and reason of a response.
+func Label(ev engine.SipTraceEvent) string {
+	line, _, _ := strings.Cut(ev.Message, "\n")
+	line = strings.TrimSpace(line)
+	if rest, ok := strings.CutPrefix(line, "SIP/2.0 "); ok {
+		return rest
+	}
+	return strings.TrimSuffix(line, " SIP/2.0")
+}
+
+// pos returns the text column of participant i's lifeline.
+func (d *Diagram) pos(i int) int {
+	return timeWidth + i*d.colWidth + d.colWidth/2
+}
+
+func (d *Diagram) width() int {
+	return timeWidth + len(d.Participants)*d.colWidth
+}
+
+// lifelines returns an empty row with a '|' on every lifeline.
+func (d *Diagram) lifelines() []rune {
+	row := []rune(strings.Repeat(" ", d.width()))
+	for i := range d.Participants {
+		row[d.pos(i)] = '|'
+	}
+	return row
+}
+
+// Header returns the participant names centered over their lifelines,
+// followed by a row of bare lifelines.
+func (d *Diagram) Header() []string {
+	row := []rune(strings.Repeat(" ", d.width()))
+	for i, p := range d.Participants {
+		name := []rune(truncate(p, d.colWidth-2))
+		start := max(d.pos(i)-len(name)/2, 0)
+		copy(row[start:], name)
+	}
+	return []string{strings.TrimRight(string(row), " "), strings.TrimRight(string(d.lifelines()), " ")}
+}
+
+// ArrowLine renders arrow i with its timestamp. When delta is set, the time
+// is the offset from the first arrow instead of the wall clock.
+func (d *Diagram) ArrowLine(i int, delta bool) string {
+	a := d.Arrows[i]
+	row := d.lifelines()
+
+	ts := a.Event.Timestamp.Format("15:04:05.000")
+	if delta {
+		ts = "+" + formatDelta(a.Event.Timestamp.Sub(d.Arrows[0].Event.Timestamp))
+	}
+	copy(row, []rune(ts))
+
+	l, r := d.pos(min(a.From, a.To)), d.pos(max(a.From, a.To))
+	if l == r {
+		// A message to the same address loops back on its lifeline.
+		label := []rune("<-' " + truncate(a.Label, d.colWidth-4))
+		copy(row[l+1:], label[:min(len(label), len(row)-l-1)])
+		return strings.TrimRight(string(row), " ")
+	}
+	for x := l + 1; x < r; x++ {
+		row[x] = '-'
+	}
+	if a.From < a.To {
+		row[r-1] = '>'
+	} else {
+		row[l+1] = '<'
+	}
+	label := []rune(" " + truncate(a.Label, r-l-7) + " ")
+	copy(row[l+1+(r-l-1-len(label))/2:], label)
+	return strings.TrimRight(string(row), " ")
+}
+
+// Text renders the whole diagram as plain text.
+func (d *Diagram) Text(delta bool) string {
+	var b strings.Builder
+	for _, line := range d.Header() {
+		b.WriteString(line + "\n")
+	}
+	for i := range d.Arrows {
+		b.WriteString(d.ArrowLine(i, delta) + "\n")
+	}
+	return b.String()
+}
+
+// Mermaid renders the diagram as a Mermaid sequenceDiagram. When delta is
+// set, each label starts with its offset from the first arrow.
+func (d *Diagram) Mermaid(delta bool) string {
+	esc := strings.NewReplacer("#", "#35;", ";", "#59;") // ';' ends a Mermaid statement
+	var b strings.Builder
+	b.WriteString("sequenceDiagram\n")
+	for i, p := range d.Participants {
+		fmt.Fprintf(&b, "    participant P%d as %s\n", i+1, esc.Replace(p))
+	}
+	for i, a := range d.Arrows {
+		fmt.Fprintf(&b, "    P%d->>P%d: %s\n", a.From+1, a.To+1, esc.Replace(d.label(i, delta)))
+	}
+	return b.String()
+}
+
+// PlantUML renders the diagram as a PlantUML sequence diagram. When delta
+// is set, each label starts with its offset from the first arrow.
+func (d *Diagram) PlantUML(delta bool) string {
+	var b strings.Builder
+	b.WriteString("@startuml\n")
+	for i, p := range d.Participants {
+		fmt.Fprintf(&b, "participant \"%s\" as P%d\n", strings.ReplaceAll(p, `"`, `'`), i+1)
+	}
+	for i, a := range d.Arrows {
+		fmt.Fprintf(&b, "P%d -> P%d : %s\n", a.From+1, a.To+1, d.label(i, delta))
+	}
+	b.WriteString("@enduml\n")
+	return b.String()
+}
+
+// label returns arrow i's label, prefixed with its offset when delta is set.
+func (d *Diagram) label(i int, delta bool) string {
+	if !delta {
+		return d.Arrows[i].Label
+	}
+	return "+" + formatDelta(d.Arrows[i].Event.Timestamp.Sub(d.Arrows[0].Event.Timestamp)) + " " + d.Arrows[i].Label
+}
+
+// Render renders the diagram in format: "mermaid", "plantuml" or "text".
+func (d *Diagram) Render(format string, delta bool) string {
+	switch format {
+	case "mermaid":
+		return d.Mermaid(delta)
+	case "plantuml":
+		return d.PlantUML(delta)
+	default:
+		return d.Text(delta)
+	}
+}
+
+// FormatForPath returns the format a file name's extension asks for:
+// "mermaid" for .mmd and .mermaid, "plantuml" for .puml, .plantuml and .pu,
+// and "text" otherwise.
+func FormatForPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mmd", ".mermaid":
+		return "mermaid"
+	case ".puml", ".plantuml", ".pu":
+		return "plantuml"
+	default:
+		return "text"
+	}
+}
+
+// truncate shortens s to n runes, marking the cut with "…".
+func truncate(s string, n int) string {
+	if n < 1 {
+		return ""
+	}
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// formatDelta formats an offset as seconds with milliseconds, e.g. "1.250s".
+func formatDelta(d time.Duration) string {
+	return d.Truncate(time.Millisecond).String()
+}
@@ -0,0 +1,107 @@
+package ladder
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/siptty/siptty/internal/engine"
+)
+
+var t0 = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func ev(dir, msg string, after time.Duration) engine.SipTraceEvent {
+	return engine.SipTraceEvent{
+		Timestamp:  t0.Add(after),
+		Direction:  dir,
+		LocalAddr:  "192.0.2.1:5060",
+		RemoteAddr: "192.0.2.10:5060",
+		Message:    msg,
+	}
+}
+
+func TestBuild(t *testing.T) {
+	d := Build([]engine.SipTraceEvent{
+		ev("send", "INVITE sip:200@pbx SIP/2.0\r\nCall-ID: c1\r\n\r\n", 0),
+		{Direction: "dns", Message: "DNS pbx -> 192.0.2.10:5060 (A)"},
+		ev("recv", "SIP/2.0 180 Ringing\r\nCall-ID: c1\r\n\r\n", 250*time.Millisecond),
+	})
+	if got := strings.Join(d.Participants, " "); got != "192.0.2.1:5060 192.0.2.10:5060" {
+		t.Fatalf("participants = %s", got)
+	}
+	if len(d.Arrows) != 2 {
+		t.Fatalf("arrows = %d, want 2 (DNS note skipped)", len(d.Arrows))
+	}
+	if a := d.Arrows[0]; a.From != 0 || a.To != 1 || a.Label != "INVITE sip:200@pbx" {
+		t.Errorf("request arrow = %+v", a)
+	}
+	if a := d.Arrows[1]; a.From != 1 || a.To != 0 || a.Label != "180 Ringing" {
+		t.Errorf("response arrow = %+v", a)
+	}
+}
+
+func TestText(t *testing.T) {
+	d := Build([]engine.SipTraceEvent{
+		ev("send", "INVITE sip:200@pbx SIP/2.0\r\n\r\n", 0),
+		ev("recv", "SIP/2.0 200 OK\r\n\r\n", 1250*time.Millisecond),
+	})
+	lines := strings.Split(strings.TrimSuffix(d.Text(false), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Text() has %d lines:\n%s", len(lines), d.Text(false))
+	}
+	if !strings.Contains(lines[0], "192.0.2.1:5060") || !strings.Contains(lines[0], "192.0.2.10:5060") {
+		t.Errorf("header = %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[2], "12:00:00.000") || !strings.Contains(lines[2], " INVITE sip:200@pbx ") ||
+		!strings.Contains(lines[2], "->|") {
+		t.Errorf("request line = %q", lines[2])
+	}
+	if !strings.Contains(lines[3], "|<-") || !strings.Contains(lines[3], " 200 OK ") {
+		t.Errorf("response line = %q", lines[3])
+	}
+	if line := d.ArrowLine(1, true); !strings.HasPrefix(line, "+1.25s") {
+		t.Errorf("delta line = %q", line)
+	}
+
+	// Every lifeline sits in the same column on every row.
+	col := strings.IndexByte(lines[1], '|')
+	for _, l := range lines[2:] {
+		if l[col] != '|' {
+			t.Errorf("lifeline moved in %q", l)
+		}
+	}
+}
+
+func TestMermaidAndPlantUML(t *testing.T) {
+	d := Build([]engine.SipTraceEvent{
+		ev("send", "INVITE sip:200@pbx;user=phone SIP/2.0\r\n\r\n", 0),
+		ev("recv", "SIP/2.0 200 OK\r\n\r\n", 1250*time.Millisecond),
+	})
+	wantMermaid := "sequenceDiagram\n" +
+		"    participant P1 as 192.0.2.1:5060\n" +
+		"    participant P2 as 192.0.2.10:5060\n" +
+		"    P1->>P2: +0s INVITE sip:200@pbx#59;user=phone\n" +
+		"    P2->>P1: +1.25s 200 OK\n"
+	if got := d.Render("mermaid", true); got != wantMermaid {
+		t.Errorf("Mermaid =\n%s\nwant\n%s", got, wantMermaid)
+	}
+	wantPlantUML := "@startuml\n" +
+		"participant \"192.0.2.1:5060\" as P1\n" +
+		"participant \"192.0.2.10:5060\" as P2\n" +
+		"P1 -> P2 : INVITE sip:200@pbx;user=phone\n" +
+		"P2 -> P1 : 200 OK\n" +
+		"@enduml\n"
+	if got := d.Render("plantuml", false); got != wantPlantUML {
+		t.Errorf("PlantUML =\n%s\nwant\n%s", got, wantPlantUML)
+	}
+}
+
+func TestFormatForPath(t *testing.T) {
+	for path, want := range map[string]string{
+		"flow.mmd": "mermaid", "flow.PUML": "plantuml", "flow.pu": "plantuml", "flow.txt": "text", "flow": "text",
+	} {
+		if got := FormatForPath(path); got != want {
+			t.Errorf("FormatForPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Package offline loads SIP messages from capture files so the TUI can
+// inspect them without the engine: no listener is opened and no account
+// registers.
+package offline
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/siptty/siptty/internal/config"
+	"github.com/siptty/siptty/internal/engine"
+	"github.com/siptty/siptty/internal/pcap"
+)
+
+// maxStreamBuffer bounds the bytes buffered for a TCP stream while waiting
+// for the end of a SIP message.
+const maxStreamBuffer = 1 << 20
+
+var errOffline = errors.New("not available offline")
+
+// Load reads the SIP messages carried over UDP and TCP in a pcap or pcapng
+// file. Each becomes a "recv" trace event from its source (RemoteAddr) to
+// its destination (LocalAddr), in capture order.
+func Load(path string) ([]engine.SipTraceEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := pcap.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	type flow struct{ src, dst netip.AddrPort }
+	streams := make(map[flow][]byte)
+	var events []engine.SipTraceEvent
+	add := func(p pcap.Packet, transport string, msg []byte) {
+		events = append(events, engine.SipTraceEvent{
+			Direction:  "recv",
+			Message:    string(msg),
+			Timestamp:  p.Time,
+			Transport:  transport,
+			LocalAddr:  p.Dst.String(),
+			RemoteAddr: p.Src.String(),
+		})
+	}
+	for {
+		p, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return events, nil
+		}
+		if err != nil {
+			return events, fmt.Errorf("%s: %w", path, err)
+		}
+		if !p.TCP {
+			if msg := bytes.TrimLeft(p.Payload, "\r\n"); startsSIP(msg) {
+				add(p, "UDP", msg)
+			}
+			continue
+		}
+		key := flow{p.Src, p.Dst}
+		buf := append(streams[key], p.Payload...)
+		for {
+			var msg []byte
+			msg, buf = nextMessage(buf)
+			if msg == nil {
+				break
+			}
+			add(p, "TCP", msg)
+		}
+		if len(buf) > maxStreamBuffer {
+			buf = nil
+		}
+		streams[key] = buf
+	}
+}
+
+// nextMessage splits the first complete SIP message off a TCP stream. It
+// skips keepalives and any bytes before the next SIP start line, and
+// returns a nil message when more data is needed.
+func nextMessage(buf []byte) (msg, rest []byte) {
+	buf = bytes.TrimLeft(buf, "\r\n")
+	for !startsSIP(buf) {
+		i := bytes.Index(buf, []byte("\r\n"))
+		if i < 0 {
+			return nil, buf
+		}
+		buf = bytes.TrimLeft(buf[i:], "\r\n") // resynchronize on the next line
+	}
+	end := bytes.Index(buf, []byte("\r\n\r\n"))
+	if end < 0 {
+		return nil, buf
+	}
+	end += 4
+	n := contentLength(buf[:end])
+	if len(buf) < end+n {
+		return nil, buf
+	}
+	return buf[:end+n], buf[end+n:]
+}
+
+// startsSIP reports whether b begins with a SIP request or status line.
+func startsSIP(b []byte) bool {
+	line, _, ok := bytes.Cut(b, []byte("\r\n"))
+	if !ok {
+		return bytes.HasPrefix(b, []byte("SIP/2.0 ")) // more may come
+	}
+	return bytes.HasPrefix(line, []byte("SIP/2.0 ")) || bytes.HasSuffix(line, []byte(" SIP/2.0"))
+}
+
+// contentLength returns the Content-Length of a SIP header block, or 0.
+func contentLength(head []byte) int {
+	for _, line := range strings.Split(string(head), "\r\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		if strings.EqualFold(key, "Content-Length") || strings.EqualFold(key, "l") {
+			n, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || n < 0 {
+				return 0
+			}
+			return n
+		}
+	}
+	return 0
+}
+
+// Engine stands in for the engine in offline mode. It serves a trace store
+// filled from a capture and refuses call control.
+type Engine struct {
+	store *engine.TraceStore
+}
+
+// NewEngine returns an offline engine whose trace store holds events.
+func NewEngine(events []engine.SipTraceEvent) *Engine {
+	store := engine.NewTraceStore(len(events), 0)
+	for _, ev := range events {
+		store.Add(ev)
+	}
+	return &Engine{store: store}
+}
+
+// Events returns nil: nothing happens offline.
+func (e *Engine) Events() <-chan engine.Event { return nil }
+
+// Accounts returns no accounts.
+func (e *Engine) Accounts() []string { return nil }
+
+// Codecs returns no codecs, as there are no accounts.
+func (e *Engine) Codecs(string) []engine.Codec { return nil }
+
+// TraceStore returns the loaded messages.
+func (e *Engine) TraceStore() *engine.TraceStore { return e.store }
+
+// DialogState returns nil, as there are no calls.
+func (e *Engine) DialogState(string) *engine.DialogState { return nil }
+
+// RTPPackets returns no packets; RTP is not loaded from captures.
+func (e *Engine) RTPPackets(string) []engine.RTPPacket { return nil }
+
+// Call control is refused offline.
+
+func (e *Engine) Dial(accountID, uri string) error                             { return errOffline }
+func (e *Engine) Answer(callID string) error                                   { return errOffline }
+func (e *Engine) Hangup(callID string) error                                   { return errOffline }
+func (e *Engine) SendDTMF(callID string, digit rune) error                     { return errOffline }
+func (e *Engine) Transfer(callID, target string) error                         { return errOffline }
+func (e *Engine) PlayAudio(callID, path string) error                          { return errOffline }
+func (e *Engine) SetImpairment(callID string, c config.ImpairmentConfig) error { return errOffline }
+func (e *Engine) SetCodecs(accountID string, codecs []engine.Codec) error      { return errOffline }
@@ -0,0 +1,107 @@
+package offline
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/siptty/siptty/internal/engine"
+	"github.com/siptty/siptty/internal/pcap"
+)
+
+var (
+	alice = netip.MustParseAddrPort("192.0.2.1:5060")
+	pbx   = netip.MustParseAddrPort("192.0.2.10:5060")
+)
+
+const (
+	invite = "INVITE sip:bob@example.com SIP/2.0\r\nCall-ID: a1\r\nContent-Length: 4\r\n\r\nv=0\n"
+	ok     = "SIP/2.0 200 OK\r\nCall-ID: a1\r\nl: 0\r\n\r\n"
+)
+
+func writeCapture(t *testing.T, packets ...pcap.Packet) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := pcap.NewWriter(f, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range packets {
+		if err := w.WritePacket(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tcp := "\r\n\r\n" + invite + ok
+	path := writeCapture(t,
+		pcap.Packet{Time: at, Src: alice, Dst: pbx, Payload: []byte(invite)},
+		pcap.Packet{Time: at, Src: alice, Dst: pbx, Payload: []byte("not sip")},
+		pcap.Packet{Time: at, Src: pbx, Dst: alice, TCP: true, Payload: []byte(tcp[:30])},
+		pcap.Packet{Time: at.Add(time.Second), Src: pbx, Dst: alice, TCP: true, Payload: []byte(tcp[30:])},
+	)
+
+	events, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("loaded %d messages, want 3: %+v", len(events), events)
+	}
+	ev := events[0]
+	if ev.Message != invite || ev.Transport != "UDP" || ev.Direction != "recv" ||
+		ev.RemoteAddr != alice.String() || ev.LocalAddr != pbx.String() || !ev.Timestamp.Equal(at) {
+		t.Errorf("UDP message = %+v", ev)
+	}
+	if events[1].Message != invite || events[1].Transport != "TCP" || events[1].RemoteAddr != pbx.String() {
+		t.Errorf("first TCP message = %+v", events[1])
+	}
+	if events[2].Message != ok {
+		t.Errorf("second TCP message = %q", events[2].Message)
+	}
+}
+
+func TestNextMessage(t *testing.T) {
+	tests := []struct {
+		name, in, msg, rest string
+	}{
+		{"complete", invite + "SIP", invite, "SIP"},
+		{"partial body", invite[:len(invite)-2], "", invite[:len(invite)-2]},
+		{"partial headers", "INVITE sip:b SIP/2.0\r\nCall", "", "INVITE sip:b SIP/2.0\r\nCall"},
+		{"keepalive", "\r\n\r\n" + ok, ok, ""},
+		{"resync", "garbage\r\n" + ok, ok, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, rest := nextMessage([]byte(tt.in))
+			if string(msg) != tt.msg || string(rest) != tt.rest {
+				t.Errorf("nextMessage = %q, %q; want %q, %q", msg, rest, tt.msg, tt.rest)
+			}
+		})
+	}
+}
+
+func TestEngine(t *testing.T) {
+	path := writeCapture(t, pcap.Packet{Time: time.Now(), Src: alice, Dst: pbx, Payload: []byte(invite)})
+	events, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewEngine(events)
+	if got := e.TraceStore().Query(engine.TraceQuery{CallID: "a1"}); len(got) != 1 {
+		t.Errorf("store has %d messages for a1, want 1", len(got))
+	}
+	if err := e.Dial("a", "sip:b@example.com"); err == nil {
+		t.Error("Dial succeeded offline")
+	}
+}
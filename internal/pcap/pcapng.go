@@ -0,0 +1,282 @@
+// Package pcap writes packet captures in the pcapng format, synthesizing the
+// IP and UDP/TCP headers of messages captured above the transport layer so
+// the file opens in Wireshark like a capture taken on the wire. It also reads
+// pcap and pcapng files back into UDP datagrams and reassembled TCP streams.
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"time"
+)
+
+// pcapng block types and the link type of the single interface, raw IP
+// (the version is in the first nibble of each packet).
+const (
+	blockSectionHeader   = 0x0A0D0D0A
+	blockInterface       = 0x00000001
+	blockEnhancedPacket  = 0x00000006
+	byteOrderMagic       = 0x1A2B3C4D
+	linkTypeRaw          = 101
+	optEndOfOpt          = 0
+	optComment           = 1
+	optShbUserAppl       = 4
+	optIfName            = 2
+	maxTCPSegment        = 65000
+	maxUDPPayload        = 65535 - 40 - 8
+	ipProtoTCP           = 6
+	ipProtoUDP           = 17
+	tcpFlagsPSHACK       = 0x18
+	defaultTTL           = 64
+	defaultTCPWindowSize = 65535
+)
+
+// Packet is one message to write, with the addresses it traveled between.
+type Packet struct {
+	Time    time.Time
+	Src     netip.AddrPort
+	Dst     netip.AddrPort
+	TCP     bool   // stream transport (TCP, TLS, WebSocket); UDP otherwise
+	Payload []byte // the message as sent, without transport headers
+	Comment string // optional packet comment, shown by Wireshark
+}
+
+// flow is one direction of a TCP connection.
+type flow struct{ src, dst netip.AddrPort }
+
+// Writer writes packets to a pcapng stream. It is not safe for concurrent use.
+type Writer struct {
+	w    io.Writer
+	ipID uint16
+	seq  map[flow]uint32 // next sequence number per direction
+}
+
+// NewWriter writes the pcapng section header and interface description to
+// w. app names the writing application in the section header.
+func NewWriter(w io.Writer, app string) (*Writer, error) {
+	pw := &Writer{w: w, seq: make(map[flow]uint32)}
+
+	var shb []byte
+	shb = binary.LittleEndian.AppendUint32(shb, byteOrderMagic)
+	shb = binary.LittleEndian.AppendUint16(shb, 1) // major version
+	shb = binary.LittleEndian.AppendUint16(shb, 0) // minor version
+	shb = binary.LittleEndian.AppendUint64(shb, 0xFFFFFFFFFFFFFFFF)
+	shb = appendOption(shb, optShbUserAppl, []byte(app))
+	shb = appendOption(shb, optEndOfOpt, nil)
+	if err := pw.writeBlock(blockSectionHeader, shb); err != nil {
+		return nil, err
+	}
+
+	var idb []byte
+	idb = binary.LittleEndian.AppendUint16(idb, linkTypeRaw)
+	idb = binary.LittleEndian.AppendUint16(idb, 0) // reserved
+	idb = binary.LittleEndian.AppendUint32(idb, 0) // no snap length limit
+	idb = appendOption(idb, optIfName, []byte(app))
+	idb = appendOption(idb, optEndOfOpt, nil)
+	if err := pw.writeBlock(blockInterface, idb); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// WritePacket writes p as one or more IP packets. TCP payloads larger than
+// an IP packet are split into segments that continue the flow's sequence.
+func (w *Writer) WritePacket(p Packet) error {
+	src, dst := p.Src.Addr().Unmap(), p.Dst.Addr().Unmap()
+	if !src.IsValid() || !dst.IsValid() {
+		return errors.New("packet without addresses")
+	}
+	if src.Is4() != dst.Is4() {
+		// Mixed families cannot share an IP header; use the IPv6 form of both.
+		src, dst = as6(src), as6(dst)
+	}
+	p.Src = netip.AddrPortFrom(src, p.Src.Port())
+	p.Dst = netip.AddrPortFrom(dst, p.Dst.Port())
+
+	if !p.TCP {
+		if len(p.Payload) > maxUDPPayload {
+			return fmt.Errorf("UDP payload of %d bytes does not fit an IP packet", len(p.Payload))
+		}
+		return w.writeIP(p, ipProtoUDP, udpHeader(p))
+	}
+	payload := p.Payload
+	for {
+		seg := payload[:min(len(payload), maxTCPSegment)]
+		payload = payload[len(seg):]
+		q := p
+		q.Payload = seg
+		if err := w.writeIP(q, ipProtoTCP, w.tcpHeader(q)); err != nil {
+			return err
+		}
+		if len(payload) == 0 {
+			return nil
+		}
+	}
+}
+
+// writeIP wraps a transport header and p's payload in an IP header and
+// writes them as an enhanced packet block.
+func (w *Writer) writeIP(p Packet, proto byte, transport []byte) error {
+	src, dst := p.Src.Addr(), p.Dst.Addr()
+	segment := append(transport, p.Payload...)
+	setChecksum(segment, proto, src, dst)
+
+	var pkt []byte
+	if src.Is4() {
+		w.ipID++
+		pkt = make([]byte, 20, 20+len(segment))
+		pkt[0] = 0x45
+		binary.BigEndian.PutUint16(pkt[2:], uint16(20+len(segment)))
+		binary.BigEndian.PutUint16(pkt[4:], w.ipID)
+		binary.BigEndian.PutUint16(pkt[6:], 0x4000) // don't fragment
+		pkt[8] = defaultTTL
+		pkt[9] = proto
+		s, d := src.As4(), dst.As4()
+		copy(pkt[12:], s[:])
+		copy(pkt[16:], d[:])
+		binary.BigEndian.PutUint16(pkt[10:], checksum(pkt, 0))
+	} else {
+		pkt = make([]byte, 40, 40+len(segment))
+		pkt[0] = 0x60
+		binary.BigEndian.PutUint16(pkt[4:], uint16(len(segment)))
+		pkt[6] = proto
+		pkt[7] = defaultTTL
+		s, d := src.As16(), dst.As16()
+		copy(pkt[8:], s[:])
+		copy(pkt[24:], d[:])
+	}
+	pkt = append(pkt, segment...)
+
+	ts := uint64(p.Time.UnixMicro())
+	var epb []byte
+	epb = binary.LittleEndian.AppendUint32(epb, 0) // interface
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ts>>32))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ts))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(pkt)))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(pkt)))
+	epb = append(epb, pad(pkt)...)
+	if p.Comment != "" {
+		epb = appendOption(epb, optComment, []byte(p.Comment))
+		epb = appendOption(epb, optEndOfOpt, nil)
+	}
+	return w.writeBlock(blockEnhancedPacket, epb)
+}
+
+// udpHeader returns the UDP header for p, checksum unset.
+func udpHeader(p Packet) []byte {
+	h := make([]byte, 8)
+	binary.BigEndian.PutUint16(h[0:], p.Src.Port())
+	binary.BigEndian.PutUint16(h[2:], p.Dst.Port())
+	binary.BigEndian.PutUint16(h[4:], uint16(8+len(p.Payload)))
+	return h
+}
+
+// tcpHeader returns a PSH/ACK header for p, checksum unset, and advances
+// the flow's sequence number. The acknowledgment number is the next
+// sequence number of the reverse direction, so both sides read as one
+// connection whose handshake was not captured.
+func (w *Writer) tcpHeader(p Packet) []byte {
+	fwd, rev := flow{p.Src, p.Dst}, flow{p.Dst, p.Src}
+	seq, ok := w.seq[fwd]
+	if !ok {
+		seq = 1
+	}
+	ack, ok := w.seq[rev]
+	if !ok {
+		ack = 1
+	}
+	w.seq[fwd] = seq + uint32(len(p.Payload))
+
+	h := make([]byte, 20)
+	binary.BigEndian.PutUint16(h[0:], p.Src.Port())
+	binary.BigEndian.PutUint16(h[2:], p.Dst.Port())
+	binary.BigEndian.PutUint32(h[4:], seq)
+	binary.BigEndian.PutUint32(h[8:], ack)
+	h[12] = 5 << 4 // data offset: 5 words
+	h[13] = tcpFlagsPSHACK
+	binary.BigEndian.PutUint16(h[14:], defaultTCPWindowSize)
+	return h
+}
+
+// setChecksum fills in the UDP or TCP checksum of segment, computed over
+// the IP pseudo-header.
+func setChecksum(segment []byte, proto byte, src, dst netip.Addr) {
+	var pseudo []byte
+	pseudo = append(pseudo, src.AsSlice()...)
+	pseudo = append(pseudo, dst.AsSlice()...)
+	if src.Is4() {
+		pseudo = append(pseudo, 0, proto)
+		pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(segment)))
+	} else {
+		pseudo = binary.BigEndian.AppendUint32(pseudo, uint32(len(segment)))
+		pseudo = append(pseudo, 0, 0, 0, proto)
+	}
+	off := 16 // TCP
+	if proto == ipProtoUDP {
+		off = 6
+	}
+	sum := checksum(segment, sumWords(pseudo, 0))
+	if sum == 0 && proto == ipProtoUDP {
+		sum = 0xFFFF // zero means "no checksum" in UDP
+	}
+	binary.BigEndian.PutUint16(segment[off:], sum)
+}
+
+// checksum returns the Internet checksum (RFC 1071) of b, continuing from
+// the partial sum initial.
+func checksum(b []byte, initial uint32) uint16 {
+	sum := sumWords(b, initial)
+	for sum > 0xFFFF {
+		sum = sum>>16 + sum&0xFFFF
+	}
+	return ^uint16(sum)
+}
+
+func sumWords(b []byte, sum uint32) uint32 {
+	for len(b) >= 2 {
+		sum += uint32(b[0])<<8 | uint32(b[1])
+		b = b[2:]
+	}
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8
+	}
+	for sum > 0xFFFF {
+		sum = sum>>16 + sum&0xFFFF
+	}
+	return sum
+}
+
+// writeBlock writes a pcapng block: type, total length, body, total length.
+func (w *Writer) writeBlock(typ uint32, body []byte) error {
+	total := uint32(12 + len(body))
+	var b []byte
+	b = binary.LittleEndian.AppendUint32(b, typ)
+	b = binary.LittleEndian.AppendUint32(b, total)
+	b = append(b, body...)
+	b = binary.LittleEndian.AppendUint32(b, total)
+	_, err := w.w.Write(b)
+	return err
+}
+
+// appendOption appends a pcapng option, its value padded to 32 bits.
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	return append(b, pad(value)...)
+}
+
+// pad returns b zero-padded to a multiple of four bytes.
+func pad(b []byte) []byte {
+	if n := len(b) % 4; n != 0 {
+		b = append(b[:len(b):len(b)], make([]byte, 4-n)...)
+	}
+	return b
+}
+
+// as6 returns the IPv6 form of a, mapping IPv4 addresses.
+func as6(a netip.Addr) netip.Addr {
+	return netip.AddrFrom16(a.As16())
+}
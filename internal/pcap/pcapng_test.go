@@ -0,0 +1,131 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// blocks splits a pcapng stream into its blocks' types and bodies.
+func blocks(t *testing.T, b []byte) (types []uint32, bodies [][]byte) {
+	t.Helper()
+	for len(b) > 0 {
+		if len(b) < 12 {
+			t.Fatalf("truncated block: %d bytes left", len(b))
+		}
+		typ := binary.LittleEndian.Uint32(b)
+		n := binary.LittleEndian.Uint32(b[4:])
+		if n%4 != 0 || int(n) > len(b) || binary.LittleEndian.Uint32(b[n-4:]) != n {
+			t.Fatalf("block %#x: bad length %d", typ, n)
+		}
+		types = append(types, typ)
+		bodies = append(bodies, b[8:n-4])
+		b = b[n:]
+	}
+	return types, bodies
+}
+
+// packetData returns the captured packet of an enhanced packet block body.
+func packetData(body []byte) (ts uint64, data []byte) {
+	ts = uint64(binary.LittleEndian.Uint32(body[4:]))<<32 | uint64(binary.LittleEndian.Uint32(body[8:]))
+	n := binary.LittleEndian.Uint32(body[12:])
+	return ts, body[20 : 20+n]
+}
+
+func TestWriterUDPv4(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, "siptty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 1, 1, 12, 0, 0, 250_000_000, time.UTC)
+	msg := []byte("OPTIONS sip:pbx SIP/2.0\r\n\r\n")
+	err = w.WritePacket(Packet{
+		Time:    at,
+		Src:     netip.MustParseAddrPort("192.0.2.1:5060"),
+		Dst:     netip.MustParseAddrPort("192.0.2.10:5060"),
+		Payload: msg,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	types, bodies := blocks(t, buf.Bytes())
+	if len(types) != 3 || types[0] != blockSectionHeader || types[1] != blockInterface || types[2] != blockEnhancedPacket {
+		t.Fatalf("blocks = %#x", types)
+	}
+	if binary.LittleEndian.Uint16(bodies[1]) != linkTypeRaw {
+		t.Errorf("link type = %d", binary.LittleEndian.Uint16(bodies[1]))
+	}
+	ts, pkt := packetData(bodies[2])
+	if ts != uint64(at.UnixMicro()) {
+		t.Errorf("timestamp = %d, want %d", ts, at.UnixMicro())
+	}
+	if pkt[0] != 0x45 || pkt[9] != ipProtoUDP || int(binary.BigEndian.Uint16(pkt[2:])) != len(pkt) {
+		t.Fatalf("IPv4 header = % x", pkt[:20])
+	}
+	if checksum(pkt[:20], 0) != 0 {
+		t.Error("IPv4 header checksum does not verify")
+	}
+	udp := pkt[20:]
+	if binary.BigEndian.Uint16(udp) != 5060 || int(binary.BigEndian.Uint16(udp[4:])) != len(udp) {
+		t.Errorf("UDP header = % x", udp[:8])
+	}
+	pseudo := append(append(netip.MustParseAddr("192.0.2.1").AsSlice(), netip.MustParseAddr("192.0.2.10").AsSlice()...),
+		0, ipProtoUDP, byte(len(udp)>>8), byte(len(udp)))
+	if checksum(udp, sumWords(pseudo, 0)) != 0 {
+		t.Error("UDP checksum does not verify")
+	}
+	if !bytes.Equal(udp[8:], msg) {
+		t.Errorf("payload = %q", udp[8:])
+	}
+}
+
+func TestWriterTCPv6Sequence(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, "siptty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := netip.MustParseAddrPort("[2001:db8::1]:40000")
+	remote := netip.MustParseAddrPort("[2001:db8::10]:5061")
+	for _, p := range []Packet{
+		{Src: local, Dst: remote, TCP: true, Payload: []byte("INVITE")},
+		{Src: remote, Dst: local, TCP: true, Payload: []byte("100")},
+		{Src: local, Dst: remote, TCP: true, Payload: []byte("ACK"), Comment: "note"},
+	} {
+		if err := w.WritePacket(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, bodies := blocks(t, buf.Bytes())
+	if len(bodies) != 5 {
+		t.Fatalf("%d blocks, want 5", len(bodies))
+	}
+	var seqs, acks []uint32
+	for _, body := range bodies[2:] {
+		_, pkt := packetData(body)
+		if pkt[0]>>4 != 6 || pkt[6] != ipProtoTCP {
+			t.Fatalf("IPv6 header = % x", pkt[:40])
+		}
+		tcp := pkt[40:]
+		seqs = append(seqs, binary.BigEndian.Uint32(tcp[4:]))
+		acks = append(acks, binary.BigEndian.Uint32(tcp[8:]))
+	}
+	// INVITE is bytes 1-6 of the local side, 100 bytes 1-3 of the remote.
+	if seqs[0] != 1 || seqs[1] != 1 || seqs[2] != 7 || acks[1] != 7 || acks[2] != 4 {
+		t.Errorf("seqs = %v, acks = %v", seqs, acks)
+	}
+}
+
+func TestWriterRejectsMissingAddress(t *testing.T) {
+	w, err := NewWriter(&bytes.Buffer{}, "siptty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WritePacket(Packet{Dst: netip.MustParseAddrPort("192.0.2.10:5060")}); err == nil {
+		t.Error("packet without source address written")
+	}
+}
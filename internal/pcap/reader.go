@@ -0,0 +1,515 @@
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/netip"
+	"slices"
+	"time"
+)
+
+// Link types read besides linkTypeRaw.
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeLinuxSLL = 113
+	linkTypeIPv4     = 228
+	linkTypeIPv6     = 229
+	linkTypeSLL2     = 276
+)
+
+const (
+	blockSimplePacket = 0x00000003
+	optIfTsresol      = 9
+
+	// Limits on reassembly state, so a damaged capture cannot exhaust memory.
+	maxFragments     = 1024
+	maxPendingChunks = 256
+)
+
+// ErrFormat is returned for input that is neither pcap nor pcapng.
+var ErrFormat = errors.New("pcap: not a pcap or pcapng file")
+
+// Reader reads the UDP and TCP payloads of the packets in a pcap or pcapng
+// file. UDP datagrams are returned whole, after IP reassembly. TCP payload
+// is returned per connection direction in sequence order, with
+// retransmissions removed, so a stream's Packets concatenate to the bytes
+// its application read; a segment that never arrived is skipped. Packets of
+// other protocols are passed over. It is not safe for concurrent use.
+type Reader struct {
+	r     *bufio.Reader
+	ng    bool
+	order binary.ByteOrder
+
+	linkType int           // classic pcap
+	tsUnit   time.Duration // classic pcap: µs or ns
+	ifaces   []iface       // pcapng, in section order
+
+	frags   map[fragKey]*fragments
+	streams map[flow]*stream
+	ready   []Packet
+	done    bool
+}
+
+type iface struct {
+	linkType int
+	tsPerSec uint64
+}
+
+// NewReader reads the file header of a pcap or pcapng stream.
+func NewReader(r io.Reader) (*Reader, error) {
+	pr := &Reader{
+		r:       bufio.NewReaderSize(r, 1<<16),
+		frags:   make(map[fragKey]*fragments),
+		streams: make(map[flow]*stream),
+	}
+	magic, err := pr.r.Peek(4)
+	if err != nil {
+		return nil, ErrFormat
+	}
+	switch {
+	case binary.LittleEndian.Uint32(magic) == blockSectionHeader:
+		pr.ng = true
+		return pr, nil
+	case binary.LittleEndian.Uint32(magic) == 0xA1B2C3D4, binary.LittleEndian.Uint32(magic) == 0xA1B23C4D:
+		pr.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(magic) == 0xA1B2C3D4, binary.BigEndian.Uint32(magic) == 0xA1B23C4D:
+		pr.order = binary.BigEndian
+	default:
+		return nil, ErrFormat
+	}
+	hdr := make([]byte, 24)
+	if _, err := io.ReadFull(pr.r, hdr); err != nil {
+		return nil, fmt.Errorf("pcap: reading header: %w", err)
+	}
+	pr.tsUnit = time.Microsecond
+	if pr.order.Uint32(hdr) == 0xA1B23C4D {
+		pr.tsUnit = time.Nanosecond
+	}
+	pr.linkType = int(pr.order.Uint32(hdr[20:]) & 0xFFFF)
+	return pr, nil
+}
+
+// Next returns the next UDP datagram or in-order TCP payload, or io.EOF
+// after the last one.
+func (r *Reader) Next() (Packet, error) {
+	for len(r.ready) == 0 {
+		if r.done {
+			return Packet{}, io.EOF
+		}
+		var (
+			ts   time.Time
+			link int
+			data []byte
+			err  error
+		)
+		if r.ng {
+			ts, link, data, err = r.nextBlock()
+		} else {
+			ts, link, data, err = r.nextRecord()
+		}
+		if err == io.EOF {
+			r.done = true
+			r.flushStreams()
+			continue
+		}
+		if err != nil {
+			return Packet{}, err
+		}
+		if data != nil {
+			r.decodeLink(ts, link, data)
+		}
+	}
+	p := r.ready[0]
+	r.ready = r.ready[1:]
+	return p, nil
+}
+
+// nextRecord reads one classic pcap record.
+func (r *Reader) nextRecord() (time.Time, int, []byte, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r.r, hdr); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF // a capture cut off mid-record ends there
+		}
+		return time.Time{}, 0, nil, err
+	}
+	sec, frac := r.order.Uint32(hdr), r.order.Uint32(hdr[4:])
+	n := r.order.Uint32(hdr[8:])
+	if n > 1<<18 {
+		return time.Time{}, 0, nil, fmt.Errorf("pcap: record of %d bytes", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return time.Time{}, 0, nil, io.EOF
+	}
+	ts := time.Unix(int64(sec), int64(frac)*int64(r.tsUnit))
+	return ts, r.linkType, data, nil
+}
+
+// nextBlock reads one pcapng block, returning the packet of a packet block
+// and a nil packet for any other block.
+func (r *Reader) nextBlock() (time.Time, int, []byte, error) {
+	hdr, err := r.r.Peek(12)
+	if err != nil {
+		return time.Time{}, 0, nil, io.EOF
+	}
+	typ := binary.LittleEndian.Uint32(hdr)
+	if typ == blockSectionHeader {
+		// The byte order magic of each section sets the order of its blocks.
+		switch binary.LittleEndian.Uint32(hdr[8:]) {
+		case byteOrderMagic:
+			r.order = binary.LittleEndian
+		case 0x4D3C2B1A:
+			r.order = binary.BigEndian
+		default:
+			return time.Time{}, 0, nil, ErrFormat
+		}
+		r.ifaces = nil
+	}
+	if r.order == nil {
+		return time.Time{}, 0, nil, ErrFormat
+	}
+	typ = r.order.Uint32(hdr)
+	n := r.order.Uint32(hdr[4:])
+	if n < 12 || n%4 != 0 || n > 1<<20 {
+		return time.Time{}, 0, nil, fmt.Errorf("pcap: bad block length %d", n)
+	}
+	block := make([]byte, n)
+	if _, err := io.ReadFull(r.r, block); err != nil {
+		return time.Time{}, 0, nil, io.EOF
+	}
+	body := block[8 : n-4]
+
+	switch typ {
+	case blockInterface:
+		if len(body) < 8 {
+			return time.Time{}, 0, nil, errors.New("pcap: short interface block")
+		}
+		ifc := iface{linkType: int(r.order.Uint16(body)), tsPerSec: 1e6}
+		if value := r.option(body[8:], optIfTsresol); len(value) > 0 {
+			ifc.tsPerSec = tsResolution(value[0])
+		}
+		r.ifaces = append(r.ifaces, ifc)
+	case blockEnhancedPacket:
+		if len(body) < 20 {
+			return time.Time{}, 0, nil, errors.New("pcap: short packet block")
+		}
+		id := r.order.Uint32(body)
+		if int(id) >= len(r.ifaces) {
+			return time.Time{}, 0, nil, fmt.Errorf("pcap: packet on unknown interface %d", id)
+		}
+		ifc := r.ifaces[id]
+		ticks := uint64(r.order.Uint32(body[4:]))<<32 | uint64(r.order.Uint32(body[8:]))
+		capLen := int(r.order.Uint32(body[12:]))
+		if capLen > len(body)-20 {
+			return time.Time{}, 0, nil, errors.New("pcap: packet longer than its block")
+		}
+		ts := time.Unix(int64(ticks/ifc.tsPerSec), int64(ticks%ifc.tsPerSec*1e9/ifc.tsPerSec))
+		return ts, ifc.linkType, body[20 : 20+capLen], nil
+	case blockSimplePacket:
+		if len(r.ifaces) == 0 || len(body) < 4 {
+			return time.Time{}, 0, nil, errors.New("pcap: simple packet without interface")
+		}
+		origLen := int(r.order.Uint32(body))
+		return time.Time{}, r.ifaces[0].linkType, body[4 : 4+min(origLen, len(body)-4)], nil
+	}
+	return time.Time{}, 0, nil, nil
+}
+
+// option returns the value of the pcapng option with code in b, or nil.
+func (r *Reader) option(b []byte, code uint16) []byte {
+	for len(b) >= 4 {
+		c, n := r.order.Uint16(b), int(r.order.Uint16(b[2:]))
+		if c == optEndOfOpt || 4+n > len(b) {
+			return nil
+		}
+		if c == code {
+			return b[4 : 4+n]
+		}
+		b = b[min(4+n+(4-n%4)%4, len(b)):]
+	}
+	return nil
+}
+
+// tsResolution decodes if_tsresol: a negative power of 10, or of 2 when the
+// high bit is set.
+func tsResolution(v byte) uint64 {
+	exp := float64(v & 0x7F)
+	base := 10.0
+	if v&0x80 != 0 {
+		base = 2
+	}
+	res := math.Pow(base, exp)
+	if res < 1 || res > 1e18 {
+		return 1e6
+	}
+	return uint64(res)
+}
+
+// decodeLink strips the link layer header and decodes the IP packet.
+func (r *Reader) decodeLink(ts time.Time, link int, b []byte) {
+	switch link {
+	case linkTypeEthernet:
+		if len(b) < 14 {
+			return
+		}
+		etherType := binary.BigEndian.Uint16(b[12:])
+		b = b[14:]
+		for (etherType == 0x8100 || etherType == 0x88A8) && len(b) >= 4 {
+			etherType = binary.BigEndian.Uint16(b[2:])
+			b = b[4:]
+		}
+		if etherType != 0x0800 && etherType != 0x86DD {
+			return
+		}
+	case linkTypeLinuxSLL:
+		if len(b) < 16 {
+			return
+		}
+		b = b[16:]
+	case linkTypeSLL2:
+		if len(b) < 20 {
+			return
+		}
+		b = b[20:]
+	case linkTypeNull:
+		if len(b) < 4 {
+			return
+		}
+		b = b[4:]
+	case linkTypeRaw, linkTypeIPv4, linkTypeIPv6:
+	default:
+		return
+	}
+	r.decodeIP(ts, b)
+}
+
+// fragKey identifies the fragments of one IP datagram.
+type fragKey struct {
+	src, dst netip.Addr
+	id       uint32
+	proto    byte
+}
+
+// fragments collects the pieces of a fragmented datagram by offset.
+type fragments struct {
+	pieces map[int][]byte
+	total  int // payload length, known once the last fragment arrives
+}
+
+// decodeIP decodes an IPv4 or IPv6 packet, reassembling fragments, and
+// hands its UDP or TCP segment on.
+func (r *Reader) decodeIP(ts time.Time, b []byte) {
+	if len(b) < 1 {
+		return
+	}
+	var (
+		src, dst netip.Addr
+		proto    byte
+		payload  []byte
+	)
+	switch b[0] >> 4 {
+	case 4:
+		ihl := int(b[0]&0x0F) * 4
+		if len(b) < 20 || ihl < 20 || len(b) < ihl {
+			return
+		}
+		total := min(int(binary.BigEndian.Uint16(b[2:])), len(b))
+		if total < ihl {
+			return
+		}
+		src, dst = netip.AddrFrom4([4]byte(b[12:16])), netip.AddrFrom4([4]byte(b[16:20]))
+		proto = b[9]
+		payload = b[ihl:total]
+		flags := binary.BigEndian.Uint16(b[6:])
+		more, offset := flags&0x2000 != 0, int(flags&0x1FFF)*8
+		if more || offset > 0 {
+			key := fragKey{src, dst, uint32(binary.BigEndian.Uint16(b[4:])), proto}
+			if payload = r.reassemble(key, offset, more, payload); payload == nil {
+				return
+			}
+		}
+	case 6:
+		if len(b) < 40 {
+			return
+		}
+		src, dst = netip.AddrFrom16([16]byte(b[8:24])), netip.AddrFrom16([16]byte(b[24:40]))
+		proto = b[6]
+		payload = b[40:min(40+int(binary.BigEndian.Uint16(b[4:])), len(b))]
+		for {
+			switch proto {
+			case 0, 43, 60: // hop-by-hop, routing, destination options
+				if len(payload) < 8 || len(payload) < (int(payload[1])+1)*8 {
+					return
+				}
+				proto, payload = payload[0], payload[(int(payload[1])+1)*8:]
+				continue
+			case 44: // fragment
+				if len(payload) < 8 {
+					return
+				}
+				key := fragKey{src, dst, binary.BigEndian.Uint32(payload[4:]), payload[0]}
+				off := binary.BigEndian.Uint16(payload[2:])
+				proto = payload[0]
+				if payload = r.reassemble(key, int(off&^7), off&1 != 0, payload[8:]); payload == nil {
+					return
+				}
+				continue
+			}
+			break
+		}
+	default:
+		return
+	}
+
+	switch proto {
+	case ipProtoUDP:
+		if len(payload) < 8 {
+			return
+		}
+		r.ready = append(r.ready, Packet{
+			Time:    ts,
+			Src:     netip.AddrPortFrom(src, binary.BigEndian.Uint16(payload)),
+			Dst:     netip.AddrPortFrom(dst, binary.BigEndian.Uint16(payload[2:])),
+			Payload: payload[8:min(max(int(binary.BigEndian.Uint16(payload[4:])), 8), len(payload))],
+		})
+	case ipProtoTCP:
+		if len(payload) < 20 || len(payload) < int(payload[12]>>4)*4 {
+			return
+		}
+		f := flow{
+			netip.AddrPortFrom(src, binary.BigEndian.Uint16(payload)),
+			netip.AddrPortFrom(dst, binary.BigEndian.Uint16(payload[2:])),
+		}
+		seq, flags := binary.BigEndian.Uint32(payload[4:]), payload[13]
+		r.segment(ts, f, seq, flags, payload[int(payload[12]>>4)*4:])
+	}
+}
+
+// reassemble stores a fragment and returns the datagram's payload once all
+// of it has arrived, or nil.
+func (r *Reader) reassemble(key fragKey, offset int, more bool, data []byte) []byte {
+	fr, ok := r.frags[key]
+	if !ok {
+		if len(r.frags) >= maxFragments {
+			clear(r.frags) // give up on stale datagrams
+		}
+		fr = &fragments{pieces: make(map[int][]byte), total: -1}
+		r.frags[key] = fr
+	}
+	fr.pieces[offset] = slices.Clone(data)
+	if !more {
+		fr.total = offset + len(data)
+	}
+	if fr.total < 0 {
+		return nil
+	}
+	out := make([]byte, 0, fr.total)
+	for len(out) < fr.total {
+		piece, ok := fr.pieces[len(out)]
+		if !ok || len(piece) == 0 {
+			return nil
+		}
+		out = append(out, piece...)
+	}
+	delete(r.frags, key)
+	return out[:fr.total]
+}
+
+// stream is the reassembly state of one TCP connection direction.
+type stream struct {
+	next    uint32            // next expected sequence number
+	pending map[uint32][]byte // segments received ahead of next
+	time    time.Time         // of the latest pending segment
+}
+
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpRST = 0x04
+)
+
+// segment feeds a TCP segment to its stream and queues the payload that is
+// now in order.
+func (r *Reader) segment(ts time.Time, f flow, seq uint32, flags byte, data []byte) {
+	s, ok := r.streams[f]
+	switch {
+	case flags&tcpSYN != 0:
+		s = &stream{next: seq + 1, pending: make(map[uint32][]byte)}
+		r.streams[f] = s
+		return
+	case !ok:
+		// The capture started mid-connection.
+		s = &stream{next: seq, pending: make(map[uint32][]byte)}
+		r.streams[f] = s
+	}
+
+	if len(data) > 0 {
+		if int32(seq-s.next) > 0 {
+			s.pending[seq] = slices.Clone(data)
+			s.time = ts
+			if len(s.pending) > maxPendingChunks {
+				r.skipGap(f, s, ts) // the missing segment is lost for good
+			}
+		} else {
+			r.deliver(ts, f, s, seq, data)
+		}
+	}
+	if flags&(tcpFIN|tcpRST) != 0 {
+		r.skipGap(f, s, ts)
+		delete(r.streams, f)
+	}
+}
+
+// deliver queues the part of data (starting at seq) that is beyond what was
+// already delivered, then any pending segments it makes contiguous.
+func (r *Reader) deliver(ts time.Time, f flow, s *stream, seq uint32, data []byte) {
+	for {
+		if skip := int(int32(s.next - seq)); skip < len(data) {
+			data = data[skip:]
+			r.ready = append(r.ready, Packet{Time: ts, Src: f.src, Dst: f.dst, TCP: true, Payload: data})
+			s.next += uint32(len(data))
+		}
+		var found bool
+		for pseq, pdata := range s.pending {
+			if int32(pseq-s.next) <= 0 {
+				delete(s.pending, pseq)
+				seq, data, found = pseq, pdata, true
+				break
+			}
+		}
+		if !found {
+			return
+		}
+	}
+}
+
+// skipGap gives up on missing data: it moves the stream to its earliest
+// pending segment and delivers from there.
+func (r *Reader) skipGap(f flow, s *stream, ts time.Time) {
+	for len(s.pending) > 0 {
+		first := true
+		var lowest uint32
+		for seq := range s.pending {
+			if first || int32(seq-lowest) < 0 {
+				lowest, first = seq, false
+			}
+		}
+		data := s.pending[lowest]
+		delete(s.pending, lowest)
+		s.next = lowest
+		r.deliver(ts, f, s, lowest, data)
+	}
+}
+
+// flushStreams delivers what is left pending at the end of the capture.
+func (r *Reader) flushStreams() {
+	for f, s := range r.streams {
+		r.skipGap(f, s, s.time)
+	}
+}
@@ -0,0 +1,143 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	alice = netip.MustParseAddrPort("192.0.2.1:5060")
+	pbx   = netip.MustParseAddrPort("192.0.2.10:5060")
+)
+
+// readAll returns the packets a Reader yields.
+func readAll(t *testing.T, b []byte) []Packet {
+	t.Helper()
+	r, err := NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []Packet
+	for {
+		p, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, p)
+	}
+}
+
+func TestReaderRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, "test")
+	at := time.Date(2024, 1, 1, 12, 0, 0, 123456000, time.UTC)
+	w.WritePacket(Packet{Time: at, Src: alice, Dst: pbx, Payload: []byte("udp one")})
+	w.WritePacket(Packet{Time: at, Src: alice, Dst: pbx, TCP: true, Payload: []byte("tcp one")})
+	w.WritePacket(Packet{Time: at, Src: pbx, Dst: alice, TCP: true, Payload: []byte("tcp two")})
+
+	got := readAll(t, buf.Bytes())
+	if len(got) != 3 {
+		t.Fatalf("read %d packets, want 3", len(got))
+	}
+	if p := got[0]; p.TCP || p.Src != alice || p.Dst != pbx || string(p.Payload) != "udp one" || !p.Time.Equal(at) {
+		t.Errorf("UDP packet = %+v", p)
+	}
+	if p := got[2]; !p.TCP || p.Src != pbx || string(p.Payload) != "tcp two" {
+		t.Errorf("TCP packet = %+v", p)
+	}
+}
+
+func TestReaderTCPReassembly(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, "test")
+	seg := func(seq uint32, flags byte, data string) {
+		p := Packet{Src: alice, Dst: pbx, TCP: true, Payload: []byte(data)}
+		h := w.tcpHeader(p)
+		binary.BigEndian.PutUint32(h[4:], seq)
+		h[13] = flags
+		if err := w.writeIP(p, ipProtoTCP, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seg(999, tcpSYN, "")
+	seg(1000, tcpFlagsPSHACK, "hello ")
+	seg(1011, tcpFlagsPSHACK, "!")      // ahead of a missing segment
+	seg(1000, tcpFlagsPSHACK, "hello ") // retransmission
+	seg(1006, tcpFlagsPSHACK, "world")  // fills the gap
+	seg(1012, tcpFIN, "")
+
+	var stream strings.Builder
+	for _, p := range readAll(t, buf.Bytes()) {
+		stream.Write(p.Payload)
+	}
+	if stream.String() != "hello world!" {
+		t.Errorf("stream = %q, want %q", stream.String(), "hello world!")
+	}
+}
+
+func TestReaderClassicEthernetFragments(t *testing.T) {
+	payload := []byte("INVITE sip:bob@example.com SIP/2.0\r\n" + strings.Repeat("X-Pad: 0123456789\r\n", 5) + "\r\n")
+	udp := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint16(udp[0:], 5060)
+	binary.BigEndian.PutUint16(udp[2:], 5062)
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+len(payload)))
+	udp = append(udp, payload...)
+
+	// Two IPv4 fragments, sent last one first, in Ethernet frames.
+	frag := func(offset int, more bool, data []byte) []byte {
+		ip := make([]byte, 20)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(data)))
+		binary.BigEndian.PutUint16(ip[4:], 77)
+		flags := uint16(offset / 8)
+		if more {
+			flags |= 0x2000
+		}
+		binary.BigEndian.PutUint16(ip[6:], flags)
+		ip[9] = ipProtoUDP
+		copy(ip[12:], []byte{192, 0, 2, 1})
+		copy(ip[16:], []byte{192, 0, 2, 10})
+		eth := make([]byte, 14)
+		binary.BigEndian.PutUint16(eth[12:], 0x0800)
+		return append(append(eth, ip...), data...)
+	}
+	frames := [][]byte{frag(48, false, udp[48:]), frag(0, true, udp[:48])}
+
+	var file []byte
+	file = binary.LittleEndian.AppendUint32(file, 0xA1B2C3D4)
+	file = binary.LittleEndian.AppendUint16(file, 2)
+	file = binary.LittleEndian.AppendUint16(file, 4)
+	file = append(file, make([]byte, 8)...)
+	file = binary.LittleEndian.AppendUint32(file, 65535)
+	file = binary.LittleEndian.AppendUint32(file, linkTypeEthernet)
+	for i, f := range frames {
+		file = binary.LittleEndian.AppendUint32(file, 1704110400)
+		file = binary.LittleEndian.AppendUint32(file, uint32(i))
+		file = binary.LittleEndian.AppendUint32(file, uint32(len(f)))
+		file = binary.LittleEndian.AppendUint32(file, uint32(len(f)))
+		file = append(file, f...)
+	}
+
+	got := readAll(t, file)
+	if len(got) != 1 {
+		t.Fatalf("read %d packets, want 1 reassembled datagram", len(got))
+	}
+	if p := got[0]; !bytes.Equal(p.Payload, payload) || p.Dst.Port() != 5062 {
+		t.Errorf("datagram = %+v", p)
+	}
+}
+
+func TestReaderRejectsOtherFiles(t *testing.T) {
+	if _, err := NewReader(strings.NewReader("INVITE sip:x SIP/2.0")); !errors.Is(err, ErrFormat) {
+		t.Errorf("err = %v, want ErrFormat", err)
+	}
+}
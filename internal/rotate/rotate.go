@@ -0,0 +1,227 @@
+// Package rotate writes a log file that is rotated by size and age. Rotated
+// files get a timestamp suffix, can be gzipped, and only the newest few are
+// kept.
+package rotate
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stampFormat is the suffix of rotated files; it sorts by time.
+const stampFormat = "20060102-150405.000"
+
+// Options controls when a File rotates and what happens to rotated files.
+type Options struct {
+	MaxSize  int64         // rotate before a write would grow the file past this; 0 for no limit
+	MaxAge   time.Duration // rotate on the first write after the file is this old; 0 for no limit
+	Compress bool          // gzip rotated files
+	Keep     int           // rotated files kept, newest first; 0 keeps all
+}
+
+// File is an append-only log file that rotates itself. Compression and
+// pruning of rotated files happen in the background. It is safe for
+// concurrent use.
+type File struct {
+	path   string
+	opts   Options
+	now    func() time.Time
+	rename func(oldpath, newpath string) error
+
+	mu      sync.Mutex
+	f       *os.File // nil after a failed reopen; the next Write retries
+	closed  bool
+	size    int64
+	opened  time.Time
+	rotated chan struct{} // wakes the background worker after a rotation
+	done    chan struct{}
+}
+
+// Open opens path for appending, creating it if needed.
+func Open(path string, opts Options) (*File, error) {
+	f := &File{
+		path:    path,
+		opts:    opts,
+		now:     time.Now,
+		rename:  os.Rename,
+		rotated: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	go f.worker()
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.f, f.size, f.opened = file, info.Size(), f.now()
+	return nil
+}
+
+// Write appends p, rotating the file first if p would take it past
+// MaxSize or it is older than MaxAge. A write larger than MaxSize goes to a
+// file of its own.
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.f == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	full := f.opts.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.opts.MaxSize
+	old := f.opts.MaxAge > 0 && f.now().Sub(f.opened) >= f.opts.MaxAge
+	if full || old {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.f.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file aside and opens a fresh one. If the
+// rename fails, it reopens the current file to keep appending to it and
+// returns the error.
+func (f *File) rotate() error {
+	if err := f.f.Close(); err != nil {
+		f.f = nil
+		return errors.Join(err, f.open())
+	}
+	f.f = nil
+	t := f.now()
+	name := f.path + "." + t.Format(stampFormat)
+	for exists(name) || exists(name+".gz") {
+		t = t.Add(time.Millisecond)
+		name = f.path + "." + t.Format(stampFormat)
+	}
+	if err := f.rename(f.path, name); err != nil {
+		return errors.Join(err, f.open())
+	}
+	select {
+	case f.rotated <- struct{}{}:
+	default: // the worker has a wakeup pending and will see this file too
+	}
+	return f.open()
+}
+
+// Close closes the file and waits for pending compression and pruning.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	var err error
+	if f.f != nil {
+		err = f.f.Close()
+		f.f = nil
+	}
+	close(f.rotated)
+	<-f.done
+	return err
+}
+
+// worker compresses rotated files and prunes old ones after each wakeup.
+func (f *File) worker() {
+	defer close(f.done)
+	for range f.rotated {
+		if f.opts.Compress {
+			for _, name := range f.Rotated() {
+				if strings.HasSuffix(name, ".gz") {
+					continue
+				}
+				if err := compress(name); err != nil {
+					slog.Warn("compressing rotated log", "file", name, "error", err)
+				}
+			}
+		}
+		f.prune()
+	}
+}
+
+// prune removes all but the newest Keep rotated files.
+func (f *File) prune() {
+	if f.opts.Keep <= 0 {
+		return
+	}
+	names := f.Rotated()
+	if len(names) <= f.opts.Keep {
+		return
+	}
+	for _, name := range names[:len(names)-f.opts.Keep] {
+		if err := os.Remove(name); err != nil {
+			slog.Warn("removing rotated log", "file", name, "error", err)
+		}
+	}
+}
+
+// Rotated returns the rotated files of the log, oldest first.
+func (f *File) Rotated() []string {
+	matches, _ := filepath.Glob(f.path + ".*")
+	var names []string
+	for _, m := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(m, f.path+"."), ".gz")
+		if _, err := time.Parse(stampFormat, stamp); err == nil {
+			names = append(names, m)
+		}
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		return strings.Compare(strings.TrimSuffix(a, ".gz"), strings.TrimSuffix(b, ".gz"))
+	})
+	return names
+}
+
+// compress replaces name with name.gz.
+func compress(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
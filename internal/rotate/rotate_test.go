@@ -0,0 +1,137 @@
+package rotate
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRotateBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.log")
+	f, err := Open(path, Options{MaxSize: 10, Compress: true, Keep: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n", "dddddd\n"} {
+		if _, err := f.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readFile(t, path); got != "dddddd\n" {
+		t.Errorf("current file = %q, want the last write", got)
+	}
+	rotated := f.Rotated()
+	if len(rotated) != 2 {
+		t.Fatalf("rotated files = %v, want the newest 2", rotated)
+	}
+	for i, want := range []string{"bbbbbb\n", "cccccc\n"} {
+		if !strings.HasSuffix(rotated[i], ".gz") {
+			t.Errorf("%s is not compressed", rotated[i])
+		}
+		if got := readFile(t, rotated[i]); got != want {
+			t.Errorf("%s = %q, want %q", rotated[i], got, want)
+		}
+	}
+}
+
+func TestRotateByAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.log")
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	f, err := Open(path, Options{MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.now = func() time.Time { return now }
+	f.opened = now
+
+	f.Write([]byte("first\n"))
+	now = now.Add(30 * time.Minute)
+	f.Write([]byte("second\n"))
+	now = now.Add(time.Hour)
+	f.Write([]byte("third\n"))
+	f.Close()
+
+	rotated := f.Rotated()
+	if len(rotated) != 1 || !strings.HasSuffix(rotated[0], ".20240101-133000.000") {
+		t.Fatalf("rotated files = %v, want one stamped 13:30", rotated)
+	}
+	if got := readFile(t, rotated[0]); got != "first\nsecond\n" {
+		t.Errorf("rotated file = %q", got)
+	}
+	if got := readFile(t, path); got != "third\n" {
+		t.Errorf("current file = %q", got)
+	}
+}
+
+func TestAppendsToExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.log")
+	os.WriteFile(path, []byte("old\n"), 0644)
+	f, err := Open(path, Options{MaxSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("new\n"))
+	f.Close()
+	if got := readFile(t, path); got != "old\nnew\n" {
+		t.Errorf("file = %q", got)
+	}
+	if _, err := f.Write([]byte("x")); err == nil {
+		t.Error("Write after Close succeeded")
+	}
+}
+
+func TestRotateFailureKeepsLogging(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.log")
+	f, err := Open(path, Options{MaxSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write([]byte("aaaaaa\n"))
+
+	f.rename = func(string, string) error { return os.ErrPermission }
+	if _, err := f.Write([]byte("bbbbbb\n")); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("Write with a failing rotation = %v, want the rename error", err)
+	}
+	f.rename = os.Rename
+	if _, err := f.Write([]byte("cccccc\n")); err != nil {
+		t.Fatalf("Write after a failed rotation: %v", err)
+	}
+	if got := readFile(t, path); got != "cccccc\n" {
+		t.Errorf("current file = %q, want the write after the retried rotation", got)
+	}
+	if rotated := f.Rotated(); len(rotated) != 1 || readFile(t, rotated[0]) != "aaaaaa\n" {
+		t.Errorf("rotated files = %v, want one holding the first write", rotated)
+	}
+}
@@ -0,0 +1,65 @@
+package rtpstats
+
+import (
+	"strings"
+	"time"
+)
+
+// impairment is the equipment impairment factor Ie and packet-loss
+// robustness factor Bpl of a codec (ITU-T G.113 appendix I), with packet
+// loss concealment where the codec has it.
+type impairment struct{ ie, bpl float64 }
+
+// codecImpairments are keyed by lower-case encoding name. The E-model here
+// is the narrowband one, so wideband codecs are rated like G.711.
+var codecImpairments = map[string]impairment{
+	"pcmu": {0, 25.1},
+	"pcma": {0, 25.1},
+	"g722": {0, 25.1},
+	"opus": {0, 25.1},
+	"g729": {11, 19},
+	"gsm":  {20, 10},
+	"ilbc": {11, 32},
+}
+
+// Quality is the input of the E-model: what the network did to a stream.
+type Quality struct {
+	Codec       string        // encoding name, e.g. "PCMU"; unknown ones rate as G.711
+	LossPercent float64       // packets lost, in percent
+	RTT         time.Duration // round trip time; 0 when unknown
+	Jitter      time.Duration // interarrival jitter
+}
+
+// RFactor returns the transmission rating R (ITU-T G.107) of a call with
+// the given quality, from 0 (unusable) to 93.2 (G.711 without impairment).
+// The one-way delay is taken as half the RTT plus a jitter buffer of twice
+// the jitter and one 20 ms packet.
+func RFactor(q Quality) float64 {
+	imp, ok := codecImpairments[strings.ToLower(q.Codec)]
+	if !ok {
+		imp = codecImpairments["pcmu"]
+	}
+
+	delay := float64((q.RTT/2 + 2*q.Jitter + 20*time.Millisecond).Milliseconds())
+	id := 0.024 * delay
+	if delay > 177.3 {
+		id += 0.11 * (delay - 177.3)
+	}
+
+	ppl := max(q.LossPercent, 0)
+	ieEff := imp.ie + (95-imp.ie)*ppl/(ppl+imp.bpl)
+
+	return max(93.2-id-ieEff, 0)
+}
+
+// MOS converts an R factor to an estimated mean opinion score, from 1 (bad)
+// to 4.5 (ITU-T G.107 annex B).
+func MOS(r float64) float64 {
+	switch {
+	case r <= 0:
+		return 1
+	case r >= 100:
+		return 4.5
+	}
+	return 1 + 0.035*r + r*(r-60)*(100-r)*7e-6
+}
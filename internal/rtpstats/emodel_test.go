@@ -0,0 +1,28 @@
+package rtpstats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMOS(t *testing.T) {
+	tests := []struct {
+		name     string
+		q        Quality
+		min, max float64
+	}{
+		{"clean G.711", Quality{Codec: "PCMU"}, 4.3, 4.45},
+		{"G.729", Quality{Codec: "G729"}, 3.9, 4.1},
+		{"5% loss", Quality{Codec: "PCMA", LossPercent: 5}, 3.75, 4.0},
+		{"satellite delay", Quality{Codec: "PCMU", RTT: 800 * time.Millisecond}, 2.5, 3.3},
+		{"unusable", Quality{Codec: "PCMU", LossPercent: 60, RTT: 2 * time.Second}, 1, 1.01},
+	}
+	for _, tt := range tests {
+		if got := MOS(RFactor(tt.q)); got < tt.min || got > tt.max {
+			t.Errorf("%s: MOS = %.2f (R %.1f), want %.2f-%.2f", tt.name, got, RFactor(tt.q), tt.min, tt.max)
+		}
+	}
+	if MOS(120) != 4.5 || MOS(-3) != 1 {
+		t.Error("MOS is not clamped to 1-4.5")
+	}
+}
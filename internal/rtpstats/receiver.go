@@ -0,0 +1,160 @@
+// Package rtpstats measures the quality of an RTP stream: the receive
+// statistics of RFC 3550 appendix A (loss, interarrival jitter, reordering,
+// duplicates), the reception reports of RTCP sender and receiver reports,
+// and a call quality estimate with the ITU-T G.107 E-model.
+package rtpstats
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+const (
+	rtpHeaderLen = 12
+	// maxDropout and maxMisorder are the sequence number gaps of RFC 3550
+	// appendix A.1: larger jumps ahead or back restart the count.
+	maxDropout  = 3000
+	maxMisorder = 100
+	// seenWindow is the number of recent sequence numbers remembered to
+	// tell duplicates from late packets.
+	seenWindow = 1024
+)
+
+// Header is the part of an RTP header the statistics need.
+type Header struct {
+	PayloadType uint8
+	Seq         uint16
+	Timestamp   uint32
+	SSRC        uint32
+}
+
+// ParseHeader reads the fixed header of an RTP packet. SRTP leaves it in
+// the clear, so it works on encrypted packets too.
+func ParseHeader(b []byte) (Header, error) {
+	if len(b) < rtpHeaderLen || b[0]>>6 != 2 {
+		return Header{}, errors.New("rtpstats: not an RTP packet")
+	}
+	if pt := b[1] & 0x7f; pt >= 72 && pt <= 76 {
+		return Header{}, errors.New("rtpstats: RTCP packet")
+	}
+	return Header{
+		PayloadType: b[1] & 0x7f,
+		Seq:         binary.BigEndian.Uint16(b[2:]),
+		Timestamp:   binary.BigEndian.Uint32(b[4:]),
+		SSRC:        binary.BigEndian.Uint32(b[8:]),
+	}, nil
+}
+
+// Stats are the receive statistics of one stream.
+type Stats struct {
+	SSRC       uint32
+	Packets    uint64 // every packet received, duplicates included
+	Bytes      uint64 // RTP header and payload
+	Expected   uint64 // packets the sequence numbers say were sent
+	Lost       int64  // Expected minus the distinct packets received
+	OutOfOrder uint64 // packets that arrived after a later one
+	Duplicates uint64
+	Jitter     time.Duration // interarrival jitter (RFC 3550 section 6.4.1)
+}
+
+// LossPercent returns the share of expected packets that were lost.
+func (s Stats) LossPercent() float64 {
+	if s.Expected == 0 || s.Lost <= 0 {
+		return 0
+	}
+	return 100 * float64(s.Lost) / float64(s.Expected)
+}
+
+// Receiver accumulates the statistics of the RTP stream received on a call.
+// A change of SSRC restarts them. It is not safe for concurrent use.
+type Receiver struct {
+	clockRate uint32
+
+	stats    Stats
+	started  bool
+	baseSeq  uint32 // extended seq of the first packet
+	maxSeq   uint32 // highest extended seq received
+	received uint64 // distinct packets
+	seen     [seenWindow]uint32
+
+	lastTransit int64
+	jitter      float64 // in timestamp units
+}
+
+// NewReceiver returns a Receiver for a stream whose RTP clock runs at
+// clockRate Hz, e.g. 8000 for G.711.
+func NewReceiver(clockRate uint32) *Receiver {
+	return &Receiver{clockRate: max(clockRate, 1)}
+}
+
+// Add counts an RTP packet that arrived at the given time. Packets that are
+// not RTP are ignored and reported as false.
+func (r *Receiver) Add(pkt []byte, arrival time.Time) bool {
+	h, err := ParseHeader(pkt)
+	if err != nil {
+		return false
+	}
+	if !r.started || h.SSRC != r.stats.SSRC {
+		r.reset(h)
+	}
+	r.stats.Packets++
+	r.stats.Bytes += uint64(len(pkt))
+
+	// Extend the sequence number relative to the highest one so far.
+	maxSeq := uint16(r.maxSeq)
+	delta := int32(int16(h.Seq - maxSeq))
+	ext := uint32(int64(r.maxSeq) + int64(delta))
+	switch {
+	case delta > maxDropout || delta < -maxMisorder:
+		// A jump this large means the sender restarted its numbering.
+		r.reset(h)
+		r.stats.Packets++
+		r.stats.Bytes += uint64(len(pkt))
+		ext = r.maxSeq
+	case delta <= 0 && r.seen[ext%seenWindow] == ext+1:
+		r.stats.Duplicates++
+		return true
+	case delta < 0:
+		r.stats.OutOfOrder++
+	default:
+		r.maxSeq = ext
+	}
+	r.seen[ext%seenWindow] = ext + 1
+	r.received++
+
+	// Interarrival jitter (RFC 3550 appendix A.8), in timestamp units.
+	if delta >= 0 {
+		arrivalTS := arrival.UnixNano() * int64(r.clockRate) / int64(time.Second)
+		transit := arrivalTS - int64(h.Timestamp)
+		if r.lastTransit != 0 {
+			d := transit - r.lastTransit
+			if d < 0 {
+				d = -d
+			}
+			r.jitter += (float64(d) - r.jitter) / 16
+		}
+		r.lastTransit = transit
+	}
+	return true
+}
+
+// reset starts the statistics over at the first packet of a stream.
+func (r *Receiver) reset(h Header) {
+	*r = Receiver{clockRate: r.clockRate, started: true}
+	r.stats.SSRC = h.SSRC
+	// Keep extended numbers clear of zero so seen entries are never 0.
+	r.baseSeq = 1<<16 + uint32(h.Seq)
+	r.maxSeq = r.baseSeq
+}
+
+// Stats returns the statistics so far.
+func (r *Receiver) Stats() Stats {
+	s := r.stats
+	if r.started {
+		s.Expected = uint64(r.maxSeq-r.baseSeq) + 1
+		s.Lost = int64(s.Expected) - int64(r.received)
+	}
+	s.Jitter = time.Duration(r.jitter * float64(time.Second) / float64(r.clockRate))
+	return s
+}
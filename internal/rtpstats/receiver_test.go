@@ -0,0 +1,73 @@
+package rtpstats
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// rtpPacket builds a PCMU packet with a 160-byte payload.
+func rtpPacket(ssrc uint32, seq uint16, ts uint32) []byte {
+	b := make([]byte, 12+160)
+	b[0] = 0x80
+	binary.BigEndian.PutUint16(b[2:], seq)
+	binary.BigEndian.PutUint32(b[4:], ts)
+	binary.BigEndian.PutUint32(b[8:], ssrc)
+	return b
+}
+
+func TestReceiver(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	r := NewReceiver(8000)
+	// Packets every 20 ms from seq 65533, wrapping: 4 is lost, 6 comes
+	// after 7, 7 is repeated.
+	for _, seq := range []uint16{65533, 65534, 65535, 0, 1, 2, 3, 5, 7, 6, 7, 8} {
+		n := int(seq-65533) & 0xffff
+		at := t0.Add(time.Duration(n) * 20 * time.Millisecond)
+		if !r.Add(rtpPacket(42, seq, uint32(n)*160), at) {
+			t.Fatalf("Add(seq %d) = false", seq)
+		}
+	}
+	if r.Add([]byte{0x80, 200, 0, 1}, t0) {
+		t.Error("Add accepted a short packet")
+	}
+
+	s := r.Stats()
+	if s.SSRC != 42 || s.Packets != 12 || s.Bytes != 12*172 {
+		t.Errorf("SSRC %d, %d packets, %d bytes; want 42, 12, %d", s.SSRC, s.Packets, s.Bytes, 12*172)
+	}
+	if s.Expected != 12 || s.Lost != 1 {
+		t.Errorf("expected %d, lost %d; want 12, 1", s.Expected, s.Lost)
+	}
+	if s.OutOfOrder != 1 || s.Duplicates != 1 {
+		t.Errorf("out of order %d, duplicates %d; want 1, 1", s.OutOfOrder, s.Duplicates)
+	}
+	if s.Jitter != 0 {
+		t.Errorf("Jitter = %v, want 0 for evenly spaced packets", s.Jitter)
+	}
+	if got := s.LossPercent(); got < 8.3 || got > 8.4 {
+		t.Errorf("LossPercent = %.2f, want 8.33", got)
+	}
+}
+
+func TestReceiverJitterAndRestart(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	r := NewReceiver(8000)
+	// Alternate arrivals 10 ms early and late.
+	for i := range 200 {
+		off := 10 * time.Millisecond
+		if i%2 == 0 {
+			off = -off
+		}
+		r.Add(rtpPacket(7, uint16(i), uint32(i*160)), t0.Add(time.Duration(i)*20*time.Millisecond+off))
+	}
+	if j := r.Stats().Jitter; j < 15*time.Millisecond || j > 21*time.Millisecond {
+		t.Errorf("Jitter = %v, want close to 20ms", j)
+	}
+
+	// A new SSRC starts over.
+	r.Add(rtpPacket(8, 1000, 0), t0)
+	if s := r.Stats(); s.SSRC != 8 || s.Packets != 1 || s.Lost != 0 || s.Jitter != 0 {
+		t.Errorf("after SSRC change: %+v", s)
+	}
+}
@@ -0,0 +1,133 @@
+package rtpstats
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// RTCP packet types (RFC 3550 section 12.1).
+const (
+	typeSenderReport   = 200
+	typeReceiverReport = 201
+)
+
+const reportBlockLen = 24
+
+// ntpEpochOffset is the number of seconds from 1900 to 1970.
+const ntpEpochOffset = 2208988800
+
+// SenderInfo is the sender information of an RTCP sender report.
+type SenderInfo struct {
+	SSRC    uint32
+	NTPTime uint64 // wall clock of the report, NTP format
+	RTPTime uint32
+	Packets uint32 // packets sent since the stream began
+	Octets  uint32 // payload octets sent
+}
+
+// ReportBlock is one reception report: how the reporter receives the stream
+// of SSRC.
+type ReportBlock struct {
+	SSRC           uint32
+	FractionLost   uint8 // lost since the previous report, in 1/256
+	CumulativeLost int32
+	HighestSeq     uint32 // extended highest sequence number received
+	Jitter         uint32 // interarrival jitter, in timestamp units
+	LSR            uint32 // middle 32 bits of the last sender report's NTP time
+	DLSR           uint32 // delay since that report, in 1/65536 s
+}
+
+// RTT returns the round trip time the block shows when it arrives at the
+// given time (RFC 3550 section 6.4.1), or false when the reporter has not
+// received a sender report yet.
+func (b ReportBlock) RTT(arrival time.Time) (time.Duration, bool) {
+	if b.LSR == 0 {
+		return 0, false
+	}
+	a := uint32(NTPTime(arrival) >> 16)
+	rtt := int32(a - b.LSR - b.DLSR)
+	if rtt < 0 {
+		return 0, false
+	}
+	return time.Duration(int64(rtt) * int64(time.Second) >> 16), true
+}
+
+// Report is the content of a compound RTCP packet that matters for the
+// statistics: the sender information of a sender report, if any, and every
+// reception report block.
+type Report struct {
+	Sender *SenderInfo
+	Blocks []ReportBlock
+}
+
+// ParseRTCP reads the sender and receiver reports of a compound RTCP
+// packet. Other packet types (SDES, BYE, APP, XR) are skipped. SRTCP
+// encrypts the reports, so this only works on plain RTCP.
+func ParseRTCP(b []byte) (Report, error) {
+	var rep Report
+	if len(b) < 4 {
+		return rep, errors.New("rtpstats: short RTCP packet")
+	}
+	for len(b) > 0 {
+		if len(b) < 4 || b[0]>>6 != 2 {
+			return rep, errors.New("rtpstats: not an RTCP packet")
+		}
+		count, typ := int(b[0]&0x1f), b[1]
+		n := 4 * (int(binary.BigEndian.Uint16(b[2:])) + 1)
+		if n > len(b) {
+			return rep, errors.New("rtpstats: truncated RTCP packet")
+		}
+		body := b[4:n]
+		b = b[n:]
+
+		switch typ {
+		case typeSenderReport:
+			if len(body) < 24 {
+				return rep, errors.New("rtpstats: short sender report")
+			}
+			rep.Sender = &SenderInfo{
+				SSRC:    binary.BigEndian.Uint32(body),
+				NTPTime: binary.BigEndian.Uint64(body[4:]),
+				RTPTime: binary.BigEndian.Uint32(body[12:]),
+				Packets: binary.BigEndian.Uint32(body[16:]),
+				Octets:  binary.BigEndian.Uint32(body[20:]),
+			}
+			body = body[24:]
+		case typeReceiverReport:
+			if len(body) < 4 {
+				return rep, errors.New("rtpstats: short receiver report")
+			}
+			body = body[4:]
+		default:
+			continue
+		}
+		if len(body) < count*reportBlockLen {
+			return rep, errors.New("rtpstats: truncated report blocks")
+		}
+		for i := range count {
+			rep.Blocks = append(rep.Blocks, parseReportBlock(body[i*reportBlockLen:]))
+		}
+	}
+	return rep, nil
+}
+
+func parseReportBlock(b []byte) ReportBlock {
+	lost := int32(binary.BigEndian.Uint32(b[4:])&0xffffff) << 8 >> 8 // sign-extend 24 bits
+	return ReportBlock{
+		SSRC:           binary.BigEndian.Uint32(b),
+		FractionLost:   b[4],
+		CumulativeLost: lost,
+		HighestSeq:     binary.BigEndian.Uint32(b[8:]),
+		Jitter:         binary.BigEndian.Uint32(b[12:]),
+		LSR:            binary.BigEndian.Uint32(b[16:]),
+		DLSR:           binary.BigEndian.Uint32(b[20:]),
+	}
+}
+
+// NTPTime returns t in the 64-bit NTP timestamp format.
+func NTPTime(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return secs<<32 | frac
+}
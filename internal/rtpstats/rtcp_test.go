@@ -0,0 +1,57 @@
+package rtpstats
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestParseRTCP(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	lsr := uint32(NTPTime(at.Add(-250*time.Millisecond)) >> 16)
+
+	// SR with one report block, then an SDES chunk.
+	var b []byte
+	b = append(b, 0x81, typeSenderReport)
+	b = binary.BigEndian.AppendUint16(b, 12)
+	b = binary.BigEndian.AppendUint32(b, 0x1111)
+	b = binary.BigEndian.AppendUint64(b, NTPTime(at))
+	b = binary.BigEndian.AppendUint32(b, 16000)
+	b = binary.BigEndian.AppendUint32(b, 100)
+	b = binary.BigEndian.AppendUint32(b, 16000)
+	b = binary.BigEndian.AppendUint32(b, 0x2222)
+	b = binary.BigEndian.AppendUint32(b, 64<<24|0xfffffe) // 25%, cumulative -2
+	b = binary.BigEndian.AppendUint32(b, 70000)
+	b = binary.BigEndian.AppendUint32(b, 80)
+	b = binary.BigEndian.AppendUint32(b, lsr)
+	b = binary.BigEndian.AppendUint32(b, 65536/20) // 50 ms
+	b = append(b, 0x81, 202, 0, 1, 0, 0, 0x11, 0x11)
+
+	rep, err := ParseRTCP(b)
+	if err != nil {
+		t.Fatalf("ParseRTCP: %v", err)
+	}
+	if rep.Sender == nil || rep.Sender.SSRC != 0x1111 || rep.Sender.Packets != 100 {
+		t.Errorf("Sender = %+v", rep.Sender)
+	}
+	if len(rep.Blocks) != 1 {
+		t.Fatalf("%d blocks, want 1", len(rep.Blocks))
+	}
+	blk := rep.Blocks[0]
+	if blk.SSRC != 0x2222 || blk.FractionLost != 64 || blk.CumulativeLost != -2 || blk.HighestSeq != 70000 || blk.Jitter != 80 {
+		t.Errorf("block = %+v", blk)
+	}
+	rtt, ok := blk.RTT(at)
+	if !ok || rtt < 199*time.Millisecond || rtt > 201*time.Millisecond {
+		t.Errorf("RTT = %v, %v; want 200ms", rtt, ok)
+	}
+	if _, ok := (ReportBlock{}).RTT(at); ok {
+		t.Error("RTT without a sender report is ok")
+	}
+
+	for _, bad := range [][]byte{nil, {0x80, 201, 0, 5, 0, 0, 0, 1}, {0x00, 201, 0, 0}} {
+		if _, err := ParseRTCP(bad); err == nil {
+			t.Errorf("ParseRTCP(%x) succeeded", bad)
+		}
+	}
+}
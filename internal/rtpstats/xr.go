@@ -0,0 +1,68 @@
+package rtpstats
+
+import "encoding/binary"
+
+const (
+	typeXR          = 207
+	blockVoIPMetric = 7
+	voipMetricsLen  = 36 // bytes, block header included
+
+	// Unavailable is the value of a VoIPMetrics level or score that was
+	// not measured.
+	Unavailable = 127
+)
+
+// VoIPMetrics is the VoIP metrics report block of RTCP XR (RFC 3611
+// section 4.7), describing the stream received from SSRC.
+type VoIPMetrics struct {
+	SSRC uint32
+
+	LossRate       uint8  // fraction of packets lost, in 1/256
+	DiscardRate    uint8  // fraction discarded by the jitter buffer, in 1/256
+	BurstDensity   uint8  // fraction lost or discarded within bursts, in 1/256
+	GapDensity     uint8  // fraction lost or discarded within gaps, in 1/256
+	BurstDuration  uint16 // mean burst length, ms
+	GapDuration    uint16 // mean gap length, ms
+	RoundTripDelay uint16 // ms
+	EndSystemDelay uint16 // ms
+
+	SignalLevel int8 // dBm0, or Unavailable
+	NoiseLevel  int8 // dBm0, or Unavailable
+	RERL        uint8
+	Gmin        uint8
+
+	RFactor    uint8 // 0-100, or Unavailable
+	ExtRFactor uint8
+	MOSLQ      uint8 // MOS times 10, or Unavailable
+	MOSCQ      uint8
+
+	RXConfig  uint8 // packet loss concealment and jitter buffer type
+	JBNominal uint16
+	JBMaximum uint16
+	JBAbsMax  uint16
+}
+
+// AppendXR appends an RTCP XR packet carrying m, sent by senderSSRC, to b.
+// It is meant to follow a sender or receiver report in a compound packet,
+// or to be sent alone at the end of a call.
+func (m VoIPMetrics) AppendXR(b []byte, senderSSRC uint32) []byte {
+	b = append(b, 0x80, typeXR)
+	b = binary.BigEndian.AppendUint16(b, (8+voipMetricsLen)/4-1)
+	b = binary.BigEndian.AppendUint32(b, senderSSRC)
+
+	b = append(b, blockVoIPMetric, 0)
+	b = binary.BigEndian.AppendUint16(b, voipMetricsLen/4-1)
+	b = binary.BigEndian.AppendUint32(b, m.SSRC)
+	b = append(b, m.LossRate, m.DiscardRate, m.BurstDensity, m.GapDensity)
+	b = binary.BigEndian.AppendUint16(b, m.BurstDuration)
+	b = binary.BigEndian.AppendUint16(b, m.GapDuration)
+	b = binary.BigEndian.AppendUint16(b, m.RoundTripDelay)
+	b = binary.BigEndian.AppendUint16(b, m.EndSystemDelay)
+	b = append(b, byte(m.SignalLevel), byte(m.NoiseLevel), m.RERL, m.Gmin)
+	b = append(b, m.RFactor, m.ExtRFactor, m.MOSLQ, m.MOSCQ)
+	b = append(b, m.RXConfig, 0)
+	b = binary.BigEndian.AppendUint16(b, m.JBNominal)
+	b = binary.BigEndian.AppendUint16(b, m.JBMaximum)
+	b = binary.BigEndian.AppendUint16(b, m.JBAbsMax)
+	return b
+}
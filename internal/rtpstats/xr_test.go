@@ -0,0 +1,46 @@
+package rtpstats
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestAppendXR(t *testing.T) {
+	m := VoIPMetrics{
+		SSRC:           0xAAAA,
+		LossRate:       13,
+		GapDensity:     13,
+		GapDuration:    60000,
+		RoundTripDelay: 120,
+		SignalLevel:    Unavailable,
+		NoiseLevel:     Unavailable,
+		RERL:           Unavailable,
+		Gmin:           16,
+		RFactor:        80,
+		ExtRFactor:     Unavailable,
+		MOSLQ:          40,
+		MOSCQ:          40,
+	}
+	b := m.AppendXR([]byte{1, 2}, 0xBBBB)[2:]
+	if len(b) != 44 {
+		t.Fatalf("XR packet is %d bytes, want 44", len(b))
+	}
+	if b[0] != 0x80 || b[1] != 207 || binary.BigEndian.Uint16(b[2:]) != 10 || binary.BigEndian.Uint32(b[4:]) != 0xBBBB {
+		t.Errorf("XR header = %x", b[:8])
+	}
+	blk := b[8:]
+	if blk[0] != 7 || binary.BigEndian.Uint16(blk[2:]) != 8 || binary.BigEndian.Uint32(blk[4:]) != 0xAAAA {
+		t.Errorf("block header = %x", blk[:8])
+	}
+	if blk[8] != 13 || binary.BigEndian.Uint16(blk[14:]) != 60000 || binary.BigEndian.Uint16(blk[16:]) != 120 {
+		t.Errorf("loss/gap/delay fields = %x", blk[8:20])
+	}
+	if blk[20] != Unavailable || blk[23] != 16 || blk[24] != 80 || blk[26] != 40 {
+		t.Errorf("level/quality fields = %x", blk[20:28])
+	}
+
+	// Receivers that only read SR/RR skip it.
+	if _, err := ParseRTCP(b); err != nil {
+		t.Errorf("ParseRTCP(XR): %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package sdp
+
+import (
+	"strconv"
+	"strings"
+)
+
+// staticPayloadTypes names the RTP/AVP static payload types (RFC 3551) a
+// description may use without an rtpmap.
+var staticPayloadTypes = map[string]string{
+	"0":  "PCMU/8000",
+	"3":  "GSM/8000",
+	"4":  "G723/8000",
+	"8":  "PCMA/8000",
+	"9":  "G722/8000",
+	"13": "CN/8000",
+	"18": "G729/8000",
+}
+
+// Codec returns the encoding of payload type pt in m as "name/rate", e.g.
+// "opus/48000", from its rtpmap or the static payload types. It is "" for
+// an unknown dynamic payload type.
+func (m *Media) Codec(pt string) string {
+	for _, v := range m.Attrs("rtpmap") {
+		if num, enc, ok := strings.Cut(v, " "); ok && num == pt {
+			enc = strings.TrimSpace(enc)
+			// Drop the channel count of "opus/48000/2".
+			if name, rest, ok := strings.Cut(enc, "/"); ok {
+				rate, _, _ := strings.Cut(rest, "/")
+				return name + "/" + rate
+			}
+			return enc
+		}
+	}
+	return staticPayloadTypes[pt]
+}
+
+// Ptime returns m's packetization time in milliseconds, falling back to the
+// session-level attribute, or 0 when neither is set.
+func (s *Session) Ptime(m *Media) int {
+	v, ok := m.Attr("ptime")
+	if !ok {
+		v, _ = s.Attr("ptime")
+	}
+	ms, _ := strconv.Atoi(strings.TrimSpace(v))
+	return ms
+}
+
+// Direction returns m's direction attribute (RFC 8866 section 6.7):
+// "sendrecv", "sendonly", "recvonly" or "inactive". A media-level attribute
+// overrides the session-level one; without either it is "sendrecv".
+func (s *Session) Direction(m *Media) string {
+	for _, attrs := range [][]Attribute{m.Attributes, s.Attributes} {
+		for _, a := range attrs {
+			switch a.Name {
+			case "sendrecv", "sendonly", "recvonly", "inactive":
+				return a.Name
+			}
+		}
+	}
+	return "sendrecv"
+}
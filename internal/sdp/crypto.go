@@ -0,0 +1,50 @@
+package sdp
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Crypto is an SDES a=crypto attribute (RFC 4568).
+type Crypto struct {
+	Tag       int
+	Suite     string // e.g. "AES_CM_128_HMAC_SHA1_80"
+	KeyParams string // e.g. "inline:base64key|2^20|1:32"
+}
+
+// Crypto returns the parseable a=crypto attributes of m, in offer order.
+func (m *Media) Crypto() []Crypto {
+	var out []Crypto
+	for _, v := range m.Attrs("crypto") {
+		fields := strings.Fields(v)
+		if len(fields) < 3 {
+			continue
+		}
+		tag, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		out = append(out, Crypto{Tag: tag, Suite: fields[1], KeyParams: fields[2]})
+	}
+	return out
+}
+
+// Fingerprint returns the DTLS certificate fingerprint (RFC 8122) for m,
+// falling back to the session-level attribute.
+func (s *Session) Fingerprint(m *Media) string {
+	if v, ok := m.Attr("fingerprint"); ok {
+		return v
+	}
+	v, _ := s.Attr("fingerprint")
+	return v
+}
+
+// IsSecure reports whether the m= line uses an SRTP profile.
+func (m *Media) IsSecure() bool {
+	return strings.Contains(m.Proto, "SAVP")
+}
+
+// IsDTLS reports whether the m= line uses DTLS-SRTP (RFC 5764).
+func (m *Media) IsDTLS() bool {
+	return strings.HasPrefix(m.Proto, "UDP/TLS/")
+}
@@ -0,0 +1,13 @@
+package sdp
+
+// ICECredentials returns the ICE username fragment and password (RFC 8839)
+// for m, falling back to the session-level attributes.
+func (s *Session) ICECredentials(m *Media) (ufrag, pwd string) {
+	if ufrag, _ = m.Attr("ice-ufrag"); ufrag == "" {
+		ufrag, _ = s.Attr("ice-ufrag")
+	}
+	if pwd, _ = m.Attr("ice-pwd"); pwd == "" {
+		pwd, _ = s.Attr("ice-pwd")
+	}
+	return ufrag, pwd
+}
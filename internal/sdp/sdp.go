@@ -0,0 +1,182 @@
+// Package sdp is a small SDP (RFC 8866) reader for the parts of a session
+// description siptty inspects: connection addresses, media lines and their
+// attributes. It does not try to be a general-purpose SDP library.
+package sdp
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Session is a parsed session description.
+type Session struct {
+	Origin     string      // o= value
+	Connection string      // session-level c= address
+	AddrType   string      // session-level c= address type: "IP4" or "IP6"
+	Attributes []Attribute // session-level a= lines
+	Media      []Media
+}
+
+// Media is one m= section.
+type Media struct {
+	Type       string   // "audio", "video", ...
+	Port       int      // 0 means the stream is rejected
+	Proto      string   // "RTP/AVP", "RTP/SAVP", "UDP/TLS/RTP/SAVP", ...
+	Formats    []string // payload types as they appear on the m= line
+	Connection string   // media-level c= address (empty: use the session's)
+	AddrType   string   // media-level c= address type
+	Attributes []Attribute
+}
+
+// Attribute is a single a= line, split at the first colon.
+type Attribute struct {
+	Name  string
+	Value string
+}
+
+// Parse reads a session description. Unknown lines are ignored.
+func Parse(body []byte) (*Session, error) {
+	s := &Session{}
+	var cur *Media
+
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if len(line) < 2 || line[1] != '=' {
+			continue
+		}
+		typ, val := line[0], line[2:]
+
+		switch typ {
+		case 'o':
+			s.Origin = val
+		case 'c':
+			addrType, addr := connectionAddress(val)
+			if cur != nil {
+				cur.Connection, cur.AddrType = addr, addrType
+			} else {
+				s.Connection, s.AddrType = addr, addrType
+			}
+		case 'm':
+			m, err := parseMediaLine(val)
+			if err != nil {
+				return nil, err
+			}
+			s.Media = append(s.Media, m)
+			cur = &s.Media[len(s.Media)-1]
+		case 'a':
+			a := parseAttribute(val)
+			if cur != nil {
+				cur.Attributes = append(cur.Attributes, a)
+			} else {
+				s.Attributes = append(s.Attributes, a)
+			}
+		}
+	}
+
+	if len(s.Media) == 0 && s.Origin == "" {
+		return nil, fmt.Errorf("sdp: no session description found")
+	}
+	return s, nil
+}
+
+// Audio returns the first audio m= section, or nil.
+func (s *Session) Audio() *Media {
+	for i := range s.Media {
+		if s.Media[i].Type == "audio" {
+			return &s.Media[i]
+		}
+	}
+	return nil
+}
+
+// Attr returns the value of the first session-level attribute with name.
+func (s *Session) Attr(name string) (string, bool) {
+	return findAttr(s.Attributes, name)
+}
+
+// Attr returns the value of the first media-level attribute with name.
+func (m *Media) Attr(name string) (string, bool) {
+	return findAttr(m.Attributes, name)
+}
+
+// Attrs returns the values of every media-level attribute with name.
+func (m *Media) Attrs(name string) []string {
+	var vals []string
+	for _, a := range m.Attributes {
+		if a.Name == name {
+			vals = append(vals, a.Value)
+		}
+	}
+	return vals
+}
+
+// Address returns the connection address in effect for m.
+func (s *Session) Address(m *Media) string {
+	if m.Connection != "" {
+		return m.Connection
+	}
+	return s.Connection
+}
+
+// MediaAddr returns the host:port m's RTP is sent to. The connection address
+// must be an IP literal of the c= line's address type.
+func (s *Session) MediaAddr(m *Media) (string, error) {
+	addr, addrType := m.Connection, m.AddrType
+	if addr == "" {
+		addr, addrType = s.Connection, s.AddrType
+	}
+	ip := net.ParseIP(addr)
+	switch {
+	case ip == nil:
+		return "", fmt.Errorf("sdp: connection address %q is not an IP address", addr)
+	case addrType == "IP4" && ip.To4() == nil, addrType == "IP6" && ip.To4() != nil:
+		return "", fmt.Errorf("sdp: connection address %s does not match address type %s", addr, addrType)
+	}
+	return net.JoinHostPort(addr, strconv.Itoa(m.Port)), nil
+}
+
+func findAttr(attrs []Attribute, name string) (string, bool) {
+	for _, a := range attrs {
+		if a.Name == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// connectionAddress extracts the address type and address from
+// "IN IP4 10.0.0.1" or "IN IP6 2001:db8::1".
+func connectionAddress(val string) (addrType, addr string) {
+	fields := strings.Fields(val)
+	if len(fields) < 3 {
+		return "", ""
+	}
+	// Multicast addresses may carry /ttl/count suffixes.
+	addr, _, _ = strings.Cut(fields[2], "/")
+	return strings.ToUpper(fields[1]), addr
+}
+
+func parseMediaLine(val string) (Media, error) {
+	fields := strings.Fields(val)
+	if len(fields) < 3 {
+		return Media{}, fmt.Errorf("sdp: malformed m= line %q", val)
+	}
+	portStr, _, _ := strings.Cut(fields[1], "/")
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return Media{}, fmt.Errorf("sdp: bad port in m= line %q", val)
+	}
+	return Media{
+		Type:    fields[0],
+		Port:    port,
+		Proto:   fields[2],
+		Formats: fields[3:],
+	}, nil
+}
+
+func parseAttribute(val string) Attribute {
+	name, value, _ := strings.Cut(val, ":")
+	return Attribute{Name: name, Value: value}
+}
@@ -0,0 +1,184 @@
+package sdp
+
+import "testing"
+
+const offerSDES = "v=0\r\n" +
+	"o=- 3891 3891 IN IP4 10.0.0.5\r\n" +
+	"s=-\r\n" +
+	"c=IN IP4 10.0.0.5\r\n" +
+	"t=0 0\r\n" +
+	"m=audio 4000 RTP/SAVP 0 8 101\r\n" +
+	"a=rtpmap:0 PCMU/8000\r\n" +
+	"a=rtpmap:8 PCMA/8000\r\n" +
+	"a=rtpmap:101 telephone-event/8000\r\n" +
+	"a=crypto:1 AES_256_CM_HMAC_SHA1_80 inline:c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0\r\n" +
+	"a=crypto:2 AES_CM_128_HMAC_SHA1_80 inline:WVNfX19zZW1jdGwgKCkgewkyMjA7fQp9CnVubGVz|2^20|1:32\r\n" +
+	"a=sendrecv\r\n"
+
+const offerDTLS = "v=0\r\n" +
+	"o=- 1 1 IN IP4 10.0.0.7\r\n" +
+	"s=-\r\n" +
+	"t=0 0\r\n" +
+	"a=fingerprint:sha-256 4A:AD:B9:B1:3F:82:18:3B:54:02:12:DF:3E:5D:49:6B:19:E5:7C:AB\r\n" +
+	"m=audio 5000 UDP/TLS/RTP/SAVP 0\r\n" +
+	"c=IN IP4 10.0.0.8\r\n" +
+	"a=setup:actpass\r\n"
+
+func TestParse(t *testing.T) {
+	s, err := Parse([]byte(offerSDES))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if s.Connection != "10.0.0.5" {
+		t.Errorf("Connection = %q, want 10.0.0.5", s.Connection)
+	}
+	audio := s.Audio()
+	if audio == nil {
+		t.Fatal("Audio() = nil")
+	}
+	if audio.Port != 4000 || audio.Proto != "RTP/SAVP" {
+		t.Errorf("audio = %d %s, want 4000 RTP/SAVP", audio.Port, audio.Proto)
+	}
+	if len(audio.Formats) != 3 || audio.Formats[2] != "101" {
+		t.Errorf("Formats = %v, want [0 8 101]", audio.Formats)
+	}
+	if got := audio.Attrs("rtpmap"); len(got) != 3 {
+		t.Errorf("len(rtpmap) = %d, want 3", len(got))
+	}
+	if _, ok := audio.Attr("sendrecv"); !ok {
+		t.Error("sendrecv attribute not found")
+	}
+	if s.Address(audio) != "10.0.0.5" {
+		t.Errorf("Address = %q, want session-level 10.0.0.5", s.Address(audio))
+	}
+}
+
+func TestCrypto(t *testing.T) {
+	s, err := Parse([]byte(offerSDES))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	audio := s.Audio()
+	crypto := audio.Crypto()
+	if len(crypto) != 2 {
+		t.Fatalf("len(Crypto) = %d, want 2", len(crypto))
+	}
+	if crypto[1].Tag != 2 || crypto[1].Suite != "AES_CM_128_HMAC_SHA1_80" {
+		t.Errorf("crypto[1] = %+v, want tag 2 AES_CM_128_HMAC_SHA1_80", crypto[1])
+	}
+	if !audio.IsSecure() || audio.IsDTLS() {
+		t.Errorf("IsSecure/IsDTLS = %v/%v, want true/false", audio.IsSecure(), audio.IsDTLS())
+	}
+}
+
+func TestDTLS(t *testing.T) {
+	s, err := Parse([]byte(offerDTLS))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	audio := s.Audio()
+	if !audio.IsDTLS() {
+		t.Error("IsDTLS = false, want true")
+	}
+	if fp := s.Fingerprint(audio); fp == "" {
+		t.Error("session-level fingerprint not found")
+	}
+	if s.Address(audio) != "10.0.0.8" {
+		t.Errorf("Address = %q, want media-level 10.0.0.8", s.Address(audio))
+	}
+}
+
+func TestICECredentials(t *testing.T) {
+	body := "v=0\r\n" +
+		"o=- 1 1 IN IP4 10.0.0.9\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"a=ice-ufrag:sessUfrag\r\n" +
+		"a=ice-pwd:sessionLevelPassword1234\r\n" +
+		"m=audio 6000 RTP/AVP 0\r\n" +
+		"c=IN IP4 10.0.0.9\r\n" +
+		"a=ice-ufrag:mediaUfrag\r\n" +
+		"a=candidate:1 1 UDP 2130706431 10.0.0.9 6000 typ host\r\n" +
+		"a=candidate:2 1 UDP 1694498815 203.0.113.9 6000 typ srflx raddr 10.0.0.9 rport 6000\r\n"
+	s, err := Parse([]byte(body))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	audio := s.Audio()
+	ufrag, pwd := s.ICECredentials(audio)
+	if ufrag != "mediaUfrag" || pwd != "sessionLevelPassword1234" {
+		t.Errorf("ICECredentials = %q/%q, want mediaUfrag/sessionLevelPassword1234", ufrag, pwd)
+	}
+	if got := len(audio.Attrs("candidate")); got != 2 {
+		t.Errorf("candidates = %d, want 2", got)
+	}
+}
+
+func TestMediaAddrIPv6(t *testing.T) {
+	body := "v=0\r\n" +
+		"o=- 7 7 IN IP6 2001:db8::5\r\n" +
+		"s=-\r\n" +
+		"c=IN IP6 2001:db8::5\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 4000 RTP/AVP 0\r\n" +
+		"m=video 5000 RTP/AVP 96\r\n" +
+		"c=IN IP4 2001:db8::6\r\n"
+	s, err := Parse([]byte(body))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if s.AddrType != "IP6" || s.Connection != "2001:db8::5" {
+		t.Errorf("connection = %s %s, want IP6 2001:db8::5", s.AddrType, s.Connection)
+	}
+	addr, err := s.MediaAddr(s.Audio())
+	if err != nil || addr != "[2001:db8::5]:4000" {
+		t.Errorf("MediaAddr(audio) = %q, %v, want [2001:db8::5]:4000", addr, err)
+	}
+	if _, err := s.MediaAddr(&s.Media[1]); err == nil {
+		t.Error("expected error for an IPv6 address on an IP4 c= line")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	if _, err := Parse([]byte("not sdp")); err == nil {
+		t.Error("expected error for non-SDP body")
+	}
+	if _, err := Parse([]byte("v=0\r\nm=audio x RTP/AVP 0\r\n")); err == nil {
+		t.Error("expected error for bad m= port")
+	}
+}
+
+func TestCodecPtimeDirection(t *testing.T) {
+	s, err := Parse([]byte("v=0\r\n" +
+		"o=- 1 1 IN IP4 10.0.0.9\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 10.0.0.9\r\n" +
+		"a=sendonly\r\n" +
+		"m=audio 6000 RTP/AVP 111 8 96\r\n" +
+		"a=rtpmap:111 opus/48000/2\r\n" +
+		"a=ptime:20\r\n" +
+		"a=inactive\r\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	audio := s.Audio()
+	for pt, want := range map[string]string{"111": "opus/48000", "8": "PCMA/8000", "96": ""} {
+		if got := audio.Codec(pt); got != want {
+			t.Errorf("Codec(%s) = %q, want %q", pt, got, want)
+		}
+	}
+	if got := s.Ptime(audio); got != 20 {
+		t.Errorf("Ptime = %d, want 20", got)
+	}
+	if got := s.Direction(audio); got != "inactive" {
+		t.Errorf("Direction = %q, want media-level inactive", got)
+	}
+
+	s, _ = Parse([]byte(offerDTLS))
+	if got := s.Direction(s.Audio()); got != "sendrecv" {
+		t.Errorf("Direction = %q, want default sendrecv", got)
+	}
+	if got := s.Ptime(s.Audio()); got != 0 {
+		t.Errorf("Ptime = %d, want 0 when unset", got)
+	}
+}
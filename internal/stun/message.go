@@ -0,0 +1,295 @@
+package stun
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"net"
+)
+
+// Message types (RFC 5389, RFC 5766).
+const (
+	TypeBindingRequest          uint16 = 0x0001
+	TypeBindingSuccess          uint16 = 0x0101
+	TypeBindingError            uint16 = 0x0111
+	TypeAllocateRequest         uint16 = 0x0003
+	TypeAllocateSuccess         uint16 = 0x0103
+	TypeAllocateError           uint16 = 0x0113
+	TypeRefreshRequest          uint16 = 0x0004
+	TypeRefreshSuccess          uint16 = 0x0104
+	TypeRefreshError            uint16 = 0x0114
+	TypeCreatePermissionRequest uint16 = 0x0008
+	TypeSendIndication          uint16 = 0x0016
+	TypeDataIndication          uint16 = 0x0017
+)
+
+// Attribute types (RFC 5389, RFC 5766, RFC 8445).
+const (
+	AttrMappedAddress      uint16 = 0x0001
+	AttrUsername           uint16 = 0x0006
+	AttrMessageIntegrity   uint16 = 0x0008
+	AttrErrorCode          uint16 = 0x0009
+	AttrLifetime           uint16 = 0x000D
+	AttrXORPeerAddress     uint16 = 0x0012
+	AttrData               uint16 = 0x0013
+	AttrRealm              uint16 = 0x0014
+	AttrNonce              uint16 = 0x0015
+	AttrXORRelayedAddress  uint16 = 0x0016
+	AttrRequestedTransport uint16 = 0x0019
+	AttrXORMappedAddress   uint16 = 0x0020
+	AttrPriority           uint16 = 0x0024
+	AttrUseCandidate       uint16 = 0x0025
+	AttrFingerprint        uint16 = 0x8028
+	AttrICEControlled      uint16 = 0x8029
+	AttrICEControlling     uint16 = 0x802A
+)
+
+const (
+	magicCookie    = 0x2112A442
+	fingerprintXOR = 0x5354554e
+	headerLen      = 20
+	integrityLen   = 4 + sha1.Size
+	fingerprintLen = 8
+)
+
+// Attr is one attribute of a message, with its value unpadded.
+type Attr struct {
+	Type  uint16
+	Value []byte
+}
+
+// Message is a decoded STUN message.
+type Message struct {
+	Type  uint16
+	TxID  [12]byte
+	Attrs []Attr
+}
+
+// NewMessage returns a message of typ with a fresh random transaction ID.
+func NewMessage(typ uint16) *Message {
+	m := &Message{Type: typ}
+	_, _ = rand.Read(m.TxID[:])
+	return m
+}
+
+// Add appends an attribute.
+func (m *Message) Add(typ uint16, value []byte) {
+	m.Attrs = append(m.Attrs, Attr{Type: typ, Value: value})
+}
+
+// AddAddress appends an XOR-encoded address attribute (XOR-MAPPED-ADDRESS,
+// XOR-PEER-ADDRESS, XOR-RELAYED-ADDRESS).
+func (m *Message) AddAddress(typ uint16, addr *net.UDPAddr) {
+	m.Add(typ, encodeAddress(addr, m.key()))
+}
+
+// Get returns the value of the first attribute of typ.
+func (m *Message) Get(typ uint16) ([]byte, bool) {
+	for _, a := range m.Attrs {
+		if a.Type == typ {
+			return a.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Address decodes the address attribute typ; XOR-encoded types are
+// recognized by their type code.
+func (m *Message) Address(typ uint16) *net.UDPAddr {
+	val, ok := m.Get(typ)
+	if !ok {
+		return nil
+	}
+	if typ == AttrMappedAddress {
+		return decodeAddress(val, nil)
+	}
+	return decodeAddress(val, m.key())
+}
+
+// ErrorCode returns the numeric ERROR-CODE, or 0 if there is none.
+func (m *Message) ErrorCode() int {
+	val, ok := m.Get(AttrErrorCode)
+	if !ok || len(val) < 4 {
+		return 0
+	}
+	return int(val[2]&0x7)*100 + int(val[3])
+}
+
+// Encode serializes the message. A non-nil key appends MESSAGE-INTEGRITY
+// computed with it; fingerprint appends FINGERPRINT.
+func (m *Message) Encode(key []byte, fingerprint bool) []byte {
+	b := make([]byte, headerLen, 256)
+	binary.BigEndian.PutUint16(b[0:], m.Type)
+	binary.BigEndian.PutUint32(b[4:], magicCookie)
+	copy(b[8:20], m.TxID[:])
+	for _, a := range m.Attrs {
+		b = appendAttr(b, a.Type, a.Value)
+	}
+
+	if key != nil {
+		binary.BigEndian.PutUint16(b[2:], uint16(len(b)-headerLen+integrityLen))
+		mac := hmac.New(sha1.New, key)
+		mac.Write(b)
+		b = appendAttr(b, AttrMessageIntegrity, mac.Sum(nil))
+	}
+	if fingerprint {
+		binary.BigEndian.PutUint16(b[2:], uint16(len(b)-headerLen+fingerprintLen))
+		var crc [4]byte
+		binary.BigEndian.PutUint32(crc[:], crc32.ChecksumIEEE(b)^fingerprintXOR)
+		b = appendAttr(b, AttrFingerprint, crc[:])
+	}
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)-headerLen))
+	return b
+}
+
+// IsMessage reports whether b looks like a STUN message, which is how STUN
+// is told apart from RTP/RTCP sharing the same socket (RFC 7983).
+func IsMessage(b []byte) bool {
+	return len(b) >= headerLen && b[0] < 4 && binary.BigEndian.Uint32(b[4:]) == magicCookie
+}
+
+// Decode parses a STUN message.
+func Decode(b []byte) (*Message, error) {
+	if !IsMessage(b) {
+		return nil, errors.New("stun: not a STUN message")
+	}
+	length := int(binary.BigEndian.Uint16(b[2:]))
+	if headerLen+length > len(b) {
+		return nil, errors.New("stun: truncated message")
+	}
+	m := &Message{Type: binary.BigEndian.Uint16(b[0:])}
+	copy(m.TxID[:], b[8:20])
+
+	attrs := b[headerLen : headerLen+length]
+	for len(attrs) >= 4 {
+		typ := binary.BigEndian.Uint16(attrs[0:])
+		alen := int(binary.BigEndian.Uint16(attrs[2:]))
+		if 4+alen > len(attrs) {
+			return nil, errors.New("stun: truncated attribute")
+		}
+		m.Attrs = append(m.Attrs, Attr{Type: typ, Value: attrs[4 : 4+alen]})
+		// Attributes are padded to 4 bytes.
+		next := 4 + (alen+3)&^3
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+	return m, nil
+}
+
+// CheckIntegrity verifies the MESSAGE-INTEGRITY of raw (the encoded form of
+// a decoded message) against key.
+func CheckIntegrity(raw, key []byte) bool {
+	if len(raw) < headerLen {
+		return false
+	}
+	length := int(binary.BigEndian.Uint16(raw[2:]))
+	if headerLen+length > len(raw) {
+		return false
+	}
+	off := headerLen
+	for off+4 <= headerLen+length {
+		typ := binary.BigEndian.Uint16(raw[off:])
+		alen := int(binary.BigEndian.Uint16(raw[off+2:]))
+		if typ == AttrMessageIntegrity {
+			if alen != sha1.Size || off+4+alen > len(raw) {
+				return false
+			}
+			// The HMAC covers everything before the attribute, with the
+			// header length adjusted to end just after it.
+			covered := make([]byte, off)
+			copy(covered, raw[:off])
+			binary.BigEndian.PutUint16(covered[2:], uint16(off-headerLen+integrityLen))
+			mac := hmac.New(sha1.New, key)
+			mac.Write(covered)
+			return hmac.Equal(mac.Sum(nil), raw[off+4:off+4+alen])
+		}
+		off += 4 + (alen+3)&^3
+	}
+	return false
+}
+
+// LongTermKey derives the long-term credential key (RFC 5389 §15.4).
+func LongTermKey(username, realm, password string) []byte {
+	sum := md5.Sum([]byte(username + ":" + realm + ":" + password))
+	return sum[:]
+}
+
+// key is the magic cookie followed by the transaction ID, used to XOR addresses.
+func (m *Message) key() []byte {
+	k := make([]byte, 16)
+	binary.BigEndian.PutUint32(k, magicCookie)
+	copy(k[4:], m.TxID[:])
+	return k
+}
+
+func appendAttr(b []byte, typ uint16, val []byte) []byte {
+	var hdr [4]byte
+	binary.BigEndian.PutUint16(hdr[0:], typ)
+	binary.BigEndian.PutUint16(hdr[2:], uint16(len(val)))
+	b = append(b, hdr[:]...)
+	b = append(b, val...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// encodeAddress encodes addr as an (XOR-)MAPPED-ADDRESS value, XOR-ing it
+// with key (magic cookie + transaction ID) when key is non-nil.
+func encodeAddress(addr *net.UDPAddr, key []byte) []byte {
+	ip := addr.IP.To4()
+	family := byte(0x01)
+	if ip == nil {
+		ip = addr.IP.To16()
+		family = 0x02
+	}
+	val := make([]byte, 4+len(ip))
+	val[1] = family
+	port := uint16(addr.Port)
+	if key != nil {
+		port ^= uint16(magicCookie >> 16)
+	}
+	binary.BigEndian.PutUint16(val[2:], port)
+	for i := range ip {
+		val[4+i] = ip[i]
+		if key != nil {
+			val[4+i] ^= key[i]
+		}
+	}
+	return val
+}
+
+// decodeAddress decodes a MAPPED-ADDRESS value, XOR-ing it with key
+// (magic cookie + transaction ID) when key is non-nil.
+func decodeAddress(val, key []byte) *net.UDPAddr {
+	if len(val) < 8 {
+		return nil
+	}
+	family := val[1]
+	port := binary.BigEndian.Uint16(val[2:])
+	var ip net.IP
+	switch family {
+	case 0x01:
+		ip = append(net.IP(nil), val[4:8]...)
+	case 0x02:
+		if len(val) < 20 {
+			return nil
+		}
+		ip = append(net.IP(nil), val[4:20]...)
+	default:
+		return nil
+	}
+	if key != nil {
+		port ^= uint16(magicCookie >> 16)
+		for i := range ip {
+			ip[i] ^= key[i]
+		}
+	}
+	return &net.UDPAddr{IP: ip, Port: int(port)}
+}
@@ -0,0 +1,80 @@
+package stun
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	m := NewMessage(TypeBindingRequest)
+	m.Add(AttrUsername, []byte("remote:local")) // 12 bytes, no padding
+	m.Add(AttrPriority, []byte{0x6e, 0x00, 0x01, 0xff})
+	m.Add(AttrUseCandidate, nil)
+	m.Add(AttrRealm, []byte("odd")) // padded to 4
+	m.AddAddress(AttrXORPeerAddress, &net.UDPAddr{IP: net.ParseIP("198.51.100.4"), Port: 49152})
+
+	key := []byte("s3cret-password")
+	raw := m.Encode(key, true)
+	if len(raw)%4 != 0 {
+		t.Fatalf("encoded length %d is not a multiple of 4", len(raw))
+	}
+
+	got, err := Decode(raw)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if got.Type != TypeBindingRequest || got.TxID != m.TxID {
+		t.Errorf("header = %#04x/%x, want %#04x/%x", got.Type, got.TxID, TypeBindingRequest, m.TxID)
+	}
+	if v, _ := got.Get(AttrUsername); string(v) != "remote:local" {
+		t.Errorf("USERNAME = %q, want %q", v, "remote:local")
+	}
+	if v, _ := got.Get(AttrRealm); string(v) != "odd" {
+		t.Errorf("REALM = %q, want %q", v, "odd")
+	}
+	if _, ok := got.Get(AttrUseCandidate); !ok {
+		t.Error("USE-CANDIDATE missing")
+	}
+	if addr := got.Address(AttrXORPeerAddress); addr == nil || addr.String() != "198.51.100.4:49152" {
+		t.Errorf("XOR-PEER-ADDRESS = %v, want 198.51.100.4:49152", addr)
+	}
+	if _, ok := got.Get(AttrFingerprint); !ok {
+		t.Error("FINGERPRINT missing")
+	}
+
+	if !CheckIntegrity(raw, key) {
+		t.Error("CheckIntegrity with the right key = false")
+	}
+	if CheckIntegrity(raw, []byte("wrong")) {
+		t.Error("CheckIntegrity with the wrong key = true")
+	}
+	tampered := bytes.Clone(raw)
+	tampered[headerLen+5] ^= 0xff
+	if CheckIntegrity(tampered, key) {
+		t.Error("CheckIntegrity of a tampered message = true")
+	}
+}
+
+func TestIsMessage(t *testing.T) {
+	if !IsMessage(NewMessage(TypeBindingRequest).Encode(nil, false)) {
+		t.Error("IsMessage(binding request) = false")
+	}
+	rtp := make([]byte, 172)
+	rtp[0] = 0x80 // RTP version 2
+	if IsMessage(rtp) {
+		t.Error("IsMessage(RTP packet) = true")
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	m := NewMessage(TypeAllocateError)
+	m.Add(AttrErrorCode, []byte{0, 0, 4, 1})
+	got, err := Decode(m.Encode(nil, false))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if code := got.ErrorCode(); code != 401 {
+		t.Errorf("ErrorCode = %d, want 401", code)
+	}
+}
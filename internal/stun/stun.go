@@ -0,0 +1,126 @@
+// Package stun implements the parts of STUN (RFC 5389) siptty needs:
+// Binding requests to discover the NAT-mapped address of a host, the
+// short-term-credential checks ICE builds on (RFC 8445), and a TURN client
+// (RFC 5766) for relayed candidates.
+package stun
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// DefaultPort is the STUN port used when the server address has none.
+const DefaultPort = "3478"
+
+// retransmitInterval is how often an unanswered request is resent.
+const retransmitInterval = 500 * time.Millisecond
+
+// Discover sends a Binding request to server ("host", "host:port" or a
+// "stun:host:port" URI) from a fresh UDP socket bound to localHost, and returns
+// the server-reflexive (mapped) address.
+func Discover(server, localHost string, timeout time.Duration) (*net.UDPAddr, error) {
+	laddr := &net.UDPAddr{IP: net.ParseIP(localHost)}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, fmt.Errorf("stun: listen: %w", err)
+	}
+	defer conn.Close()
+	return DiscoverConn(conn, server, timeout)
+}
+
+// DiscoverConn is Discover over an existing socket, so the mapping returned is
+// the one for conn's local port. Requests are retransmitted every 500ms until
+// timeout (RFC 5389 §7.2.1, simplified).
+func DiscoverConn(conn net.PacketConn, server string, timeout time.Duration) (*net.UDPAddr, error) {
+	raddr, err := net.ResolveUDPAddr("udp", ServerAddr(server))
+	if err != nil {
+		return nil, fmt.Errorf("stun: resolve %s: %w", server, err)
+	}
+	req := NewMessage(TypeBindingRequest)
+	var addr *net.UDPAddr
+	err = roundTrip(conn, raddr, req.TxID, req.Encode(nil, false), timeout, func(raw []byte) bool {
+		a, err := parseBindingResponse(raw, req.TxID)
+		if err != nil {
+			return false // not ours, or malformed; keep waiting
+		}
+		addr = a
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("stun: %s: %w", server, err)
+	}
+	return addr, nil
+}
+
+// errTimeout is returned by roundTrip when no response arrives in time.
+var errTimeout = errors.New("no response")
+
+// roundTrip sends req to raddr and retransmits it until accept returns true
+// for a received datagram or timeout passes.
+func roundTrip(conn net.PacketConn, raddr net.Addr, txID [12]byte, req []byte, timeout time.Duration, accept func(raw []byte) bool) error {
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 1500)
+	defer conn.SetReadDeadline(time.Time{})
+	for time.Now().Before(deadline) {
+		if _, err := conn.WriteTo(req, raddr); err != nil {
+			return fmt.Errorf("send: %w", err)
+		}
+		wait := time.Now().Add(retransmitInterval)
+		if wait.After(deadline) {
+			wait = deadline
+		}
+		_ = conn.SetReadDeadline(wait)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					break
+				}
+				return fmt.Errorf("read: %w", err)
+			}
+			if IsMessage(buf[:n]) && string(buf[8:20]) == string(txID[:]) && accept(buf[:n]) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w within %s", errTimeout, timeout)
+}
+
+// ServerAddr normalizes a configured STUN or TURN server to host:port.
+func ServerAddr(server string) string {
+	for _, scheme := range []string{"stun:", "stuns:", "turn:", "turns:"} {
+		server = strings.TrimPrefix(server, scheme)
+	}
+	// TURN URIs may carry ?transport=udp (RFC 7065); only UDP is used.
+	server, _, _ = strings.Cut(server, "?")
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), DefaultPort)
+}
+
+// parseBindingResponse validates a Binding success response for txID and
+// returns its (XOR-)MAPPED-ADDRESS.
+func parseBindingResponse(raw []byte, txID [12]byte) (*net.UDPAddr, error) {
+	m, err := Decode(raw)
+	if err != nil {
+		return nil, err
+	}
+	if m.Type != TypeBindingSuccess {
+		return nil, errors.New("stun: not a binding success response")
+	}
+	if m.TxID != txID {
+		return nil, errors.New("stun: transaction mismatch")
+	}
+	if addr := m.Address(AttrXORMappedAddress); addr != nil {
+		return addr, nil
+	}
+	if addr := m.Address(AttrMappedAddress); addr != nil {
+		return addr, nil
+	}
+	return nil, errors.New("stun: no mapped address in response")
+}
@@ -0,0 +1,122 @@
+package stun
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// startServer runs a STUN stand-in on loopback that answers Binding requests
+// with the sender's address, as seen from the server, plus portOffset.
+func startServer(t *testing.T, portOffset int) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < headerLen || binary.BigEndian.Uint16(buf[0:]) != TypeBindingRequest {
+				continue
+			}
+			mapped := *from.(*net.UDPAddr)
+			mapped.Port += portOffset
+			_, _ = conn.WriteTo(bindingSuccess(buf[8:20], &mapped), from)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestDiscoverConn(t *testing.T) {
+	server := startServer(t, 1000)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer conn.Close()
+
+	addr, err := DiscoverConn(conn, "stun:"+server, 2*time.Second)
+	if err != nil {
+		t.Fatalf("DiscoverConn: %v", err)
+	}
+	local := conn.LocalAddr().(*net.UDPAddr)
+	if !addr.IP.Equal(local.IP) || addr.Port != local.Port+1000 {
+		t.Errorf("mapped = %s, want 127.0.0.1:%d", addr, local.Port+1000)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	server := startServer(t, 0)
+	addr, err := Discover(server, "127.0.0.1", 2*time.Second)
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	if !addr.IP.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("mapped IP = %s, want 127.0.0.1", addr.IP)
+	}
+}
+
+func TestDiscoverTimeout(t *testing.T) {
+	// A socket that never answers.
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer silent.Close()
+
+	if _, err := Discover(silent.LocalAddr().String(), "127.0.0.1", 300*time.Millisecond); err == nil {
+		t.Fatal("expected timeout error")
+	}
+}
+
+func TestParseBindingResponseIPv6(t *testing.T) {
+	var txID [12]byte
+	copy(txID[:], "abcdefghijkl")
+	want := &net.UDPAddr{IP: net.ParseIP("2001:db8::7"), Port: 40000}
+
+	got, err := parseBindingResponse(bindingSuccess(txID[:], want), txID)
+	if err != nil {
+		t.Fatalf("parseBindingResponse: %v", err)
+	}
+	if !got.IP.Equal(want.IP) || got.Port != want.Port {
+		t.Errorf("mapped = %s, want %s", got, want)
+	}
+
+	var other [12]byte
+	if _, err := parseBindingResponse(bindingSuccess(txID[:], want), other); err == nil {
+		t.Error("expected transaction mismatch error")
+	}
+}
+
+func TestServerAddr(t *testing.T) {
+	tests := map[string]string{
+		"stun.example.com":                    "stun.example.com:3478",
+		"stun:stun.example.com:19302":         "stun.example.com:19302",
+		"10.0.0.1:3479":                       "10.0.0.1:3479",
+		"[2001:db8::1]":                       "[2001:db8::1]:3478",
+		"turn:turn.example.com?transport=udp": "turn.example.com:3478",
+	}
+	for in, want := range tests {
+		if got := ServerAddr(in); got != want {
+			t.Errorf("ServerAddr(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// bindingSuccess encodes a Binding success response for txID carrying addr
+// as XOR-MAPPED-ADDRESS.
+func bindingSuccess(txID []byte, addr *net.UDPAddr) []byte {
+	m := &Message{Type: TypeBindingSuccess}
+	copy(m.TxID[:], txID)
+	m.AddAddress(AttrXORMappedAddress, addr)
+	return m.Encode(nil, false)
+}
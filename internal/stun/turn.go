@@ -0,0 +1,200 @@
+package stun
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// transportUDP is the REQUESTED-TRANSPORT value for UDP (protocol 17).
+var transportUDP = []byte{17, 0, 0, 0}
+
+// Allocation is a TURN relayed transport address (RFC 5766) held by a socket.
+// Traffic to and from peers goes through the server as Send and Data
+// indications; the owner of the socket reads Data indications itself and
+// unwraps them with ParseData.
+type Allocation struct {
+	Relayed  *net.UDPAddr  // the relayed transport address peers send to
+	Mapped   *net.UDPAddr  // our server-reflexive address, as seen by the server
+	Lifetime time.Duration // as granted by the server
+
+	conn   net.PacketConn
+	server *net.UDPAddr
+	user   string
+	realm  string
+	key    []byte
+
+	mu      sync.Mutex
+	nonce   string
+	pending map[[12]byte]func(*Message) // requests sent without waiting, to resend on a stale nonce
+}
+
+// Allocate requests a UDP relay from server using the long-term credentials
+// user/pass. The first, unauthenticated request is answered with 401 and the
+// realm and nonce to authenticate the retry with.
+func Allocate(conn net.PacketConn, server, user, pass string, timeout time.Duration) (*Allocation, error) {
+	raddr, err := net.ResolveUDPAddr("udp", ServerAddr(server))
+	if err != nil {
+		return nil, fmt.Errorf("turn: resolve %s: %w", server, err)
+	}
+	a := &Allocation{conn: conn, server: raddr, user: user, pending: make(map[[12]byte]func(*Message))}
+
+	res, err := a.request(TypeAllocateRequest, timeout, func(m *Message) {
+		m.Add(AttrRequestedTransport, transportUDP)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.Type == TypeAllocateError && (res.ErrorCode() == 401 || res.ErrorCode() == 438) {
+		realm, _ := res.Get(AttrRealm)
+		nonce, _ := res.Get(AttrNonce)
+		a.realm, a.nonce = string(realm), string(nonce)
+		a.key = LongTermKey(user, a.realm, pass)
+		res, err = a.request(TypeAllocateRequest, timeout, func(m *Message) {
+			m.Add(AttrRequestedTransport, transportUDP)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if res.Type != TypeAllocateSuccess {
+		return nil, fmt.Errorf("turn: allocate rejected with error %d", res.ErrorCode())
+	}
+
+	a.Relayed = res.Address(AttrXORRelayedAddress)
+	a.Mapped = res.Address(AttrXORMappedAddress)
+	if a.Relayed == nil {
+		return nil, errors.New("turn: allocate response has no relayed address")
+	}
+	if val, ok := res.Get(AttrLifetime); ok && len(val) == 4 {
+		a.Lifetime = time.Duration(binary.BigEndian.Uint32(val)) * time.Second
+	}
+	return a, nil
+}
+
+// Server returns the TURN server's address; datagrams from it carry Data indications.
+func (a *Allocation) Server() *net.UDPAddr {
+	return a.server
+}
+
+// CreatePermission asks the server to let peer send to the relayed address
+// for the next five minutes (RFC 5766 §8). It does not wait for the
+// response, since the socket is usually being read by someone else by the
+// time permissions are needed: whoever reads it passes the server's answers
+// to Handle.
+func (a *Allocation) CreatePermission(peer *net.UDPAddr) error {
+	return a.send(func(m *Message) {
+		m.Type = TypeCreatePermissionRequest
+		m.AddAddress(AttrXORPeerAddress, peer)
+	})
+}
+
+// Refresh extends the allocation by the lifetime the server granted. Like
+// CreatePermission, it does not wait for the response.
+func (a *Allocation) Refresh() error {
+	return a.send(func(m *Message) {
+		m.Type = TypeRefreshRequest
+	})
+}
+
+// Handle takes a message the server sent in answer to a request made
+// without waiting, and reports whether it was one. A 438 (Stale Nonce)
+// error carries the nonce the request is sent again with.
+func (a *Allocation) Handle(m *Message) bool {
+	a.mu.Lock()
+	build, ok := a.pending[m.TxID]
+	delete(a.pending, m.TxID)
+	stale := ok && m.ErrorCode() == 438
+	if nonce, found := m.Get(AttrNonce); stale && found {
+		a.nonce = string(nonce)
+	}
+	a.mu.Unlock()
+	if stale {
+		_ = a.send(build)
+	}
+	return ok
+}
+
+// send writes an authenticated request built by build and remembers it for
+// Handle.
+func (a *Allocation) send(build func(*Message)) error {
+	m := NewMessage(0)
+	build(m)
+	a.authenticate(m)
+	a.mu.Lock()
+	a.pending[m.TxID] = build
+	a.mu.Unlock()
+	_, err := a.conn.WriteTo(m.Encode(a.key, true), a.server)
+	return err
+}
+
+// Send relays data to peer through the server in a Send indication.
+func (a *Allocation) Send(peer *net.UDPAddr, data []byte) error {
+	m := NewMessage(TypeSendIndication)
+	m.AddAddress(AttrXORPeerAddress, peer)
+	m.Add(AttrData, data)
+	_, err := a.conn.WriteTo(m.Encode(nil, true), a.server)
+	return err
+}
+
+// Close releases the allocation with a zero-lifetime Refresh, without
+// waiting for the answer.
+func (a *Allocation) Close() error {
+	return a.send(func(m *Message) {
+		m.Type = TypeRefreshRequest
+		m.Add(AttrLifetime, []byte{0, 0, 0, 0})
+	})
+}
+
+// ParseData unwraps a Data indication, returning the peer it came from and
+// the payload it carried.
+func ParseData(raw []byte) (peer *net.UDPAddr, data []byte, ok bool) {
+	m, err := Decode(raw)
+	if err != nil || m.Type != TypeDataIndication {
+		return nil, nil, false
+	}
+	peer = m.Address(AttrXORPeerAddress)
+	data, found := m.Get(AttrData)
+	if peer == nil || !found {
+		return nil, nil, false
+	}
+	return peer, data, true
+}
+
+// request sends an authenticated (once a realm is known) request of typ,
+// with attributes added by build, and returns the server's answer.
+func (a *Allocation) request(typ uint16, timeout time.Duration, build func(*Message)) (*Message, error) {
+	m := NewMessage(typ)
+	build(m)
+	a.authenticate(m)
+
+	var res *Message
+	err := roundTrip(a.conn, a.server, m.TxID, m.Encode(a.key, true), timeout, func(raw []byte) bool {
+		r, err := Decode(raw)
+		if err != nil {
+			return false
+		}
+		res = r
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("turn: %s: %w", a.server, err)
+	}
+	return res, nil
+}
+
+// authenticate adds USERNAME, REALM and NONCE once the server has sent a challenge.
+func (a *Allocation) authenticate(m *Message) {
+	if a.key == nil {
+		return
+	}
+	a.mu.Lock()
+	nonce := a.nonce
+	a.mu.Unlock()
+	m.Add(AttrUsername, []byte(a.user))
+	m.Add(AttrRealm, []byte(a.realm))
+	m.Add(AttrNonce, []byte(nonce))
+}
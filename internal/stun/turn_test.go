@@ -0,0 +1,166 @@
+package stun
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// startTURNServer runs a TURN stand-in on loopback that challenges the first
+// Allocate, grants authenticated ones a fixed relayed address, and answers
+// Send indications to itself by echoing the payload back as a Data indication.
+// Its nonce goes stale once allocated: a Refresh is answered with 438 and a
+// fresh nonce until it carries that one.
+func startTURNServer(t *testing.T, user, pass string, relayed *net.UDPAddr) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	const realm, nonce, freshNonce = "example.org", "n0nce", "fr3sh"
+	key := LongTermKey(user, realm, pass)
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req, err := Decode(buf[:n])
+			if err != nil {
+				continue
+			}
+			switch req.Type {
+			case TypeAllocateRequest:
+				res := &Message{TxID: req.TxID}
+				if _, ok := req.Get(AttrUsername); !ok || !CheckIntegrity(buf[:n], key) {
+					res.Type = TypeAllocateError
+					res.Add(AttrErrorCode, []byte{0, 0, 4, 1})
+					res.Add(AttrRealm, []byte(realm))
+					res.Add(AttrNonce, []byte(nonce))
+					_, _ = conn.WriteTo(res.Encode(nil, true), from)
+					continue
+				}
+				res.Type = TypeAllocateSuccess
+				res.AddAddress(AttrXORRelayedAddress, relayed)
+				res.AddAddress(AttrXORMappedAddress, from.(*net.UDPAddr))
+				res.Add(AttrLifetime, []byte{0, 0, 0x02, 0x58})
+				_, _ = conn.WriteTo(res.Encode(key, true), from)
+			case TypeRefreshRequest:
+				res := &Message{TxID: req.TxID, Type: TypeRefreshSuccess}
+				if got, _ := req.Get(AttrNonce); string(got) != freshNonce {
+					res.Type = TypeRefreshError
+					res.Add(AttrErrorCode, []byte{0, 0, 4, 38})
+					res.Add(AttrNonce, []byte(freshNonce))
+				}
+				_, _ = conn.WriteTo(res.Encode(key, true), from)
+			case TypeSendIndication:
+				data, _ := req.Get(AttrData)
+				ind := NewMessage(TypeDataIndication)
+				ind.AddAddress(AttrXORPeerAddress, req.Address(AttrXORPeerAddress))
+				ind.Add(AttrData, data)
+				_, _ = conn.WriteTo(ind.Encode(nil, true), from)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestAllocate(t *testing.T) {
+	relayed := &net.UDPAddr{IP: net.ParseIP("192.0.2.50"), Port: 50000}
+	server := startTURNServer(t, "alice", "secret", relayed)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer conn.Close()
+
+	alloc, err := Allocate(conn, "turn:"+server, "alice", "secret", 2*time.Second)
+	if err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	if alloc.Relayed.String() != relayed.String() {
+		t.Errorf("Relayed = %s, want %s", alloc.Relayed, relayed)
+	}
+	if alloc.Mapped.String() != conn.LocalAddr().String() {
+		t.Errorf("Mapped = %s, want %s", alloc.Mapped, conn.LocalAddr())
+	}
+	if alloc.Lifetime != 600*time.Second {
+		t.Errorf("Lifetime = %s, want 10m0s", alloc.Lifetime)
+	}
+
+	peer := &net.UDPAddr{IP: net.ParseIP("198.51.100.9"), Port: 7078}
+	if err := alloc.Send(peer, []byte("hello")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1500)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("read data indication: %v", err)
+	}
+	from, data, ok := ParseData(buf[:n])
+	if !ok || from.String() != peer.String() || string(data) != "hello" {
+		t.Errorf("ParseData = (%v, %q, %v), want (%s, %q, true)", from, data, ok, peer, "hello")
+	}
+}
+
+func TestAllocateBadCredentials(t *testing.T) {
+	server := startTURNServer(t, "alice", "secret", &net.UDPAddr{IP: net.ParseIP("192.0.2.50"), Port: 50000})
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := Allocate(conn, server, "alice", "wrong", 2*time.Second); err == nil {
+		t.Fatal("expected error for bad credentials")
+	}
+}
+
+func TestRefreshStaleNonce(t *testing.T) {
+	server := startTURNServer(t, "alice", "secret", &net.UDPAddr{IP: net.ParseIP("192.0.2.50"), Port: 50000})
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer conn.Close()
+
+	alloc, err := Allocate(conn, server, "alice", "secret", 2*time.Second)
+	if err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	if err := alloc.Refresh(); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+
+	// The 438 is retried with the new nonce, which the server accepts.
+	buf := make([]byte, 1500)
+	for _, want := range []uint16{TypeRefreshError, TypeRefreshSuccess} {
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("read refresh answer: %v", err)
+		}
+		m, err := Decode(buf[:n])
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		if m.Type != want {
+			t.Fatalf("answer type = %#x, want %#x", m.Type, want)
+		}
+		if !alloc.Handle(m) {
+			t.Fatalf("Handle(%#x) = false, want true", m.Type)
+		}
+	}
+
+	unknown := NewMessage(TypeRefreshSuccess)
+	if alloc.Handle(unknown) {
+		t.Error("Handle of an unsolicited answer = true, want false")
+	}
+}
@@ -2,15 +2,26 @@ package tui
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/rivo/tview"
 	"github.com/siptty/siptty/internal/engine"
 )
 
+// accountInfo is the latest known state of one account, kept for the details view.
+type accountInfo struct {
+	index int // list index
+	reg   engine.RegStateEvent
+	tls   *engine.TLSStateEvent
+	nat   *engine.NATStateEvent
+	alive *engine.KeepaliveEvent
+}
+
 // AccountPanel displays SIP account registration state.
 type AccountPanel struct {
 	list     *tview.List
-	accounts map[string]int // accountID -> list index
+	accounts map[string]*accountInfo // accountID -> state
+	order    []string                // list index -> accountID
 }
 
 // NewAccountPanel creates a tview.List with title "ACCOUNTS" and border.
@@ -20,15 +31,103 @@ func NewAccountPanel() *AccountPanel {
 	list.SetTitle("ACCOUNTS").SetBorder(true)
 	return &AccountPanel{
 		list:     list,
-		accounts: make(map[string]int),
+		accounts: make(map[string]*accountInfo),
 	}
 }
 
 // Update processes a RegStateEvent and updates the account display.
 // Colored bullet: green "●" registered, red "○" unregistered, yellow "◉" failed.
 func (p *AccountPanel) Update(ev engine.RegStateEvent) {
+	info := p.account(ev.AccountID)
+	info.reg = ev
+	p.render(ev.AccountID, info)
+}
+
+// UpdateTLS records the negotiated TLS parameters for an account.
+func (p *AccountPanel) UpdateTLS(ev engine.TLSStateEvent) {
+	info := p.account(ev.AccountID)
+	info.tls = &ev
+	p.render(ev.AccountID, info)
+}
+
+// UpdateNAT records the public address learned for an account.
+func (p *AccountPanel) UpdateNAT(ev engine.NATStateEvent) {
+	info := p.account(ev.AccountID)
+	info.nat = &ev
+	p.render(ev.AccountID, info)
+}
+
+// UpdateKeepalive records the latest keepalive result for an account.
+func (p *AccountPanel) UpdateKeepalive(ev engine.KeepaliveEvent) {
+	info := p.account(ev.AccountID)
+	info.alive = &ev
+	p.render(ev.AccountID, info)
+}
+
+// SelectedAccountID returns the account ID of the currently selected list item.
+func (p *AccountPanel) SelectedAccountID() string {
+	idx := p.list.GetCurrentItem()
+	if idx < 0 || idx >= len(p.order) {
+		return ""
+	}
+	return p.order[idx]
+}
+
+// Details returns a multi-line description of an account for the details modal.
+func (p *AccountPanel) Details(accountID string) string {
+	info, ok := p.accounts[accountID]
+	if !ok {
+		return fmt.Sprintf("Account %s: no state yet", accountID)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Account: %s\n", accountID)
+	fmt.Fprintf(&b, "State:   %s\n", info.reg.State)
+	if info.reg.Reason != "" {
+		fmt.Fprintf(&b, "Reason:  %s\n", info.reg.Reason)
+	}
+	if len(info.reg.ServiceRoute) > 0 {
+		fmt.Fprintf(&b, "Service-Route: %s\n", strings.Join(info.reg.ServiceRoute, ", "))
+	}
+	if len(info.reg.Path) > 0 {
+		fmt.Fprintf(&b, "Path:    %s\n", strings.Join(info.reg.Path, ", "))
+	}
+	if info.alive != nil {
+		fmt.Fprintf(&b, "Keepalive: %s\n", reachability(info.alive))
+		if info.alive.Reason != "" {
+			fmt.Fprintf(&b, "  %s\n", info.alive.Reason)
+		}
+	}
+	if info.tls != nil {
+		b.WriteString("\nTLS\n")
+		fmt.Fprintf(&b, "  Server name: %s\n", info.tls.ServerName)
+		fmt.Fprintf(&b, "  Version:     %s\n", info.tls.Version)
+		fmt.Fprintf(&b, "  Cipher:      %s\n", info.tls.CipherSuite)
+		fmt.Fprintf(&b, "  Peer cert:   %s\n", info.tls.PeerSubject)
+	}
+	if info.nat != nil {
+		b.WriteString("\nNAT\n")
+		fmt.Fprintf(&b, "  Public addr: %s\n", info.nat.PublicAddr)
+		fmt.Fprintf(&b, "  Learned via: %s\n", info.nat.Source)
+	}
+	return b.String()
+}
+
+// account returns the state for accountID, adding a list item if it is new.
+func (p *AccountPanel) account(accountID string) *accountInfo {
+	if info, ok := p.accounts[accountID]; ok {
+		return info
+	}
+	info := &accountInfo{index: p.list.GetItemCount()}
+	p.list.AddItem(accountID, "", 0, nil)
+	p.accounts[accountID] = info
+	p.order = append(p.order, accountID)
+	return info
+}
+
+func (p *AccountPanel) render(accountID string, info *accountInfo) {
 	var bullet string
-	switch ev.State {
+	switch info.reg.State {
 	case "registered":
 		bullet = "[green]●[-]"
 	case "unregistered":
@@ -39,17 +138,35 @@ func (p *AccountPanel) Update(ev engine.RegStateEvent) {
 		bullet = "[grey]?[-]"
 	}
 
-	primary := fmt.Sprintf("%s %s", bullet, ev.AccountID)
-	secondary := fmt.Sprintf("  %s", ev.State)
-	if ev.Reason != "" {
-		secondary = fmt.Sprintf("  %s (%s)", ev.State, ev.Reason)
+	primary := fmt.Sprintf("%s %s", bullet, accountID)
+	secondary := fmt.Sprintf("  %s", info.reg.State)
+	if info.reg.Reason != "" {
+		secondary = fmt.Sprintf("  %s (%s)", info.reg.State, info.reg.Reason)
+	}
+	if info.tls != nil {
+		secondary += fmt.Sprintf(" [green]%s[-]", info.tls.Version)
+	}
+	if info.nat != nil {
+		secondary += fmt.Sprintf(" [blue]NAT %s[-]", info.nat.PublicAddr)
 	}
+	if info.alive != nil {
+		color := "green"
+		if !info.alive.Reachable {
+			color = "red"
+		}
+		secondary += fmt.Sprintf(" [%s]%s[-]", color, reachability(info.alive))
+	}
+
+	p.list.SetItemText(info.index, primary, secondary)
+}
 
-	if idx, ok := p.accounts[ev.AccountID]; ok {
-		p.list.SetItemText(idx, primary, secondary)
-	} else {
-		idx := p.list.GetItemCount()
-		p.list.AddItem(primary, secondary, 0, nil)
-		p.accounts[ev.AccountID] = idx
+// reachability renders a keepalive result: "reachable 23ms" or "unreachable".
+func reachability(ev *engine.KeepaliveEvent) string {
+	if !ev.Reachable {
+		return "unreachable"
+	}
+	if ev.RTT == 0 {
+		return "reachable"
 	}
+	return fmt.Sprintf("reachable %dms", ev.RTT.Milliseconds())
 }
@@ -3,12 +3,20 @@ package tui
 import (
 	"fmt"
 	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
+	"github.com/siptty/siptty/internal/anonymize"
+	"github.com/siptty/siptty/internal/config"
 	"github.com/siptty/siptty/internal/engine"
+	"github.com/siptty/siptty/internal/ladder"
 )
 
 const (
@@ -20,12 +28,18 @@ const (
 type EngineInterface interface {
 	Events() <-chan engine.Event
 	Accounts() []string
+	Codecs(accountID string) []engine.Codec
+	SetCodecs(accountID string, codecs []engine.Codec) error
 	Dial(accountID, uri string) error
 	Answer(callID string) error
 	Hangup(callID string) error
 	SendDTMF(callID string, digit rune) error
 	Transfer(callID, target string) error
 	PlayAudio(callID, path string) error
+	SetImpairment(callID string, c config.ImpairmentConfig) error
+	DialogState(callID string) *engine.DialogState
+	TraceStore() *engine.TraceStore
+	RTPPackets(sipCallID string) []engine.RTPPacket
 }
 
 // App is the top-level TUI application.
@@ -36,7 +50,7 @@ type App struct {
 	calls    *CallPanel
 	trace    *TracePanel
 	blf      *tview.Table
-	dialogs  *tview.TextView
+	dialogs  *DialogsPanel
 	pages    *tview.Pages
 	grid     *tview.Grid
 
@@ -47,29 +61,37 @@ type App struct {
 	// overlay prevents global keys from interfering with modals/prompts
 	overlay bool
 
+	// refreshOverlay, if set, redraws a live overlay after new events.
+	refreshOverlay func()
+
 	// traceDirty is set when trace events are buffered but not yet flushed.
 	// A timer goroutine flushes and redraws at most every 50ms.
 	traceDirty atomic.Bool
+
+	// anon anonymizes exports, with trace.anonymize enabled.
+	anon *anonymize.Anonymizer
 }
 
-// NewApp builds the full tview layout and returns an App.
-func NewApp(eng EngineInterface) *App {
+// NewApp builds the full tview layout and returns an App. traceCfg holds the
+// saved trace filters.
+func NewApp(eng EngineInterface, traceCfg config.TraceConfig) *App {
 	a := &App{
 		app:    tview.NewApplication(),
 		engine: eng,
+		anon:   anonymize.FromConfig(traceCfg.Anonymize),
 	}
 
 	// Build panels.
 	a.accounts = NewAccountPanel()
 	a.calls = NewCallPanel()
-	a.trace = NewTracePanel()
+	a.trace = NewTracePanel(eng.TraceStore(), traceCfg)
 	a.blf = newBLFPlaceholder()
-	a.dialogs = newDialogsPlaceholder()
+	a.dialogs = NewDialogsPanel(eng.TraceStore())
 
 	// Bottom tabbed section — trace and dialogs only (calls live in the top row).
 	a.pages = tview.NewPages().
 		AddPage("trace", a.trace.view, true, true).
-		AddPage("dialogs", a.dialogs, true, false)
+		AddPage("dialogs", a.dialogs.table, true, false)
 
 	tabBar := tview.NewTextView().
 		SetDynamicColors(true).
@@ -90,7 +112,7 @@ func NewApp(eng EngineInterface) *App {
 	// Footer.
 	footer := tview.NewTextView().
 		SetDynamicColors(true).
-		SetText("[yellow]d[white]:Dial [yellow]a[white]:Ans [yellow]h[white]:Hang [yellow]x[white]:Xfer [yellow]p[white]:DTMF [yellow]Tab[white]:Focus [yellow]1/2[white]:Tabs [yellow]?[white]:Help")
+		SetText("[yellow]d[white]:Dial [yellow]a[white]:Ans [yellow]h[white]:Hang [yellow]x[white]:Xfer [yellow]p[white]:DTMF [yellow]Tab[white]:Focus [yellow]1/2[white]:Tabs [yellow]Enter[white]:Msg [yellow]?[white]:Help")
 
 	// Top three-column row.
 	topRow := tview.NewFlex().SetDirection(tview.FlexColumn).
@@ -138,11 +160,30 @@ func NewApp(eng EngineInterface) *App {
 		}
 	})
 
-	// Focus cycle: top-row panels only.
+	// Enter on an account or call opens its details.
+	a.accounts.list.SetSelectedFunc(func(int, string, string, rune) {
+		a.showAccountDetails()
+	})
+	a.calls.table.SetSelectedFunc(func(int, int) {
+		a.showCallDetails()
+	})
+	// Enter on a trace entry opens the full message.
+	a.trace.view.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEnter {
+			a.showTraceDetail()
+		}
+	})
+	// Enter on a dialog opens its ladder diagram.
+	a.dialogs.table.SetSelectedFunc(func(int, int) {
+		a.showDialogLadder()
+	})
+
+	// Focus cycle: top-row panels, then the bottom tabs.
 	a.panels = []tview.Primitive{
 		a.accounts.list,
 		a.calls.table,
 		a.blf,
+		a.pages,
 	}
 	a.focus = 1 // start on calls table
 	a.highlightFocus()
@@ -156,6 +197,7 @@ func NewApp(eng EngineInterface) *App {
 func (a *App) Run() error {
 	a.app.SetRoot(a.grid, true)
 	go a.eventLoop()
+	a.scheduleTraceDraw() // show anything already in the store
 	return a.app.Run()
 }
 
@@ -176,16 +218,41 @@ func (a *App) eventLoop() {
 			a.app.QueueUpdateDraw(func() {
 				a.accounts.Update(e)
 			})
+		case engine.TLSStateEvent:
+			a.app.QueueUpdateDraw(func() {
+				a.accounts.UpdateTLS(e)
+			})
+		case engine.ICEStateEvent:
+			a.app.QueueUpdateDraw(func() {
+				a.calls.UpdateICE(e)
+			})
+		case engine.KeepaliveEvent:
+			a.app.QueueUpdateDraw(func() {
+				a.accounts.UpdateKeepalive(e)
+			})
+		case engine.NATStateEvent:
+			a.app.QueueUpdateDraw(func() {
+				a.accounts.UpdateNAT(e)
+			})
 		case engine.CallStateEvent:
 			a.app.QueueUpdateDraw(func() {
 				a.calls.Update(e)
+				if a.refreshOverlay != nil {
+					a.refreshOverlay()
+				}
 			})
 		case engine.SipTraceEvent:
-			// Buffer the trace text (goroutine-safe, never blocks) and schedule
-			// a debounced flush+redraw. This keeps the eventLoop free-running
-			// and batches rapid trace events into a single redraw.
-			a.trace.Buffer(e)
+			// The message is already in the trace store; schedule a debounced
+			// flush+redraw. This keeps the eventLoop free-running and batches
+			// rapid trace events into a single redraw.
 			a.scheduleTraceDraw()
+		case engine.MediaStatsEvent:
+			a.app.QueueUpdateDraw(func() {
+				a.calls.UpdateStats(e)
+				if a.refreshOverlay != nil {
+					a.refreshOverlay()
+				}
+			})
 		case engine.DTMFEvent:
 			a.app.QueueUpdateDraw(func() {
 				a.calls.ShowDTMF(e)
@@ -204,6 +271,10 @@ func (a *App) scheduleTraceDraw() {
 			a.traceDirty.Store(false)
 			a.app.QueueUpdateDraw(func() {
 				a.trace.Flush()
+				a.dialogs.Flush()
+				if a.refreshOverlay != nil {
+					a.refreshOverlay()
+				}
 			})
 		}()
 	}
@@ -235,6 +306,36 @@ func (a *App) setupKeyBindings() {
 			return event
 		}
 
+		// Trace panel prompts.
+		if a.app.GetFocus() == a.trace.view && event.Key() == tcell.KeyRune {
+			switch event.Rune() {
+			case '/':
+				a.promptTraceSearch()
+				return nil
+			case 'f':
+				a.promptTraceFilter()
+				return nil
+			case 'w', 'W':
+				a.promptExport(a.trace.VisibleEvents(), event.Rune() == 'W')
+				return nil
+			}
+		}
+		// Dialog list export, of the marked dialogs or the selected one.
+		if a.app.GetFocus() == a.dialogs.table && event.Key() == tcell.KeyRune {
+			switch event.Rune() {
+			case 'w', 'W':
+				if group := a.dialogs.Group(); len(group) > 0 {
+					a.promptExport(a.dialogs.GroupMessages(group), event.Rune() == 'W')
+				}
+				return nil
+			case 'e', 'E':
+				if group := a.dialogs.Group(); len(group) > 0 {
+					a.promptFlowExport(a.dialogs.GroupMessages(group), event.Rune() == 'E', nil, nil)
+				}
+				return nil
+			}
+		}
+
 		switch event.Key() {
 		case tcell.KeyF1:
 			a.showHelp()
@@ -262,6 +363,12 @@ func (a *App) setupKeyBindings() {
 			case 'p':
 				a.promptDTMF()
 				return nil
+			case 'i':
+				a.promptImpairment()
+				return nil
+			case 'c':
+				a.showCodecs()
+				return nil
 			case '1':
 				a.pages.SwitchToPage("trace")
 				return nil
@@ -299,12 +406,16 @@ func setBorderColor(p tview.Primitive, color tcell.Color) {
 		v.SetBorderColor(color)
 	case *tview.TextView:
 		v.SetBorderColor(color)
+	case *tview.Pages:
+		_, front := v.GetFrontPage()
+		setBorderColor(front, color)
 	}
 }
 
 // restoreGrid resets the root to the main grid and restores focus after an overlay.
 func (a *App) restoreGrid() {
 	a.overlay = false
+	a.refreshOverlay = nil
 	a.app.SetRoot(a.grid, true)
 	a.app.SetFocus(a.panels[a.focus])
 	a.highlightFocus()
@@ -382,6 +493,220 @@ func (a *App) promptDTMF() {
 	a.app.SetFocus(input)
 }
 
+// promptImpairment reads a network impairment (see engine.ParseImpairment)
+// for the selected call, starting from the current one. An empty
+// expression turns it off.
+func (a *App) promptImpairment() {
+	callID := a.calls.SelectedCallID()
+	if callID == "" {
+		return
+	}
+	a.overlay = true
+	input := tview.NewInputField().
+		SetLabel("Impairment: ").
+		SetText(a.calls.Impairment(callID))
+	input.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEnter {
+			a.applyImpairment(callID, input.GetText())
+		}
+		a.restoreGrid()
+	})
+	a.app.SetRoot(tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(a.grid, 0, 1, false).
+		AddItem(input, 1, 0, true),
+		true,
+	)
+	a.app.SetFocus(input)
+}
+
+func (a *App) applyImpairment(callID, expr string) {
+	c, err := engine.ParseImpairment(expr)
+	if err == nil {
+		err = a.engine.SetImpairment(callID, c)
+	}
+	switch {
+	case err != nil:
+		a.setStatus(fmt.Sprintf("Impairment error: %v", err))
+	case engine.FormatImpairment(c) == "":
+		a.setStatus(fmt.Sprintf("Call %s: impairment off", callID))
+	default:
+		a.setStatus(fmt.Sprintf("Call %s: impairment %s", callID, engine.FormatImpairment(c)))
+	}
+}
+
+// promptTraceSearch reads a search term, marking matches in the trace as it
+// is typed. Enter keeps the search, Escape clears it.
+func (a *App) promptTraceSearch() {
+	a.overlay = true
+	input := tview.NewInputField().
+		SetLabel("Search: ").
+		SetText(a.trace.search)
+	input.SetChangedFunc(func(text string) {
+		a.trace.SetSearch(text)
+	})
+	input.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEscape {
+			a.trace.SetSearch("")
+		}
+		a.restoreGrid()
+	})
+	a.app.SetRoot(tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(a.grid, 0, 1, false).
+		AddItem(input, 1, 0, true),
+		true,
+	)
+	a.app.SetFocus(input)
+}
+
+// promptTraceFilter reads a filter expression (see engine.ParseTraceFilter)
+// for the trace panel. An empty expression clears the filter.
+func (a *App) promptTraceFilter() {
+	a.overlay = true
+	input := tview.NewInputField().
+		SetLabel("Filter: ")
+	input.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEnter {
+			a.applyTraceFilter(input.GetText())
+		}
+		a.restoreGrid()
+	})
+	a.app.SetRoot(tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(a.grid, 0, 1, false).
+		AddItem(input, 1, 0, true),
+		true,
+	)
+	a.app.SetFocus(input)
+}
+
+// promptExport asks for a file to export events to; its extension picks
+// pcapng, JSON Lines or text (see engine.ExportTraceFile).
+func (a *App) promptExport(events []engine.SipTraceEvent, withRTP bool) {
+	if len(events) == 0 {
+		a.setStatus("Nothing to export")
+		return
+	}
+	a.overlay = true
+	label := "Export (.pcapng/.txt/.jsonl): "
+	if withRTP {
+		label = "Export with RTP (.pcapng): "
+	}
+	if a.anon != nil {
+		label = "Anonymized " + strings.ToLower(label[:1]) + label[1:]
+	}
+	input := tview.NewInputField().
+		SetLabel(label).
+		SetText(time.Now().Format("siptty-20060102-150405.pcapng"))
+	input.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEnter && strings.TrimSpace(input.GetText()) != "" {
+			a.exportTrace(strings.TrimSpace(input.GetText()), events, withRTP)
+		}
+		a.restoreGrid()
+	})
+	a.app.SetRoot(tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(a.grid, 0, 1, false).
+		AddItem(input, 1, 0, true),
+		true,
+	)
+	a.app.SetFocus(input)
+}
+
+func (a *App) exportTrace(path string, events []engine.SipTraceEvent, withRTP bool) {
+	var rtp []engine.RTPPacket
+	if withRTP {
+		seen := make(map[string]bool)
+		for _, ev := range events {
+			if id := ev.CallID(); id != "" && !seen[id] {
+				seen[id] = true
+				rtp = append(rtp, a.engine.RTPPackets(id)...)
+			}
+		}
+	}
+	if a.anon != nil {
+		events, rtp = a.anon.Events(events), a.anon.RTP(rtp)
+	}
+	n, err := engine.ExportTraceFile(path, events, rtp)
+	if err != nil {
+		a.setStatus(fmt.Sprintf("Export error: %v", err))
+		return
+	}
+	what := "messages"
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".pcap" || ext == ".pcapng" {
+		what = "packets"
+	}
+	msg := fmt.Sprintf("Wrote %d %s to %s", n, what, path)
+	if a.anon != nil {
+		msg += " (anonymized)"
+	}
+	if withRTP && len(rtp) == 0 {
+		msg += " (no RTP captured; set capture_rtp)"
+	}
+	a.setStatus(msg)
+}
+
+// promptFlowExport asks for a file to write the call flow of events to; its
+// extension picks Mermaid, PlantUML or text (see ladder.FormatForPath).
+// With delta set, arrows are labeled with their offset from the first. The
+// prompt returns to back, focused on focus, or to the main grid when nil.
+func (a *App) promptFlowExport(events []engine.SipTraceEvent, delta bool, back, focus tview.Primitive) {
+	diagram := ladder.Build(events)
+	if len(diagram.Arrows) == 0 {
+		a.setStatus("Nothing to export")
+		return
+	}
+	a.overlay = true
+	label := "Export call flow (.mmd/.puml/.txt): "
+	if delta {
+		label = "Export call flow with time offsets (.mmd/.puml/.txt): "
+	}
+	input := tview.NewInputField().
+		SetLabel(label).
+		SetText(time.Now().Format("siptty-20060102-150405.mmd"))
+	input.SetDoneFunc(func(key tcell.Key) {
+		if path := strings.TrimSpace(input.GetText()); key == tcell.KeyEnter && path != "" {
+			format := ladder.FormatForPath(path)
+			if err := os.WriteFile(path, []byte(diagram.Render(format, delta)), 0644); err != nil {
+				a.setStatus(fmt.Sprintf("Export error: %v", err))
+			} else {
+				a.setStatus(fmt.Sprintf("Wrote %s call flow of %d messages to %s", format, len(diagram.Arrows), path))
+			}
+		}
+		if back == nil {
+			a.restoreGrid()
+			return
+		}
+		a.app.SetRoot(back, true)
+		a.app.SetFocus(focus)
+	})
+	var under tview.Primitive = a.grid
+	if back != nil {
+		under = back
+	}
+	a.app.SetRoot(tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(under, 0, 1, false).
+		AddItem(input, 1, 0, true),
+		true,
+	)
+	a.app.SetFocus(input)
+}
+
+func (a *App) applyTraceFilter(expr string) {
+	if strings.TrimSpace(expr) == "" {
+		a.trace.SetFilter(nil)
+		return
+	}
+	fc, err := engine.ParseTraceFilter(expr)
+	if err != nil {
+		a.setStatus(fmt.Sprintf("Filter error: %v", err))
+		return
+	}
+	f, err := engine.NewTraceFilter(fc)
+	if err != nil {
+		a.setStatus(fmt.Sprintf("Filter error: %v", err))
+		return
+	}
+	a.trace.SetFilter(f)
+}
+
 func (a *App) showHelp() {
 	a.overlay = true
 	modal := tview.NewModal().
@@ -389,13 +714,37 @@ func (a *App) showHelp() {
 			"NAVIGATION\n" +
 			"  Tab ............ Cycle panel focus\n" +
 			"  1 / 2 .......... Switch bottom tabs\n" +
-			"  Escape ......... Cancel input\n\n" +
+			"  Escape ......... Cancel input\n" +
+			"  Enter .......... Account details (accounts panel)\n" +
+			"  Enter .......... Call and dialog state, live (calls panel)\n\n" +
+			"SIP TRACE\n" +
+			"  Up/Down, k/j ... Select message\n" +
+			"  Enter .......... Full message\n" +
+			"  n / N .......... Next / previous in Call-ID\n" +
+			"  Escape ......... Follow new messages\n" +
+			"  / .............. Search (n / N: next / previous match)\n" +
+			"  f .............. Filter, e.g. method:INVITE status:4xx\n" +
+			"  F .............. Cycle saved filters\n" +
+			"  o .............. Show / hide OPTIONS\n" +
+			"  w / W .......... Export shown messages (pcapng, txt, jsonl) / with RTP\n\n" +
+			"SIP DIALOGS\n" +
+			"  Enter .......... Ladder diagram (of the marked dialogs, if any)\n" +
+			"  Space .......... Mark dialog, to view and export together\n" +
+			"  s / S .......... Sort column / reverse sort\n" +
+			"  w / W .......... Export dialog (pcapng, txt, jsonl) / with RTP\n" +
+			"  e / E .......... Export call flow (mmd, puml, txt) / with time offsets\n" +
+			"  Up/Down (ladder) Select message\n" +
+			"  t (ladder) ..... Wall clock / relative time\n" +
+			"  c (ladder) ..... Show / hide correlated legs (transfers, forks, SBC legs)\n" +
+			"  e (ladder) ..... Export call flow\n\n" +
 			"CALL CONTROL\n" +
 			"  d .............. Dial a SIP URI\n" +
 			"  a .............. Answer incoming call\n" +
 			"  h .............. Hangup selected call\n" +
 			"  x .............. Transfer call\n" +
-			"  p .............. Send DTMF digits\n\n" +
+			"  p .............. Send DTMF digits\n" +
+			"  i .............. Network impairment, e.g. loss:5 burst:3 jitter:40 sip_drop:10\n" +
+			"  c .............. Codec priority (reorder, enable) and negotiated codecs\n\n" +
 			"GENERAL\n" +
 			"  ? / F1 ......... This help\n" +
 			"  F10 / Ctrl-C ... Quit").
@@ -406,9 +755,373 @@ func (a *App) showHelp() {
 	a.app.SetRoot(modal, true)
 }
 
+func (a *App) showAccountDetails() {
+	accountID := a.accounts.SelectedAccountID()
+	if accountID == "" {
+		return
+	}
+	a.overlay = true
+	modal := tview.NewModal().
+		SetText(a.accounts.Details(accountID)).
+		AddButtons([]string{"Close"}).
+		SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+			a.restoreGrid()
+		})
+	a.app.SetRoot(modal, true)
+}
+
+// showCallDetails opens the state of the selected call and of its INVITE
+// dialog, as the engine follows it. It is redrawn as new messages arrive, so
+// re-INVITEs show up while it is open.
+func (a *App) showCallDetails() {
+	callID := a.calls.SelectedCallID()
+	if callID == "" {
+		return
+	}
+	a.overlay = true
+
+	view := tview.NewTextView().
+		SetDynamicColors(true).
+		SetScrollable(true)
+	view.SetBorder(true).SetTitle("Call " + callID)
+	draw := func() {
+		text := tview.Escape(a.calls.Details(callID))
+		if st := a.engine.DialogState(callID); st != nil {
+			text += formatDialogState(st)
+		}
+		row, col := view.GetScrollOffset()
+		view.SetText(text).ScrollTo(row, col)
+	}
+	draw()
+	a.refreshOverlay = draw
+
+	view.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyEscape || event.Key() == tcell.KeyRune && event.Rune() == 'q' {
+			a.restoreGrid()
+			return nil
+		}
+		return event
+	})
+
+	hint := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText("[yellow]Up/Down[white]:Scroll [yellow]Esc[white]:Close")
+	a.app.SetRoot(tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(view, 0, 1, true).
+		AddItem(hint, 1, 0, false),
+		true,
+	)
+	a.app.SetFocus(view)
+}
+
+// showCodecs opens the codec priority list of the selected account, or
+// the first, and the codec negotiated on each call, redrawn as calls
+// change. u and d move the selected codec up and down the list, Space
+// enables or disables it and a steps to the next account; a change applies
+// from the account's next offer or answer.
+func (a *App) showCodecs() {
+	a.overlay = true
+
+	accounts := a.engine.Accounts()
+	accountID := a.accounts.SelectedAccountID()
+	if accountID == "" && len(accounts) > 0 {
+		accountID = accounts[0]
+	}
+	sel := 0
+
+	view := tview.NewTextView().
+		SetDynamicColors(true).
+		SetScrollable(true)
+	view.SetBorder(true)
+	hint := tview.NewTextView().SetDynamicColors(true)
+	setHint := func(msg string) {
+		hint.SetText("[yellow]Up/Down[white]:Select [yellow]u/d[white]:Move [yellow]Space[white]:On/off [yellow]a[white]:Account [yellow]Esc[white]:Close" + msg)
+	}
+	setHint("")
+
+	draw := func() {
+		view.SetTitle("Codecs: " + accountID)
+		codecs := a.engine.Codecs(accountID)
+		sel = max(min(sel, len(codecs)-1), 0)
+		var b strings.Builder
+		b.WriteString("[yellow]OFFERED[-]\n")
+		for i, c := range codecs {
+			mark := "[ ]"
+			if c.Enabled {
+				mark = "[x]"
+			}
+			line := fmt.Sprintf("  %s %-22s PT %-3d %d ms", mark, c, c.PayloadType, c.Ptime.Milliseconds())
+			if c.Fmtp != "" {
+				line += "  " + c.Fmtp
+			}
+			line = tview.Escape(line)
+			if i == sel {
+				line = "[::r]" + line + "[::-]"
+			}
+			b.WriteString(line + "\n")
+		}
+		if len(codecs) == 0 {
+			b.WriteString("  no account\n")
+		}
+		b.WriteString("\n")
+		b.WriteString("[yellow]CALLS[-]\n")
+		if lines := a.calls.Codecs(); len(lines) > 0 {
+			b.WriteString(tview.Escape(strings.Join(lines, "\n")) + "\n")
+		} else {
+			b.WriteString("  none\n")
+		}
+		row, col := view.GetScrollOffset()
+		view.SetText(b.String()).ScrollTo(row, col)
+	}
+	draw()
+	a.refreshOverlay = draw
+
+	apply := func(codecs []engine.Codec) {
+		if err := a.engine.SetCodecs(accountID, codecs); err != nil {
+			setHint("  [red]" + tview.Escape(err.Error()))
+		} else {
+			setHint("")
+		}
+		draw()
+	}
+
+	view.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		codecs := a.engine.Codecs(accountID)
+		switch {
+		case event.Key() == tcell.KeyEscape || event.Key() == tcell.KeyRune && event.Rune() == 'q':
+			a.restoreGrid()
+		case event.Key() == tcell.KeyUp:
+			sel--
+			draw()
+		case event.Key() == tcell.KeyDown:
+			sel++
+			draw()
+		case event.Key() != tcell.KeyRune || len(codecs) == 0:
+			return event
+		case event.Rune() == 'u' && sel > 0:
+			codecs[sel-1], codecs[sel] = codecs[sel], codecs[sel-1]
+			sel--
+			apply(codecs)
+		case event.Rune() == 'd' && sel < len(codecs)-1:
+			codecs[sel], codecs[sel+1] = codecs[sel+1], codecs[sel]
+			sel++
+			apply(codecs)
+		case event.Rune() == ' ':
+			codecs[sel].Enabled = !codecs[sel].Enabled
+			apply(codecs)
+		case event.Rune() == 'a' && len(accounts) > 1:
+			i := slices.Index(accounts, accountID)
+			accountID = accounts[(i+1)%len(accounts)]
+			sel = 0
+			setHint("")
+			draw()
+		default:
+			return event
+		}
+		return nil
+	})
+
+	a.app.SetRoot(tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(view, 0, 1, true).
+		AddItem(hint, 1, 0, false),
+		true,
+	)
+	a.app.SetFocus(view)
+}
+
+// showTraceDetail opens the full text of the selected trace entry, or the
+// newest one, with n / N stepping through the messages of its Call-ID.
+func (a *App) showTraceDetail() {
+	entry, ok := a.trace.Selected()
+	if !ok {
+		a.trace.moveSelection(1)
+		if entry, ok = a.trace.Selected(); !ok {
+			return
+		}
+	}
+	a.overlay = true
+
+	view := tview.NewTextView().
+		SetDynamicColors(true).
+		SetScrollable(true)
+	view.SetBorder(true)
+	show := func(e traceEntry) {
+		entry = e
+		a.trace.Select(e.seq)
+		view.SetTitle(a.traceDetailTitle(e))
+		view.SetText(traceDetailHeader(e) + formatSIPMessage(e.ev)).ScrollToBeginning()
+	}
+	show(entry)
+
+	view.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyEscape {
+			a.restoreGrid()
+			return nil
+		}
+		if event.Key() != tcell.KeyRune {
+			return event
+		}
+		switch event.Rune() {
+		case 'q':
+			a.restoreGrid()
+		case 'n':
+			if next, ok := a.trace.Sibling(entry.seq, 1); ok {
+				show(next)
+			}
+		case 'N':
+			if prev, ok := a.trace.Sibling(entry.seq, -1); ok {
+				show(prev)
+			}
+		default:
+			return event
+		}
+		return nil
+	})
+
+	hint := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText("[yellow]n/N[white]:Next/prev in Call-ID [yellow]Up/Down[white]:Scroll [yellow]Esc[white]:Close")
+	a.app.SetRoot(tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(view, 0, 1, true).
+		AddItem(hint, 1, 0, false),
+		true,
+	)
+	a.app.SetFocus(view)
+}
+
+// showDialogLadder opens the call flow of the selected dialog: a ladder
+// diagram with one arrow per message above the full text of the selected one.
+func (a *App) showDialogLadder() {
+	group := a.dialogs.Group()
+	if len(group) == 0 {
+		return
+	}
+	events := a.dialogs.GroupMessages(group)
+	diagram := ladder.Build(events)
+	if len(diagram.Arrows) == 0 {
+		return
+	}
+	a.overlay = true
+
+	// With c, the ladder shows every leg correlated with the group as well.
+	related := a.dialogs.Related(group)
+	shown := group
+
+	flow := tview.NewTextView().
+		SetDynamicColors(true).
+		SetRegions(true).
+		SetWrap(false).
+		SetScrollable(true)
+	flow.SetBorder(true)
+	detail := tview.NewTextView().
+		SetDynamicColors(true).
+		SetScrollable(true)
+	detail.SetBorder(true)
+
+	var root *tview.Flex
+	selected, delta := 0, false
+	draw := func() {
+		title := fmt.Sprintf("%d dialogs (%d messages)", len(shown), len(diagram.Arrows))
+		if d := shown[0]; len(shown) == 1 {
+			title = fmt.Sprintf("%s %s → %s — %s (%d messages)", d.Method, d.From, d.To, d.State, len(diagram.Arrows))
+		}
+		if len(related) > len(shown) {
+			title += fmt.Sprintf(" — %d correlated legs", len(related))
+		}
+		flow.SetTitle(tview.Escape(title))
+		flow.SetText(ladderText(diagram, delta))
+		flow.Highlight(strconv.Itoa(selected)).ScrollToHighlight()
+		ev := diagram.Arrows[selected].Event
+		detail.SetTitle(tview.Escape(fmt.Sprintf("%d/%d %s", selected+1, len(diagram.Arrows), diagram.Arrows[selected].Label)))
+		detail.SetText(traceDetailHeader(traceEntry{ev: ev}) + formatSIPMessage(ev)).ScrollToBeginning()
+	}
+	move := func(i int) {
+		selected = max(0, min(i, len(diagram.Arrows)-1))
+		draw()
+	}
+	draw()
+
+	flow.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyEscape:
+			a.restoreGrid()
+		case tcell.KeyUp:
+			move(selected - 1)
+		case tcell.KeyDown:
+			move(selected + 1)
+		case tcell.KeyHome:
+			move(0)
+		case tcell.KeyEnd:
+			move(len(diagram.Arrows) - 1)
+		case tcell.KeyPgUp, tcell.KeyPgDn:
+			detail.InputHandler()(event, nil) // scroll the message
+		case tcell.KeyRune:
+			switch event.Rune() {
+			case 'q':
+				a.restoreGrid()
+			case 'k':
+				move(selected - 1)
+			case 'j':
+				move(selected + 1)
+			case 't':
+				delta = !delta
+				draw()
+			case 'e':
+				a.promptFlowExport(events, delta, root, flow)
+			case 'c':
+				next := group
+				if len(shown) < len(related) {
+					next = related
+				}
+				nextEvents := a.dialogs.GroupMessages(next)
+				nextDiagram := ladder.Build(nextEvents)
+				if len(nextDiagram.Arrows) == 0 {
+					return nil
+				}
+				shown, events, diagram = next, nextEvents, nextDiagram
+				move(0)
+			default:
+				return event
+			}
+		default:
+			return event
+		}
+		return nil
+	})
+
+	hint := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText("[yellow]Up/Down[white]:Message [yellow]PgUp/PgDn[white]:Scroll message [yellow]t[white]:Relative time [yellow]c[white]:Correlated legs [yellow]e[white]:Export flow [yellow]Esc[white]:Close")
+	root = tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(flow, 0, 3, true).
+		AddItem(detail, 0, 2, false).
+		AddItem(hint, 1, 0, false)
+	a.app.SetRoot(root, true)
+	a.app.SetFocus(flow)
+}
+
+// traceDetailTitle names the message and, if it has a Call-ID, its place
+// among that Call-ID's messages.
+func (a *App) traceDetailTitle(e traceEntry) string {
+	title := firstSIPLine(e.ev.Message)
+	if e.callID == "" {
+		return tview.Escape(title)
+	}
+	pos, total := a.trace.CallPosition(e.seq)
+	return tview.Escape(fmt.Sprintf("%s — %d/%d in Call-ID %s", title, pos, total, e.callID))
+}
+
+// traceDetailHeader describes where and when the message was captured.
+func traceDetailHeader(e traceEntry) string {
+	ev := e.ev
+	return fmt.Sprintf("[grey]%s %s %s %s ↔ %s[-]\n\n",
+		ev.Timestamp.Format("2006-01-02 15:04:05.000"), ev.Direction, ev.Transport,
+		tview.Escape(ev.LocalAddr), tview.Escape(ev.RemoteAddr))
+}
+
 func (a *App) setStatus(msg string) {
 	slog.Info("tui status", "msg", msg)
-	a.trace.view.SetTitle(fmt.Sprintf("SIP Trace — %s", msg))
+	a.trace.SetStatus(msg)
 }
 
 func newBLFPlaceholder() *tview.Table {
@@ -433,11 +1146,3 @@ func newBLFPlaceholder() *tview.Table {
 
 	return table
 }
-
-func newDialogsPlaceholder() *tview.TextView {
-	tv := tview.NewTextView().
-		SetDynamicColors(true).
-		SetText("SIP dialog viewer — available in a future release")
-	tv.SetTitle("SIP Dialogs").SetBorder(true)
-	return tv
-}
@@ -2,6 +2,8 @@ package tui
 
 import (
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/gdamore/tcell/v2"
@@ -9,10 +11,13 @@ import (
 	"github.com/siptty/siptty/internal/engine"
 )
 
-// callRow tracks a call's position in the table.
+// callRow tracks a call's position in the table and its latest state.
 type callRow struct {
 	row   int
 	state string
+	last  engine.CallStateEvent
+	ice   *engine.ICEStateEvent
+	stats *engine.MediaStatsEvent
 }
 
 // CallPanel displays active calls and a dial input.
@@ -29,11 +34,11 @@ func NewCallPanel() *CallPanel {
 	table := tview.NewTable().
 		SetBorders(false).
 		SetSelectable(false, false). // Disabled until a call row exists (avoids tview infinite loop).
-		SetFixed(1, 0)              // Row 0 is a fixed header.
+		SetFixed(1, 0)               // Row 0 is a fixed header.
 	table.SetTitle("CALLS").SetBorder(true)
 
 	// Header row.
-	headers := []string{"ID", "Remote", "State", "Duration"}
+	headers := []string{"ID", "Remote", "State", "Duration", "Media", "Quality"}
 	for col, h := range headers {
 		table.SetCell(0, col, tview.NewTableCell("[bold]"+h+"[-]").
 			SetSelectable(false).
@@ -68,6 +73,16 @@ func (p *CallPanel) Update(ev engine.CallStateEvent) {
 		p.table.SetSelectable(true, false)
 	}
 	cr.state = ev.State
+	if ev.Encryption == "" && cr.last.Encryption != "" {
+		ev.Encryption = cr.last.Encryption // later events need not repeat it
+	}
+	if ev.SIPCallID == "" {
+		ev.SIPCallID = cr.last.SIPCallID
+	}
+	if ev.Codec == "" {
+		ev.Codec = cr.last.Codec
+	}
+	cr.last = ev
 
 	color := stateColor(ev.State)
 	row := cr.row
@@ -76,6 +91,160 @@ func (p *CallPanel) Update(ev engine.CallStateEvent) {
 	p.table.SetCell(row, 1, tview.NewTableCell(ev.RemoteURI).SetTextColor(color))
 	p.table.SetCell(row, 2, tview.NewTableCell(ev.State).SetTextColor(color))
 	p.table.SetCell(row, 3, tview.NewTableCell(formatDuration(ev.Duration)).SetTextColor(color))
+	p.table.SetCell(row, 4, tview.NewTableCell(cr.mediaLabel()))
+	p.table.SetCell(row, 5, qualityCell(cr.stats))
+	if ev.Reason != "" {
+		p.table.GetCell(row, 2).SetText(fmt.Sprintf("%s (%s)", ev.State, ev.Reason))
+	}
+}
+
+// UpdateICE records the ICE outcome for a call.
+func (p *CallPanel) UpdateICE(ev engine.ICEStateEvent) {
+	cr, ok := p.calls[ev.CallID]
+	if !ok {
+		return
+	}
+	cr.ice = &ev
+	p.table.GetCell(cr.row, 4).SetText(cr.mediaLabel())
+}
+
+// UpdateStats records the latest media statistics of a call.
+func (p *CallPanel) UpdateStats(ev engine.MediaStatsEvent) {
+	cr, ok := p.calls[ev.CallID]
+	if !ok {
+		return
+	}
+	cr.stats = &ev
+	p.table.SetCell(cr.row, 5, qualityCell(cr.stats))
+}
+
+// qualityCell renders the Quality column: the MOS estimate and the loss of
+// the worse direction, colored by MOS.
+func qualityCell(st *engine.MediaStatsEvent) *tview.TableCell {
+	if st == nil || st.RxPackets == 0 {
+		return tview.NewTableCell("")
+	}
+	color := tcell.ColorGreen
+	switch {
+	case st.MOS < 3.6:
+		color = tcell.ColorRed
+	case st.MOS < 4:
+		color = tcell.ColorYellow
+	}
+	loss := max(st.LossPercent, st.RemoteLossPercent)
+	return tview.NewTableCell(fmt.Sprintf("MOS %.1f %.1f%%", st.MOS, loss)).SetTextColor(color)
+}
+
+// mediaLabel renders the Media column: encryption and codec, plus ICE once
+// connected.
+func (cr *callRow) mediaLabel() string {
+	label := encryptionLabel(cr.last.Encryption)
+	if name, _, _ := strings.Cut(cr.last.Codec, "/"); name != "" {
+		label += " " + name
+	}
+	if cr.ice != nil && cr.ice.State == "connected" {
+		label += " ICE"
+	}
+	return label
+}
+
+// Details returns a multi-line description of a call for the details modal.
+func (p *CallPanel) Details(callID string) string {
+	cr, ok := p.calls[callID]
+	if !ok {
+		return fmt.Sprintf("Call %s: no state yet", callID)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Call:      %s (%s)\n", callID, cr.last.Direction)
+	fmt.Fprintf(&b, "Remote:    %s\n", cr.last.RemoteURI)
+	fmt.Fprintf(&b, "State:     %s\n", cr.state)
+	if cr.last.Reason != "" {
+		fmt.Fprintf(&b, "Reason:    %s\n", cr.last.Reason)
+	}
+	fmt.Fprintf(&b, "Media:     %s\n", encryptionLabel(cr.last.Encryption))
+	if cr.last.Codec != "" {
+		fmt.Fprintf(&b, "Codec:     %s\n", cr.last.Codec)
+	}
+	if cr.last.MediaAddr != "" {
+		fmt.Fprintf(&b, "Peer RTP:  %s\n", cr.last.MediaAddr)
+	}
+	if st := cr.stats; st != nil {
+		b.WriteString("\nMEDIA\n")
+		fmt.Fprintf(&b, "  Codec:     %s\n", st.Codec)
+		fmt.Fprintf(&b, "  Received:  %d packets, %d bytes\n", st.RxPackets, st.RxBytes)
+		fmt.Fprintf(&b, "  Sent:      %d packets, %d bytes\n", st.TxPackets, st.TxBytes)
+		fmt.Fprintf(&b, "  Lost:      %d (%.1f%%), %d out of order, %d duplicates\n", st.Lost, st.LossPercent, st.OutOfOrder, st.Duplicates)
+		fmt.Fprintf(&b, "  Jitter:    %s\n", st.Jitter.Round(100*time.Microsecond))
+		if st.PeerPackets > 0 {
+			fmt.Fprintf(&b, "  Peer sent: %d packets, %d bytes (RTCP)\n", st.PeerPackets, st.PeerBytes)
+		}
+		if st.RemoteLossPercent > 0 || st.RemoteJitter > 0 {
+			fmt.Fprintf(&b, "  Delivered: %.1f%% lost, %s jitter (peer's RTCP)\n", st.RemoteLossPercent, st.RemoteJitter.Round(100*time.Microsecond))
+		}
+		if st.RTT > 0 {
+			fmt.Fprintf(&b, "  RTT:       %s\n", st.RTT.Round(100*time.Microsecond))
+		}
+		fmt.Fprintf(&b, "  Quality:   R %.0f, MOS %.2f\n", st.RFactor, st.MOS)
+		if st.Impairment != "" {
+			b.WriteString("\nIMPAIRMENT\n")
+			fmt.Fprintf(&b, "  Settings:  %s\n", st.Impairment)
+			imp := st.Impaired
+			fmt.Fprintf(&b, "  RTP:       %d of %d dropped, %d reordered, %d duplicated\n", imp.Dropped, imp.Packets, imp.Reordered, imp.Duplicated)
+			if sip := st.SIPImpaired; sip.Packets > 0 {
+				fmt.Fprintf(&b, "  SIP:       %d of %d dropped\n", sip.Dropped, sip.Packets)
+			}
+		}
+	}
+	if cr.ice != nil {
+		b.WriteString("\nICE\n")
+		fmt.Fprintf(&b, "  State:  %s\n", cr.ice.State)
+		if cr.ice.State == "connected" {
+			fmt.Fprintf(&b, "  Local:  %s\n", cr.ice.Local)
+			fmt.Fprintf(&b, "  Remote: %s\n", cr.ice.Remote)
+		}
+		if cr.ice.Reason != "" {
+			fmt.Fprintf(&b, "  Reason: %s\n", cr.ice.Reason)
+		}
+	}
+	return b.String()
+}
+
+// Impairment returns the network impairment of a call, as the engine last
+// reported it.
+func (p *CallPanel) Impairment(callID string) string {
+	if cr, ok := p.calls[callID]; ok && cr.stats != nil {
+		return cr.stats.Impairment
+	}
+	return ""
+}
+
+// Codecs returns a line per call, in table order, with the codec it
+// negotiated.
+func (p *CallPanel) Codecs() []string {
+	rows := make([]*callRow, 0, len(p.calls))
+	for _, cr := range p.calls {
+		rows = append(rows, cr)
+	}
+	slices.SortFunc(rows, func(a, b *callRow) int { return a.row - b.row })
+
+	var lines []string
+	for _, cr := range rows {
+		codec := cr.last.Codec
+		if codec == "" {
+			codec = "-"
+		}
+		lines = append(lines, fmt.Sprintf("  %-4s %-12s %-30s %s", cr.last.CallID, cr.state, cr.last.RemoteURI, codec))
+	}
+	return lines
+}
+
+// encryptionLabel renders the media security indicator for the Media column.
+func encryptionLabel(suite string) string {
+	if suite == "" {
+		return "RTP"
+	}
+	return "🔒 " + suite
 }
 
 // ShowDTMF briefly highlights the DTMF digit on the call row.
@@ -124,3 +293,64 @@ func formatDuration(d time.Duration) string {
 	}
 	return fmt.Sprintf("%d:%02d", s/60, s%60)
 }
+
+// formatDialogState renders the dialog state of a call, then the INVITE and
+// 2xx that established it, for the call details view.
+func formatDialogState(st *engine.DialogState) string {
+	var b strings.Builder
+	row := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "  %-16s %s\n", name+":", tview.Escape(value))
+		}
+	}
+	b.WriteString("\n[yellow]DIALOG[-]\n")
+	row("Call-ID", st.CallID)
+	row("Local", st.LocalURI)
+	row("Local tag", st.LocalTag)
+	row("Remote", st.RemoteURI)
+	row("Remote tag", st.RemoteTag)
+	row("CSeq", fmt.Sprintf("local %d, remote %d", st.LocalCSeq, st.RemoteCSeq))
+	row("Remote target", st.RemoteTarget)
+	for i, r := range st.RouteSet {
+		row(fmt.Sprintf("Route %d", i+1), r)
+	}
+	if st.SessionExpires > 0 {
+		timer := fmt.Sprintf("%ds", st.SessionExpires)
+		if st.Refresher != "" {
+			timer += ", refresher " + st.Refresher
+		}
+		row("Session timer", timer)
+	}
+	if st.MinSE > 0 {
+		row("Min-SE", fmt.Sprintf("%ds", st.MinSE))
+	}
+	if st.Refreshes > 0 {
+		row("Re-INVITEs", fmt.Sprintf("%d accepted", st.Refreshes))
+	}
+
+	for _, side := range []struct {
+		name string
+		m    *engine.MediaState
+	}{{"LOCAL SDP", st.LocalSDP}, {"REMOTE SDP", st.RemoteSDP}} {
+		if side.m == nil {
+			continue
+		}
+		fmt.Fprintf(&b, "\n[yellow]%s[-]\n", side.name)
+		row("Codec", side.m.Codec)
+		row("Offered", strings.Join(side.m.Codecs, ", "))
+		if side.m.Ptime > 0 {
+			row("Ptime", fmt.Sprintf("%d ms", side.m.Ptime))
+		}
+		row("RTP address", side.m.Addr)
+		row("Direction", side.m.Direction)
+	}
+
+	for _, ev := range []engine.SipTraceEvent{st.Invite, st.Answer} {
+		if ev.Message == "" {
+			continue
+		}
+		b.WriteString("\n[yellow]" + tview.Escape(firstSIPLine(ev.Message)) + "[-]\n")
+		b.WriteString(traceDetailHeader(traceEntry{ev: ev}) + formatSIPMessage(ev))
+	}
+	return b.String()
+}
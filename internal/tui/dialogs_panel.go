@@ -0,0 +1,283 @@
+package tui
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"github.com/siptty/siptty/internal/engine"
+	"github.com/siptty/siptty/internal/ladder"
+)
+
+// dialogColumns are the dialog list's columns, in the order 's' cycles the
+// sort through them.
+var dialogColumns = []string{"Start", "Method", "From", "To", "State", "Msgs", "Call-ID"}
+
+// DialogsPanel lists the SIP dialogs in the engine's trace store, one row
+// per Call-ID, like sngrep's call list. New messages are read from the store
+// on the tview main goroutine, the same way as the trace panel's.
+type DialogsPanel struct {
+	table *tview.Table
+	store *engine.TraceStore
+
+	// The fields below are only touched on the tview main goroutine.
+	tracker  *engine.DialogTracker
+	lastSeq  int                 // seq of the newest message read from the store
+	rows     []*engine.SipDialog // in table order, row i+1
+	sortCol  int
+	sortDesc bool
+	marked   map[string]bool // Call-IDs marked with Space, viewed and exported together
+}
+
+// NewDialogsPanel creates the dialog list for the messages in store, sorted
+// by start time.
+func NewDialogsPanel(store *engine.TraceStore) *DialogsPanel {
+	table := tview.NewTable().
+		SetBorders(false).
+		SetSelectable(false, false). // Disabled until a dialog row exists.
+		SetFixed(1, 0)
+	table.SetBorder(true)
+	p := &DialogsPanel{table: table, store: store, tracker: engine.NewDialogTracker(), marked: make(map[string]bool)}
+	table.SetInputCapture(p.handleKey)
+	p.redraw()
+	return p
+}
+
+// Flush files the messages added to the store since the last flush into
+// their dialogs and redraws the list.
+// Must be called on the tview main goroutine (inside QueueUpdateDraw).
+func (p *DialogsPanel) Flush() {
+	events := p.store.Since(p.lastSeq)
+	if len(events) == 0 {
+		return
+	}
+	for _, ev := range events {
+		p.tracker.Add(ev)
+		p.lastSeq = ev.Seq
+	}
+	p.redraw()
+}
+
+// Messages returns the stored messages of dialog d, oldest first. Messages
+// the store has evicted are gone from the ladder too.
+func (p *DialogsPanel) Messages(d *engine.SipDialog) []engine.SipTraceEvent {
+	return p.store.Query(engine.TraceQuery{CallID: d.CallID})
+}
+
+// Group returns the marked dialogs in table order or, when none is marked,
+// the selected one.
+func (p *DialogsPanel) Group() []*engine.SipDialog {
+	var group []*engine.SipDialog
+	for _, d := range p.rows {
+		if p.marked[d.CallID] {
+			group = append(group, d)
+		}
+	}
+	if len(group) == 0 {
+		if d := p.Selected(); d != nil {
+			group = append(group, d)
+		}
+	}
+	return group
+}
+
+// Related returns dialogs and every dialog correlated with one of them,
+// oldest first.
+func (p *DialogsPanel) Related(dialogs []*engine.SipDialog) []*engine.SipDialog {
+	seen := make(map[string]bool)
+	var out []*engine.SipDialog
+	for _, d := range dialogs {
+		for _, r := range p.tracker.Related(d.CallID) {
+			if !seen[r.CallID] {
+				seen[r.CallID] = true
+				out = append(out, r)
+			}
+		}
+	}
+	slices.SortStableFunc(out, func(a, b *engine.SipDialog) int {
+		return a.Start.Compare(b.Start)
+	})
+	return out
+}
+
+// GroupMessages returns the stored messages of dialogs, in capture order.
+func (p *DialogsPanel) GroupMessages(dialogs []*engine.SipDialog) []engine.SipTraceEvent {
+	var events []engine.SipTraceEvent
+	for _, d := range dialogs {
+		events = append(events, p.Messages(d)...)
+	}
+	slices.SortFunc(events, func(a, b engine.SipTraceEvent) int {
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+	return events
+}
+
+// handleKey changes the sort: s moves it to the next column and S reverses
+// it. Space marks the selected dialog. Enter is handled by the App.
+func (p *DialogsPanel) handleKey(event *tcell.EventKey) *tcell.EventKey {
+	if event.Key() != tcell.KeyRune {
+		return event
+	}
+	switch event.Rune() {
+	case ' ':
+		d := p.Selected()
+		if d == nil {
+			return nil
+		}
+		if p.marked[d.CallID] {
+			delete(p.marked, d.CallID)
+		} else {
+			p.marked[d.CallID] = true
+		}
+	case 's':
+		p.sortCol = (p.sortCol + 1) % len(dialogColumns)
+	case 'S':
+		p.sortDesc = !p.sortDesc
+	default:
+		return event
+	}
+	p.redraw()
+	return nil
+}
+
+// redraw sorts the dialogs and rebuilds the table, keeping the selected
+// dialog selected.
+func (p *DialogsPanel) redraw() {
+	selected := p.Selected()
+
+	p.rows = p.tracker.Dialogs() // oldest first, the tie-break for every column
+	slices.SortStableFunc(p.rows, func(a, b *engine.SipDialog) int {
+		c := compareDialogs(a, b, p.sortCol)
+		if p.sortDesc {
+			return -c
+		}
+		return c
+	})
+
+	p.table.Clear()
+	for col, h := range dialogColumns {
+		switch {
+		case col == p.sortCol && p.sortDesc:
+			h += " ▼"
+		case col == p.sortCol:
+			h += " ▲"
+		}
+		p.table.SetCell(0, col, tview.NewTableCell("[bold]"+h+"[-]").
+			SetSelectable(false).
+			SetExpansion(1))
+	}
+	for i, d := range p.rows {
+		row := i + 1
+		color := dialogStateColor(d.State)
+		start := "  " + d.Start.Format("15:04:05.000")
+		if p.marked[d.CallID] {
+			start = "[aqua]*[-] " + d.Start.Format("15:04:05.000")
+		}
+		p.table.SetCell(row, 0, tview.NewTableCell(start))
+		p.table.SetCell(row, 1, tview.NewTableCell(d.Method))
+		p.table.SetCell(row, 2, tview.NewTableCell(tview.Escape(d.From)))
+		p.table.SetCell(row, 3, tview.NewTableCell(tview.Escape(d.To)))
+		p.table.SetCell(row, 4, tview.NewTableCell(d.State).SetTextColor(color))
+		p.table.SetCell(row, 5, tview.NewTableCell(strconv.Itoa(d.Count)).SetAlign(tview.AlignRight))
+		p.table.SetCell(row, 6, tview.NewTableCell(tview.Escape(d.CallID)))
+		if selected != nil && d.CallID == selected.CallID {
+			p.table.Select(row, 0)
+		}
+	}
+	if len(p.rows) > 0 {
+		p.table.SetSelectable(true, false)
+	}
+	title := fmt.Sprintf("SIP Dialogs (%d) [sort: %s]", len(p.rows), dialogColumns[p.sortCol])
+	if n := p.markedCount(); n > 0 {
+		title += fmt.Sprintf(" [%d marked]", n)
+	}
+	p.table.SetTitle(title)
+}
+
+// markedCount returns the number of marked dialogs still listed.
+func (p *DialogsPanel) markedCount() int {
+	n := 0
+	for _, d := range p.rows {
+		if p.marked[d.CallID] {
+			n++
+		}
+	}
+	return n
+}
+
+// Selected returns the dialog on the selected row, or nil.
+func (p *DialogsPanel) Selected() *engine.SipDialog {
+	row, _ := p.table.GetSelection()
+	if row < 1 || row > len(p.rows) {
+		return nil
+	}
+	return p.rows[row-1]
+}
+
+// compareDialogs orders two dialogs by the given column.
+func compareDialogs(a, b *engine.SipDialog, col int) int {
+	switch dialogColumns[col] {
+	case "Method":
+		return strings.Compare(a.Method, b.Method)
+	case "From":
+		return strings.Compare(a.From, b.From)
+	case "To":
+		return strings.Compare(a.To, b.To)
+	case "State":
+		return strings.Compare(a.State, b.State)
+	case "Msgs":
+		return cmp.Compare(a.Count, b.Count)
+	case "Call-ID":
+		return strings.Compare(a.CallID, b.CallID)
+	default:
+		return a.Start.Compare(b.Start)
+	}
+}
+
+// dialogStateColor returns the color for a dialog state.
+func dialogStateColor(state string) tcell.Color {
+	switch state {
+	case "In call", "Active", "Completed":
+		return tcell.ColorGreen
+	case "Calling", "Ringing", "Subscribing", "Pending":
+		return tcell.ColorYellow
+	case "Busy", "Rejected", "Failed":
+		return tcell.ColorRed
+	default:
+		return tcell.ColorGrey
+	}
+}
+
+// ladderText renders a diagram with tview tags: one region per arrow, named
+// by its index, colored like the message's start line.
+func ladderText(d *ladder.Diagram, delta bool) string {
+	var b strings.Builder
+	for _, line := range d.Header() {
+		b.WriteString("[::b]" + tview.Escape(line) + "[::-]\n")
+	}
+	for i, a := range d.Arrows {
+		fmt.Fprintf(&b, "[\"%d\"][%s]%s[-][\"\"]\n", i, arrowColor(a.Event), tview.Escape(d.ArrowLine(i, delta)))
+	}
+	return b.String()
+}
+
+// arrowColor colors requests yellow and responses by class, as in the
+// message detail.
+func arrowColor(ev engine.SipTraceEvent) string {
+	switch code := ev.StatusCode(); {
+	case code == 0:
+		return "yellow"
+	case code < 200:
+		return "grey"
+	case code < 300:
+		return "green"
+	case code < 400:
+		return "aqua"
+	default:
+		return "red"
+	}
+}
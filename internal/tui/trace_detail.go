@@ -0,0 +1,67 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rivo/tview"
+	"github.com/siptty/siptty/internal/engine"
+)
+
+// formatSIPMessage renders a traced SIP message with tview color tags: the
+// request or status line, header names and SDP body lines each get their
+// own color. All message text is escaped.
+func formatSIPMessage(ev engine.SipTraceEvent) string {
+	msg := strings.ReplaceAll(ev.Message, "\r\n", "\n")
+	head, body, _ := strings.Cut(msg, "\n\n")
+	lines := strings.Split(head, "\n")
+
+	var b strings.Builder
+	b.WriteString(formatStartLine(lines[0]))
+	b.WriteString("\n")
+	for _, line := range lines[1:] {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			b.WriteString(tview.Escape(line) + "\n")
+			continue
+		}
+		fmt.Fprintf(&b, "[aqua]%s[-]:%s\n", tview.Escape(key), tview.Escape(value))
+	}
+
+	body = strings.TrimRight(body, "\n")
+	if body == "" {
+		return b.String()
+	}
+	b.WriteString("\n")
+	isSDP := strings.Contains(strings.ToLower(ev.Header("Content-Type", "c")), "application/sdp")
+	for _, line := range strings.Split(body, "\n") {
+		typ, value, ok := strings.Cut(line, "=")
+		if !isSDP || !ok || len(typ) != 1 {
+			b.WriteString("[grey]" + tview.Escape(line) + "[-]\n")
+			continue
+		}
+		fmt.Fprintf(&b, "[fuchsia]%s[-]=[lightgreen]%s[-]\n", typ, tview.Escape(value))
+	}
+	return b.String()
+}
+
+// formatStartLine colors a request line by method and a status line by
+// response class.
+func formatStartLine(line string) string {
+	if code, ok := strings.CutPrefix(line, "SIP/2.0 "); ok {
+		color := "white"
+		switch {
+		case strings.HasPrefix(code, "1"):
+			color = "grey"
+		case strings.HasPrefix(code, "2"):
+			color = "green"
+		case strings.HasPrefix(code, "3"):
+			color = "aqua"
+		default:
+			color = "red"
+		}
+		return fmt.Sprintf("[%s::b]%s[-::-]", color, tview.Escape(line))
+	}
+	method, rest, _ := strings.Cut(line, " ")
+	return fmt.Sprintf("[yellow::b]%s[-::-] %s", tview.Escape(method), tview.Escape(rest))
+}
@@ -2,38 +2,154 @@ package tui
 
 import (
 	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
 	"strings"
-	"sync"
 
+	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
+	"github.com/siptty/siptty/internal/config"
 	"github.com/siptty/siptty/internal/engine"
 )
 
-const maxTraceMessages = 1000
+// traceEntry is one captured message as shown in the trace panel. seq is
+// its seq in the engine's trace store and its region ID in the view.
+type traceEntry struct {
+	seq    int
+	ev     engine.SipTraceEvent
+	callID string
+}
 
-// TracePanel displays a scrolling SIP message trace log.
-// Trace events are buffered and flushed to the tview.TextView periodically
-// to avoid blocking the eventLoop goroutine with tview's synchronous draw calls.
+// TracePanel displays a scrolling SIP message trace log of the messages in
+// the engine's trace store, for the detail view too. Entries can be filtered
+// and searched; the view shows the entries that pass the filter.
+// New messages are read from the store and written to the tview.TextView
+// periodically to avoid blocking the eventLoop goroutine with tview's
+// synchronous draw calls.
 type TracePanel struct {
-	view     *tview.TextView
-	msgCount int
+	view  *tview.TextView
+	store *engine.TraceStore
+
+	// The fields below are only touched on the tview main goroutine.
+	entries  []traceEntry // the store's events, oldest first
+	lastSeq  int          // seq of the newest entry read from the store
+	selected int          // seq of the highlighted entry, or -1 to follow new messages
 
-	mu      sync.Mutex
-	pending strings.Builder
+	saved          []*engine.TraceFilter // from [[trace.filters]]
+	savedIdx       int                   // index of the active saved filter, or -1
+	filter         *engine.TraceFilter   // active filter, nil for none
+	excludeOptions bool
+	search         string // lowercase incremental search term
+	status         string // last status message, shown in the title
 }
 
-// NewTracePanel creates a scrolling TextView for SIP trace messages.
-func NewTracePanel() *TracePanel {
+// NewTracePanel creates a scrolling TextView for the SIP trace messages in
+// store with the saved filters and OPTIONS toggle from cfg.
+func NewTracePanel(store *engine.TraceStore, cfg config.TraceConfig) *TracePanel {
 	tv := tview.NewTextView().
 		SetDynamicColors(true).
+		SetRegions(true).
 		SetScrollable(true)
-	tv.SetTitle("SIP Trace").SetBorder(true)
-	return &TracePanel{view: tv}
+	tv.SetBorder(true)
+	p := &TracePanel{view: tv, store: store, selected: -1, savedIdx: -1, excludeOptions: cfg.ExcludeOptions}
+	for _, fc := range cfg.Filters {
+		f, err := engine.NewTraceFilter(fc)
+		if err != nil {
+			slog.Warn("skipping trace filter", "name", fc.Name, "error", err)
+			continue
+		}
+		p.saved = append(p.saved, f)
+	}
+	tv.SetInputCapture(p.handleKey)
+	p.updateTitle()
+	return p
+}
+
+// Flush reads the messages added to the store since the last flush, writes
+// those that pass the filter to the tview.TextView and, unless an entry is
+// selected, scrolls to the end.
+// Must be called on the tview main goroutine (inside QueueUpdateDraw).
+func (p *TracePanel) Flush() {
+	events := p.store.Since(p.lastSeq)
+	if len(events) == 0 {
+		return
+	}
+
+	var text strings.Builder
+	for _, ev := range events {
+		e := traceEntry{seq: ev.Seq, ev: ev, callID: ev.CallID()}
+		p.lastSeq = ev.Seq
+		p.entries = append(p.entries, e)
+		if p.visible(e) {
+			text.WriteString(p.format(e))
+		}
+	}
+
+	if p.entries[0].seq < p.store.Oldest() {
+		p.trim()
+	} else {
+		fmt.Fprint(p.view, text.String())
+	}
+
+	if p.search != "" {
+		p.updateTitle() // match count
+	}
+	if p.selected < 0 {
+		p.view.ScrollToEnd()
+	}
+}
+
+// trim drops the entries the store has evicted and redraws the view from
+// the rest. A selection that was dropped is cleared.
+func (p *TracePanel) trim() {
+	oldest := p.store.Oldest()
+	i := slices.IndexFunc(p.entries, func(e traceEntry) bool { return e.seq >= oldest })
+	if i < 0 {
+		i = len(p.entries)
+	}
+	p.entries = append([]traceEntry(nil), p.entries[i:]...)
+	if p.selected >= 0 && p.selected < oldest {
+		p.selected = -1
+	}
+	p.redraw()
+}
+
+// redraw rebuilds the view from the entries that pass the filter.
+func (p *TracePanel) redraw() {
+	var text strings.Builder
+	for _, e := range p.entries {
+		if p.visible(e) {
+			text.WriteString(p.format(e))
+		}
+	}
+	p.view.Clear()
+	fmt.Fprint(p.view, text.String())
+	if p.selected >= 0 {
+		p.view.Highlight(strconv.Itoa(p.selected))
+		p.view.ScrollToHighlight()
+	} else {
+		p.view.ScrollToEnd()
+	}
 }
 
-// Buffer formats a SIP trace event and appends it to the pending buffer.
-// Goroutine-safe. Does not touch tview widgets directly.
-func (p *TracePanel) Buffer(ev engine.SipTraceEvent) {
+// visible reports whether e passes the OPTIONS toggle and the active filter.
+func (p *TracePanel) visible(e traceEntry) bool {
+	if p.excludeOptions && e.ev.Method() == "OPTIONS" {
+		return false
+	}
+	return p.filter == nil || p.filter.Match(e.ev)
+}
+
+// matches reports whether e contains the search term.
+func (p *TracePanel) matches(e traceEntry) bool {
+	return p.search != "" && strings.Contains(strings.ToLower(e.ev.Message), p.search)
+}
+
+// format renders the two-line summary of an entry as a region named after
+// its seq, so it can be highlighted when selected. Search matches are marked.
+func (p *TracePanel) format(e traceEntry) string {
+	ev := e.ev
 	var color, arrow string
 	switch ev.Direction {
 	case "send":
@@ -42,60 +158,293 @@ func (p *TracePanel) Buffer(ev engine.SipTraceEvent) {
 	case "recv":
 		color = "yellow"
 		arrow = "←"
+	case "dns":
+		color = "aqua"
+		arrow = "»"
 	default:
 		color = "white"
 		arrow = "?"
 	}
 
+	line := tview.Escape(firstSIPLine(ev.Message))
+	if p.matches(e) {
+		line = markTerm(firstSIPLine(ev.Message), p.search)
+	}
 	ts := ev.Timestamp.Format("15:04:05.000")
-	firstLine := firstSIPLine(ev.Message)
-
-	entry := fmt.Sprintf("[%s]%s %s %s %s %s %s[-]\n[%s]%s[-]\n",
-		color, ts, arrow, ev.Transport, ev.LocalAddr, arrow, ev.RemoteAddr,
-		color, firstLine,
+	return fmt.Sprintf("[\"%d\"][%s]%s %s %s %s %s %s[-]\n[%s]%s[-][\"\"]\n",
+		e.seq, color, ts, arrow, ev.Transport, ev.LocalAddr, arrow, ev.RemoteAddr,
+		color, line,
 	)
+}
 
-	p.mu.Lock()
-	p.pending.WriteString(entry)
-	p.mu.Unlock()
+// markTerm escapes line and highlights the occurrences of term (lowercase)
+// in it. When the term only occurs further down the message, a marker says so.
+func markTerm(line, term string) string {
+	lower := strings.ToLower(line)
+	var b strings.Builder
+	found := false
+	for {
+		i := strings.Index(lower, term)
+		if i < 0 || len(lower) != len(line) {
+			break
+		}
+		found = true
+		b.WriteString(tview.Escape(line[:i]))
+		b.WriteString("[black:yellow]" + tview.Escape(line[i:i+len(term)]) + "[-:-]")
+		line, lower = line[i+len(term):], lower[i+len(term):]
+	}
+	b.WriteString(tview.Escape(line))
+	if !found {
+		b.WriteString(" [black:yellow]+match[-:-]")
+	}
+	return b.String()
 }
 
-// Flush writes all pending trace text to the tview.TextView and scrolls to end.
-// Must be called on the tview main goroutine (inside QueueUpdateDraw).
-func (p *TracePanel) Flush() {
-	p.mu.Lock()
-	text := p.pending.String()
-	p.pending.Reset()
-	p.mu.Unlock()
+// handleKey moves the selection while the trace view has focus: Up/Down (or
+// k/j) step through entries, Home/End jump to the ends, n/N jump between
+// search matches and Escape goes back to following new messages. o toggles
+// OPTIONS and F cycles the saved filters. Enter, / and f are handled by the App.
+func (p *TracePanel) handleKey(event *tcell.EventKey) *tcell.EventKey {
+	switch event.Key() {
+	case tcell.KeyUp:
+		p.moveSelection(-1)
+	case tcell.KeyDown:
+		p.moveSelection(1)
+	case tcell.KeyHome:
+		p.selectVisible(0, 1)
+	case tcell.KeyEnd:
+		p.selectVisible(len(p.entries)-1, -1)
+	case tcell.KeyEscape:
+		p.selected = -1
+		p.view.Highlight()
+		p.view.ScrollToEnd()
+	case tcell.KeyRune:
+		switch event.Rune() {
+		case 'k':
+			p.moveSelection(-1)
+		case 'j':
+			p.moveSelection(1)
+		case 'n':
+			p.NextMatch(1)
+		case 'N':
+			p.NextMatch(-1)
+		case 'o':
+			p.ToggleOptions()
+		case 'F':
+			p.CycleFilter()
+		default:
+			return event
+		}
+	default:
+		return event
+	}
+	return nil
+}
 
-	if text == "" {
+// moveSelection selects the visible entry delta places from the current
+// one. With nothing selected, it starts from the newest visible entry.
+func (p *TracePanel) moveSelection(delta int) {
+	i := p.index(p.selected)
+	if i < 0 {
+		p.selectVisible(len(p.entries)-1, -1)
 		return
 	}
+	p.selectVisible(i+delta, delta)
+}
+
+// selectVisible selects the first visible entry from index i on, stepping by dir.
+func (p *TracePanel) selectVisible(i, dir int) {
+	for ; i >= 0 && i < len(p.entries); i += dir {
+		if p.visible(p.entries[i]) {
+			p.Select(p.entries[i].seq)
+			return
+		}
+	}
+}
 
-	fmt.Fprint(p.view, text)
-	p.msgCount += strings.Count(text, "\n") / 2
+// Select highlights the entry with the given seq and scrolls to it.
+func (p *TracePanel) Select(seq int) {
+	p.selected = seq
+	p.view.Highlight(strconv.Itoa(seq))
+	p.view.ScrollToHighlight()
+}
 
-	if p.msgCount > maxTraceMessages {
-		p.trim()
+// VisibleEvents returns the messages that pass the filter, oldest first.
+func (p *TracePanel) VisibleEvents() []engine.SipTraceEvent {
+	var out []engine.SipTraceEvent
+	for _, e := range p.entries {
+		if p.visible(e) {
+			out = append(out, e.ev)
+		}
 	}
+	return out
+}
 
-	p.view.ScrollToEnd()
+// Selected returns the highlighted entry.
+func (p *TracePanel) Selected() (traceEntry, bool) {
+	i := p.index(p.selected)
+	if i < 0 {
+		return traceEntry{}, false
+	}
+	return p.entries[i], true
 }
 
-// trim removes the oldest messages to keep the buffer at maxTraceMessages.
-func (p *TracePanel) trim() {
-	text := p.view.GetText(false)
-	lines := strings.Split(text, "\n")
-	excess := p.msgCount - maxTraceMessages
-	linesToRemove := excess * 2
-	if linesToRemove >= len(lines) {
-		p.view.Clear()
-		p.msgCount = 0
+// Sibling returns the nearest entry after (dir > 0) or before (dir < 0) the
+// one with seq that belongs to the same Call-ID, whether or not it is visible.
+func (p *TracePanel) Sibling(seq, dir int) (traceEntry, bool) {
+	i := p.index(seq)
+	if i < 0 || p.entries[i].callID == "" {
+		return traceEntry{}, false
+	}
+	callID := p.entries[i].callID
+	for j := i + dir; j >= 0 && j < len(p.entries); j += dir {
+		if p.entries[j].callID == callID {
+			return p.entries[j], true
+		}
+	}
+	return traceEntry{}, false
+}
+
+// CallPosition returns the 1-based position of the entry with seq among the
+// entries of its Call-ID, and their count.
+func (p *TracePanel) CallPosition(seq int) (pos, total int) {
+	i := p.index(seq)
+	if i < 0 {
+		return 0, 0
+	}
+	for j, e := range p.entries {
+		if e.callID == p.entries[i].callID {
+			total++
+			if j <= i {
+				pos = total
+			}
+		}
+	}
+	return pos, total
+}
+
+// index returns the position of the entry with seq in entries, or -1.
+// Entries are in seq order with no gaps, so it is an offset from the oldest.
+func (p *TracePanel) index(seq int) int {
+	if seq < 0 || len(p.entries) == 0 {
+		return -1
+	}
+	i := seq - p.entries[0].seq
+	if i < 0 || i >= len(p.entries) {
+		return -1
+	}
+	return i
+}
+
+// SetFilter replaces the active filter with f (nil clears it).
+func (p *TracePanel) SetFilter(f *engine.TraceFilter) {
+	p.filter = f
+	p.savedIdx = -1
+	p.refilter()
+}
+
+// CycleFilter activates the next saved filter; after the last one, none.
+func (p *TracePanel) CycleFilter() {
+	if len(p.saved) == 0 {
+		p.SetStatus("no saved filters ([[trace.filters]] in config)")
 		return
 	}
-	p.view.Clear()
-	fmt.Fprint(p.view, strings.Join(lines[linesToRemove:], "\n"))
-	p.msgCount = maxTraceMessages
+	p.savedIdx++
+	if p.savedIdx >= len(p.saved) {
+		p.savedIdx = -1
+		p.filter = nil
+	} else {
+		p.filter = p.saved[p.savedIdx]
+	}
+	p.refilter()
+}
+
+// ToggleOptions shows or hides OPTIONS requests and their responses.
+func (p *TracePanel) ToggleOptions() {
+	p.excludeOptions = !p.excludeOptions
+	p.refilter()
+}
+
+// refilter redraws after a filter change, dropping a selection that is no
+// longer visible.
+func (p *TracePanel) refilter() {
+	if i := p.index(p.selected); i >= 0 && !p.visible(p.entries[i]) {
+		p.selected = -1
+		p.view.Highlight()
+	}
+	p.updateTitle()
+	p.redraw()
+}
+
+// SetSearch sets the incremental search term, marks the matching entries
+// and selects the newest visible match. An empty term ends the search.
+func (p *TracePanel) SetSearch(term string) {
+	p.search = strings.ToLower(term)
+	p.updateTitle()
+	p.redraw()
+	if p.search != "" {
+		p.selected = -1
+		p.NextMatch(-1)
+	}
+}
+
+// NextMatch selects the next (dir > 0) or previous (dir < 0) visible entry
+// matching the search, wrapping around. With nothing selected it starts
+// from the oldest or newest entry.
+func (p *TracePanel) NextMatch(dir int) bool {
+	if p.search == "" || len(p.entries) == 0 {
+		return false
+	}
+	n := len(p.entries)
+	start := p.index(p.selected)
+	switch {
+	case start >= 0:
+	case dir > 0:
+		start = -1
+	default:
+		start = n
+	}
+	for k := 1; k <= n; k++ {
+		i := ((start+dir*k)%n + n) % n
+		if e := p.entries[i]; p.visible(e) && p.matches(e) {
+			p.Select(e.seq)
+			return true
+		}
+	}
+	return false
+}
+
+// SetStatus shows msg in the panel title.
+func (p *TracePanel) SetStatus(msg string) {
+	p.status = msg
+	p.updateTitle()
+}
+
+// updateTitle shows the active filter, OPTIONS toggle, search and status.
+func (p *TracePanel) updateTitle() {
+	title := "SIP Trace"
+	switch {
+	case p.filter != nil && p.filter.Name() != "":
+		title += " [filter: " + p.filter.Name() + "]"
+	case p.filter != nil:
+		title += " [filtered]"
+	}
+	if p.excludeOptions {
+		title += " [no OPTIONS]"
+	}
+	if p.search != "" {
+		count := 0
+		for _, e := range p.entries {
+			if p.visible(e) && p.matches(e) {
+				count++
+			}
+		}
+		title += fmt.Sprintf(" [/%s: %d]", p.search, count)
+	}
+	if p.status != "" {
+		title += " — " + p.status
+	}
+	p.view.SetTitle(tview.Escape(title))
 }
 
 // firstSIPLine extracts the first non-empty line from a SIP message.